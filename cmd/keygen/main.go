@@ -2,66 +2,338 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
 	"sso/internal/lib/keygen"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	privateKeyFile = "private.pem"
+	publicKeyFile  = "public.pem"
+
+	privateKeyPerm = 0o600
+	publicKeyPerm  = 0o644
+)
+
+// standardKeyBits are the RSA key sizes keygen generates without a warning.
+var standardKeyBits = map[int]bool{2048: true, 3072: true, 4096: true}
+
+var (
+	errAppNotFound = errors.New("app not found")
+	errAppExists   = errors.New("app name is already used by another app")
+	errFileExists  = errors.New("file already exists")
 )
 
 func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
+}
+
+func run(args []string, out io.Writer) error {
 	var (
-		dbPath  string
-		appID   int
-		appName string
-		bits    int
+		dbPath       string
+		appID        int
+		appName      string
+		alg          string
+		bits         int
+		rotate       bool
+		outDir       string
+		stdout       bool
+		noDB         bool
+		force        bool
+		validateOnly bool
+		allowWeak    bool
 	)
 
-	flag.StringVar(&dbPath, "db", "./storage/sso.db", "Path to SQLite database")
-	flag.IntVar(&appID, "app-id", 1, "Application ID")
-	flag.StringVar(&appName, "app-name", "Test", "Application name")
-	flag.IntVar(&bits, "bits", 2048, "RSA key size in bits (2048 or 4096 recommended)")
-	flag.Parse()
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.StringVar(&dbPath, "db", "./storage/sso.db", "Path to SQLite database")
+	fs.IntVar(&appID, "app-id", 0, "Application ID; 0 creates a new app with the next free ID")
+	fs.StringVar(&appName, "app-name", "Test", "Application name")
+	fs.StringVar(&alg, "alg", models.AlgRS256, fmt.Sprintf("Token signing algorithm, %s or %s (Ed25519)", models.AlgRS256, models.AlgEdDSA))
+	fs.IntVar(&bits, "bits", 2048, "RSA key size in bits (2048 or 4096 recommended); ignored for EdDSA")
+	fs.BoolVar(&rotate, "rotate", false, "Rotate keys of an existing app, keeping its current public key as the previous one")
+	fs.StringVar(&outDir, "out-dir", "", "Directory to write private.pem and public.pem to")
+	fs.BoolVar(&stdout, "stdout", false, "Print both keys to stdout, including the private key")
+	fs.BoolVar(&noDB, "no-db", false, "Do not write the keys to the database")
+	fs.BoolVar(&force, "force", false, "Overwrite existing key files in -out-dir")
+	fs.BoolVar(&validateOnly, "validate-only", false, "Generate a key pair and check it signs and verifies a token, without writing anything")
+	fs.BoolVar(&allowWeak, "allow-weak", false, fmt.Sprintf("Allow RSA key sizes below %d bits (insecure, for testing only)", keygen.MinRSAKeyBits))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
-	// Generate RSA key pair
-	fmt.Printf("Generating %d-bit RSA key pair...\n", bits)
-	keyPair, err := keygen.GenerateRSAKeyPair(bits)
+	if rotate && appID == 0 {
+		return errors.New("-rotate requires -app-id")
+	}
+	if noDB && rotate {
+		return errors.New("-no-db cannot be combined with -rotate")
+	}
+	if noDB && outDir == "" && !stdout {
+		return errors.New("-no-db requires -out-dir or -stdout, otherwise the generated keys are lost")
+	}
+
+	var (
+		keyPair *keygen.KeyPair
+		err     error
+	)
+	switch alg {
+	case models.AlgRS256:
+		generate := keygen.GenerateRSAKeyPair
+		if bits < keygen.MinRSAKeyBits {
+			if !allowWeak {
+				return fmt.Errorf("%d-bit keys are insecure, use at least %d bits or pass -allow-weak", bits, keygen.MinRSAKeyBits)
+			}
+			generate = keygen.GenerateWeakRSAKeyPair
+		}
+		if !standardKeyBits[bits] {
+			fmt.Fprintf(out, "WARNING: %d bits is not a standard RSA key size, 2048, 3072 or 4096 are recommended\n", bits)
+		}
+
+		fmt.Fprintf(out, "Generating %d-bit RSA key pair...\n", bits)
+		keyPair, err = generate(bits)
+	case models.AlgEdDSA:
+		fmt.Fprintln(out, "Generating Ed25519 key pair...")
+		keyPair, err = keygen.GenerateEd25519KeyPair()
+	default:
+		return fmt.Errorf("unsupported -alg %q, use %s or %s", alg, models.AlgRS256, models.AlgEdDSA)
+	}
 	if err != nil {
-		log.Fatalf("Failed to generate key pair: %v", err)
+		return fmt.Errorf("failed to generate key pair: %w", err)
 	}
 
-	fmt.Println("Keys generated successfully!")
-	fmt.Println("\nNOTE: Private key has been stored in the database and is not printed to stdout for security reasons.")
-	fmt.Printf("=== PUBLIC KEY ===\n%s\n", keyPair.PublicKey)
+	fmt.Fprintln(out, "Keys generated successfully!")
+
+	if validateOnly {
+		if err = validateKeyPair(keyPair, alg); err != nil {
+			return fmt.Errorf("key pair validation failed: %w", err)
+		}
+		fmt.Fprintln(out, "✓ Key pair parsed and signed/verified a test token, nothing was written")
+		return nil
+	}
+
+	if outDir != "" {
+		if err = writeKeyFiles(outDir, keyPair, force); err != nil {
+			return fmt.Errorf("failed to write key files: %w", err)
+		}
+		fmt.Fprintf(out, "✓ Keys written to %s\n", outDir)
+	}
+
+	if stdout {
+		fmt.Fprintf(out, "=== PRIVATE KEY ===\n%s\n", keyPair.PrivateKey)
+	} else if !noDB {
+		fmt.Fprintln(out, "\nNOTE: Private key has been stored in the database and is not printed to stdout for security reasons.")
+	}
+	fmt.Fprintf(out, "=== PUBLIC KEY ===\n%s\n", keyPair.PublicKey)
+
+	if noDB {
+		return nil
+	}
 
 	// Open database
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer func() {
 		_ = db.Close()
 	}()
 
-	// Insert or update app with generated keys.
+	if rotate {
+		if err = rotateAppKeys(db, appID, keyPair, alg); err != nil {
+			return fmt.Errorf("failed to rotate app keys: %w", err)
+		}
+
+		fmt.Fprintf(out, "\n✓ App (id=%d) keys rotated, previous public key kept for verification\n", appID)
+		fmt.Fprintf(out, "✓ Database path: %s\n", dbPath)
+		return nil
+	}
+
+	if appID == 0 {
+		if appID, err = insertApp(db, appName, keyPair, alg); err != nil {
+			return fmt.Errorf("failed to insert app: %w", err)
+		}
+	} else if err = upsertApp(db, appID, appName, keyPair, alg); err != nil {
+		return fmt.Errorf("failed to insert/update app: %w", err)
+	}
+
+	fmt.Fprintf(out, "\n✓ App (id=%d, name=%s) successfully added to database with %s keys\n", appID, appName, alg)
+	fmt.Fprintf(out, "✓ Database path: %s\n", dbPath)
+
+	return nil
+}
+
+// validateKeyPair checks that both keys parse and that a token signed with the
+// private key by alg verifies against the public key.
+func validateKeyPair(keyPair *keygen.KeyPair, alg string) error {
+	if alg == models.AlgEdDSA {
+		if _, err := keygen.ParseEd25519PrivateKey(keyPair.PrivateKey); err != nil {
+			return err
+		}
+	} else if _, err := keygen.ParseRSAPrivateKey(keyPair.PrivateKey); err != nil {
+		return err
+	}
+	if _, err := keygen.ParsePublicKey(keyPair.PublicKey); err != nil {
+		return err
+	}
+
+	app := models.App{PrivateKey: keyPair.PrivateKey, PublicKey: keyPair.PublicKey, Algorithm: alg}
+	token, err := jwt.New(slog.New(slog.NewTextHandler(io.Discard, nil))).
+		NewToken(models.User{}, app, time.Minute)
+	if err != nil {
+		return err
+	}
+
+	_, err = jwt.Verify(token, keyPair.PublicKey)
+
+	return err
+}
+
+// insertApp inserts a new app and returns the ID SQLite allocated for it.
+func insertApp(db *sql.DB, appName string, keyPair *keygen.KeyPair, alg string) (int, error) {
+	// See the NOTE in upsertApp about coupling to the apps schema.
+	res, err := db.Exec(`INSERT INTO apps (name, private_key, public_key, algorithm) VALUES (?, ?, ?, ?)`,
+		appName, keyPair.PrivateKey, keyPair.PublicKey, alg)
+	if err != nil {
+		return 0, appNameError(appName, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// upsertApp inserts the app or replaces the name, keys and algorithm of an existing
+// one. Replacing the keys drops the previous public key too: unlike rotateAppKeys,
+// an upsert starts the app afresh, so no token signed before it verifies.
+func upsertApp(db *sql.DB, appID int, appName string, keyPair *keygen.KeyPair, alg string) error {
 	// NOTE: This raw SQL is intentionally coupled to the `apps` table schema defined in the
 	// database migrations and storage layer. If the `apps` schema changes (e.g., columns are
 	// added, removed, or renamed), this query MUST be updated accordingly to stay in sync.
 	// Prefer refactoring this tool in the future to reuse the storage layer's app persistence
 	// API instead of duplicating schema knowledge here.
-	query := `INSERT INTO apps (id, name, private_key, public_key) 
-			  VALUES (?, ?, ?, ?) 
-			  ON CONFLICT(id) DO UPDATE SET 
+	query := `INSERT INTO apps (id, name, private_key, public_key, algorithm)
+			  VALUES (?, ?, ?, ?, ?)
+			  ON CONFLICT(id) DO UPDATE SET
 			  	name = excluded.name,
 			  	private_key = excluded.private_key,
-			  	public_key = excluded.public_key`
+			  	public_key = excluded.public_key,
+			  	algorithm = excluded.algorithm,
+			  	previous_public_key = ''`
+
+	_, err := db.Exec(query, appID, appName, keyPair.PrivateKey, keyPair.PublicKey, alg)
+
+	return appNameError(appName, err)
+}
+
+// appNameError reports a unique violation on apps.name as errAppExists. Names
+// are unique regardless of case, see migration 9.
+func appNameError(appName string, err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return fmt.Errorf("%q: %w", appName, errAppExists)
+	}
+
+	return err
+}
+
+// rotateAppKeys replaces the keys of an existing app, moving its current public key
+// into previous_public_key so tokens signed before the rotation still verify. The
+// new keys may be of another algorithm: old tokens verify by the previous key's.
+func rotateAppKeys(db *sql.DB, appID int, keyPair *keygen.KeyPair, alg string) error {
+	// The right-hand side of SET sees the row as it was before the update, so the
+	// current public key is moved into previous_public_key in the same statement.
+	query := `UPDATE apps SET
+			  	previous_public_key = public_key,
+			  	private_key = ?,
+			  	public_key = ?,
+			  	algorithm = ?
+			  WHERE id = ?`
+
+	res, err := db.Exec(query, keyPair.PrivateKey, keyPair.PublicKey, alg, appID)
+	if err != nil {
+		return err
+	}
 
-	_, err = db.Exec(query, appID, appName, keyPair.PrivateKey, keyPair.PublicKey)
+	affected, err := res.RowsAffected()
 	if err != nil {
-		log.Fatalf("Failed to insert/update app: %v", err)
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("app id=%d: %w", appID, errAppNotFound)
+	}
+
+	return nil
+}
+
+// writeKeyFiles writes the key pair as private.pem (0600) and public.pem (0644) into dir.
+// Existing files are only overwritten when force is set.
+func writeKeyFiles(dir string, keyPair *keygen.KeyPair, force bool) error {
+	files := []struct {
+		name    string
+		content string
+		perm    os.FileMode
+	}{
+		{name: privateKeyFile, content: keyPair.PrivateKey, perm: privateKeyPerm},
+		{name: publicKeyFile, content: keyPair.PublicKey, perm: publicKeyPerm},
+	}
+
+	// Check both files up front so a refusal never leaves a half-written pair behind.
+	if !force {
+		for _, f := range files {
+			path := filepath.Join(dir, f.name)
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s: %w (use -force to overwrite)", path, errFileExists)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := writeFile(filepath.Join(dir, f.name), f.content, f.perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path, content string, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	// OpenFile applies the umask and keeps the mode of an existing file, so set it explicitly.
+	if err = file.Chmod(perm); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if _, err = file.WriteString(content); err != nil {
+		_ = file.Close()
+		return err
 	}
 
-	fmt.Printf("\n✓ App (id=%d, name=%s) successfully added to database with RSA keys\n", appID, appName)
-	fmt.Printf("✓ Database path: %s\n", dbPath)
+	return file.Close()
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"sso/internal/domain/models"
+	"sso/internal/lib/keygen"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKeyBits = 2048
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://../../migrations", "sqlite3://"+dbPath)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	srcErr, dbErr := m.Close()
+	require.NoError(t, srcErr)
+	require.NoError(t, dbErr)
+
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func generateKeyPair(t *testing.T) *keygen.KeyPair {
+	t.Helper()
+
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(t, err)
+
+	return keyPair
+}
+
+func TestRotateAppKeys(t *testing.T) {
+	db := newTestDB(t)
+
+	oldKeys := generateKeyPair(t)
+	require.NoError(t, upsertApp(db, 1, "mobile", oldKeys, models.AlgRS256))
+
+	newKeys := generateKeyPair(t)
+	require.NoError(t, rotateAppKeys(db, 1, newKeys, models.AlgRS256))
+
+	var name, privateKey, publicKey, previousPublicKey string
+	err := db.QueryRow(`SELECT name, private_key, public_key, previous_public_key FROM apps WHERE id = ?`, 1).
+		Scan(&name, &privateKey, &publicKey, &previousPublicKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, "mobile", name)
+	assert.Equal(t, newKeys.PrivateKey, privateKey)
+	assert.Equal(t, newKeys.PublicKey, publicKey)
+	assert.Equal(t, oldKeys.PublicKey, previousPublicKey)
+}
+
+func TestUpsertApp_AfterRotateDropsPreviousKey(t *testing.T) {
+	db := newTestDB(t)
+
+	require.NoError(t, upsertApp(db, 1, "mobile", generateKeyPair(t), models.AlgRS256))
+	require.NoError(t, rotateAppKeys(db, 1, generateKeyPair(t), models.AlgRS256))
+
+	freshKeys := generateKeyPair(t)
+	require.NoError(t, upsertApp(db, 1, "mobile", freshKeys, models.AlgRS256))
+
+	var publicKey, previousPublicKey string
+	err := db.QueryRow(`SELECT public_key, previous_public_key FROM apps WHERE id = ?`, 1).Scan(&publicKey, &previousPublicKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, freshKeys.PublicKey, publicKey)
+	assert.Empty(t, previousPublicKey, "keys from before the upsert no longer verify")
+}
+
+func TestRotateAppKeys_UnknownApp(t *testing.T) {
+	db := newTestDB(t)
+
+	err := rotateAppKeys(db, 42, generateKeyPair(t), models.AlgRS256)
+
+	require.ErrorIs(t, err, errAppNotFound)
+}
+
+func TestUpsertApp_DuplicateName(t *testing.T) {
+	db := newTestDB(t)
+	keyPair := generateKeyPair(t)
+
+	require.NoError(t, upsertApp(db, 1, "mobile", keyPair, models.AlgRS256))
+	require.NoError(t, upsertApp(db, 1, "mobile", keyPair, models.AlgRS256), "re-running for the same app is fine")
+
+	err := upsertApp(db, 2, "Mobile", keyPair, models.AlgRS256)
+	require.ErrorIs(t, err, errAppExists)
+}
+
+func TestWriteKeyFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	keyPair := generateKeyPair(t)
+
+	require.NoError(t, writeKeyFiles(dir, keyPair, false))
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		perm    os.FileMode
+	}{
+		{name: privateKeyFile, content: keyPair.PrivateKey, perm: 0o600},
+		{name: publicKeyFile, content: keyPair.PublicKey, perm: 0o644},
+	} {
+		path := filepath.Join(dir, tt.name)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, tt.content, string(content))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, tt.perm, info.Mode().Perm(), tt.name)
+	}
+}
+
+func TestWriteKeyFiles_RefusesOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	original := generateKeyPair(t)
+	require.NoError(t, writeKeyFiles(dir, original, false))
+
+	err := writeKeyFiles(dir, generateKeyPair(t), false)
+	require.ErrorIs(t, err, errFileExists)
+
+	content, err := os.ReadFile(filepath.Join(dir, privateKeyFile))
+	require.NoError(t, err)
+	assert.Equal(t, original.PrivateKey, string(content))
+}
+
+func TestWriteKeyFiles_Force(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, writeKeyFiles(dir, generateKeyPair(t), false))
+
+	replacement := generateKeyPair(t)
+	require.NoError(t, writeKeyFiles(dir, replacement, true))
+
+	content, err := os.ReadFile(filepath.Join(dir, publicKeyFile))
+	require.NoError(t, err)
+	assert.Equal(t, replacement.PublicKey, string(content))
+}
+
+func TestRun_AllocatesAppID(t *testing.T) {
+	db := newTestDB(t)
+	dbPath := dbFile(t, db)
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"-db", dbPath, "-app-name", "mobile"}, &out))
+	assert.Contains(t, out.String(), "App (id=1, name=mobile)")
+
+	out.Reset()
+	require.NoError(t, run([]string{"-db", dbPath, "-app-name", "web"}, &out))
+	assert.Contains(t, out.String(), "App (id=2, name=web)", "a second run creates a new app")
+
+	var name string
+	require.NoError(t, db.QueryRow(`SELECT name FROM apps WHERE id = 1`).Scan(&name))
+	assert.Equal(t, "mobile", name, "the first app is left untouched")
+
+	err := run([]string{"-db", dbPath, "-app-name", "web"}, io.Discard)
+	assert.ErrorIs(t, err, errAppExists)
+}
+
+func TestRun_ExplicitAppIDUpserts(t *testing.T) {
+	db := newTestDB(t)
+	dbPath := dbFile(t, db)
+
+	require.NoError(t, run([]string{"-db", dbPath, "-app-id", "7", "-app-name", "mobile"}, io.Discard))
+	require.NoError(t, run([]string{"-db", dbPath, "-app-id", "7", "-app-name", "mobile-v2"}, io.Discard))
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM apps`).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	var name string
+	require.NoError(t, db.QueryRow(`SELECT name FROM apps WHERE id = 7`).Scan(&name))
+	assert.Equal(t, "mobile-v2", name)
+}
+
+func TestRun_RotateRequiresAppID(t *testing.T) {
+	err := run([]string{"-rotate", "-db", dbFile(t, newTestDB(t))}, io.Discard)
+	assert.ErrorContains(t, err, "-rotate requires -app-id")
+}
+
+func TestRun_ValidateOnly(t *testing.T) {
+	db := newTestDB(t)
+	dbPath := filepath.Join(t.TempDir(), "untouched.db")
+	outDir := filepath.Join(t.TempDir(), "keys")
+
+	var out bytes.Buffer
+	err := run([]string{"-validate-only", "-db", dbPath, "-out-dir", outDir}, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "nothing was written")
+
+	_, err = os.Stat(dbPath)
+	assert.ErrorIs(t, err, os.ErrNotExist, "database must not be created")
+	_, err = os.Stat(outDir)
+	assert.ErrorIs(t, err, os.ErrNotExist, "key files must not be written")
+
+	// Same against an existing database: no app row appears.
+	require.NoError(t, run([]string{"-validate-only", "-db", dbFile(t, db)}, io.Discard))
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM apps`).Scan(&count))
+	assert.Zero(t, count)
+}
+
+func TestValidateKeyPair_Mismatch(t *testing.T) {
+	keyPair := generateKeyPair(t)
+	keyPair.PublicKey = generateKeyPair(t).PublicKey
+
+	assert.Error(t, validateKeyPair(keyPair, models.AlgRS256))
+}
+
+// dbFile returns the path of the main database file backing db.
+func dbFile(t *testing.T, db *sql.DB) string {
+	t.Helper()
+
+	var seq int
+	var name, file string
+	require.NoError(t, db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file))
+
+	return file
+}
+
+func TestRun_WeakKeySize(t *testing.T) {
+	err := run([]string{"-validate-only", "-bits", "1024"}, io.Discard)
+	require.Error(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"-validate-only", "-bits", "1024", "-allow-weak"}, &out))
+	assert.Contains(t, out.String(), "WARNING")
+}
+
+func TestRun_EdDSA(t *testing.T) {
+	db := newTestDB(t)
+	dbPath := dbFile(t, db)
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"-db", dbPath, "-app-name", "mobile", "-alg", models.AlgEdDSA}, &out))
+	assert.Contains(t, out.String(), "with EdDSA keys")
+
+	var privateKey, alg string
+	require.NoError(t, db.QueryRow(`SELECT private_key, algorithm FROM apps WHERE id = 1`).Scan(&privateKey, &alg))
+	assert.Equal(t, models.AlgEdDSA, alg)
+	_, err := keygen.ParseEd25519PrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	require.NoError(t, run([]string{"-validate-only", "-alg", models.AlgEdDSA}, io.Discard))
+	assert.ErrorContains(t, run([]string{"-validate-only", "-alg", "HS256"}, io.Discard), "unsupported -alg")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/hash"
+	"sso/internal/storage"
+	_ "sso/internal/storage/sqlite" // registers the sqlite storage driver
+)
+
+// passwordEnv lets the password stay out of the shell history and process list.
+const passwordEnv = "SEED_ADMIN_PASSWORD"
+
+var errAdminExists = errors.New("an admin already exists")
+
+// adminStorage is the subset of storage.Storage the seed needs.
+type adminStorage interface {
+	SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte, pepperVersion int, profile storage.Profile) (int64, error)
+	User(ctx context.Context, email string, appID int) (models.User, error)
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	HasAdmin(ctx context.Context) (bool, error)
+}
+
+func main() {
+	var configPath, email, password string
+	var force bool
+
+	flag.StringVar(&configPath, "config", os.Getenv("CONFIG_PATH"), "Path to config file")
+	flag.StringVar(&email, "email", "", "Email of the admin user")
+	flag.StringVar(&password, "password", "", "Password of the admin user (defaults to $"+passwordEnv+")")
+	flag.BoolVar(&force, "force", false, "Create the admin even if another admin already exists")
+	flag.Parse()
+
+	if password == "" {
+		password = os.Getenv(passwordEnv)
+	}
+	if configPath == "" {
+		log.Fatal("-config is required")
+	}
+	if email == "" || password == "" {
+		log.Fatal("-email and -password (or $" + passwordEnv + ") are required")
+	}
+
+	cfg := config.MustLoadByPath(configPath)
+
+	// The hash must be verifiable by the server, so use the same pepper settings.
+	hasher, err := hash.NewHasher(
+		cfg.Password.Peppers,
+		cfg.Password.PepperVersion,
+		hash.WithConcurrencyLimit(cfg.Password.MaxConcurrentHashes, cfg.Password.HashQueueTimeout),
+		hash.WithVariant(hash.Variant(cfg.Password.Variant)),
+	)
+	if err != nil {
+		log.Fatalf("Failed to init password hasher: %v", err)
+	}
+
+	// Opened from the config like the server's, so that e.g. emails are hashed
+	// the same way.
+	s, err := storage.Open(storage.Config{StorageConfig: cfg.Storage, Path: cfg.StoragePath})
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+
+	id, err := seedAdmin(context.Background(), s, hasher, email, password, force)
+	if err != nil {
+		log.Fatalf("Failed to seed admin: %v", err)
+	}
+
+	fmt.Printf("✓ User %s (id=%d) is an admin\n", email, id)
+}
+
+// seedAdmin makes the user with the given email an admin, creating it if needed,
+// and returns its ID. Running it again for the same email is a no-op. An existing
+// user keeps its password. Unless force is set, it refuses to add an admin when
+// another one already exists.
+func seedAdmin(ctx context.Context, s adminStorage, hasher *hash.Hasher, email, password string, force bool) (int64, error) {
+	user, err := s.User(ctx, email, 0)
+	switch {
+	case err == nil:
+		if user.IsAdmin {
+			return user.ID, nil
+		}
+	case errors.Is(err, storage.ErrUserNotFound):
+	default:
+		return 0, err
+	}
+
+	if !force {
+		hasAdmin, err := s.HasAdmin(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if hasAdmin {
+			return 0, fmt.Errorf("%w (use -force to add another one)", errAdminExists)
+		}
+	}
+
+	if user.ID == 0 {
+		passData, err := hasher.HashPassword(password)
+		if err != nil {
+			return 0, err
+		}
+
+		user.ID, err = s.SaveUser(ctx, email, passData.Hash, passData.Salt, passData.PepperVersion, storage.Profile{})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = s.SetAdmin(ctx, user.ID, true); err != nil {
+		return 0, err
+	}
+
+	return user.ID, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sso/internal/lib/hash"
+	"sso/internal/storage"
+	"sso/internal/storage/sqlite"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPassword = "admin-password"
+
+func newTestStorage(t *testing.T) *sqlite.Storage {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://../../migrations", "sqlite3://"+dbPath)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	srcErr, dbErr := m.Close()
+	require.NoError(t, srcErr)
+	require.NoError(t, dbErr)
+
+	s, err := sqlite.New(dbPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func newTestHasher(t *testing.T) *hash.Hasher {
+	t.Helper()
+
+	hasher, err := hash.NewHasher(nil, hash.NoPepper)
+	require.NoError(t, err)
+
+	return hasher
+}
+
+func TestSeedAdmin_CreatesFirstAdmin(t *testing.T) {
+	s := newTestStorage(t)
+	hasher := newTestHasher(t)
+	ctx := context.Background()
+
+	id, err := seedAdmin(ctx, s, hasher, "admin@example.com", testPassword, false)
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, "admin@example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.True(t, user.IsAdmin)
+	assert.NoError(t, hasher.ComparePassword(testPassword, user.PasswordSalt, user.PasswordHash, user.PepperVersion))
+
+	// Seeding the same admin again is a no-op.
+	again, err := seedAdmin(ctx, s, hasher, "admin@example.com", testPassword, false)
+	require.NoError(t, err)
+	assert.Equal(t, id, again)
+}
+
+func TestSeedAdmin_PromotesExistingUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	existingID, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), hash.NoPepper, storage.Profile{})
+	require.NoError(t, err)
+
+	id, err := seedAdmin(ctx, s, newTestHasher(t), "user@example.com", testPassword, false)
+	require.NoError(t, err)
+	assert.Equal(t, existingID, id)
+
+	isAdmin, err := s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+}
+
+func TestSeedAdmin_AdminExistsGuard(t *testing.T) {
+	s := newTestStorage(t)
+	hasher := newTestHasher(t)
+	ctx := context.Background()
+
+	_, err := seedAdmin(ctx, s, hasher, "first@example.com", testPassword, false)
+	require.NoError(t, err)
+
+	_, err = seedAdmin(ctx, s, hasher, "second@example.com", testPassword, false)
+	require.ErrorIs(t, err, errAdminExists)
+
+	_, err = s.User(ctx, "second@example.com", 0)
+	assert.Error(t, err, "refused seed must not create the user")
+
+	id, err := seedAdmin(ctx, s, hasher, "second@example.com", testPassword, true)
+	require.NoError(t, err)
+
+	isAdmin, err := s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+}
@@ -1,87 +1,162 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
 	"sso/internal/app"
+	"sso/internal/app/janitor"
 	"sso/internal/config"
-	"sso/internal/lib/logger/slogcute"
-	"sso/internal/storage/sqlite"
+	"sso/internal/lib/emailhash"
+	"sso/internal/lib/hash"
+	"sso/internal/lib/logger"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	_ "sso/internal/storage/sqlite" // registers the sqlite storage driver
 	"syscall"
 )
 
-const (
-	envLocal = "local"
-	envDev   = "dev"
-	envProd  = "prod"
-)
-
 func main() {
 	cfg := config.MustLoad()
 
-	log := setupLogger(cfg.Env)
+	logOut, closeLogOut := logger.Output(cfg.Log.File, cfg.Log.MaxSizeMB)
+	defer func() {
+		_ = closeLogOut()
+	}()
 
-	log.Info("Application started", slog.String("env", cfg.Env))
+	var logOpts []logger.Option
+	if cfg.Log.RedactPII != nil {
+		logOpts = append(logOpts, logger.WithRedactPII(*cfg.Log.RedactPII))
+	}
+	log := logger.New(cfg.Env, cfg.LogLevel, logOut, logOpts...)
 
-	storage, err := sqlite.New(cfg.StoragePath)
+	log.Info("Application started", slog.Any("config", cfg))
+
+	hashOpts := []hash.Option{
+		hash.WithConcurrencyLimit(cfg.Password.MaxConcurrentHashes, cfg.Password.HashQueueTimeout),
+		hash.WithVariant(hash.Variant(cfg.Password.Variant)),
+		hash.WithLengthCheck(hash.LengthCheck(cfg.Password.LengthCheck)),
+	}
+	if cfg.Password.CalibrateTarget > 0 {
+		params := hash.Calibrate(cfg.Password.CalibrateTarget)
+		log.Info("calibrated password hashing",
+			slog.Duration("target", cfg.Password.CalibrateTarget),
+			slog.Uint64("memory_kib", uint64(params.Memory)),
+			slog.Uint64("time", uint64(params.Time)),
+			slog.Uint64("threads", uint64(params.Threads)),
+		)
+		hashOpts = append(hashOpts, hash.WithParams(params))
+	}
+
+	hasher, err := hash.NewHasher(cfg.Password.Peppers, cfg.Password.PepperVersion, hashOpts...)
+	if err != nil {
+		log.Error("failed to init password hasher", slog.String("error", err.Error()))
+		_ = closeLogOut()
+		os.Exit(1)
+	}
+
+	// The storage hashes the emails of users itself; the service needs the
+	// same hashes for the emails it keeps alongside, see auth.WithEmailHashing.
+	var emails *emailhash.Hasher
+	if cfg.Storage.HashEmails {
+		if emails, err = emailhash.New(cfg.Storage.EmailHashKey); err != nil {
+			log.Error("failed to init email hashing", slog.String("error", err.Error()))
+			_ = closeLogOut()
+			os.Exit(1)
+		}
+	}
+
+	store, err := storage.Open(storage.Config{
+		StorageConfig:    cfg.Storage,
+		Path:             cfg.StoragePath,
+		ReplicaPath:      cfg.StorageReplicaPath,
+		OperationTimeout: cfg.GRPC.LongestTimeout(),
+		Log:              log,
+	})
 	if err != nil {
 		log.Error("failed to init storage", slog.String("error", err.Error()))
+		_ = closeLogOut()
 		os.Exit(1)
 	}
-	log.Info("storage initialized", slog.String("path", cfg.StoragePath))
+	log.Info("storage initialized",
+		slog.String("driver", cfg.Storage.Driver),
+		slog.String("path", cfg.StoragePath),
+		slog.String("replica_path", cfg.StorageReplicaPath),
+	)
 
 	application := app.New(
 		log,
-		storage,
-		storage,
-		cfg.GRPC.Port,
+		hasher,
+		store,
+		store,
+		cfg.GRPC,
+		cfg.JWT,
 		cfg.TokenTTL,
-		cfg.GRPC.Timeout,
+		auth.WithEmailVerification(cfg.EmailVerification.Required, cfg.EmailVerification.TokenTTL),
+		auth.WithPasswordResetTTL(cfg.Password.ResetTokenTTL),
+		auth.WithPasswordHistory(cfg.Password.History),
+		auth.WithMinPasswordAge(cfg.Password.MinAge),
+		auth.WithIdempotencyWindow(cfg.IdempotencyWindow),
+		auth.WithEmailHashing(emails),
+		auth.WithRememberMeTTL(cfg.RememberMeTTL),
+		auth.WithIDTokens(cfg.JWT.IDTokens),
+		auth.WithEmailCheck(cfg.EmailCheck.Enabled, ratelimit.Limit{RPS: cfg.EmailCheck.RPS, Burst: cfg.EmailCheck.Burst}),
+		auth.WithAppScopedRegistration(cfg.Registration.AppScoped, cfg.Registration.AllowedAppIDs),
+		auth.WithMaxSessions(cfg.Sessions.Max, auth.SessionLimitPolicy(cfg.Sessions.Policy)),
 	)
 
-	go application.GRPCSrv.MustRun()
+	if !cfg.Janitor.Disabled {
+		application.StartJanitor(janitor.New(log, store, cfg.Janitor, cfg.IdempotencyWindow))
+	}
+
+	readinessCtx, stopReadiness := context.WithCancel(context.Background())
+	go application.GRPCSrv.WatchReadiness(readinessCtx, store, cfg.GRPC.Health)
+
+	// A server that fails to bind or serve shuts the others down instead of
+	// panicking, so storage is still closed cleanly.
+	serveErr := make(chan error, 3)
+	run := func(srv interface{ Run() error }) {
+		if err := srv.Run(); err != nil {
+			serveErr <- err
+		}
+	}
+
+	go run(application.GRPCSrv)
+	if application.WebSrv != nil {
+		go run(application.WebSrv)
+	}
+	if application.GatewaySrv != nil {
+		go run(application.GatewaySrv)
+	}
 
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
-	<-stop
+	failed := false
+	select {
+	case <-stop:
+	case err := <-serveErr:
+		log.Error("server failed", slog.String("error", err.Error()))
+		failed = true
+	}
 
-	application.Stop()
+	stopReadiness()
+	if err := application.Stop(); err != nil {
+		log.Error("failed to stop gracefully", slog.String("error", err.Error()))
+	}
 
-	if err = storage.Close(); err != nil {
+	if err = store.Close(); err != nil {
 		log.Error("failed to close storage", slog.String("error", err.Error()))
 	}
 
 	log.Info("Gracefully stopped")
-}
 
-func setupLogger(env string) *slog.Logger {
-	switch env {
-	case envLocal:
-		return setupCuteSlog()
-	case envDev:
-		return slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
-	case envProd:
-		return slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		)
-	default:
-		panic("unknown environment: " + env)
-	}
-}
-
-func setupCuteSlog() *slog.Logger {
-	opts := slogcute.CuteHandlerOptions{
-		SlogOptions: &slog.HandlerOptions{
-			Level: slog.LevelDebug,
-		},
+	if failed {
+		// os.Exit skips deferred calls, so flush the log output first.
+		_ = closeLogOut()
+		os.Exit(1)
 	}
-
-	handler := opts.NewCuteHandler(os.Stdout)
-
-	return slog.New(handler)
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sso/internal/config"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage/sqlite"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
+}
+
+// run verifies the token given as the only positional argument against the public
+// keys of its app and prints its claims as JSON.
+func run(args []string, out io.Writer) error {
+	var (
+		configPath     string
+		dbPath         string
+		appID          int
+		insecureDecode bool
+	)
+
+	fs := flag.NewFlagSet("tokentool", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", "", "Path to config file; its storage_path and jwt issuer/audience are used")
+	fs.StringVar(&dbPath, "db", "./storage/sso.db", "Path to SQLite database (ignored with -config)")
+	fs.IntVar(&appID, "app-id", 0, "ID of the app whose keys verify the token (defaults to the token's app_id claim)")
+	fs.BoolVar(&insecureDecode, "insecure-decode", false, "Print the claims without verifying the signature or expiry")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "Usage: tokentool [flags] <token>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one token is required")
+	}
+	token := fs.Arg(0)
+
+	if insecureDecode {
+		claims, err := jwt.DecodeUnverified(token)
+		if err != nil {
+			return fmt.Errorf("failed to decode token: %w", err)
+		}
+		return printClaims(out, claims)
+	}
+
+	var jwtOpts []jwt.Option
+	if configPath != "" {
+		cfg := config.MustLoadByPath(configPath)
+		dbPath = cfg.StoragePath
+		jwtOpts = append(jwtOpts, jwt.WithIssuer(cfg.JWT.Issuer), jwt.WithAudience(cfg.JWT.Audience))
+	}
+
+	if appID == 0 {
+		claims, err := jwt.DecodeUnverified(token)
+		if err != nil {
+			return fmt.Errorf("failed to decode token: %w", err)
+		}
+		appID = claims.AppID
+	}
+
+	s, err := sqlite.New(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+
+	app, err := s.App(context.Background(), appID)
+	if err != nil {
+		return fmt.Errorf("failed to load app %d: %w", appID, err)
+	}
+
+	verify, err := jwt.New(slog.New(slog.NewTextHandler(io.Discard, nil)), jwtOpts...).ClaimsVerifier(app)
+	if err != nil {
+		return fmt.Errorf("failed to load keys of app %d: %w", appID, err)
+	}
+
+	claims, err := verify(token)
+	if err != nil {
+		return fmt.Errorf("token is invalid for app %d: %w", appID, err)
+	}
+
+	return printClaims(out, claims)
+}
+
+func printClaims(out io.Writer, claims *jwt.Claims) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(claims)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/keygen"
+	"sso/internal/storage"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAppID = 1
+
+// newTestDB creates a migrated database with one app and returns its path and the app.
+func newTestDB(t *testing.T) (string, models.App) {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://../../migrations", "sqlite3://"+dbPath)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	srcErr, dbErr := m.Close()
+	require.NoError(t, srcErr)
+	require.NoError(t, dbErr)
+
+	keyPair, err := keygen.GenerateRSAKeyPair(keygen.MinRSAKeyBits)
+	require.NoError(t, err)
+	app := models.App{ID: testAppID, Name: "test", PrivateKey: keyPair.PrivateKey, PublicKey: keyPair.PublicKey}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	_, err = db.Exec(`INSERT INTO apps (id, name, private_key, public_key) VALUES (?, ?, ?, ?)`,
+		app.ID, app.Name, app.PrivateKey, app.PublicKey)
+	require.NoError(t, err)
+
+	return dbPath, app
+}
+
+func newToken(t *testing.T, app models.App, ttl time.Duration) string {
+	t.Helper()
+
+	token, err := jwt.New(slog.New(slog.NewTextHandler(io.Discard, nil))).
+		NewToken(models.User{ID: 7, Email: "user@example.com"}, app, ttl)
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestRun_ValidToken(t *testing.T) {
+	dbPath, app := newTestDB(t)
+	token := newToken(t, app, time.Hour)
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"-db", dbPath, "-app-id", strconv.Itoa(testAppID), token}, &out))
+
+	var claims jwt.Claims
+	require.NoError(t, json.Unmarshal(out.Bytes(), &claims))
+	assert.Equal(t, int64(7), claims.UserID)
+	assert.Equal(t, "user@example.com", claims.Email)
+	assert.Equal(t, testAppID, claims.AppID)
+
+	out.Reset()
+	require.NoError(t, run([]string{"-db", dbPath, token}, &out), "app ID taken from the token")
+	assert.Contains(t, out.String(), `"uid": 7`)
+}
+
+func TestRun_ExpiredToken(t *testing.T) {
+	dbPath, app := newTestDB(t)
+	token := newToken(t, app, -time.Minute)
+
+	err := run([]string{"-db", dbPath, token}, io.Discard)
+	assert.ErrorContains(t, err, "expired")
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"-insecure-decode", token}, &out), "decoding skips verification")
+	assert.Contains(t, out.String(), `"uid": 7`)
+}
+
+func TestRun_UnknownApp(t *testing.T) {
+	dbPath, app := newTestDB(t)
+	token := newToken(t, app, time.Hour)
+
+	err := run([]string{"-db", dbPath, "-app-id", "42", token}, io.Discard)
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+}
+
+func TestRun_RequiresToken(t *testing.T) {
+	assert.Error(t, run([]string{"-db", "unused.db"}, io.Discard))
+}
@@ -1,36 +1,147 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"net"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	"sso/internal/app/janitor"
+	"sso/internal/config"
+	"sso/internal/grpc/gateway"
+	"sso/internal/lib/hash"
 	"sso/internal/lib/jwt"
+	"sso/internal/lib/vault"
 	"sso/internal/services/auth"
+	"strconv"
+	"sync"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type App struct {
 	GRPCSrv *grpcapp.App
+	// WebSrv serves gRPC-Web; nil unless enabled in the config.
+	WebSrv *httpapp.App
+	// GatewaySrv serves JSON over HTTP; nil unless enabled in the config.
+	GatewaySrv *httpapp.App
+
+	gatewayConn     *grpc.ClientConn
+	shutdownTimeout time.Duration
+
+	// stopJanitor stops the janitor started by StartJanitor and waits for it;
+	// nil if none was started.
+	stopJanitor func()
 }
 
 func New(log *slog.Logger,
+	hasher *hash.Hasher,
 	userProvider auth.UserProvider,
 	appProvider auth.AppProvider,
-	grpcPort int,
+	grpcCfg config.GRPCConfig,
+	jwtCfg config.JWTConfig,
 	tokenTTL time.Duration,
-	operationTimeout time.Duration,
+	authOpts ...auth.Option,
 ) *App {
-	jwtProvider := jwt.New(log)
+	jwtOpts := []jwt.Option{
+		jwt.WithIssuer(jwtCfg.Issuer),
+		jwt.WithAudience(jwtCfg.Audience),
+		jwt.WithMaxTTL(jwtCfg.MaxTTL),
+		jwt.WithLeeway(jwtCfg.Leeway),
+	}
+	if jwtCfg.Signer == "vault" {
+		transit := vault.NewTransit(jwtCfg.Vault.Address, jwtCfg.Vault.Mount, jwtCfg.Vault.Token, nil)
+		jwtOpts = append(jwtOpts, jwt.WithSigner(jwt.NewKMSSigner(transit, jwtCfg.Vault.KeyIDs, jwtCfg.Vault.Timeout)))
+	}
+	jwtProvider := jwt.New(log, jwtOpts...)
+
+	authService := auth.New(log, hasher, userProvider, appProvider, jwtProvider, tokenTTL, authOpts...)
+
+	grpcApp := grpcapp.New(log, authService, grpcCfg)
 
-	authService := auth.New(log, userProvider, appProvider, jwtProvider, tokenTTL)
+	var webApp *httpapp.App
+	if grpcCfg.Web.Enabled {
+		webApp = httpapp.New(log, "grpc-web", grpcApp.WebHandler(grpcCfg.Web.AllowedOrigins), grpcCfg.Web.Port)
+	}
 
-	grpcApp := grpcapp.New(log, authService, grpcPort, operationTimeout)
+	application := &App{
+		GRPCSrv:         grpcApp,
+		WebSrv:          webApp,
+		shutdownTimeout: grpcCfg.ShutdownTimeout,
+	}
 
-	return &App{
-		GRPCSrv: grpcApp,
+	if grpcCfg.Gateway.Enabled {
+		application.gatewayConn, application.GatewaySrv = newGateway(log, grpcCfg)
 	}
+
+	return application
 }
 
-// Stop gracefully stops the application.
-func (a *App) Stop() {
-	a.GRPCSrv.Stop()
+// newGateway creates the JSON/HTTP gateway, which calls the gRPC server over loopback.
+func newGateway(log *slog.Logger, grpcCfg config.GRPCConfig) (*grpc.ClientConn, *httpapp.App) {
+	cc, err := grpc.NewClient(
+		net.JoinHostPort("localhost", strconv.Itoa(grpcCfg.Port)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		panic("failed to create gateway client: " + err.Error())
+	}
+
+	handler, err := gateway.NewHandler(cc)
+	if err != nil {
+		panic("failed to create gateway handler: " + err.Error())
+	}
+
+	return cc, httpapp.New(log, "gateway", handler, grpcCfg.Gateway.Port)
+}
+
+// StartJanitor runs j in the background until the application stops.
+func (a *App) StartJanitor(j *janitor.Janitor) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		j.Run(ctx)
+	}()
+
+	a.stopJanitor = func() {
+		cancel()
+		<-done
+	}
+}
+
+// Stop gracefully stops the application. Health reports NOT_SERVING first, then
+// all servers drain in parallel for up to the shutdown timeout, after which the
+// connections still open are closed. The janitor, if started, is stopped
+// along with them. It returns the errors of all servers.
+func (a *App) Stop() error {
+	a.GRPCSrv.SetNotServing()
+
+	ctx := context.Background()
+	if a.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.shutdownTimeout)
+		defer cancel()
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, 3)
+	)
+	wg.Go(func() { errs[0] = a.GRPCSrv.Stop(ctx) })
+	if a.WebSrv != nil {
+		wg.Go(func() { errs[1] = a.WebSrv.Stop(ctx) })
+	}
+	if a.GatewaySrv != nil {
+		wg.Go(func() { errs[2] = errors.Join(a.GatewaySrv.Stop(ctx), a.gatewayConn.Close()) })
+	}
+	if a.stopJanitor != nil {
+		wg.Go(a.stopJanitor)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
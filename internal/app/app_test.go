@@ -0,0 +1,73 @@
+package app
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sso/internal/app/janitor"
+	"sso/internal/config"
+	"sso/internal/lib/hash"
+	"sso/internal/storage/sqlite"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	return l
+}
+
+func TestApp_StopLeavesNoListeners(t *testing.T) {
+	storage, err := sqlite.New(filepath.Join(t.TempDir(), "sso.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+	hasher, err := hash.NewHasher(nil, hash.NoPepper)
+	require.NoError(t, err)
+
+	grpcL, webL, gatewayL := listen(t), listen(t), listen(t)
+
+	cfg := config.MustLoadByPath("../../config/local.yaml")
+	cfg.GRPC.Port = grpcL.Addr().(*net.TCPAddr).Port
+	cfg.GRPC.Web.Enabled = true
+	cfg.GRPC.Gateway.Enabled = true
+	cfg.GRPC.ShutdownTimeout = 5 * time.Second
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	application := New(log, hasher, storage, storage, cfg.GRPC, cfg.JWT, cfg.TokenTTL)
+	application.StartJanitor(janitor.New(log, storage, config.JanitorConfig{Interval: time.Millisecond}, time.Hour))
+
+	served := make(chan error, 3)
+	go func() { served <- application.GRPCSrv.Serve(grpcL) }()
+	go func() { served <- application.WebSrv.Serve(webL) }()
+	go func() { served <- application.GatewaySrv.Serve(gatewayL) }()
+
+	addrs := []string{grpcL.Addr().String(), webL.Addr().String(), gatewayL.Addr().String()}
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err, "%s is listening", addr)
+		_ = conn.Close()
+	}
+
+	require.NoError(t, application.Stop())
+
+	for range addrs {
+		select {
+		case err := <-served:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			require.FailNow(t, "a server is still serving after Stop")
+		}
+	}
+	for _, addr := range addrs {
+		_, err := net.Dial("tcp", addr)
+		assert.Error(t, err, "%s is no longer listening", addr)
+	}
+}
@@ -1,52 +1,159 @@
 package grpcapp
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"slices"
+	"sso/internal/config"
 	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/lib/ratelimit"
 	"sso/internal/services/auth"
-	"time"
+	"strings"
+	"sync/atomic"
 
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
 type App struct {
 	log        *slog.Logger
 	gRPCServer *grpc.Server
+	health     *health.Server
 	port       int
+
+	// shuttingDown is set by SetNotServing; new calls are rejected from then on.
+	shuttingDown *atomic.Bool
 }
 
-func New(log *slog.Logger, authService auth.Service, port int, operationTimeout time.Duration) *App {
-	grpcServer := grpc.NewServer()
+func New(log *slog.Logger, authService auth.Service, cfg config.GRPCConfig) *App {
+	shuttingDown := new(atomic.Bool)
+
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(cfg.MaxSendMsgSize),
+		grpc.MaxConcurrentStreams(uint32(cfg.MaxConcurrentStreams)),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     cfg.Keepalive.MaxConnectionIdle,
+			MaxConnectionAge:      cfg.Keepalive.MaxConnectionAge,
+			MaxConnectionAgeGrace: cfg.Keepalive.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.Keepalive.MinPingInterval,
+			PermitWithoutStream: cfg.Keepalive.PermitWithoutStream,
+		}),
+		grpc.ChainUnaryInterceptor(
+			shutdownInterceptor(shuttingDown),
+			authgrpc.OpInterceptor(),
+			authgrpc.FieldLimitsInterceptor(cfg.MaxPasswordLength),
+			authgrpc.AuthInterceptor(authService, cfg.Timeout, cfg.ProtectedMethods),
+		),
+	}
+
+	if cfg.TLS.Enabled() {
+		creds, err := serverCredentials(cfg.TLS)
+		if err != nil {
+			panic("failed to load TLS credentials: " + err.Error())
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+
+	authgrpc.Register(grpcServer, authService, authgrpc.Timeouts{
+		Default: cfg.Timeout,
+		Methods: cfg.MethodTimeouts,
+	}, newLoginLimiter(cfg.RateLimit))
+
+	// Not ready until WatchReadiness sees storage respond.
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
 
-	authgrpc.Register(grpcServer, authService, operationTimeout)
 	return &App{
-		log:        log,
-		gRPCServer: grpcServer,
-		port:       port,
+		log:          log,
+		gRPCServer:   grpcServer,
+		health:       healthServer,
+		port:         cfg.Port,
+		shuttingDown: shuttingDown,
+	}
+}
+
+// shutdownInterceptor rejects new calls with Unavailable once shuttingDown is
+// set, so clients retry them on another instance instead of starting work that
+// the shutdown would cut off. Calls already in flight are not affected, and the
+// health service keeps answering, so checkers see NOT_SERVING.
+func shutdownInterceptor(shuttingDown *atomic.Bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if shuttingDown.Load() && !strings.HasPrefix(info.FullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+			return nil, status.Error(codes.Unavailable, "server shutting down")
+		}
+
+		return handler(ctx, req)
 	}
 }
 
+func newLoginLimiter(cfg config.RateLimitConfig) *ratelimit.Keyed[int] {
+	overrides := make(map[int]ratelimit.Limit, len(cfg.LoginPerAppOverrides))
+	for appID, l := range cfg.LoginPerAppOverrides {
+		overrides[appID] = ratelimit.Limit{RPS: l.RPS, Burst: l.Burst}
+	}
+
+	return ratelimit.NewKeyed(ratelimit.Limit{RPS: cfg.LoginPerApp.RPS, Burst: cfg.LoginPerApp.Burst}, overrides)
+}
+
+// WebHandler exposes the gRPC services to browsers over gRPC-Web on HTTP/1.1.
+// CORS requests are allowed from allowedOrigins; "*" allows any origin.
+func (a *App) WebHandler(allowedOrigins []string) http.Handler {
+	allowAny := slices.Contains(allowedOrigins, "*")
+
+	return grpcweb.WrapServer(a.gRPCServer,
+		grpcweb.WithOriginFunc(func(origin string) bool {
+			return allowAny || slices.Contains(allowedOrigins, origin)
+		}),
+		// The headers grpc-web clients send, plus bearer tokens for protected methods.
+		grpcweb.WithAllowedRequestHeaders([]string{
+			"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout", "authorization",
+		}),
+	)
+}
+
+// MustRun is like Run but panics on error.
 func (a *App) MustRun() {
 	if err := a.Run(); err != nil {
 		panic(err)
 	}
 }
 
+// Run listens on the configured port and serves until the app is stopped.
+// It returns an error if the port can't be bound or serving fails.
 func (a *App) Run() error {
 	const op = "grpcapp.Run"
 
-	log := a.log.With(slog.String("op", op),
-		slog.Int("port", a.port),
-	)
-
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
 
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	return a.Serve(l)
+}
+
+// Serve accepts gRPC connections on the given listener, e.g. one bound to an ephemeral port.
+func (a *App) Serve(l net.Listener) error {
+	const op = "grpcapp.Serve"
+
+	log := a.log.With(slog.String("op", op),
+		slog.Int("port", a.port),
+	)
+
 	log.Info("gRPC server is running", slog.String("addr", l.Addr().String()))
 
 	if err := a.gRPCServer.Serve(l); err != nil {
@@ -55,11 +162,37 @@ func (a *App) Run() error {
 	return nil
 }
 
-func (a *App) Stop() {
+// SetNotServing makes the health service report NOT_SERVING for good, so
+// health checkers route traffic elsewhere before the servers start draining,
+// and rejects new calls with Unavailable from then on.
+func (a *App) SetNotServing() {
+	a.shuttingDown.Store(true)
+	a.health.Shutdown()
+}
+
+// Stop reports NOT_SERVING, stops accepting connections and waits for in-flight
+// RPCs to finish until ctx is done, then closes the connections still open.
+func (a *App) Stop(ctx context.Context) error {
 	const op = "grpcapp.Stop"
 
-	a.log.With(slog.String("op", op)).
-		Info("stopping gRPC server", slog.Int("port", a.port))
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping gRPC server", slog.Int("port", a.port))
+
+	a.SetNotServing()
 
-	a.gRPCServer.GracefulStop()
+	stopped := make(chan struct{})
+	go func() {
+		a.gRPCServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		log.Error("failed to stop gRPC server gracefully", slog.String("error", ctx.Err().Error()))
+		a.gRPCServer.Stop()
+		<-stopped
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
 }
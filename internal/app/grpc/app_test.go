@@ -0,0 +1,406 @@
+package grpcapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const testMsgSize = 4 << 20
+
+type stubAuthService struct{}
+
+func (stubAuthService) Login(context.Context, string, string, int) (string, error) {
+	return "token", nil
+}
+
+func (stubAuthService) Register(context.Context, string, string) (int64, error) {
+	return 1, nil
+}
+
+func (stubAuthService) AppPublicKey(context.Context, int) (auth.AppPublicKey, error) {
+	return auth.AppPublicKey{}, nil
+}
+
+func (stubAuthService) LoginWithIDToken(context.Context, string, string, int) (auth.LoginTokens, error) {
+	return auth.LoginTokens{}, nil
+}
+
+func (stubAuthService) LoginWithOptions(context.Context, string, string, int, auth.LoginOptions) (auth.LoginTokens, error) {
+	return auth.LoginTokens{AccessToken: "token"}, nil
+}
+
+func (stubAuthService) Diagnostics(context.Context, string) (storage.Diagnostics, error) {
+	return storage.Diagnostics{OK: true}, nil
+}
+
+func (stubAuthService) CheckEmail(context.Context, string, int) (bool, error) {
+	return false, nil
+}
+
+func (stubAuthService) WatchAuthEvents(context.Context, string) (<-chan auth.AuthEvent, error) {
+	return nil, nil
+}
+
+func (stubAuthService) SelfTest(context.Context, string, int) (auth.SelfTestResult, error) {
+	return auth.SelfTestResult{}, nil
+}
+
+func (stubAuthService) VerifyPassword(context.Context, string, string, int) error {
+	return nil
+}
+
+func (stubAuthService) RegisterInApp(context.Context, string, string, int) (int64, error) {
+	return 0, nil
+}
+
+func (stubAuthService) RegisterWithProfile(context.Context, string, string, storage.Profile) (int64, error) {
+	return 1, nil
+}
+
+func (stubAuthService) RegisterIdempotent(context.Context, string, string, string) (int64, error) {
+	return 1, nil
+}
+
+func (stubAuthService) IsAdmin(context.Context, int64) (bool, error) {
+	return false, nil
+}
+
+func (stubAuthService) ImportUsers(_ context.Context, users []storage.UserImport, _ bool) ([]storage.ImportResult, error) {
+	return make([]storage.ImportResult, len(users)), nil
+}
+
+func (stubAuthService) WhoAmI(context.Context, string) (models.User, error) {
+	return models.User{}, nil
+}
+
+func (stubAuthService) BatchVerifyTokens(_ context.Context, checks []auth.TokenCheck) ([]auth.TokenCheckResult, error) {
+	return make([]auth.TokenCheckResult, len(checks)), nil
+}
+
+func (stubAuthService) Stats(context.Context) (auth.Stats, error) {
+	return auth.Stats{}, nil
+}
+
+func (stubAuthService) DeleteUser(context.Context, int64) error {
+	return nil
+}
+
+func (stubAuthService) RestoreUser(context.Context, int64) error {
+	return nil
+}
+
+func (stubAuthService) SetUserEnabled(context.Context, string, int64, bool) error {
+	return nil
+}
+
+func (stubAuthService) RequestEmailVerification(context.Context, int64) (string, error) {
+	return "token", nil
+}
+
+func (stubAuthService) VerifyEmail(context.Context, string) (int64, error) {
+	return 1, nil
+}
+
+func (stubAuthService) RequestPasswordReset(context.Context, string, int) (string, error) {
+	return "token", nil
+}
+
+func (stubAuthService) ResetPassword(context.Context, string, string) error {
+	return nil
+}
+
+func (stubAuthService) ChangePassword(context.Context, int64, string, string) error {
+	return nil
+}
+
+func (stubAuthService) ListSessions(context.Context, int64) ([]models.Session, error) {
+	return nil, nil
+}
+
+func (stubAuthService) RevokeSession(context.Context, int64, int64) error {
+	return nil
+}
+
+func testGRPCConfig() config.GRPCConfig {
+	return config.GRPCConfig{
+		Timeout:        time.Second,
+		MaxRecvMsgSize: testMsgSize,
+		MaxSendMsgSize: testMsgSize,
+
+		MaxConcurrentStreams: 100,
+
+		MaxPasswordLength: 1024,
+	}
+}
+
+// serve starts the app on an ephemeral port and returns a client connected to it.
+func serve(t *testing.T, cfg config.GRPCConfig) ssov1.AuthClient {
+	t.Helper()
+
+	return ssov1.NewAuthClient(dial(t, cfg))
+}
+
+// dial starts the app on an ephemeral port and returns a connection to it.
+func dial(t *testing.T, cfg config.GRPCConfig) *grpc.ClientConn {
+	t.Helper()
+
+	_, cc := start(t, cfg)
+
+	return cc
+}
+
+// start starts the app on an ephemeral port and returns it with a connection to it.
+func start(t *testing.T, cfg config.GRPCConfig) (*App, *grpc.ClientConn) {
+	t.Helper()
+
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuthService{}, cfg)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = a.Serve(l)
+	}()
+	t.Cleanup(func() { _ = a.Stop(context.Background()) })
+
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+
+	return a, cc
+}
+
+func TestMaxRecvMsgSize(t *testing.T) {
+	cfg := testGRPCConfig()
+	cfg.MaxRecvMsgSize = 1024
+	client := serve(t, cfg)
+
+	_, err := client.Register(context.Background(), &ssov1.RegisterRequest{
+		Email:    "user@example.com",
+		Password: "password",
+	})
+	require.NoError(t, err)
+
+	_, err = client.Register(context.Background(), &ssov1.RegisterRequest{
+		Email:    "user@example.com",
+		Password: strings.Repeat("x", 2048),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	cfg := testGRPCConfig()
+	cfg.MaxConcurrentStreams = 1
+	cc := dial(t, cfg)
+	client := ssov1.NewAuthClient(cc)
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	watch, err := healthpb.NewHealthClient(cc).Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	require.NoError(t, err)
+
+	// The open stream takes the only slot: the client holds the call back
+	// instead of starting a second stream on the connection.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = client.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: 1})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	stopWatch()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: 1})
+	assert.NoError(t, err)
+}
+
+func TestKeepalive_ClosesIdleConnection(t *testing.T) {
+	cfg := testGRPCConfig()
+	cfg.Keepalive.MaxConnectionIdle = 100 * time.Millisecond
+	cc := dial(t, cfg)
+
+	_, err := ssov1.NewAuthClient(cc).IsAdmin(context.Background(), &ssov1.IsAdminRequest{UserId: 1})
+	require.NoError(t, err)
+	require.Equal(t, connectivity.Ready, cc.GetState())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The server sends GOAWAY once the connection has been idle for too long,
+	// which moves the client out of READY.
+	assert.True(t, cc.WaitForStateChange(ctx, connectivity.Ready), "idle connection was not closed")
+}
+
+func TestLoginRateLimit_PerApp(t *testing.T) {
+	cfg := testGRPCConfig()
+	cfg.RateLimit.LoginPerApp = config.LimitConfig{RPS: 0.001, Burst: 2}
+	client := serve(t, cfg)
+
+	login := func(appID int32) error {
+		_, err := client.Login(context.Background(), &ssov1.LoginRequest{
+			Email:    "user@example.com",
+			Password: "password",
+			AppId:    appID,
+		})
+		return err
+	}
+
+	require.NoError(t, login(1))
+	require.NoError(t, login(1))
+
+	err := login(1)
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// A noisy app does not eat into another app's budget.
+	assert.NoError(t, login(2))
+}
+
+func TestProtectedMethods(t *testing.T) {
+	cfg := testGRPCConfig()
+	cfg.ProtectedMethods = []string{ssov1.Auth_IsAdmin_FullMethodName}
+	client := serve(t, cfg)
+
+	_, err := client.IsAdmin(context.Background(), &ssov1.IsAdminRequest{UserId: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+	_, err = client.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: 1})
+	assert.NoError(t, err)
+
+	_, err = client.Register(context.Background(), &ssov1.RegisterRequest{Email: "user@example.com", Password: "password"})
+	assert.NoError(t, err, "unlisted methods stay public")
+}
+
+func TestShutdown_RejectsNewCalls(t *testing.T) {
+	a, cc := start(t, testGRPCConfig())
+	client := ssov1.NewAuthClient(cc)
+
+	_, err := client.IsAdmin(context.Background(), &ssov1.IsAdminRequest{UserId: 1})
+	require.NoError(t, err)
+
+	a.SetNotServing()
+
+	_, err = client.IsAdmin(context.Background(), &ssov1.IsAdminRequest{UserId: 1})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "server shutting down", status.Convert(err).Message())
+
+	resp, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err, "health checks are still answered")
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.GetStatus())
+}
+
+// grpcWebFrame wraps msg in a gRPC-Web data frame: a flags byte, a big-endian
+// length and the message.
+func grpcWebFrame(t *testing.T, msg proto.Message) []byte {
+	t.Helper()
+
+	payload, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+
+	return append(frame, payload...)
+}
+
+func TestWebHandler_Register(t *testing.T) {
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuthService{}, testGRPCConfig())
+	srv := httptest.NewServer(a.WebHandler([]string{"https://app.example.com"}))
+	t.Cleanup(srv.Close)
+
+	body := grpcWebFrame(t, &ssov1.RegisterRequest{Email: "user@example.com", Password: "password"})
+	req, err := http.NewRequest(http.MethodPost, srv.URL+ssov1.Auth_Register_FullMethodName, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("Origin", "https://app.example.com")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	raw, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(raw), 5)
+	require.Zero(t, raw[0], "the first frame carries the message")
+	size := binary.BigEndian.Uint32(raw[1:5])
+
+	var registered ssov1.RegisterResponse
+	require.NoError(t, proto.Unmarshal(raw[5:5+size], &registered))
+	assert.Equal(t, int64(1), registered.GetUserId())
+	assert.Contains(t, string(raw[5+size:]), "grpc-status: 0", "trailers report success")
+}
+
+func TestWebHandler_CORS(t *testing.T) {
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuthService{}, testGRPCConfig())
+	srv := httptest.NewServer(a.WebHandler([]string{"https://app.example.com"}))
+	t.Cleanup(srv.Close)
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, srv.URL+ssov1.Auth_Login_FullMethodName, nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,authorization")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+
+		return resp
+	}
+
+	resp := preflight("https://app.example.com")
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, strings.ToLower(resp.Header.Get("Access-Control-Allow-Headers")), "authorization")
+
+	resp = preflight("https://evil.example.com")
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestRun_PortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	cfg := testGRPCConfig()
+	cfg.Port = l.Addr().(*net.TCPAddr).Port
+
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuthService{}, cfg)
+
+	assert.NotPanics(t, func() {
+		err = a.Run()
+	})
+	assert.ErrorIs(t, err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,80 @@
+package grpcapp
+
+import (
+	"context"
+	"log/slog"
+	"sso/internal/config"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Pinger is a dependency the server cannot serve without, e.g. storage.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Reconnector is a Pinger that can replace its connections, e.g. storage whose
+// database file was swapped underneath it.
+type Reconnector interface {
+	Pinger
+	Reconnect(ctx context.Context) error
+}
+
+// WatchReadiness pings p every cfg.CheckInterval until ctx is done and reports
+// the result on the gRPC health service: SERVING once a ping succeeds and
+// NOT_SERVING after cfg.FailureThreshold failed pings in a row, so load
+// balancers stop routing traffic to an instance that lost its storage.
+// If p is a Reconnector, it is also asked to reconnect after every
+// cfg.FailureThreshold failed pings in a row.
+func (a *App) WatchReadiness(ctx context.Context, p Pinger, cfg config.HealthConfig) {
+	const op = "grpcapp.WatchReadiness"
+
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(cfg.CheckInterval)
+	defer ticker.Stop()
+
+	var (
+		failures int
+		serving  bool
+	)
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, cfg.CheckTimeout)
+		err := p.Ping(pingCtx)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			failures = 0
+			if !serving {
+				serving = true
+				log.Info("storage is reachable, serving")
+				a.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+			}
+		default:
+			failures++
+			log.Warn("storage ping failed", slog.Int("failures", failures), slog.String("error", err.Error()))
+			if serving && failures >= cfg.FailureThreshold {
+				serving = false
+				log.Error("storage is unreachable, not serving")
+				a.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			}
+			if r, ok := p.(Reconnector); ok && failures%cfg.FailureThreshold == 0 {
+				reconnectCtx, cancel := context.WithTimeout(ctx, cfg.CheckTimeout)
+				if err := r.Reconnect(reconnectCtx); err != nil {
+					log.Warn("storage reconnect failed", slog.String("error", err.Error()))
+				}
+				cancel()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package grpcapp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sso/internal/config"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// flakyPinger fails while down is set.
+type flakyPinger struct {
+	down atomic.Bool
+}
+
+func (p *flakyPinger) Ping(context.Context) error {
+	if p.down.Load() {
+		return errors.New("database is unreachable")
+	}
+	return nil
+}
+
+func TestWatchReadiness(t *testing.T) {
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuthService{}, testGRPCConfig())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = a.Serve(l)
+	}()
+	t.Cleanup(func() { _ = a.Stop(context.Background()) })
+
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+	client := healthpb.NewHealthClient(cc)
+
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		return resp.GetStatus()
+	}
+
+	pinger := &flakyPinger{}
+	pinger.down.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go a.WatchReadiness(ctx, pinger, config.HealthConfig{
+		CheckInterval:    10 * time.Millisecond,
+		CheckTimeout:     time.Second,
+		FailureThreshold: 3,
+	})
+
+	// Storage is not reachable yet.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status())
+
+	pinger.down.Store(false)
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 5*time.Millisecond, "serving once storage responds")
+
+	pinger.down.Store(true)
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 5*time.Millisecond, "not serving after repeated failures")
+}
+
+// deadPoolPinger fails until it is reconnected.
+type deadPoolPinger struct {
+	flakyPinger
+	reconnects atomic.Int32
+}
+
+func (p *deadPoolPinger) Reconnect(context.Context) error {
+	p.reconnects.Add(1)
+	p.down.Store(false)
+	return nil
+}
+
+func TestWatchReadiness_Reconnects(t *testing.T) {
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuthService{}, testGRPCConfig())
+
+	pinger := &deadPoolPinger{}
+	pinger.down.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go a.WatchReadiness(ctx, pinger, config.HealthConfig{
+		CheckInterval:    10 * time.Millisecond,
+		CheckTimeout:     time.Second,
+		FailureThreshold: 3,
+	})
+
+	assert.Eventually(t, func() bool {
+		return pinger.reconnects.Load() == 1 && !pinger.down.Load()
+	}, time.Second, 5*time.Millisecond, "reconnects after failure_threshold failed pings")
+
+	// Healthy again: no further reconnects.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), pinger.reconnects.Load())
+}
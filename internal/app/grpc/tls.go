@@ -0,0 +1,45 @@
+package grpcapp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sso/internal/config"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// serverCredentials loads the server certificate and, when a client CA is
+// configured, requires clients to present a certificate it has signed.
+func serverCredentials(cfg config.TLSConfig) (credentials.TransportCredentials, error) {
+	const op = "grpcapp.serverCredentials"
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCA != "" {
+		caPEM, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%s: %w", op, errors.New("no certificates found in client CA file"))
+		}
+
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}
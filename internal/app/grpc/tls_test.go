@@ -0,0 +1,168 @@
+package grpcapp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sso/internal/config"
+	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/services/auth"
+	"testing"
+	"time"
+
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a certificate for name signed by the CA.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"example"}},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeTLSFiles writes the server certificate, its key and the client CA to
+// PEM files and returns the matching config.
+func writeTLSFiles(t *testing.T, server tls.Certificate, clientCA *testCA) config.TLSConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(server.PrivateKey)
+	require.NoError(t, err)
+
+	cfg := config.TLSConfig{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		ClientCA: filepath.Join(dir, "ca.pem"),
+	}
+	require.NoError(t, os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	require.NoError(t, os.WriteFile(cfg.ClientCA, clientCA.pem, 0600))
+
+	return cfg
+}
+
+// subjectAuthService answers Login with the caller's client certificate subject.
+type subjectAuthService struct {
+	stubAuthService
+}
+
+func (subjectAuthService) LoginWithOptions(ctx context.Context, _ string, _ string, _ int, _ auth.LoginOptions) (auth.LoginTokens, error) {
+	subject, _ := authgrpc.ClientCertSubject(ctx)
+	return auth.LoginTokens{AccessToken: subject}, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	cfg := testGRPCConfig()
+	cfg.TLS = writeTLSFiles(t, ca.issue(t, "127.0.0.1", x509.ExtKeyUsageServerAuth), ca)
+
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), subjectAuthService{}, cfg)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = a.Serve(l)
+	}()
+	t.Cleanup(func() { _ = a.Stop(context.Background()) })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	login := func(t *testing.T, clientCerts ...tls.Certificate) (string, error) {
+		creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: clientCerts})
+		cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = cc.Close() })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		resp, err := ssov1.NewAuthClient(cc).Login(ctx, &ssov1.LoginRequest{
+			Email:    "user@example.com",
+			Password: "password",
+			AppId:    1,
+		})
+		return resp.GetToken(), err
+	}
+
+	t.Run("valid certificate", func(t *testing.T) {
+		subject, err := login(t, ca.issue(t, "billing", x509.ExtKeyUsageClientAuth))
+		require.NoError(t, err)
+		assert.Equal(t, "CN=billing,O=example", subject, "handlers see the client identity")
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		_, err := login(t)
+		assert.Error(t, err)
+	})
+
+	t.Run("untrusted CA", func(t *testing.T) {
+		untrusted := newTestCA(t, "other-ca")
+		_, err := login(t, untrusted.issue(t, "billing", x509.ExtKeyUsageClientAuth))
+		assert.Error(t, err)
+	})
+}
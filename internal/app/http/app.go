@@ -0,0 +1,78 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// App serves an HTTP handler next to the gRPC server, e.g. gRPC-Web for browsers.
+type App struct {
+	log    *slog.Logger
+	name   string
+	server *http.Server
+	port   int
+}
+
+// New creates an HTTP server for handler on port. name identifies it in logs.
+func New(log *slog.Logger, name string, handler http.Handler, port int) *App {
+	return &App{
+		log:    log,
+		name:   name,
+		server: &http.Server{Handler: handler},
+		port:   port,
+	}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return a.Serve(l)
+}
+
+// Serve accepts HTTP connections on the given listener, e.g. one bound to an ephemeral port.
+func (a *App) Serve(l net.Listener) error {
+	const op = "httpapp.Serve"
+
+	log := a.log.With(slog.String("op", op),
+		slog.String("server", a.name),
+		slog.Int("port", a.port),
+	)
+
+	log.Info("HTTP server is running", slog.String("addr", l.Addr().String()))
+
+	if err := a.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Stop stops accepting connections and waits for in-flight requests to finish
+// until ctx is done, then closes the connections still open.
+func (a *App) Stop(ctx context.Context) error {
+	const op = "httpapp.Stop"
+
+	log := a.log.With(slog.String("op", op), slog.String("server", a.name))
+	log.Info("stopping HTTP server", slog.Int("port", a.port))
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		log.Error("failed to stop HTTP server gracefully", slog.String("error", err.Error()))
+		return errors.Join(fmt.Errorf("%s: %s: %w", op, a.name, err), a.server.Close())
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package httpapp
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStop_ClosesConnectionsAfterTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), "test", handler, 0)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = a.Serve(l)
+	}()
+
+	requestErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		requestErr <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Stop(ctx), context.DeadlineExceeded)
+
+	select {
+	case err := <-requestErr:
+		assert.Error(t, err, "the stuck request is cut off")
+	case <-time.After(time.Second):
+		require.FailNow(t, "the stuck request is still open after Stop")
+	}
+}
@@ -0,0 +1,100 @@
+// Package janitor deletes the rows storage keeps after they expire: sessions,
+// one-time tokens and idempotency keys.
+package janitor
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sso/internal/config"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"time"
+)
+
+// Purger deletes expired rows, e.g. storage.
+type Purger interface {
+	PurgeExpired(ctx context.Context, expiredBefore time.Time, keysBefore time.Time) (storage.PurgeResult, error)
+}
+
+// Janitor periodically purges expired rows from a Purger.
+type Janitor struct {
+	log    *slog.Logger
+	purger Purger
+
+	interval time.Duration
+	jitter   time.Duration
+	// keyTTL is how long idempotency keys are kept, the idempotency window.
+	keyTTL time.Duration
+
+	now func() time.Time
+}
+
+// New creates a Janitor purging p as configured by cfg. Idempotency keys are
+// kept for keyTTL, the window in which Register honors them.
+func New(log *slog.Logger, p Purger, cfg config.JanitorConfig, keyTTL time.Duration) *Janitor {
+	return &Janitor{
+		log:      log,
+		purger:   p,
+		interval: cfg.Interval,
+		jitter:   cfg.Jitter,
+		keyTTL:   keyTTL,
+		now:      time.Now,
+	}
+}
+
+// Run purges expired rows every interval plus jitter until ctx is done. The
+// first purge also waits, so restarting instances don't purge at once.
+func (j *Janitor) Run(ctx context.Context) {
+	const op = "janitor.Run"
+
+	log := j.log.With(slog.String("op", op))
+
+	timer := time.NewTimer(j.delay())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		result, err := j.Purge(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Error("failed to purge expired rows", slog.String("error", err.Error()))
+		case result.Total() > 0:
+			log.Info("purged expired rows",
+				slog.Int64("sessions", result.Sessions),
+				slog.Int64("email_verification_tokens", result.EmailVerificationTokens),
+				slog.Int64("password_reset_tokens", result.PasswordResetTokens),
+				slog.Int64("idempotency_keys", result.IdempotencyKeys),
+			)
+		default:
+			log.Debug("no expired rows to purge")
+		}
+
+		timer.Reset(j.delay())
+	}
+}
+
+// Purge deletes the rows expired by now once. Sessions are kept for
+// jwt.MaxLeeway past their expiry: their tokens may still verify until then,
+// and a revoked session must not be forgotten before its token is rejected.
+func (j *Janitor) Purge(ctx context.Context) (storage.PurgeResult, error) {
+	now := j.now()
+
+	return j.purger.PurgeExpired(ctx, now.Add(-jwt.MaxLeeway), now.Add(-j.keyTTL))
+}
+
+// delay returns the time until the next purge.
+func (j *Janitor) delay() time.Duration {
+	if j.jitter <= 0 {
+		return j.interval
+	}
+
+	return j.interval + rand.N(j.jitter)
+}
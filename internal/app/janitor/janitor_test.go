@@ -0,0 +1,80 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sso/internal/config"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// purgeCall is the arguments of a PurgeExpired call.
+type purgeCall struct {
+	expiredBefore time.Time
+	keysBefore    time.Time
+}
+
+type fakePurger struct {
+	calls chan purgeCall
+	err   error
+}
+
+func (p *fakePurger) PurgeExpired(_ context.Context, expiredBefore time.Time, keysBefore time.Time) (storage.PurgeResult, error) {
+	p.calls <- purgeCall{expiredBefore: expiredBefore, keysBefore: keysBefore}
+
+	return storage.PurgeResult{Sessions: 1}, p.err
+}
+
+func newTestJanitor(p Purger, cfg config.JanitorConfig) *Janitor {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), p, cfg, 24*time.Hour)
+}
+
+func TestJanitor_PurgesPeriodically(t *testing.T) {
+	purger := &fakePurger{calls: make(chan purgeCall, 10), err: errors.New("database is locked")}
+	j := newTestJanitor(purger, config.JanitorConfig{Interval: 10 * time.Millisecond})
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	j.now = func() time.Time { return now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		j.Run(ctx)
+	}()
+
+	for range 2 {
+		select {
+		case call := <-purger.calls:
+			assert.Equal(t, now.Add(-jwt.MaxLeeway), call.expiredBefore, "sessions outlive their expiry by the leeway")
+			assert.Equal(t, now.Add(-24*time.Hour), call.keysBefore)
+		case <-time.After(time.Second):
+			require.FailNow(t, "no purge", "a failed purge does not stop the janitor")
+		}
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		require.FailNow(t, "Run did not return once ctx was done")
+	}
+}
+
+func TestJanitor_Delay(t *testing.T) {
+	j := newTestJanitor(nil, config.JanitorConfig{Interval: time.Hour})
+	assert.Equal(t, time.Hour, j.delay())
+
+	j = newTestJanitor(nil, config.JanitorConfig{Interval: time.Hour, Jitter: time.Minute})
+	for range 100 {
+		delay := j.delay()
+		assert.GreaterOrEqual(t, delay, time.Hour)
+		assert.Less(t, delay, time.Hour+time.Minute)
+	}
+}
@@ -2,51 +2,483 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"sso/internal/lib/emailhash"
+	"sso/internal/lib/hash"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger"
+	"strings"
 	"time"
 
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig    `yaml:"grpc"`
+	Env         string `yaml:"env" env-default:"local"`
+	LogLevel    string `yaml:"log_level" env:"LOG_LEVEL"` // overrides the env-derived level when set
+	StoragePath string `yaml:"storage_path" env-required:"true"`
+	// StorageReplicaPath optionally points reads at a replica of StoragePath.
+	StorageReplicaPath string        `yaml:"storage_replica_path" env:"STORAGE_REPLICA_PATH"`
+	Storage            StorageConfig `yaml:"storage"`
+	TokenTTL           time.Duration `yaml:"token_ttl" env-required:"true"`
+	// RememberMeTTL is the token lifetime of logins made with remember-me. Zero
+	// gives them the normal token TTL.
+	RememberMeTTL time.Duration `yaml:"remember_me_ttl" env:"REMEMBER_ME_TTL"`
+	// IdempotencyWindow is how long Register remembers idempotency keys sent by clients.
+	IdempotencyWindow time.Duration  `yaml:"idempotency_window" env-default:"24h"`
+	GRPC              GRPCConfig     `yaml:"grpc"`
+	Log               LogConfig      `yaml:"log"`
+	Password          PasswordConfig `yaml:"password"`
+
+	EmailVerification EmailVerificationConfig `yaml:"email_verification"`
+	Registration      RegistrationConfig      `yaml:"registration"`
+	EmailCheck        EmailCheckConfig        `yaml:"email_check"`
+	JWT               JWTConfig               `yaml:"jwt"`
+	Janitor           JanitorConfig           `yaml:"janitor"`
+	Sessions          SessionsConfig          `yaml:"sessions"`
+}
+
+// JWTConfig scopes tokens to a deployment. Issuer and Audience become the iss and
+// aud claims and verification rejects tokens with other values, so e.g. a staging
+// token is not accepted in prod. Empty values are neither set nor checked.
+type JWTConfig struct {
+	Issuer   string `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience string `yaml:"audience" env:"JWT_AUDIENCE"`
+	// MaxTTL caps the lifetime of every token, including apps with a longer
+	// token TTL of their own. Zero leaves it unbounded.
+	MaxTTL time.Duration `yaml:"max_ttl" env:"JWT_MAX_TTL"`
+	// Leeway is the clock skew tolerated when verifying tokens: they are accepted
+	// this long past exp and before nbf and iat. At most jwt.MaxLeeway.
+	Leeway time.Duration `yaml:"leeway" env:"JWT_LEEWAY"`
+	// IDTokens lets Login issue OpenID Connect ID tokens to clients asking for
+	// them with the id-token metadata.
+	IDTokens bool `yaml:"id_tokens" env:"JWT_ID_TOKENS"`
+	// Signer signs tokens: "key" with the apps' private keys from storage, or
+	// "vault" with keys in Vault's transit engine, see Vault.
+	Signer string      `yaml:"signer" env:"JWT_SIGNER" env-default:"key"`
+	Vault  VaultConfig `yaml:"vault"`
+}
+
+// VaultConfig is the Vault transit engine the "vault" signer signs with. Apps
+// keep only their public key in storage.
+type VaultConfig struct {
+	Address string `yaml:"address" env:"VAULT_ADDR"`
+	Token   string `yaml:"token" env:"VAULT_TOKEN"`
+	Mount   string `yaml:"mount" env-default:"transit"`
+	// KeyIDs maps app IDs to the names of their RSA transit keys. Apps missing
+	// here fail to log in.
+	KeyIDs map[int]string `yaml:"key_ids"`
+	// Timeout bounds each signing call to Vault.
+	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
 }
 
 type GRPCConfig struct {
 	Port    int           `yaml:"port"`
 	Timeout time.Duration `yaml:"timeout"`
+	// MethodTimeouts replace Timeout for full Auth method names, e.g.
+	// "/auth.Auth/Register", which hashes a password and may need longer.
+	MethodTimeouts map[string]time.Duration `yaml:"method_timeouts"`
+	// ShutdownTimeout bounds how long stopping waits for in-flight requests on
+	// the gRPC and HTTP servers; connections still open after it are closed.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"30s"`
+	// Message size limits in bytes; requests or responses above them fail with ResourceExhausted.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size" env-default:"4194304"`
+	MaxSendMsgSize int `yaml:"max_send_msg_size" env-default:"4194304"`
+	// MaxConcurrentStreams caps the RPCs (unary calls and streams) in flight on
+	// one client connection. Clients hold further ones until a slot frees up.
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams" env-default:"100"`
+	// MaxPasswordLength rejects longer passwords in bytes with InvalidArgument,
+	// bounding the input to Argon2. Emails are capped at 254 bytes.
+	MaxPasswordLength int `yaml:"max_password_length" env-default:"1024"`
+
+	Keepalive KeepaliveConfig `yaml:"keepalive"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// ProtectedMethods are full gRPC method names (e.g. "/auth.Auth/IsAdmin") that
+	// require a valid bearer token in the authorization metadata.
+	ProtectedMethods []string `yaml:"protected_methods"`
+
+	Health  HealthConfig  `yaml:"health"`
+	TLS     TLSConfig     `yaml:"tls"`
+	Web     WebConfig     `yaml:"web"`
+	Gateway GatewayConfig `yaml:"gateway"`
+}
+
+// LongestTimeout returns the longest of Timeout and MethodTimeouts, i.e. how
+// long any Auth call may run.
+func (c GRPCConfig) LongestTimeout() time.Duration {
+	longest := c.Timeout
+	for _, timeout := range c.MethodTimeouts {
+		longest = max(longest, timeout)
+	}
+
+	return longest
+}
+
+// HealthConfig drives the readiness check behind the gRPC health service: storage
+// is pinged every CheckInterval, the server reports SERVING after the first
+// successful ping and NOT_SERVING after FailureThreshold failures in a row.
+type HealthConfig struct {
+	CheckInterval    time.Duration `yaml:"check_interval" env-default:"5s"`
+	CheckTimeout     time.Duration `yaml:"check_timeout" env-default:"1s"`
+	FailureThreshold int           `yaml:"failure_threshold" env-default:"3"`
+}
+
+// TLSConfig serves gRPC over TLS when CertFile and KeyFile are set. ClientCA
+// additionally requires every client to present a certificate signed by one of
+// the CAs in that PEM file (mutual TLS).
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+// Enabled reports whether the gRPC server should serve TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// GatewayConfig serves the Auth service as JSON over HTTP on a separate port.
+// See package gateway for the routes.
+type GatewayConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port" env-default:"8080"`
+}
+
+// WebConfig serves the gRPC services over gRPC-Web on a separate HTTP/1.1 port,
+// so browser clients can call them without a gateway. The port is plaintext, so
+// it cannot be combined with TLS.
+type WebConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port" env-default:"8081"`
+	// AllowedOrigins are the origins allowed to make CORS requests; "*" allows any.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// KeepaliveConfig bounds how long clients may hold connections and how often they may ping.
+type KeepaliveConfig struct {
+	// MaxConnectionIdle closes connections without active RPCs for this long.
+	MaxConnectionIdle time.Duration `yaml:"max_connection_idle" env-default:"15m"`
+	// MaxConnectionAge closes any connection after this long; in-flight RPCs get MaxConnectionAgeGrace to finish.
+	MaxConnectionAge      time.Duration `yaml:"max_connection_age" env-default:"30m"`
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace" env-default:"5m"`
+	// MinPingInterval is the minimum time between client pings; more frequent pings close the connection.
+	MinPingInterval     time.Duration `yaml:"min_ping_interval" env-default:"5m"`
+	PermitWithoutStream bool          `yaml:"permit_without_stream"`
+}
+
+// RateLimitConfig throttles Login per app, so one noisy integration cannot starve the others.
+type RateLimitConfig struct {
+	LoginPerApp LimitConfig `yaml:"login_per_app"`
+	// LoginPerAppOverrides replaces LoginPerApp for specific app IDs.
+	LoginPerAppOverrides map[int]LimitConfig `yaml:"login_per_app_overrides"`
 }
 
+// LimitConfig is a token bucket refilled at RPS tokens per second up to Burst.
+type LimitConfig struct {
+	RPS   float64 `yaml:"rps" env-default:"100"` // 0 disables the limit
+	Burst int     `yaml:"burst" env-default:"200"`
+}
+
+// StorageConfig selects the storage backend with Driver and tunes the SQLite
+// connection pool (the replica pool uses the same limits). SQLite serializes
+// writers even in WAL mode, so max_open_conns: 1 is a safe choice for
+// write-heavy deployments: it trades throughput for never hitting busy_timeout.
+type StorageConfig struct {
+	// Driver is the backend, see storage.Open; only sqlite is built in so far.
+	Driver       string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"sqlite"`
+	MaxOpenConns int    `yaml:"max_open_conns" env-default:"25"`
+	// MaxIdleConns of -1 keeps no idle connections: 0 is taken for unset.
+	MaxIdleConns    int           `yaml:"max_idle_conns" env-default:"5"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env-default:"5m"`
+	// ReuseDeletedEmails lets new users register with the email of a soft-deleted user.
+	ReuseDeletedEmails bool `yaml:"reuse_deleted_emails"`
+	// HashEmails stores users by an HMAC of their lower-cased email under
+	// EmailHashKey instead of the email itself. Turn it on for a new database
+	// only: users stored before are no longer found by email, and so are all
+	// users if the key changes.
+	HashEmails   bool   `yaml:"hash_emails" env:"STORAGE_HASH_EMAILS"`
+	EmailHashKey string `yaml:"email_hash_key" env:"STORAGE_EMAIL_HASH_KEY"`
+
+	// SQLite pragmas, see sqlite.Options.
+	JournalMode string `yaml:"journal_mode" env-default:"WAL"`
+	// BusyTimeout of -1s fails on a lock at once: 0 is taken for unset.
+	BusyTimeout        time.Duration `yaml:"busy_timeout" env-default:"5s"`
+	Synchronous        string        `yaml:"synchronous" env-default:"NORMAL"`
+	DisableForeignKeys bool          `yaml:"disable_foreign_keys"`
+}
+
+type LogConfig struct {
+	File      string `yaml:"file"` // empty means stdout
+	MaxSizeMB int    `yaml:"max_size_mb" env-default:"100"`
+	// RedactPII masks emails in logs. Unset, it is on in prod and off elsewhere.
+	RedactPII *bool `yaml:"redact_pii" env:"LOG_REDACT_PII"`
+}
+
+// PasswordConfig configures the optional server-side pepper mixed into password hashes.
+// Peppers maps a version to its secret; PepperVersion selects the one used for new
+// hashes (0 disables peppering). Keep retired versions so existing hashes still verify.
+//
+// Every Argon2 operation allocates 64MB, so MaxConcurrentHashes caps how many run
+// at once (-1 is unbounded). Excess requests wait up to HashQueueTimeout and then
+// fail with ResourceExhausted.
+//
+// Variant is the Argon2 variant of new hashes, argon2id or argon2i. Hashes record
+// their variant, so changing it doesn't affect existing ones.
+//
+// LengthCheck is how stored salt and key lengths are validated: strict rejects
+// sizes smaller than the ones this service makes, lenient accepts anything
+// Argon2 allows, e.g. for hashes imported from another system.
+//
+// CalibrateTarget, when set, tunes the Argon2 costs at startup so that hashing
+// takes about that long on the current hardware; zero keeps the defaults.
+//
+// History is how many of a user's last passwords, the current one included, a
+// password reset or change refuses; 0 allows any. Each costs an Argon2
+// verification per reset, so it is capped at 24. MinAge is how long after a
+// reset or change a user must wait to change the password again; resets are
+// not limited by it.
+type PasswordConfig struct {
+	Variant             string         `yaml:"variant" env:"PASSWORD_VARIANT" env-default:"argon2id"`
+	LengthCheck         string         `yaml:"length_check" env:"PASSWORD_LENGTH_CHECK" env-default:"strict"`
+	CalibrateTarget     time.Duration  `yaml:"calibrate_target" env:"PASSWORD_CALIBRATE_TARGET"`
+	PepperVersion       int            `yaml:"pepper_version" env:"PASSWORD_PEPPER_VERSION"`
+	Peppers             map[int]string `yaml:"peppers"`
+	MaxConcurrentHashes int            `yaml:"max_concurrent_hashes" env-default:"16"`
+	HashQueueTimeout    time.Duration  `yaml:"hash_queue_timeout" env-default:"2s"`
+	// ResetTokenTTL is how long a password reset token stays valid.
+	ResetTokenTTL time.Duration `yaml:"reset_token_ttl" env-default:"1h"`
+	History       int           `yaml:"history" env:"PASSWORD_HISTORY"`
+	MinAge        time.Duration `yaml:"min_age" env:"PASSWORD_MIN_AGE"`
+}
+
+// EmailVerificationConfig controls email ownership checks. With Required set,
+// Login rejects users who have not verified their email yet.
+type EmailVerificationConfig struct {
+	Required bool          `yaml:"required"`
+	TokenTTL time.Duration `yaml:"token_ttl" env-default:"24h"`
+}
+
+// RegistrationConfig scopes new users to the app they register through. Users
+// registered without an app, or while AppScoped is off, belong to all apps.
+type RegistrationConfig struct {
+	AppScoped bool `yaml:"app_scoped"`
+	// AllowedAppIDs limits app-scoped registration to these apps; empty allows any existing app.
+	AllowedAppIDs []int `yaml:"allowed_app_ids"`
+}
+
+// EmailCheckConfig enables the email availability check for signup forms. It is
+// limited to RPS checks per second per client IP, up to Burst at once, so that
+// it is slow to use for enumerating registered emails.
+type EmailCheckConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	RPS     float64 `yaml:"rps" env-default:"1"`
+	Burst   int     `yaml:"burst" env-default:"5"`
+}
+
+// JanitorConfig drives the background purge of expired sessions, one-time
+// tokens and idempotency keys, which runs every Interval plus a random delay of
+// up to Jitter, so instances sharing a database don't all purge it at once.
+type JanitorConfig struct {
+	Disabled bool          `yaml:"disabled"`
+	Interval time.Duration `yaml:"interval" env-default:"1h"`
+	Jitter   time.Duration `yaml:"jitter" env-default:"5m"`
+}
+
+// SessionsConfig caps the active sessions of a user. A login over Max either
+// revokes the user's oldest sessions (evict_oldest) or fails (reject), as
+// Policy says. A Max of 0 leaves sessions unlimited.
+type SessionsConfig struct {
+	Max    int    `yaml:"max" env:"SESSIONS_MAX"`
+	Policy string `yaml:"policy" env:"SESSIONS_POLICY" env-default:"evict_oldest"`
+}
+
+// MustLoad loads the config from the -config flag or CONFIG_PATH. Either may
+// list several comma-separated files, see MustLoadByPaths.
 func MustLoad() *Config {
 	path := fetchConfigPath()
 	if path == "" {
 		panic("config file path is empty")
 	}
 
-	return MustLoadByPath(path)
+	return MustLoadByPaths(strings.Split(path, ",")...)
 }
 
 func MustLoadByPath(configPath string) *Config {
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		panic("config file not found: " + configPath)
+	return MustLoadByPaths(configPath)
+}
+
+// MustLoadByPaths loads the config files in order, each overriding the fields it
+// sets in the ones before, e.g. a shared base.yaml followed by prod.yaml. Maps
+// are merged key by key and lists are replaced. Environment variables, defaults
+// and required fields are applied to the merged result.
+func MustLoadByPaths(configPaths ...string) *Config {
+	if len(configPaths) == 0 {
+		panic("no config file paths given")
 	}
 
 	var cfg Config
 
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+	for _, configPath := range configPaths {
+		if _, err := os.Stat(configPath); os.IsNotExist(err) {
+			panic("config file not found: " + configPath)
+		}
+		if err := parseFile(configPath, &cfg); err != nil {
+			panic("failed to read config " + configPath + ": " + err.Error())
+		}
+	}
+
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
 		panic("failed to read config: " + err.Error())
 	}
 
+	cfg.mustValidate()
+
 	return &cfg
 }
 
+// parseFile decodes the file at path over cfg, leaving fields it does not set as they are.
+func parseFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		return cleanenv.ParseYAML(f, cfg)
+	case ".json":
+		return cleanenv.ParseJSON(f, cfg)
+	case ".toml":
+		return cleanenv.ParseTOML(f, cfg)
+	default:
+		return fmt.Errorf("unsupported config file format %q", ext)
+	}
+}
+
+func (cfg *Config) mustValidate() {
+	if cfg.GRPC.MaxRecvMsgSize <= 0 {
+		panic("grpc.max_recv_msg_size must be positive")
+	}
+	if cfg.GRPC.MaxSendMsgSize <= 0 {
+		panic("grpc.max_send_msg_size must be positive")
+	}
+	if cfg.GRPC.MaxConcurrentStreams <= 0 || cfg.GRPC.MaxConcurrentStreams > math.MaxUint32 {
+		panic(fmt.Sprintf("grpc.max_concurrent_streams must be between 1 and %d", uint32(math.MaxUint32)))
+	}
+	if cfg.GRPC.MaxPasswordLength <= 0 {
+		panic("grpc.max_password_length must be positive")
+	}
+
+	for method, timeout := range cfg.GRPC.MethodTimeouts {
+		if !isAuthMethod(method) || timeout < 0 {
+			panic(fmt.Sprintf("grpc.method_timeouts: %q must be a full auth.Auth method name with a non-negative timeout", method))
+		}
+	}
+
+	if cfg.GRPC.Health.CheckInterval <= 0 || cfg.GRPC.Health.CheckTimeout <= 0 {
+		panic("grpc.health.check_interval and grpc.health.check_timeout must be positive")
+	}
+	if cfg.GRPC.Health.FailureThreshold <= 0 {
+		panic("grpc.health.failure_threshold must be positive")
+	}
+
+	if cfg.GRPC.TLS.Enabled() && (cfg.GRPC.TLS.CertFile == "" || cfg.GRPC.TLS.KeyFile == "") {
+		panic("grpc.tls.cert_file and grpc.tls.key_file must be set together")
+	}
+	if cfg.GRPC.TLS.ClientCA != "" && !cfg.GRPC.TLS.Enabled() {
+		panic("grpc.tls.client_ca requires grpc.tls.cert_file and grpc.tls.key_file")
+	}
+	// The gateway calls the gRPC server over a plaintext loopback connection.
+	if cfg.GRPC.TLS.Enabled() && cfg.GRPC.Gateway.Enabled {
+		panic("grpc.gateway cannot be enabled together with grpc.tls")
+	}
+	// gRPC-Web is served by a plaintext HTTP server, which would bypass the TLS
+	// and client certificate checks of the gRPC port.
+	if cfg.GRPC.TLS.Enabled() && cfg.GRPC.Web.Enabled {
+		panic("grpc.web cannot be enabled together with grpc.tls")
+	}
+
+	if cfg.RememberMeTTL < 0 {
+		panic("remember_me_ttl must not be negative")
+	}
+	// Longer remember-me tokens would be clamped to jwt.max_ttl without notice.
+	if cfg.JWT.MaxTTL > 0 && cfg.RememberMeTTL > cfg.JWT.MaxTTL {
+		panic("remember_me_ttl must not exceed jwt.max_ttl")
+	}
+
+	if cfg.JWT.Leeway < 0 || cfg.JWT.Leeway > jwt.MaxLeeway {
+		panic(fmt.Sprintf("jwt.leeway must be between 0 and %s", jwt.MaxLeeway))
+	}
+	switch cfg.JWT.Signer {
+	case "key":
+	case "vault":
+		if cfg.JWT.Vault.Address == "" || cfg.JWT.Vault.Token == "" || len(cfg.JWT.Vault.KeyIDs) == 0 {
+			panic("jwt.signer vault requires jwt.vault.address, token and key_ids")
+		}
+		if cfg.JWT.Vault.Timeout <= 0 {
+			panic("jwt.vault.timeout must be positive")
+		}
+	default:
+		panic(fmt.Sprintf("invalid jwt.signer %q, want key or vault", cfg.JWT.Signer))
+	}
+
+	if len(cfg.Registration.AllowedAppIDs) > 0 && !cfg.Registration.AppScoped {
+		panic("registration.allowed_app_ids requires registration.app_scoped")
+	}
+	for _, appID := range cfg.Registration.AllowedAppIDs {
+		if appID <= 0 {
+			panic("registration.allowed_app_ids must be positive")
+		}
+	}
+
+	if cfg.Janitor.Interval <= 0 || cfg.Janitor.Jitter < 0 {
+		panic("janitor.interval must be positive and janitor.jitter must not be negative")
+	}
+
+	if cfg.Sessions.Max < 0 {
+		panic("sessions.max must not be negative")
+	}
+	if cfg.Sessions.Policy != "evict_oldest" && cfg.Sessions.Policy != "reject" {
+		panic(fmt.Sprintf("sessions.policy must be evict_oldest or reject, got %q", cfg.Sessions.Policy))
+	}
+
+	if cfg.Storage.HashEmails && len(cfg.Storage.EmailHashKey) < emailhash.MinKeyLength {
+		panic(fmt.Sprintf("storage.hash_emails requires an email_hash_key of at least %d bytes", emailhash.MinKeyLength))
+	}
+
+	if cfg.Storage.MaxOpenConns <= 0 {
+		panic("storage.max_open_conns must be positive")
+	}
+
+	if _, err := hash.ParseVariant(cfg.Password.Variant); err != nil {
+		panic("invalid password.variant: " + err.Error())
+	}
+	if _, err := hash.ParseLengthCheck(cfg.Password.LengthCheck); err != nil {
+		panic("invalid password.length_check: " + err.Error())
+	}
+	if cfg.Password.History < 0 || cfg.Password.History > 24 {
+		panic("password.history must be between 0 and 24")
+	}
+	if cfg.Password.MinAge < 0 {
+		panic("password.min_age must not be negative")
+	}
+
+	if cfg.LogLevel != "" {
+		if _, err := logger.ParseLevel(cfg.LogLevel); err != nil {
+			panic("invalid log_level: " + err.Error())
+		}
+	}
+}
+
 func fetchConfigPath() string {
 	var res string
 
-	flag.StringVar(&res, "config", "", "path to config file")
+	flag.StringVar(&res, "config", "", "path to config file, or comma-separated files applied in order")
 	flag.Parse()
 
 	if res == "" {
@@ -54,3 +486,14 @@ func fetchConfigPath() string {
 	}
 	return res
 }
+
+// isAuthMethod reports whether method is the full name of an RPC registered
+// by the Auth service, e.g. "/auth.Auth/Register".
+func isAuthMethod(method string) bool {
+	for _, m := range ssov1.Auth_ServiceDesc.Methods {
+		if method == "/"+ssov1.Auth_ServiceDesc.ServiceName+"/"+m.MethodName {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"bytes"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -144,6 +147,187 @@ grpc:
 	assert.IsType(t, time.Duration(0), cfg.GRPC.Timeout, "Timeout должен быть time.Duration")
 }
 
+func TestMustLoadByPath_LogLevel(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "log_level_config.yaml")
+
+	content := `
+env: "prod"
+log_level: "debug"
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+`
+	err := os.WriteFile(configPath, []byte(content), 0644)
+	require.NoError(t, err)
+
+	cfg := MustLoadByPath(configPath)
+	assert.Equal(t, "debug", cfg.LogLevel, "log_level должен браться из файла")
+
+	t.Setenv("LOG_LEVEL", "error")
+
+	cfg = MustLoadByPath(configPath)
+	assert.Equal(t, "error", cfg.LogLevel, "LOG_LEVEL должен переопределять значение из файла")
+}
+
+func TestMustLoadByPath_InvalidLogLevel(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "invalid_log_level_config.yaml")
+
+	content := `
+log_level: "verbose"
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+`
+	err := os.WriteFile(configPath, []byte(content), 0644)
+	require.NoError(t, err)
+
+	assert.Panics(t, func() {
+		MustLoadByPath(configPath)
+	}, "должна быть паника при неизвестном log_level")
+}
+
+func TestMustLoadByPath_MsgSizeLimits(t *testing.T) {
+	tempDir := t.TempDir()
+
+	defaultsPath := filepath.Join(tempDir, "msg_size_defaults.yaml")
+	err := os.WriteFile(defaultsPath, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+grpc:
+  port: 44044
+  timeout: 10s
+`), 0644)
+	require.NoError(t, err)
+
+	cfg := MustLoadByPath(defaultsPath)
+	assert.Equal(t, 4194304, cfg.GRPC.MaxRecvMsgSize, "max_recv_msg_size должен иметь дефолт 4MB")
+	assert.Equal(t, 4194304, cfg.GRPC.MaxSendMsgSize, "max_send_msg_size должен иметь дефолт 4MB")
+
+	invalidPath := filepath.Join(tempDir, "msg_size_invalid.yaml")
+	err = os.WriteFile(invalidPath, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+grpc:
+  port: 44044
+  timeout: 10s
+  max_recv_msg_size: -1
+`), 0644)
+	require.NoError(t, err)
+
+	assert.Panics(t, func() {
+		MustLoadByPath(invalidPath)
+	}, "должна быть паника при неположительном max_recv_msg_size")
+}
+
+func TestMustLoadByPath_MaxConcurrentStreams(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		streams string
+		want    int
+		wantErr bool
+	}{
+		"default":  {want: 100},
+		"custom":   {streams: "max_concurrent_streams: 16", want: 16},
+		"negative": {streams: "max_concurrent_streams: -1", wantErr: true},
+		"too big":  {streams: "max_concurrent_streams: 4294967296", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+grpc:
+  port: 44044
+  timeout: 10s
+  `+tc.streams+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).GRPC.MaxConcurrentStreams)
+		})
+	}
+}
+
+func TestMustLoadByPath_StoragePool(t *testing.T) {
+	tempDir := t.TempDir()
+
+	configPath := filepath.Join(tempDir, "storage_pool.yaml")
+	err := os.WriteFile(configPath, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+storage:
+  max_open_conns: 1
+`), 0644)
+	require.NoError(t, err)
+
+	cfg := MustLoadByPath(configPath)
+	assert.Equal(t, 1, cfg.Storage.MaxOpenConns, "max_open_conns должен быть 1")
+	assert.Equal(t, 5, cfg.Storage.MaxIdleConns, "max_idle_conns должен иметь дефолт 5")
+	assert.Equal(t, 5*time.Minute, cfg.Storage.ConnMaxLifetime, "conn_max_lifetime должен иметь дефолт 5m")
+	assert.Equal(t, "sqlite", cfg.Storage.Driver, "driver должен иметь дефолт sqlite")
+
+	invalidPath := filepath.Join(tempDir, "storage_pool_invalid.yaml")
+	err = os.WriteFile(invalidPath, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+storage:
+  max_open_conns: -1
+`), 0644)
+	require.NoError(t, err)
+
+	assert.Panics(t, func() {
+		MustLoadByPath(invalidPath)
+	}, "должна быть паника при неположительном max_open_conns")
+
+	nonePath := filepath.Join(tempDir, "storage_pool_none.yaml")
+	err = os.WriteFile(nonePath, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+storage:
+  max_idle_conns: -1
+  busy_timeout: -1s
+`), 0644)
+	require.NoError(t, err)
+
+	cfg = MustLoadByPath(nonePath)
+	assert.Equal(t, -1, cfg.Storage.MaxIdleConns, "-1 отключает простаивающие соединения")
+	assert.Equal(t, -time.Second, cfg.Storage.BusyTimeout, "отрицательный busy_timeout не ждёт блокировку")
+}
+
+func TestMustLoadByPath_EmailHashing(t *testing.T) {
+	tempDir := t.TempDir()
+	key := strings.Repeat("k", 32)
+
+	for name, tc := range map[string]struct {
+		storage string
+		wantErr bool
+	}{
+		"default":   {storage: "{}"},
+		"hashed":    {storage: "{hash_emails: true, email_hash_key: " + key + "}"},
+		"no key":    {storage: "{hash_emails: true}", wantErr: true},
+		"short key": {storage: "{hash_emails: true, email_hash_key: " + key[1:] + "}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+storage: `+tc.storage+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.NotPanics(t, func() { MustLoadByPath(path) })
+		})
+	}
+}
+
 func BenchmarkMustLoadByPath(b *testing.B) {
 	tempDir := b.TempDir()
 	configPath := filepath.Join(tempDir, "bench_config.yaml")
@@ -167,3 +351,498 @@ grpc:
 		_ = MustLoadByPath(configPath)
 	}
 }
+
+func TestMustLoadByPath_TLS(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		tls     string
+		wantErr bool
+	}{
+		"mtls":             {tls: "cert_file: server.pem\n    key_file: server.key\n    client_ca: ca.pem"},
+		"server only":      {tls: "cert_file: server.pem\n    key_file: server.key"},
+		"missing key":      {tls: "cert_file: server.pem", wantErr: true},
+		"client ca alone":  {tls: "client_ca: ca.pem", wantErr: true},
+		"with the gateway": {tls: "cert_file: server.pem\n    key_file: server.key\n  gateway:\n    enabled: true", wantErr: true},
+		"with grpc-web":    {tls: "cert_file: server.pem\n    key_file: server.key\n  web:\n    enabled: true", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+grpc:
+  port: 44044
+  timeout: 10s
+  tls:
+    `+tc.tls+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			cfg := MustLoadByPath(path)
+			assert.True(t, cfg.GRPC.TLS.Enabled())
+		})
+	}
+}
+
+func TestMustLoadByPath_PasswordVariant(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		password string
+		want     string
+		wantErr  bool
+	}{
+		"default":  {password: "{}", want: "argon2id"},
+		"argon2i":  {password: "{variant: argon2i}", want: "argon2i"},
+		"argon2d":  {password: "{variant: argon2d}", wantErr: true},
+		"misspelt": {password: "{variant: Argon2id}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+password: `+tc.password+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).Password.Variant)
+		})
+	}
+}
+
+func TestMustLoadByPath_LogRedactPII(t *testing.T) {
+	tempDir := t.TempDir()
+	on, off := true, false
+
+	for name, tc := range map[string]struct {
+		log  string
+		want *bool
+	}{
+		"unset": {log: "{}"},
+		"on":    {log: "{redact_pii: true}", want: &on},
+		"off":   {log: "{redact_pii: false}", want: &off},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+log: `+tc.log+"\n"), 0644)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, MustLoadByPath(path).Log.RedactPII)
+		})
+	}
+}
+
+func TestMustLoadByPath_PasswordLengthCheck(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		password string
+		want     string
+		wantErr  bool
+	}{
+		"default": {password: "{}", want: "strict"},
+		"lenient": {password: "{length_check: lenient}", want: "lenient"},
+		"unknown": {password: "{length_check: none}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+password: `+tc.password+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).Password.LengthCheck)
+		})
+	}
+}
+
+func TestMustLoadByPath_RememberMeTTL(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		extra   string
+		want    time.Duration
+		wantErr bool
+	}{
+		"unset":          {extra: "", want: 0},
+		"within max ttl": {extra: "remember_me_ttl: 720h\njwt: {max_ttl: 720h}", want: 720 * time.Hour},
+		"unbounded":      {extra: "remember_me_ttl: 8760h", want: 8760 * time.Hour},
+		"above max ttl":  {extra: "remember_me_ttl: 720h\njwt: {max_ttl: 24h}", wantErr: true},
+		"negative":       {extra: "remember_me_ttl: -1h", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+`+tc.extra+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).RememberMeTTL)
+		})
+	}
+}
+
+func TestMustLoadByPath_JWTLeeway(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		extra   string
+		want    time.Duration
+		wantErr bool
+	}{
+		"unset":      {extra: "", want: 0},
+		"within max": {extra: "jwt: {leeway: 30s}", want: 30 * time.Second},
+		"at max":     {extra: "jwt: {leeway: 2m}", want: 2 * time.Minute},
+		"above max":  {extra: "jwt: {leeway: 5m}", wantErr: true},
+		"negative":   {extra: "jwt: {leeway: -1s}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+`+tc.extra+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).JWT.Leeway)
+		})
+	}
+}
+
+func TestMustLoadByPath_JWTSigner(t *testing.T) {
+	tempDir := t.TempDir()
+	vault := `{address: "https://vault:8200", token: root, key_ids: {1: sso-app-1}}`
+
+	for name, tc := range map[string]struct {
+		jwt     string
+		want    JWTConfig
+		wantErr bool
+	}{
+		"default": {jwt: "{}", want: JWTConfig{Signer: "key", Vault: VaultConfig{Mount: "transit", Timeout: 5 * time.Second}}},
+		"vault": {jwt: "{signer: vault, vault: " + vault + "}", want: JWTConfig{Signer: "vault", Vault: VaultConfig{
+			Address: "https://vault:8200", Token: "root", Mount: "transit", KeyIDs: map[int]string{1: "sso-app-1"}, Timeout: 5 * time.Second,
+		}}},
+		"vault without keys":    {jwt: `{signer: vault, vault: {address: "https://vault:8200", token: root}}`, wantErr: true},
+		"vault without address": {jwt: "{signer: vault, vault: {token: root, key_ids: {1: k}}}", wantErr: true},
+		"unknown signer":        {jwt: "{signer: hsm}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+jwt: `+tc.jwt+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).JWT)
+		})
+	}
+}
+
+func TestMustLoadByPath_GRPCMethodTimeouts(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		grpc    string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		"unset":           {grpc: "{}"},
+		"override":        {grpc: `{method_timeouts: {"/auth.Auth/Register": 30s}}`, want: map[string]time.Duration{"/auth.Auth/Register": 30 * time.Second}},
+		"short name":      {grpc: `{method_timeouts: {Register: 30s}}`, wantErr: true},
+		"misspelled":      {grpc: `{method_timeouts: {"/auth.Auth/Regster": 30s}}`, wantErr: true},
+		"unknown service": {grpc: `{method_timeouts: {"/grpc.health.v1.Health/Check": 30s}}`, wantErr: true},
+		"negative":        {grpc: `{method_timeouts: {"/auth.Auth/Register": -1s}}`, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+grpc: `+tc.grpc+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).GRPC.MethodTimeouts)
+		})
+	}
+}
+
+func TestGRPCConfig_LongestTimeout(t *testing.T) {
+	cfg := GRPCConfig{Timeout: 10 * time.Second}
+	assert.Equal(t, 10*time.Second, cfg.LongestTimeout())
+
+	cfg.MethodTimeouts = map[string]time.Duration{"/auth.Auth/Register": 30 * time.Second, "/auth.Auth/Login": time.Second}
+	assert.Equal(t, 30*time.Second, cfg.LongestTimeout())
+}
+
+func TestMustLoadByPath_Janitor(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		janitor string
+		want    JanitorConfig
+		wantErr bool
+	}{
+		"default":         {janitor: "{}", want: JanitorConfig{Interval: time.Hour, Jitter: 5 * time.Minute}},
+		"disabled":        {janitor: "{disabled: true}", want: JanitorConfig{Disabled: true, Interval: time.Hour, Jitter: 5 * time.Minute}},
+		"custom":          {janitor: "{interval: 10m, jitter: 30s}", want: JanitorConfig{Interval: 10 * time.Minute, Jitter: 30 * time.Second}},
+		"negative jitter": {janitor: "{jitter: -1s}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+janitor: `+tc.janitor+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).Janitor)
+		})
+	}
+}
+
+func TestMustLoadByPath_Sessions(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		sessions string
+		want     SessionsConfig
+		wantErr  bool
+	}{
+		"default":        {sessions: "{}", want: SessionsConfig{Policy: "evict_oldest"}},
+		"capped":         {sessions: "{max: 5}", want: SessionsConfig{Max: 5, Policy: "evict_oldest"}},
+		"reject":         {sessions: "{max: 5, policy: reject}", want: SessionsConfig{Max: 5, Policy: "reject"}},
+		"negative max":   {sessions: "{max: -1}", wantErr: true},
+		"unknown policy": {sessions: "{max: 5, policy: evict_newest}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+sessions: `+tc.sessions+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).Sessions)
+		})
+	}
+}
+
+func TestMustLoadByPath_PasswordReuse(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		password    string
+		wantHistory int
+		wantMinAge  time.Duration
+		wantErr     bool
+	}{
+		"default":          {password: "{}"},
+		"history":          {password: "{history: 5}", wantHistory: 5},
+		"min age":          {password: "{history: 5, min_age: 24h}", wantHistory: 5, wantMinAge: 24 * time.Hour},
+		"negative history": {password: "{history: -1}", wantErr: true},
+		"long history":     {password: "{history: 25}", wantErr: true},
+		"negative min age": {password: "{min_age: -1h}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+password: `+tc.password+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			cfg := MustLoadByPath(path)
+			assert.Equal(t, tc.wantHistory, cfg.Password.History)
+			assert.Equal(t, tc.wantMinAge, cfg.Password.MinAge)
+		})
+	}
+}
+
+func TestMustLoadByPath_Registration(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		registration string
+		want         RegistrationConfig
+		wantErr      bool
+	}{
+		"default":          {registration: "{}", want: RegistrationConfig{}},
+		"app scoped":       {registration: "{app_scoped: true}", want: RegistrationConfig{AppScoped: true}},
+		"allowed apps":     {registration: "{app_scoped: true, allowed_app_ids: [1, 2]}", want: RegistrationConfig{AppScoped: true, AllowedAppIDs: []int{1, 2}}},
+		"allowed unscoped": {registration: "{allowed_app_ids: [1]}", wantErr: true},
+		"non-positive app": {registration: "{app_scoped: true, allowed_app_ids: [0]}", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+".yaml")
+			err := os.WriteFile(path, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+registration: `+tc.registration+"\n"), 0644)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Panics(t, func() { MustLoadByPath(path) })
+				return
+			}
+			assert.Equal(t, tc.want, MustLoadByPath(path).Registration)
+		})
+	}
+}
+
+func TestMustLoadByPaths_OverlayPrecedence(t *testing.T) {
+	tempDir := t.TempDir()
+
+	basePath := filepath.Join(tempDir, "base.yaml")
+	require.NoError(t, os.WriteFile(basePath, []byte(`
+env: "dev"
+storage_path: "/tmp/base.db"
+token_ttl: 1h
+grpc:
+  port: 44044
+  timeout: 10s
+  protected_methods: ["/auth.Auth/IsAdmin", "/auth.Auth/Login"]
+password:
+  peppers: {1: "base-secret"}
+  pepper_version: 1
+`), 0644))
+
+	overlayPath := filepath.Join(tempDir, "prod.yaml")
+	require.NoError(t, os.WriteFile(overlayPath, []byte(`
+env: "prod"
+grpc:
+  port: 443
+  protected_methods: ["/auth.Auth/IsAdmin"]
+password:
+  peppers: {2: "prod-secret"}
+  pepper_version: 2
+jwt:
+  issuer: "sso-prod"
+`), 0644))
+
+	cfg := MustLoadByPaths(basePath, overlayPath)
+
+	// Set in both: the overlay wins.
+	assert.Equal(t, "prod", cfg.Env)
+	assert.Equal(t, 443, cfg.GRPC.Port)
+	assert.Equal(t, []string{"/auth.Auth/IsAdmin"}, cfg.GRPC.ProtectedMethods, "lists are replaced")
+	assert.Equal(t, map[int]string{1: "base-secret", 2: "prod-secret"}, cfg.Password.Peppers, "maps are merged")
+	// Only in the base: kept.
+	assert.Equal(t, "/tmp/base.db", cfg.StoragePath)
+	assert.Equal(t, 10*time.Second, cfg.GRPC.Timeout)
+	// Only in the overlay: applied.
+	assert.Equal(t, "sso-prod", cfg.JWT.Issuer)
+	// In neither: defaulted.
+	assert.Equal(t, 4194304, cfg.GRPC.MaxRecvMsgSize)
+
+	reversed := MustLoadByPaths(overlayPath, basePath)
+	assert.Equal(t, "dev", reversed.Env)
+	assert.Equal(t, 44044, reversed.GRPC.Port)
+}
+
+func TestMustLoadByPaths_RequiredFieldsOnMergedResult(t *testing.T) {
+	tempDir := t.TempDir()
+
+	basePath := filepath.Join(tempDir, "base.yaml")
+	require.NoError(t, os.WriteFile(basePath, []byte(`
+grpc:
+  port: 44044
+  timeout: 10s
+`), 0644))
+
+	overlayPath := filepath.Join(tempDir, "overlay.yaml")
+	require.NoError(t, os.WriteFile(overlayPath, []byte(`
+storage_path: "/tmp/test.db"
+token_ttl: 1h
+`), 0644))
+
+	assert.Panics(t, func() { MustLoadByPaths(basePath) }, "storage_path and token_ttl are required")
+
+	cfg := MustLoadByPaths(basePath, overlayPath)
+	assert.Equal(t, "/tmp/test.db", cfg.StoragePath)
+	assert.Equal(t, time.Hour, cfg.TokenTTL)
+
+	assert.Panics(t, func() { MustLoadByPaths(basePath, filepath.Join(tempDir, "missing.yaml")) })
+}
+
+func TestConfig_LogValue(t *testing.T) {
+	cfg := &Config{
+		Env:         "prod",
+		StoragePath: "/var/lib/sso/sso.db",
+		TokenTTL:    time.Hour,
+		GRPC:        GRPCConfig{Port: 44044, Timeout: 5 * time.Second},
+		Storage: StorageConfig{
+			HashEmails:   true,
+			EmailHashKey: "email-hash-key-that-is-32-bytes-long",
+		},
+		Password: PasswordConfig{
+			PepperVersion: 2,
+			Peppers:       map[int]string{1: "old-pepper-secret", 2: "new-pepper-secret"},
+		},
+		JWT: JWTConfig{Signer: "vault", Vault: VaultConfig{Address: "https://vault:8200", Token: "vault-token-secret"}},
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("started", slog.Any("config", cfg))
+	out := buf.String()
+
+	for _, secret := range []string{"email-hash-key-that-is-32-bytes-long", "old-pepper-secret", "new-pepper-secret", "vault-token-secret"} {
+		assert.NotContains(t, out, secret)
+	}
+	assert.NotContains(t, out, "email_hash_key")
+	assert.NotContains(t, out, "peppers")
+
+	assert.Contains(t, out, `"env":"prod"`)
+	assert.Contains(t, out, `"storage_path":"/var/lib/sso/sso.db"`)
+	assert.Contains(t, out, `"token_ttl":3600000000000`)
+	assert.Contains(t, out, `"port":44044`)
+	assert.Contains(t, out, `"hash_emails":true`)
+	assert.Contains(t, out, `"pepper_versions":[1,2]`)
+	assert.Contains(t, out, `"redact_pii":"unset"`)
+	assert.Contains(t, out, `"address":"https://vault:8200"`)
+}
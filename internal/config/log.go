@@ -0,0 +1,142 @@
+package config
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// LogValue implements slog.LogValuer so the effective config can be logged at
+// startup. Fields are listed one by one, so new ones stay out of the logs until
+// added here: secrets such as password peppers and the email hash key never are.
+func (cfg *Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("env", cfg.Env),
+		slog.String("log_level", cfg.LogLevel),
+		slog.String("storage_path", cfg.StoragePath),
+		slog.String("storage_replica_path", cfg.StorageReplicaPath),
+		slog.Group("storage",
+			slog.String("driver", cfg.Storage.Driver),
+			slog.Int("max_open_conns", cfg.Storage.MaxOpenConns),
+			slog.Int("max_idle_conns", cfg.Storage.MaxIdleConns),
+			slog.Duration("conn_max_lifetime", cfg.Storage.ConnMaxLifetime),
+			slog.Bool("reuse_deleted_emails", cfg.Storage.ReuseDeletedEmails),
+			slog.Bool("hash_emails", cfg.Storage.HashEmails),
+			slog.String("journal_mode", cfg.Storage.JournalMode),
+			slog.Duration("busy_timeout", cfg.Storage.BusyTimeout),
+			slog.String("synchronous", cfg.Storage.Synchronous),
+			slog.Bool("disable_foreign_keys", cfg.Storage.DisableForeignKeys),
+		),
+		slog.Duration("token_ttl", cfg.TokenTTL),
+		slog.Duration("remember_me_ttl", cfg.RememberMeTTL),
+		slog.Duration("idempotency_window", cfg.IdempotencyWindow),
+		slog.Group("grpc",
+			slog.Int("port", cfg.GRPC.Port),
+			slog.Duration("timeout", cfg.GRPC.Timeout),
+			slog.Any("method_timeouts", cfg.GRPC.MethodTimeouts),
+			slog.Duration("shutdown_timeout", cfg.GRPC.ShutdownTimeout),
+			slog.Int("max_recv_msg_size", cfg.GRPC.MaxRecvMsgSize),
+			slog.Int("max_send_msg_size", cfg.GRPC.MaxSendMsgSize),
+			slog.Int("max_concurrent_streams", cfg.GRPC.MaxConcurrentStreams),
+			slog.Int("max_password_length", cfg.GRPC.MaxPasswordLength),
+			slog.Group("keepalive",
+				slog.Duration("max_connection_idle", cfg.GRPC.Keepalive.MaxConnectionIdle),
+				slog.Duration("max_connection_age", cfg.GRPC.Keepalive.MaxConnectionAge),
+				slog.Duration("max_connection_age_grace", cfg.GRPC.Keepalive.MaxConnectionAgeGrace),
+				slog.Duration("min_ping_interval", cfg.GRPC.Keepalive.MinPingInterval),
+				slog.Bool("permit_without_stream", cfg.GRPC.Keepalive.PermitWithoutStream),
+			),
+			slog.Group("rate_limit",
+				slog.Group("login_per_app",
+					slog.Float64("rps", cfg.GRPC.RateLimit.LoginPerApp.RPS),
+					slog.Int("burst", cfg.GRPC.RateLimit.LoginPerApp.Burst),
+				),
+				slog.Any("login_per_app_overrides", cfg.GRPC.RateLimit.LoginPerAppOverrides),
+			),
+			slog.Any("protected_methods", cfg.GRPC.ProtectedMethods),
+			slog.Group("health",
+				slog.Duration("check_interval", cfg.GRPC.Health.CheckInterval),
+				slog.Duration("check_timeout", cfg.GRPC.Health.CheckTimeout),
+				slog.Int("failure_threshold", cfg.GRPC.Health.FailureThreshold),
+			),
+			slog.Group("tls",
+				slog.String("cert_file", cfg.GRPC.TLS.CertFile),
+				slog.String("key_file", cfg.GRPC.TLS.KeyFile),
+				slog.String("client_ca", cfg.GRPC.TLS.ClientCA),
+			),
+			slog.Group("web",
+				slog.Bool("enabled", cfg.GRPC.Web.Enabled),
+				slog.Int("port", cfg.GRPC.Web.Port),
+				slog.Any("allowed_origins", cfg.GRPC.Web.AllowedOrigins),
+			),
+			slog.Group("gateway",
+				slog.Bool("enabled", cfg.GRPC.Gateway.Enabled),
+				slog.Int("port", cfg.GRPC.Gateway.Port),
+			),
+		),
+		slog.Group("log",
+			slog.String("file", cfg.Log.File),
+			slog.Int("max_size_mb", cfg.Log.MaxSizeMB),
+			slog.String("redact_pii", optionalBool(cfg.Log.RedactPII)),
+		),
+		slog.Group("password",
+			slog.String("variant", cfg.Password.Variant),
+			slog.String("length_check", cfg.Password.LengthCheck),
+			slog.Duration("calibrate_target", cfg.Password.CalibrateTarget),
+			slog.Int("pepper_version", cfg.Password.PepperVersion),
+			// Only the versions: the peppers themselves are secret.
+			slog.Any("pepper_versions", slices.Sorted(maps.Keys(cfg.Password.Peppers))),
+			slog.Int("max_concurrent_hashes", cfg.Password.MaxConcurrentHashes),
+			slog.Duration("hash_queue_timeout", cfg.Password.HashQueueTimeout),
+			slog.Duration("reset_token_ttl", cfg.Password.ResetTokenTTL),
+			slog.Int("history", cfg.Password.History),
+			slog.Duration("min_age", cfg.Password.MinAge),
+		),
+		slog.Group("email_verification",
+			slog.Bool("required", cfg.EmailVerification.Required),
+			slog.Duration("token_ttl", cfg.EmailVerification.TokenTTL),
+		),
+		slog.Group("registration",
+			slog.Bool("app_scoped", cfg.Registration.AppScoped),
+			slog.Any("allowed_app_ids", cfg.Registration.AllowedAppIDs),
+		),
+		slog.Group("email_check",
+			slog.Bool("enabled", cfg.EmailCheck.Enabled),
+			slog.Float64("rps", cfg.EmailCheck.RPS),
+			slog.Int("burst", cfg.EmailCheck.Burst),
+		),
+		slog.Group("jwt",
+			slog.String("issuer", cfg.JWT.Issuer),
+			slog.String("audience", cfg.JWT.Audience),
+			slog.Duration("max_ttl", cfg.JWT.MaxTTL),
+			slog.Duration("leeway", cfg.JWT.Leeway),
+			slog.Bool("id_tokens", cfg.JWT.IDTokens),
+			slog.String("signer", cfg.JWT.Signer),
+			// Not the token: it is secret.
+			slog.Group("vault",
+				slog.String("address", cfg.JWT.Vault.Address),
+				slog.String("mount", cfg.JWT.Vault.Mount),
+				slog.Any("key_ids", cfg.JWT.Vault.KeyIDs),
+				slog.Duration("timeout", cfg.JWT.Vault.Timeout),
+			),
+		),
+		slog.Group("janitor",
+			slog.Bool("disabled", cfg.Janitor.Disabled),
+			slog.Duration("interval", cfg.Janitor.Interval),
+			slog.Duration("jitter", cfg.Janitor.Jitter),
+		),
+		slog.Group("sessions",
+			slog.Int("max", cfg.Sessions.Max),
+			slog.String("policy", cfg.Sessions.Policy),
+		),
+	)
+}
+
+// optionalBool formats an optional setting, "unset" standing for its default.
+func optionalBool(b *bool) string {
+	if b == nil {
+		return "unset"
+	}
+	return strconv.FormatBool(*b)
+}
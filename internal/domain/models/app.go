@@ -1,8 +1,112 @@
 package models
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Token signing algorithms of an app, as in the alg header of its tokens.
+const (
+	AlgRS256 = "RS256" // RSASSA-PKCS1-v1_5 with SHA-256
+	AlgEdDSA = "EdDSA" // Ed25519
+)
+
 type App struct {
-	ID         int
-	Name       string
-	PrivateKey string // RSA private key in PEM format (for signing tokens)
-	PublicKey  string // RSA public key in PEM format (for verifying tokens)
+	ID                int
+	Name              string
+	PrivateKey        string        // Private key in PEM format (for signing tokens)
+	PublicKey         string        // Public key in PEM format (for verifying tokens)
+	PreviousPublicKey string        // Public key replaced by the last rotation; empty if never rotated
+	TokenTTL          time.Duration // Token lifetime for this app; zero means the global default
+	BindTokens        bool          // Tokens are only accepted from the client they were issued to
+	Algorithm         string        // AlgRS256 or AlgEdDSA, matching the keys; empty means AlgRS256
+	Issuer            string        // iss claim of the app's tokens, e.g. per tenant; empty means the global issuer
+	// ExtraClaims is a JSON object of static claims added to the app's access
+	// tokens, e.g. {"tenant": "acme"}; nil if none. See ParseExtraClaims.
+	ExtraClaims json.RawMessage
+	// AllowedCallbacks are the callback URIs the app may redirect users to, see
+	// CallbackAllowed; nil allows none.
+	AllowedCallbacks []string
+}
+
+// ReservedClaims are the claims the service sets itself, which an app's
+// ExtraClaims must not override.
+var ReservedClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "uid", "email", "app_id", "is_admin", "cnf"}
+
+// ParseExtraClaims decodes the ExtraClaims of an app. They must be a JSON object
+// without any of the ReservedClaims; empty raw decodes to no claims. Numbers
+// decode as json.Number, so tokens carry them exactly as stored, even integers
+// beyond the precision of a float64.
+func ParseExtraClaims(raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var claims map[string]any
+	if err := dec.Decode(&claims); err != nil || claims == nil || dec.More() {
+		return nil, errors.New("extra claims must be a JSON object")
+	}
+	for name := range claims {
+		if slices.Contains(ReservedClaims, name) {
+			return nil, fmt.Errorf("extra claim %q is reserved", name)
+		}
+	}
+
+	return claims, nil
+}
+
+// ValidateCallbackPatterns checks the AllowedCallbacks of an app. Each is an
+// absolute URI, optionally ending in "*" to allow any URI it is a prefix of.
+// A prefix must reach past the host, so that e.g. "https://example.com*" does
+// not allow "https://example.com.evil.net".
+func ValidateCallbackPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		prefix, wildcard := strings.CutSuffix(pattern, "*")
+		if strings.Contains(prefix, "*") {
+			return fmt.Errorf("callback %q may only end in *", pattern)
+		}
+
+		u, err := url.Parse(prefix)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("callback %q must be an absolute URI", pattern)
+		}
+		if wildcard && u.Path == "" {
+			return fmt.Errorf("callback %q must have a path before *", pattern)
+		}
+	}
+
+	return nil
+}
+
+// CallbackAllowed reports whether uri equals one of patterns or starts with the
+// prefix of a pattern ending in "*".
+func CallbackAllowed(patterns []string, uri string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(uri, prefix) {
+				return true
+			}
+		} else if uri == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SigningAlgorithm returns the algorithm the app's tokens are signed with.
+func (a App) SigningAlgorithm() string {
+	if a.Algorithm == "" {
+		return AlgRS256
+	}
+
+	return a.Algorithm
 }
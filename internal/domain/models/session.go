@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is a login of a user to an app, alive until its token expires or it is revoked.
+type Session struct {
+	ID        int64
+	UserID    int64
+	AppID     int
+	ClientIP  string // Address the login came from; empty if unknown
+	UserAgent string // User agent the client reported at login; empty if none
+	CreatedAt time.Time
+	// LastUsedAt is when the session's token was last verified by this service,
+	// initially CreatedAt. Apps verifying tokens on their own don't update it.
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
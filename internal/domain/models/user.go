@@ -1,8 +1,27 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type User struct {
 	ID           int64
 	Email        string
 	PasswordHash []byte
 	PasswordSalt []byte
+	// PepperVersion identifies the server-side pepper mixed into PasswordHash; 0 means none.
+	PepperVersion int
+	IsAdmin       bool
+	EmailVerified bool
+	// Disabled users can't log in until an admin enables them again.
+	Disabled    bool
+	DisplayName string
+	// Metadata is the JSON object the app attached at signup; nil if none.
+	Metadata json.RawMessage
+	// AppID is the app the user registered through; 0 if the user belongs to all apps.
+	AppID int
+	// PasswordChangedAt is when the password was last reset or changed; zero if
+	// never since registration.
+	PasswordChangedAt time.Time
 }
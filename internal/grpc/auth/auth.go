@@ -3,25 +3,50 @@ package auth
 import (
 	"context"
 	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/lib/ratelimit"
 	"sso/internal/services/auth"
+	"strconv"
+	"strings"
 	"time"
 
 	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
-	auth             auth.Service
-	operationTimeout time.Duration
+	auth         auth.Service
+	timeouts     Timeouts
+	loginLimiter *ratelimit.Keyed[int]
 }
 
-func Register(gRPC *grpc.Server, authService auth.Service, operationTimeout time.Duration) {
+// Timeouts are the operation timeouts of the Auth handlers, see withOperationTimeout.
+type Timeouts struct {
+	Default time.Duration
+	// Methods overrides Default for full gRPC method names, e.g.
+	// "/auth.Auth/Register", which hashes a password and may need longer.
+	Methods map[string]time.Duration
+}
+
+// For returns the operation timeout of the full gRPC method name.
+func (t Timeouts) For(method string) time.Duration {
+	if timeout, ok := t.Methods[method]; ok {
+		return timeout
+	}
+
+	return t.Default
+}
+
+// Register registers the Auth service. loginLimiter throttles Login per app_id; nil disables it.
+func Register(gRPC *grpc.Server, authService auth.Service, timeouts Timeouts, loginLimiter *ratelimit.Keyed[int]) {
 	ssov1.RegisterAuthServer(gRPC, &serverAPI{
-		auth:             authService,
-		operationTimeout: operationTimeout,
+		auth:         authService,
+		timeouts:     timeouts,
+		loginLimiter: loginLimiter,
 	})
 }
 
@@ -41,11 +66,32 @@ func (s *serverAPI) Login(
 		return nil, status.Error(codes.InvalidArgument, "app_id is required")
 	}
 
-	// Create context with timeout for database operations
-	opCtx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	remember, err := rememberMe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts auth.LoginOptions
+	if opts.IDToken, err = boolHeader(ctx, idTokenHeader); err != nil {
+		return nil, err
+	}
+	if opts.UserInfo, err = boolHeader(ctx, userInfoHeader); err != nil {
+		return nil, err
+	}
+
+	if s.loginLimiter != nil && !s.loginLimiter.Allow(int(req.GetAppId())) {
+		return nil, status.Error(codes.ResourceExhausted, "too many login requests for this app")
+	}
+
+	opCtx, cancel := withOperationTimeout(ctx, s.timeouts.For(ssov1.Auth_Login_FullMethodName))
 	defer cancel()
 
-	token, err := s.auth.Login(opCtx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
+	opCtx = auth.WithClientInfo(opCtx, clientInfo(ctx))
+	if remember {
+		opCtx = auth.WithRememberMe(opCtx)
+	}
+
+	tokens, err := s.auth.LoginWithOptions(opCtx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()), opts)
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
@@ -53,14 +99,47 @@ func (s *serverAPI) Login(
 		if errors.Is(err, auth.ErrInvalidAppID) {
 			return nil, status.Error(codes.InvalidArgument, "invalid app id")
 		}
-		if errors.Is(err, context.DeadlineExceeded) {
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "account disabled")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		if errors.Is(err, auth.ErrAppKeyMissing) {
+			return nil, status.Error(codes.FailedPrecondition, "app is not configured for signing tokens")
+		}
+		if errors.Is(err, auth.ErrIDTokensDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, "ID tokens are disabled")
+		}
+		if errors.Is(err, auth.ErrFingerprintRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "app requires a client certificate or device-id")
+		}
+		if errors.Is(err, auth.ErrTooManySessions) {
+			return nil, status.Error(codes.FailedPrecondition, "too many active sessions, revoke one to log in")
+		}
+		if errors.Is(err, auth.ErrBusy) {
+			return nil, status.Error(codes.ResourceExhausted, "server busy, retry later")
+		}
+		if errors.Is(err, auth.ErrUnavailable) {
+			return nil, status.Error(codes.Unavailable, "storage unavailable, retry later")
+		}
+		if errors.Is(err, auth.ErrCanceled) {
+			return nil, status.Error(codes.Canceled, "operation canceled")
+		}
+		if errors.Is(err, auth.ErrDeadlineExceeded) {
 			return nil, status.Error(codes.DeadlineExceeded, "operation timeout")
 		}
 		return nil, status.Error(codes.Internal, "failed to login")
 	}
 
+	if header := loginHeader(tokens); header.Len() > 0 {
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			return nil, status.Error(codes.Internal, "failed to send login metadata")
+		}
+	}
+
 	return &ssov1.LoginResponse{
-		Token: token,
+		Token: tokens.AccessToken,
 	}, nil
 }
 
@@ -76,16 +155,37 @@ func (s *serverAPI) Register(
 		return nil, status.Error(codes.InvalidArgument, "password is required")
 	}
 
-	// Create context with timeout for database operations
-	opCtx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	key, err := idempotencyKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opCtx, cancel := withOperationTimeout(ctx, s.timeouts.For(ssov1.Auth_Register_FullMethodName))
 	defer cancel()
 
-	userID, err := s.auth.Register(opCtx, req.GetEmail(), req.GetPassword())
+	var userID int64
+	if key != "" {
+		userID, err = s.auth.RegisterIdempotent(opCtx, key, req.GetEmail(), req.GetPassword())
+	} else {
+		userID, err = s.auth.Register(opCtx, req.GetEmail(), req.GetPassword())
+	}
 	if err != nil {
 		if errors.Is(err, auth.ErrUserExists) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
-		if errors.Is(err, context.DeadlineExceeded) {
+		if errors.Is(err, auth.ErrIdempotencyKeyUsed) {
+			return nil, status.Error(codes.InvalidArgument, "idempotency key already used for another request")
+		}
+		if errors.Is(err, auth.ErrBusy) {
+			return nil, status.Error(codes.ResourceExhausted, "server busy, retry later")
+		}
+		if errors.Is(err, auth.ErrUnavailable) {
+			return nil, status.Error(codes.Unavailable, "storage unavailable, retry later")
+		}
+		if errors.Is(err, auth.ErrCanceled) {
+			return nil, status.Error(codes.Canceled, "operation canceled")
+		}
+		if errors.Is(err, auth.ErrDeadlineExceeded) {
 			return nil, status.Error(codes.DeadlineExceeded, "operation timeout")
 		}
 		return nil, status.Error(codes.Internal, "failed to register user")
@@ -104,8 +204,7 @@ func (s *serverAPI) IsAdmin(
 		return nil, status.Error(codes.InvalidArgument, "user_id is required")
 	}
 
-	// Create context with timeout for database operations
-	opCtx, cancel := context.WithTimeout(ctx, s.operationTimeout)
+	opCtx, cancel := withOperationTimeout(ctx, s.timeouts.For(ssov1.Auth_IsAdmin_FullMethodName))
 	defer cancel()
 
 	isAdmin, err := s.auth.IsAdmin(opCtx, req.GetUserId())
@@ -113,7 +212,13 @@ func (s *serverAPI) IsAdmin(
 		if errors.Is(err, auth.ErrUserNotFound) {
 			return nil, status.Error(codes.NotFound, "user not found")
 		}
-		if errors.Is(err, context.DeadlineExceeded) {
+		if errors.Is(err, auth.ErrUnavailable) {
+			return nil, status.Error(codes.Unavailable, "storage unavailable, retry later")
+		}
+		if errors.Is(err, auth.ErrCanceled) {
+			return nil, status.Error(codes.Canceled, "operation canceled")
+		}
+		if errors.Is(err, auth.ErrDeadlineExceeded) {
 			return nil, status.Error(codes.DeadlineExceeded, "operation timeout")
 		}
 		return nil, status.Error(codes.Internal, "failed to check admin status")
@@ -122,3 +227,177 @@ func (s *serverAPI) IsAdmin(
 		IsAdmin: isAdmin,
 	}, nil
 }
+
+// whoAmI resolves the caller from the bearer token in its metadata. It backs the
+// WhoAmI RPC, which needs a protos release before it can be registered.
+func (s *serverAPI) whoAmI(ctx context.Context) (models.User, error) {
+	return authenticate(ctx, s.auth, s.timeouts.Default)
+}
+
+// authenticate verifies the bearer token in the incoming metadata and returns the
+// user it identifies. Errors are gRPC status errors, Unauthenticated for bad tokens.
+func authenticate(ctx context.Context, authService auth.Service, timeout time.Duration) (models.User, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	opCtx, cancel := withOperationTimeout(ctx, timeout)
+	defer cancel()
+
+	opCtx = auth.WithClientInfo(opCtx, clientInfo(ctx))
+	user, err := authService.WhoAmI(opCtx, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return models.User{}, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrUnavailable) {
+			return models.User{}, status.Error(codes.Unavailable, "storage unavailable, retry later")
+		}
+		if errors.Is(err, auth.ErrCanceled) {
+			return models.User{}, status.Error(codes.Canceled, "operation canceled")
+		}
+		if errors.Is(err, auth.ErrDeadlineExceeded) {
+			return models.User{}, status.Error(codes.DeadlineExceeded, "operation timeout")
+		}
+		return models.User{}, status.Error(codes.Internal, "failed to verify token")
+	}
+
+	return user, nil
+}
+
+// idempotencyKeyHeader is the metadata key clients set to make Register safe to retry.
+const idempotencyKeyHeader = "idempotency-key"
+
+// maxIdempotencyKeyLen bounds stored keys; a UUID or random token fits easily.
+const maxIdempotencyKeyLen = 255
+
+// idempotencyKey returns the idempotency key from the incoming metadata, or "" if none.
+func idempotencyKey(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get(idempotencyKeyHeader)
+	if len(values) == 0 {
+		return "", nil
+	}
+	if len(values) > 1 || values[0] == "" || len(values[0]) > maxIdempotencyKeyLen {
+		return "", status.Errorf(codes.InvalidArgument, "%s must be a single value of 1 to %d characters", idempotencyKeyHeader, maxIdempotencyKeyLen)
+	}
+
+	return values[0], nil
+}
+
+// maxUserAgentLen bounds the user agent recorded for a login; longer ones are cut.
+const maxUserAgentLen = 512
+
+// userAgent returns the user-agent metadata of the call, or "" if none. gRPC
+// clients append their own library version to it.
+func userAgent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("user-agent")
+	if len(values) == 0 {
+		return ""
+	}
+
+	ua := values[0]
+	if len(ua) > maxUserAgentLen {
+		ua = strings.ToValidUTF8(ua[:maxUserAgentLen], "")
+	}
+
+	return ua
+}
+
+// rememberMeHeader is the metadata key clients set to "true" on Login for a
+// long-lived token. LoginRequest has no field for it.
+const rememberMeHeader = "remember-me"
+
+// idTokenHeader is the metadata key clients set to "true" on Login for an
+// OpenID Connect ID token, which is sent back in the response header of the
+// same key. LoginRequest and LoginResponse have no fields for it.
+const idTokenHeader = "id-token"
+
+// userInfoHeader is the metadata key clients set to "true" on Login to learn
+// the user and token expiry without decoding the token. They are sent back in
+// the user-id, user-email, user-is-admin and token-expires-at (Unix seconds,
+// like the exp claim) response headers, as LoginResponse has no fields for them.
+const userInfoHeader = "user-info"
+
+// sessionsEvictedHeader is the response header listing the IDs of the older
+// sessions a login revoked to stay within the session cap, one value each.
+const sessionsEvictedHeader = "sessions-evicted"
+
+// loginHeader returns the response metadata carrying the optional results of
+// Login; it is empty unless the client asked for some or sessions were evicted.
+func loginHeader(tokens auth.LoginTokens) metadata.MD {
+	header := metadata.MD{}
+	if tokens.IDToken != "" {
+		header.Set(idTokenHeader, tokens.IDToken)
+	}
+	if info := tokens.UserInfo; info != nil {
+		header.Set("user-id", strconv.FormatInt(info.UserID, 10))
+		header.Set("user-email", info.Email)
+		header.Set("user-is-admin", strconv.FormatBool(info.IsAdmin))
+		header.Set("token-expires-at", strconv.FormatInt(info.ExpiresAt.Unix(), 10))
+	}
+	for _, sessionID := range tokens.EvictedSessions {
+		header.Append(sessionsEvictedHeader, strconv.FormatInt(sessionID, 10))
+	}
+
+	return header
+}
+
+// rememberMe reports whether the remember-me metadata asks for a long-lived token.
+func rememberMe(ctx context.Context) (bool, error) {
+	return boolHeader(ctx, rememberMeHeader)
+}
+
+// boolHeader returns the boolean value of the key metadata, false if it is not set.
+func boolHeader(ctx context.Context, key string) (bool, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(values[0])
+	if len(values) > 1 || err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%s must be a single boolean value", key)
+	}
+
+	return value, nil
+}
+
+// withOperationTimeout bounds the service call by the server's operation timeout
+// without extending the client's deadline: the call gets whichever ends first, so
+// a client asking for 1s is answered within 1s, and one with no deadline gets the
+// full server timeout. A non-positive timeout leaves only the client's deadline.
+func withOperationTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	// A derived context never outlives its parent, so this is the minimum.
+	return context.WithTimeout(ctx, timeout)
+}
+
+// bearerToken extracts the token from the "authorization: Bearer <token>" metadata.
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "missing metadata")
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", status.Error(codes.Unauthenticated, "authorization metadata is required")
+	}
+
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return "", status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+	}
+
+	return token, nil
+}
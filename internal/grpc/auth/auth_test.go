@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"strings"
+	"testing"
+	"time"
+
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const validToken = "valid-token"
+
+// stubService implements only the methods under test; others panic.
+type stubService struct {
+	auth.Service
+}
+
+func (stubService) WhoAmI(_ context.Context, token string) (models.User, error) {
+	if token != validToken {
+		return models.User{}, auth.ErrInvalidToken
+	}
+
+	return models.User{ID: 7, Email: "user@example.com", IsAdmin: true}, nil
+}
+
+func newTestServer() *serverAPI {
+	return &serverAPI{auth: stubService{}, timeouts: Timeouts{Default: time.Second}}
+}
+
+func withAuthorization(value string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", value))
+}
+
+func TestWhoAmI_ValidToken(t *testing.T) {
+	user, err := newTestServer().whoAmI(withAuthorization("Bearer " + validToken))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(7), user.ID)
+	assert.Equal(t, "user@example.com", user.Email)
+	assert.True(t, user.IsAdmin)
+}
+
+func TestWhoAmI_Unauthenticated(t *testing.T) {
+	for name, ctx := range map[string]context.Context{
+		"no metadata":      context.Background(),
+		"no authorization": metadata.NewIncomingContext(context.Background(), metadata.MD{}),
+		"not bearer":       withAuthorization("Basic " + validToken),
+		"empty token":      withAuthorization("Bearer "),
+		"expired token":    withAuthorization("Bearer expired-token"),
+	} {
+		_, err := newTestServer().whoAmI(ctx)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), name)
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	const protected, public = "/auth.Auth/Protected", "/auth.Auth/Public"
+
+	interceptor := AuthInterceptor(stubService{}, time.Second, []string{protected})
+
+	var (
+		gotUser models.User
+		gotOK   bool
+	)
+	handler := func(ctx context.Context, _ any) (any, error) {
+		gotUser, gotOK = ClaimsFromContext(ctx)
+		return "ok", nil
+	}
+	call := func(ctx context.Context, method string) error {
+		gotUser, gotOK = models.User{}, false
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	require.NoError(t, call(withAuthorization("Bearer "+validToken), protected))
+	assert.True(t, gotOK)
+	assert.Equal(t, int64(7), gotUser.ID)
+
+	err := call(context.Background(), protected)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = call(withAuthorization("Bearer expired-token"), protected)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	require.NoError(t, call(context.Background(), public))
+	assert.False(t, gotOK, "public methods carry no identity")
+}
+
+// deadlineService records the deadline Login is called with.
+type deadlineService struct {
+	auth.Service
+	deadline chan time.Time
+}
+
+func (s deadlineService) LoginWithOptions(ctx context.Context, _, _ string, _ int, _ auth.LoginOptions) (auth.LoginTokens, error) {
+	deadline, _ := ctx.Deadline() // zero without a deadline
+	s.deadline <- deadline
+
+	return auth.LoginTokens{AccessToken: "token"}, nil
+}
+
+func loginDeadline(t *testing.T, ctx context.Context, operationTimeout time.Duration) time.Time {
+	t.Helper()
+
+	svc := deadlineService{deadline: make(chan time.Time, 1)}
+	server := &serverAPI{auth: svc, timeouts: Timeouts{Default: operationTimeout}}
+
+	_, err := server.Login(ctx, &ssov1.LoginRequest{Email: "user@example.com", Password: "password", AppId: 1})
+	require.NoError(t, err)
+
+	return <-svc.deadline
+}
+
+func TestOperationTimeout_ShortClientDeadlineWins(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	clientDeadline, _ := ctx.Deadline()
+
+	assert.Equal(t, clientDeadline, loginDeadline(t, ctx, time.Minute))
+}
+
+func TestOperationTimeout_NoClientDeadlineUsesServerTimeout(t *testing.T) {
+	start := time.Now()
+
+	deadline := loginDeadline(t, context.Background(), time.Minute)
+
+	assert.WithinRange(t, deadline, start.Add(time.Minute), time.Now().Add(time.Minute))
+}
+
+func TestOperationTimeout_LongClientDeadlineIsCapped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	start := time.Now()
+
+	deadline := loginDeadline(t, ctx, time.Minute)
+
+	assert.WithinRange(t, deadline, start.Add(time.Minute), time.Now().Add(time.Minute))
+}
+
+func TestOperationTimeout_ZeroKeepsClientDeadline(t *testing.T) {
+	assert.True(t, loginDeadline(t, context.Background(), 0).IsZero(), "no deadline at all")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	clientDeadline, _ := ctx.Deadline()
+
+	assert.Equal(t, clientDeadline, loginDeadline(t, ctx, 0))
+}
+
+// failingLoginService fails every login with err.
+type failingLoginService struct {
+	auth.Service
+	err error
+}
+
+func (s failingLoginService) LoginWithOptions(context.Context, string, string, int, auth.LoginOptions) (auth.LoginTokens, error) {
+	return auth.LoginTokens{}, s.err
+}
+
+func TestLogin_AccountDisabled(t *testing.T) {
+	server := &serverAPI{auth: failingLoginService{err: auth.ErrAccountDisabled}, timeouts: Timeouts{Default: time.Second}}
+
+	_, err := server.Login(context.Background(), &ssov1.LoginRequest{Email: "user@example.com", Password: "password", AppId: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// slowRegisterService takes delay to register, like hashing an expensive password.
+type slowRegisterService struct {
+	auth.Service
+	delay time.Duration
+}
+
+func (s slowRegisterService) Register(ctx context.Context, _, _ string) (int64, error) {
+	select {
+	case <-time.After(s.delay):
+		return 1, nil
+	case <-ctx.Done():
+		return 0, auth.ErrDeadlineExceeded
+	}
+}
+
+func TestOperationTimeout_MethodOverride(t *testing.T) {
+	svc := slowRegisterService{delay: 100 * time.Millisecond}
+	req := &ssov1.RegisterRequest{Email: "user@example.com", Password: "password"}
+
+	server := &serverAPI{auth: svc, timeouts: Timeouts{Default: 10 * time.Millisecond}}
+	_, err := server.Register(context.Background(), req)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "the default timeout cuts Register off")
+
+	server.timeouts.Methods = map[string]time.Duration{ssov1.Auth_Register_FullMethodName: time.Minute}
+	resp, err := server.Register(context.Background(), req)
+	require.NoError(t, err, "the longer override lets Register finish")
+	assert.Equal(t, int64(1), resp.GetUserId())
+}
+
+func TestTimeouts_For(t *testing.T) {
+	timeouts := Timeouts{
+		Default: time.Second,
+		Methods: map[string]time.Duration{ssov1.Auth_Register_FullMethodName: time.Minute},
+	}
+
+	assert.Equal(t, time.Minute, timeouts.For(ssov1.Auth_Register_FullMethodName))
+	assert.Equal(t, time.Second, timeouts.For(ssov1.Auth_Login_FullMethodName))
+}
+
+func TestClientIP(t *testing.T) {
+	assert.Empty(t, clientIP(context.Background()), "no peer")
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50123}})
+	assert.Equal(t, "2001:db8::1", clientIP(ctx))
+}
+
+func TestClientFingerprint(t *testing.T) {
+	assert.Empty(t, clientFingerprint(context.Background()), "no peer or metadata")
+
+	device := metadata.NewIncomingContext(context.Background(), metadata.Pairs(deviceIDHeader, "laptop"))
+	assert.Equal(t, "device-id:laptop", clientFingerprint(device))
+
+	cert := &x509.Certificate{Raw: []byte("client certificate")}
+	sum := sha256.Sum256(cert.Raw)
+	mtls := peer.NewContext(device, &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+	}})
+	assert.Equal(t, "x5t#S256:"+base64.RawURLEncoding.EncodeToString(sum[:]), clientFingerprint(mtls),
+		"a client certificate takes precedence over the device ID")
+}
+
+func TestUserAgent(t *testing.T) {
+	assert.Empty(t, userAgent(context.Background()), "no metadata")
+	assert.Empty(t, userAgent(metadata.NewIncomingContext(context.Background(), metadata.MD{})))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "app/1.0 grpc-go/1.70.0"))
+	assert.Equal(t, "app/1.0 grpc-go/1.70.0", userAgent(ctx))
+
+	// Cut in the middle of the two-byte "é", which is dropped.
+	long := strings.Repeat("a", maxUserAgentLen-1) + "é"
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", long))
+	assert.Equal(t, strings.Repeat("a", maxUserAgentLen-1), userAgent(ctx))
+}
+
+func TestRememberMe(t *testing.T) {
+	remember, err := rememberMe(context.Background())
+	require.NoError(t, err)
+	assert.False(t, remember, "no metadata")
+
+	for value, want := range map[string]bool{"true": true, "1": true, "false": false} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(rememberMeHeader, value))
+		remember, err := rememberMe(ctx)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, remember, value)
+	}
+
+	for _, md := range []metadata.MD{
+		metadata.Pairs(rememberMeHeader, "yes please"),
+		metadata.Pairs(rememberMeHeader, "true", rememberMeHeader, "false"),
+	} {
+		_, err := rememberMe(metadata.NewIncomingContext(context.Background(), md))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func TestLogin_StorageUnavailable(t *testing.T) {
+	server := &serverAPI{auth: failingLoginService{err: fmt.Errorf("auth.Login: %w", auth.ErrUnavailable)}, timeouts: Timeouts{Default: time.Second}}
+
+	_, err := server.Login(context.Background(), &ssov1.LoginRequest{Email: "user@example.com", Password: "password", AppId: 1})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxEmailLength is the longest email address SMTP allows (RFC 5321), in bytes.
+const MaxEmailLength = 254
+
+type emailRequest interface {
+	GetEmail() string
+}
+
+type passwordRequest interface {
+	GetPassword() string
+}
+
+// FieldLimitsInterceptor rejects requests whose email is longer than
+// MaxEmailLength or whose password is longer than maxPasswordLength bytes with
+// InvalidArgument, before any work is done on them, e.g. hashing a huge password.
+// It applies to every request with an email or password field.
+func FieldLimitsInterceptor(maxPasswordLength int) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if r, ok := req.(emailRequest); ok && len(r.GetEmail()) > MaxEmailLength {
+			return nil, status.Errorf(codes.InvalidArgument, "email must be at most %d characters", MaxEmailLength)
+		}
+		if r, ok := req.(passwordRequest); ok && len(r.GetPassword()) > maxPasswordLength {
+			return nil, status.Errorf(codes.InvalidArgument, "password must be at most %d characters", maxPasswordLength)
+		}
+
+		return handler(ctx, req)
+	}
+}
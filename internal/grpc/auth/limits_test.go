@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFieldLimitsInterceptor(t *testing.T) {
+	const maxPassword = 64
+
+	interceptor := FieldLimitsInterceptor(maxPassword)
+	handler := func(context.Context, any) (any, error) { return "ok", nil }
+	call := func(req any) error {
+		_, err := interceptor(context.Background(), req, &grpc.UnaryServerInfo{}, handler)
+		return err
+	}
+
+	longEmail := strings.Repeat("a", MaxEmailLength-len("@example.com")+1) + "@example.com"
+	longPassword := strings.Repeat("p", maxPassword+1)
+
+	for name, req := range map[string]any{
+		"login email":       &ssov1.LoginRequest{Email: longEmail, Password: "password", AppId: 1},
+		"login password":    &ssov1.LoginRequest{Email: "user@example.com", Password: longPassword, AppId: 1},
+		"register email":    &ssov1.RegisterRequest{Email: longEmail, Password: "password"},
+		"register password": &ssov1.RegisterRequest{Email: "user@example.com", Password: longPassword},
+	} {
+		assert.Equal(t, codes.InvalidArgument, status.Code(call(req)), name)
+	}
+
+	assert.NoError(t, call(&ssov1.RegisterRequest{
+		Email:    longEmail[1:],
+		Password: longPassword[1:],
+	}), "values at the limits pass")
+	assert.NoError(t, call(&ssov1.IsAdminRequest{UserId: 1}), "requests without the fields pass")
+}
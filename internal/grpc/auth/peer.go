@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"sso/internal/services/auth"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientCertSubject returns the subject of the certificate the caller presented
+// over mutual TLS, e.g. "CN=billing,O=example". ok is false for plaintext
+// connections and TLS connections without a client certificate.
+func ClientCertSubject(ctx context.Context) (subject string, ok bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0].Subject.String(), true
+}
+
+// clientIP returns the address of the caller's end of the connection, or "" if
+// unknown. Behind a proxy, it is the proxy's.
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+
+	return host
+}
+
+// deviceIDHeader is the metadata key clients without a TLS client certificate
+// send a stable device ID in, for apps that bind tokens to clients.
+const deviceIDHeader = "device-id"
+
+// clientFingerprint identifies the caller for token binding: by the SHA-256 of
+// the certificate it presented over mutual TLS or, without one, by its
+// device-id metadata. It is "" if the caller has neither.
+func clientFingerprint(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 && len(tlsInfo.State.VerifiedChains[0]) > 0 {
+			sum := sha256.Sum256(tlsInfo.State.VerifiedChains[0][0].Raw)
+			return "x5t#S256:" + base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(deviceIDHeader); len(values) == 1 && values[0] != "" {
+		return "device-id:" + values[0]
+	}
+
+	return ""
+}
+
+// clientInfo describes the caller for the auth service.
+func clientInfo(ctx context.Context) auth.ClientInfo {
+	return auth.ClientInfo{
+		IP:          clientIP(ctx),
+		UserAgent:   userAgent(ctx),
+		Fingerprint: clientFingerprint(ctx),
+	}
+}
@@ -0,0 +1,125 @@
+// Package gateway exposes the Auth gRPC service as JSON over HTTP, translating
+// gRPC status codes to HTTP ones (e.g. InvalidArgument to 400, NotFound to 404).
+// It proxies to the gRPC server, so interceptors such as protected methods and
+// rate limits apply to HTTP calls too. Routes:
+//
+//	POST /v1/login                   Login    {"email", "password", "app_id"} -> {"token"}
+//	POST /v1/register                Register {"email", "password"} -> {"user_id"}
+//	GET  /v1/users/{user_id}/is_admin IsAdmin  -> {"is_admin"}
+//
+// JSON field names are the proto field names. An "Authorization" header is
+// forwarded as authorization metadata.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// call invokes one RPC for an HTTP request whose path parameters are params.
+type call func(ctx context.Context, client ssov1.AuthClient, marshaler runtime.Marshaler, r *http.Request, params map[string]string) (proto.Message, error)
+
+type route struct {
+	method  string
+	pattern string
+	rpc     string
+	call    call
+}
+
+var routes = []route{
+	{
+		method:  http.MethodPost,
+		pattern: "/v1/login",
+		rpc:     ssov1.Auth_Login_FullMethodName,
+		call: func(ctx context.Context, client ssov1.AuthClient, marshaler runtime.Marshaler, r *http.Request, _ map[string]string) (proto.Message, error) {
+			var req ssov1.LoginRequest
+			if err := decodeBody(marshaler, r, &req); err != nil {
+				return nil, err
+			}
+			return client.Login(ctx, &req)
+		},
+	},
+	{
+		method:  http.MethodPost,
+		pattern: "/v1/register",
+		rpc:     ssov1.Auth_Register_FullMethodName,
+		call: func(ctx context.Context, client ssov1.AuthClient, marshaler runtime.Marshaler, r *http.Request, _ map[string]string) (proto.Message, error) {
+			var req ssov1.RegisterRequest
+			if err := decodeBody(marshaler, r, &req); err != nil {
+				return nil, err
+			}
+			return client.Register(ctx, &req)
+		},
+	},
+	{
+		method:  http.MethodGet,
+		pattern: "/v1/users/{user_id}/is_admin",
+		rpc:     ssov1.Auth_IsAdmin_FullMethodName,
+		call: func(ctx context.Context, client ssov1.AuthClient, _ runtime.Marshaler, _ *http.Request, params map[string]string) (proto.Message, error) {
+			userID, err := strconv.ParseInt(params["user_id"], 10, 64)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid user_id %q", params["user_id"])
+			}
+			return client.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
+		},
+	},
+}
+
+// NewHandler returns an HTTP handler serving the routes above by calling the Auth
+// service over cc.
+func NewHandler(cc grpc.ClientConnInterface) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+	)
+	client := ssov1.NewAuthClient(cc)
+
+	for _, rt := range routes {
+		err := mux.HandlePath(rt.method, rt.pattern, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			inbound, outbound := runtime.MarshalerForRequest(mux, r)
+
+			ctx, err := runtime.AnnotateContext(ctx, mux, r, rt.rpc, runtime.WithHTTPPathPattern(rt.pattern))
+			if err != nil {
+				runtime.HTTPError(ctx, mux, outbound, w, r, err)
+				return
+			}
+
+			resp, err := rt.call(ctx, client, inbound, r, params)
+			if err != nil {
+				runtime.HTTPError(ctx, mux, outbound, w, r, err)
+				return
+			}
+
+			runtime.ForwardResponseMessage(ctx, mux, outbound, w, r, resp)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mux, nil
+}
+
+func decodeBody(marshaler runtime.Marshaler, r *http.Request, req proto.Message) error {
+	if err := marshaler.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+		return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+	}
+
+	return nil
+}
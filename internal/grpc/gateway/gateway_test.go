@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/services/auth"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// stubService implements only the methods the gateway exposes; others panic.
+type stubService struct {
+	auth.Service
+}
+
+func (stubService) LoginWithOptions(_ context.Context, email, password string, appID int, _ auth.LoginOptions) (auth.LoginTokens, error) {
+	if email != "user@example.com" || password != "password" || appID != 1 {
+		return auth.LoginTokens{}, auth.ErrInvalidCredentials
+	}
+
+	return auth.LoginTokens{AccessToken: "token"}, nil
+}
+
+func (stubService) Register(context.Context, string, string) (int64, error) {
+	return 7, nil
+}
+
+func (stubService) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	if userID != 7 {
+		return false, auth.ErrUserNotFound
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	return len(md.Get("authorization")) == 1 && md.Get("authorization")[0] == "Bearer admin", nil
+}
+
+// newTestGateway serves the gateway in front of a gRPC server backed by stubService.
+func newTestGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	grpcServer := grpc.NewServer()
+	authgrpc.Register(grpcServer, stubService{}, authgrpc.Timeouts{Default: time.Second}, nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = grpcServer.Serve(l)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+
+	handler, err := NewHandler(cc)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func post(t *testing.T, url, body string) (*http.Response, map[string]any) {
+	t.Helper()
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	var decoded map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+
+	return resp, decoded
+}
+
+func TestLogin(t *testing.T) {
+	srv := newTestGateway(t)
+
+	resp, body := post(t, srv.URL+"/v1/login", `{"email": "user@example.com", "password": "password", "app_id": 1}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "token", body["token"])
+}
+
+func TestLogin_BadRequest(t *testing.T) {
+	srv := newTestGateway(t)
+
+	for name, reqBody := range map[string]string{
+		"missing password":    `{"email": "user@example.com", "app_id": 1}`,
+		"empty body":          ``,
+		"malformed json":      `{"email":`,
+		"invalid credentials": `{"email": "user@example.com", "password": "wrong", "app_id": 1}`,
+	} {
+		resp, body := post(t, srv.URL+"/v1/login", reqBody)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, name)
+		assert.NotEmpty(t, body["message"], name)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	srv := newTestGateway(t)
+
+	resp, body := post(t, srv.URL+"/v1/register", `{"email": "user@example.com", "password": "password"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "7", body["user_id"], "int64 fields are JSON strings")
+}
+
+func TestIsAdmin(t *testing.T) {
+	srv := newTestGateway(t)
+
+	get := func(path, authorization string) (int, map[string]any) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return resp.StatusCode, body
+	}
+
+	code, body := get("/v1/users/7/is_admin", "Bearer admin")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["is_admin"], "the authorization header is forwarded")
+
+	code, body = get("/v1/users/7/is_admin", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["is_admin"])
+
+	code, _ = get("/v1/users/8/is_admin", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = get("/v1/users/abc/is_admin", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,52 @@
+// Package emailhash maps emails to keyed hashes, stored in place of the emails
+// themselves so that a leaked database doesn't expose addresses. The hashes are
+// deterministic, so users can still be looked up by email.
+package emailhash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// MinKeyLength is the shortest key New accepts, in bytes.
+const MinKeyLength = 32
+
+var ErrKeyTooShort = errors.New("email hash key is too short")
+
+// Hasher hashes emails with HMAC-SHA256 under a server key. A nil Hasher
+// leaves emails as they are, so callers need not tell the modes apart.
+type Hasher struct {
+	key []byte
+}
+
+// New returns a Hasher with key, which must be at least MinKeyLength bytes.
+// Changing the key makes every stored hash unreachable.
+func New(key string) (*Hasher, error) {
+	if len(key) < MinKeyLength {
+		return nil, ErrKeyTooShort
+	}
+
+	return &Hasher{key: []byte(key)}, nil
+}
+
+// Hash returns what email is stored and looked up as: the hex HMAC of the
+// normalized email, or email itself with a nil Hasher.
+func (h *Hasher) Hash(email string) string {
+	if h == nil {
+		return email
+	}
+
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(Normalize(email)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Normalize returns the form emails are hashed in: without surrounding
+// whitespace and in lower case, so that they match however a user types them.
+func Normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
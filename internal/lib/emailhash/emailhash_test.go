@@ -0,0 +1,36 @@
+package emailhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = strings.Repeat("k", MinKeyLength)
+
+func TestHash(t *testing.T) {
+	h, err := New(testKey)
+	require.NoError(t, err)
+
+	hashed := h.Hash("user@example.com")
+	assert.NotContains(t, hashed, "user")
+	assert.Len(t, hashed, 64)
+	assert.Equal(t, hashed, h.Hash("  User@Example.COM\n"), "case and whitespace are normalized")
+	assert.NotEqual(t, hashed, h.Hash("other@example.com"))
+
+	other, err := New(strings.Repeat("o", MinKeyLength))
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed, other.Hash("user@example.com"), "hashes depend on the key")
+}
+
+func TestHash_NilHasher(t *testing.T) {
+	var h *Hasher
+	assert.Equal(t, " User@Example.com", h.Hash(" User@Example.com"))
+}
+
+func TestNew_ShortKey(t *testing.T) {
+	_, err := New(testKey[1:])
+	assert.ErrorIs(t, err, ErrKeyTooShort)
+}
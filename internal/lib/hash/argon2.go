@@ -1,11 +1,13 @@
 package hash
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"errors"
 	"fmt"
-
-	"golang.org/x/crypto/argon2"
+	"time"
 )
 
 const (
@@ -16,15 +18,272 @@ const (
 	saltLength  = 16
 )
 
+// NoPepper is the pepper version of hashes made without a pepper.
+const NoPepper = 0
+
+var (
+	// ErrBusy is returned when the concurrency limit is reached and no Argon2 slot
+	// frees up within the configured wait.
+	ErrBusy = errors.New("too many concurrent password hash operations")
+
+	ErrEmptyPassword    = errors.New("password cannot be empty")
+	ErrPasswordMismatch = errors.New("passwords do not match")
+	// ErrInvalidSaltLength and ErrInvalidHashLength mean the stored salt or hash
+	// is not one this package produced, e.g. it was corrupted or truncated.
+	ErrInvalidSaltLength = errors.New("invalid salt length")
+	ErrInvalidHashLength = errors.New("invalid hash length")
+)
+
+// dummySalt and dummyHash are the fixed inputs CompareDummy verifies against.
+var (
+	dummySalt = make([]byte, saltLength)
+	dummyHash = make([]byte, keyLength)
+)
+
 type PasswordData struct {
+	// Hash is encoded with the variant, parameters and salt it was made with.
 	Hash []byte
-	Salt []byte
+	// Salt is the salt in Hash, kept for storage that has a column for it.
+	Salt          []byte
+	PepperVersion int
+}
+
+// Hasher hashes and verifies passwords, optionally mixing in a server-side secret
+// (pepper) so that a leaked database alone is not enough to crack them offline.
+type Hasher struct {
+	peppers        map[int][]byte
+	currentVersion int
+	variant        Variant
+	params         Params
+	lengthCheck    LengthCheck
+	recorder       Recorder
+
+	// slots bounds concurrent Argon2 operations when set; see WithConcurrencyLimit.
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// Option configures a Hasher.
+type Option func(*Hasher)
+
+// WithConcurrencyLimit caps concurrent Argon2 operations at limit. Each one
+// allocates the configured Argon2 memory (64MB by default), so this bounds peak memory under a login storm.
+// Operations over the limit wait up to wait for a slot and then fail with ErrBusy.
+// A limit of 0 or less leaves concurrency unbounded.
+func WithConcurrencyLimit(limit int, wait time.Duration) Option {
+	return func(h *Hasher) {
+		if limit > 0 {
+			h.slots = make(chan struct{}, limit)
+			h.wait = wait
+		}
+	}
+}
+
+// WithVariant makes new hashes use variant instead of Argon2id. Hashes record
+// their variant, so existing ones keep verifying after a change.
+func WithVariant(variant Variant) Option {
+	return func(h *Hasher) {
+		h.variant = variant
+	}
+}
+
+// WithParams makes new hashes use the given Argon2 cost parameters, e.g. ones
+// found with Calibrate. Hashes record their parameters, so existing ones keep
+// verifying after a change.
+func WithParams(p Params) Option {
+	return func(h *Hasher) {
+		h.params = p
+	}
+}
+
+// WithLengthCheck sets how strictly ComparePassword validates stored salt and
+// key lengths; see LengthCheck. The default is LengthStrict.
+func WithLengthCheck(check LengthCheck) Option {
+	return func(h *Hasher) {
+		h.lengthCheck = check
+	}
+}
+
+// NewHasher creates a Hasher. peppers maps a version to its secret. New hashes use
+// currentVersion, or no pepper when it is NoPepper. Keep retired versions in peppers
+// so that hashes made before a rotation still verify.
+func NewHasher(peppers map[int]string, currentVersion int, opts ...Option) (*Hasher, error) {
+	h := &Hasher{
+		peppers:        make(map[int][]byte, len(peppers)),
+		currentVersion: currentVersion,
+		variant:        Argon2id,
+		params:         defaultParams,
+		lengthCheck:    LengthStrict,
+		recorder:       noopRecorder{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if _, err := ParseVariant(string(h.variant)); err != nil {
+		return nil, err
+	}
+	if _, err := ParseLengthCheck(string(h.lengthCheck)); err != nil {
+		return nil, err
+	}
+	if err := h.params.validate(); err != nil {
+		return nil, err
+	}
+
+	for version, secret := range peppers {
+		if version == NoPepper {
+			return nil, fmt.Errorf("pepper version %d is reserved for unpeppered hashes", NoPepper)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("pepper version %d has an empty secret", version)
+		}
+		h.peppers[version] = []byte(secret)
+	}
+
+	if currentVersion != NoPepper {
+		if _, ok := h.peppers[currentVersion]; !ok {
+			return nil, fmt.Errorf("current pepper version %d is not configured", currentVersion)
+		}
+	}
+
+	return h, nil
+}
+
+// HashPassword hashes the given password using the configured variant and
+// parameters and the current pepper and returns the hash, salt and pepper version.
+func (h *Hasher) HashPassword(password string) (*PasswordData, error) {
+	input, err := h.pepper(password, h.currentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := h.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	passData, err := hashPassword(h.variant, h.params, password, input)
+	h.recorder.ObserveDuration(OpHash, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	passData.PepperVersion = h.currentVersion
+
+	return passData, nil
+}
+
+// ComparePassword compares the given password with the original hash, using the
+// variant, parameters and salt recorded in the hash, and the pepper version it
+// was made with. Legacy hashes without them use Argon2id, the default
+// parameters and salt. Salt and key lengths are validated as configured with
+// WithLengthCheck, and the key is derived at the length it was stored with.
+// bcrypt hashes ($2a$, $2b$, $2y$) imported from other
+// systems are verified too, without the pepper; see NeedsRehash.
+func (h *Hasher) ComparePassword(password string, salt, originalHash []byte, pepperVersion int) error {
+	err := h.comparePassword(password, salt, originalHash, pepperVersion)
+	if err != nil {
+		h.recorder.VerificationFailed(failureReason(err))
+	}
+
+	return err
+}
+
+func (h *Hasher) comparePassword(password string, salt, originalHash []byte, pepperVersion int) error {
+	input, err := h.pepper(password, pepperVersion)
+	if err != nil {
+		return err
+	}
+
+	release, err := h.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	start := time.Now()
+	defer func() { h.recorder.ObserveDuration(OpVerify, time.Since(start)) }()
+
+	return comparePassword(h.lengthCheck, password, input, salt, originalHash)
+}
+
+// CompareDummy is the package-level CompareDummy under the concurrency limit, so an
+// unknown user fails with ErrBusy exactly when a known one would. It uses the
+// configured parameters, so it takes as long as verifying a new hash.
+func (h *Hasher) CompareDummy(password string) error {
+	release, err := h.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	start := time.Now()
+	compareDummy(h.variant, h.params, password)
+	h.recorder.ObserveDuration(OpVerifyDummy, time.Since(start))
+
+	return nil
+}
+
+// acquire takes an Argon2 slot, waiting up to h.wait, and returns the function
+// that gives it back.
+func (h *Hasher) acquire() (release func(), err error) {
+	if h.slots == nil {
+		return func() {}, nil
+	}
+
+	release = func() { <-h.slots }
+
+	select {
+	case h.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(h.wait)
+	defer timer.Stop()
+
+	select {
+	case h.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrBusy
+	}
+}
+
+// pepper returns the Argon2 input for the password: the password itself for
+// NoPepper, otherwise HMAC-SHA256 of it keyed with the pepper secret.
+func (h *Hasher) pepper(password string, version int) ([]byte, error) {
+	if version == NoPepper {
+		return []byte(password), nil
+	}
+
+	secret, ok := h.peppers[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown pepper version %d", version)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+
+	return mac.Sum(nil), nil
 }
 
 // HashPassword hashes the given password using Argon2id and returns the hash and salt.
 func HashPassword(password string) (*PasswordData, error) {
+	return hashPassword(Argon2id, defaultParams, password, []byte(password))
+}
+
+// ComparePassword compares the given password with the original hash; salt is
+// only used for legacy hashes, see Hasher.ComparePassword. Lengths are checked
+// with LengthStrict.
+func ComparePassword(password string, salt, originalHash []byte) error {
+	return comparePassword(LengthStrict, password, []byte(password), salt, originalHash)
+}
+
+func hashPassword(variant Variant, p Params, password string, input []byte) (*PasswordData, error) {
 	if password == "" {
-		return nil, fmt.Errorf("password cannot be empty")
+		return nil, ErrEmptyPassword
 	}
 
 	salt := make([]byte, saltLength)
@@ -32,32 +291,65 @@ func HashPassword(password string) (*PasswordData, error) {
 		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, timeCost, memoryCost, parallelism, keyLength)
+	hash := encoded{
+		variant: variant,
+		params:  p,
+		salt:    salt,
+		key:     variant.key(input, salt, p, keyLength),
+	}
 
 	return &PasswordData{
-		Hash: hash,
+		Hash: []byte(hash.String()),
 		Salt: salt,
 	}, nil
 }
 
-// ComparePassword compares the given password with the original hash using the provided salt.
-func ComparePassword(password string, salt, originalHash []byte) error {
-	if len(salt) != saltLength {
-		return fmt.Errorf("invalid salt length: expected %d, got %d", saltLength, len(salt))
+func comparePassword(check LengthCheck, password string, input []byte, salt, originalHash []byte) error {
+	if isBcrypt(originalHash) {
+		return compareBcrypt(password, originalHash)
+	}
+
+	hash := encoded{variant: Argon2id, params: defaultParams, salt: salt, key: originalHash}
+	legacy := !isEncoded(originalHash)
+	if !legacy {
+		var err error
+		if hash, err = decode(originalHash); err != nil {
+			return err
+		}
 	}
 
-	if len(originalHash) != keyLength {
-		return fmt.Errorf("invalid hash length: expected %d, got %d", keyLength, len(originalHash))
+	if err := check.validate(hash, legacy); err != nil {
+		return err
 	}
 
+	return hash.compare(password, input)
+}
+
+// compare checks the password, passed through the pepper as input, against e.
+// The lengths of e must have been validated.
+func (e encoded) compare(password string, input []byte) error {
 	if password == "" {
-		return fmt.Errorf("password cannot be empty")
+		return ErrEmptyPassword
 	}
 
-	newHash := argon2.IDKey([]byte(password), salt, timeCost, memoryCost, parallelism, keyLength)
+	newHash := e.variant.key(input, e.salt, e.params, uint32(len(e.key)))
 
-	if subtle.ConstantTimeCompare(originalHash, newHash) != 1 {
-		return fmt.Errorf("passwords do not match")
+	if subtle.ConstantTimeCompare(e.key, newHash) != 1 {
+		return ErrPasswordMismatch
 	}
 	return nil
 }
+
+// CompareDummy performs the same Argon2 work as ComparePassword against a fixed hash
+// and discards the result. Call it when there is no stored hash to compare with
+// (e.g. the user does not exist), so that both paths take comparable time and
+// response timing does not reveal which accounts exist.
+func CompareDummy(password string) {
+	compareDummy(Argon2id, defaultParams, password)
+}
+
+func compareDummy(variant Variant, p Params, password string) {
+	newHash := variant.key([]byte(password), dummySalt, p, keyLength)
+
+	_ = subtle.ConstantTimeCompare(dummyHash, newHash)
+}
@@ -0,0 +1,291 @@
+package hash
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+)
+
+const testPassword = "correct-password"
+
+func TestHasher_WithoutPepper(t *testing.T) {
+	h, err := NewHasher(nil, NoPepper)
+	require.NoError(t, err)
+
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+	assert.Equal(t, NoPepper, passData.PepperVersion)
+
+	require.NoError(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+	// Unpeppered hashes are interchangeable with the package-level helpers.
+	require.NoError(t, ComparePassword(testPassword, passData.Salt, passData.Hash))
+}
+
+func TestHasher_WithPepper(t *testing.T) {
+	h, err := NewHasher(map[int]string{1: "pepper-v1"}, 1)
+	require.NoError(t, err)
+
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+	assert.Equal(t, 1, passData.PepperVersion)
+
+	require.NoError(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+	assert.Error(t, h.ComparePassword("wrong-password", passData.Salt, passData.Hash, passData.PepperVersion))
+
+	// Without the pepper the stored hash is useless.
+	assert.Error(t, ComparePassword(testPassword, passData.Salt, passData.Hash))
+}
+
+func TestHasher_WrongPepperFails(t *testing.T) {
+	h, err := NewHasher(map[int]string{1: "pepper-v1"}, 1)
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	other, err := NewHasher(map[int]string{1: "another-secret"}, 1)
+	require.NoError(t, err)
+
+	assert.Error(t, other.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+}
+
+func TestHasher_Rotation(t *testing.T) {
+	before, err := NewHasher(map[int]string{1: "pepper-v1"}, 1)
+	require.NoError(t, err)
+	oldData, err := before.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	after, err := NewHasher(map[int]string{1: "pepper-v1", 2: "pepper-v2"}, 2)
+	require.NoError(t, err)
+	newData, err := after.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, newData.PepperVersion)
+	assert.NoError(t, after.ComparePassword(testPassword, oldData.Salt, oldData.Hash, oldData.PepperVersion))
+	assert.NoError(t, after.ComparePassword(testPassword, newData.Salt, newData.Hash, newData.PepperVersion))
+
+	// Dropping a retired version makes its hashes unverifiable.
+	assert.Error(t, before.ComparePassword(testPassword, newData.Salt, newData.Hash, newData.PepperVersion))
+}
+
+func TestNewHasher_InvalidConfig(t *testing.T) {
+	_, err := NewHasher(nil, 1)
+	assert.Error(t, err, "current version must be configured")
+
+	_, err = NewHasher(map[int]string{NoPepper: "secret"}, NoPepper)
+	assert.Error(t, err, "version 0 is reserved")
+
+	_, err = NewHasher(map[int]string{1: ""}, 1)
+	assert.Error(t, err, "empty secret")
+}
+
+// legacyHash returns a hash in the format used before hashes were encoded:
+// the bare Argon2id key, with the salt stored separately.
+func legacyHash(password string) (salt, hash []byte) {
+	salt = []byte("0123456789abcdef")
+
+	return salt, argon2.IDKey([]byte(password), salt, timeCost, memoryCost, parallelism, keyLength)
+}
+
+func TestSentinelErrors(t *testing.T) {
+	h, err := NewHasher(nil, NoPepper)
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+	legacySalt, legacy := legacyHash(testPassword)
+	shortSalt := encoded{variant: Argon2id, params: defaultParams, salt: passData.Salt[:8], key: make([]byte, keyLength)}
+
+	_, err = h.HashPassword("")
+	assert.ErrorIs(t, err, ErrEmptyPassword)
+	_, err = HashPassword("")
+	assert.ErrorIs(t, err, ErrEmptyPassword)
+
+	for name, tc := range map[string]struct {
+		password   string
+		salt, hash []byte
+		want       error
+	}{
+		"empty password": {password: "", salt: passData.Salt, hash: passData.Hash, want: ErrEmptyPassword},
+		"mismatch":       {password: "wrong-password", salt: passData.Salt, hash: passData.Hash, want: ErrPasswordMismatch},
+		"short salt":     {password: testPassword, salt: legacySalt[:8], hash: legacy, want: ErrInvalidSaltLength},
+		"long hash":      {password: testPassword, salt: legacySalt, hash: append(legacy, 0), want: ErrInvalidHashLength},
+
+		"short encoded salt": {password: testPassword, hash: []byte(shortSalt.String()), want: ErrInvalidSaltLength},
+		"malformed":          {password: testPassword, hash: append(passData.Hash, '!'), want: ErrMalformedHash},
+		"unknown variant":    {password: testPassword, hash: bytes.Replace(passData.Hash, []byte("argon2id"), []byte("argon2d"), 1), want: ErrUnknownVariant},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, h.ComparePassword(tc.password, tc.salt, tc.hash, NoPepper), tc.want)
+			assert.ErrorIs(t, ComparePassword(tc.password, tc.salt, tc.hash), tc.want)
+		})
+	}
+}
+
+func TestHasher_ConcurrencyLimit(t *testing.T) {
+	h, err := NewHasher(nil, NoPepper, WithConcurrencyLimit(2, 50*time.Millisecond))
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	// Occupy both slots, as two in-flight logins would.
+	releaseFirst, err := h.acquire()
+	require.NoError(t, err)
+	releaseSecond, err := h.acquire()
+	require.NoError(t, err)
+
+	_, err = h.HashPassword(testPassword)
+	assert.ErrorIs(t, err, ErrBusy)
+	assert.ErrorIs(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion), ErrBusy)
+	assert.ErrorIs(t, h.CompareDummy(testPassword), ErrBusy)
+
+	// A slot freed while waiting is picked up.
+	time.AfterFunc(10*time.Millisecond, releaseFirst)
+	assert.NoError(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+
+	releaseSecond()
+}
+
+func TestHasher_ConcurrencyLimitBoundsInFlight(t *testing.T) {
+	const limit, callers = 2, 8
+
+	h, err := NewHasher(nil, NoPepper, WithConcurrencyLimit(limit, time.Minute))
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	var (
+		wg          sync.WaitGroup
+		maxInFlight atomic.Int32
+		done        = make(chan struct{})
+	)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if n := int32(len(h.slots)); n > maxInFlight.Load() {
+					maxInFlight.Store(n)
+				}
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	for range callers {
+		wg.Go(func() {
+			assert.NoError(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+		})
+	}
+	wg.Wait()
+	close(done)
+
+	assert.Equal(t, int32(limit), maxInFlight.Load())
+}
+
+// TestHasher_ConcurrencyLimitCapsMemory is a small load test: a burst of logins
+// far above the limit must not allocate Argon2 memory for all of them at once.
+func TestHasher_ConcurrencyLimitCapsMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+
+	const limit, callers = 2, 16
+	const argon2Bytes = memoryCost * 1024
+
+	h, err := NewHasher(nil, NoPepper, WithConcurrencyLimit(limit, time.Minute))
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var (
+		wg       sync.WaitGroup
+		peakHeap atomic.Uint64
+		done     = make(chan struct{})
+	)
+
+	go func() {
+		var m runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				runtime.ReadMemStats(&m)
+				if m.HeapInuse > peakHeap.Load() {
+					peakHeap.Store(m.HeapInuse)
+				}
+			}
+		}
+	}()
+
+	for range callers {
+		wg.Go(func() {
+			_ = h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion)
+		})
+	}
+	wg.Wait()
+	close(done)
+
+	// Unbounded, 16 callers would hold 1GB of Argon2 memory at once. Bounded to 2,
+	// the heap stays within a few Argon2 blocks, allowing for garbage the GC has
+	// not collected yet.
+	growth := peakHeap.Load() - min(peakHeap.Load(), before.HeapInuse)
+	assert.Less(t, growth, uint64(6*argon2Bytes), "heap grew by %d MB", growth>>20)
+}
+
+func BenchmarkHashPassword(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		peppers map[int]string
+		version int
+	}{
+		{name: "no_pepper", version: NoPepper},
+		{name: "pepper", peppers: map[int]string{1: "pepper-v1"}, version: 1},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			h, err := NewHasher(bc.peppers, bc.version)
+			require.NoError(b, err)
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := h.HashPassword(testPassword); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkComparePassword(b *testing.B) {
+	h, err := NewHasher(nil, NoPepper)
+	require.NoError(b, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(b, err)
+
+	for _, bc := range []struct {
+		name     string
+		password string
+	}{
+		{name: "match", password: testPassword},
+		{name: "mismatch", password: "wrong-password"},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				_ = h.ComparePassword(bc.password, passData.Salt, passData.Hash, passData.PepperVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package hash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptPrefixes start the bcrypt hashes ComparePassword accepts.
+var bcryptPrefixes = [][]byte{[]byte("$2a$"), []byte("$2b$"), []byte("$2y$")}
+
+func isBcrypt(hash []byte) bool {
+	for _, prefix := range bcryptPrefixes {
+		if bytes.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareBcrypt verifies a bcrypt hash imported from another system. Such
+// hashes were made without our pepper, so the plain password is checked.
+func compareBcrypt(password string, hash []byte) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return ErrPasswordMismatch
+	default:
+		return fmt.Errorf("%w: bcrypt: %v", ErrMalformedHash, err)
+	}
+}
+
+// NeedsRehash reports whether a stored hash should be replaced with one made by
+// HashPassword once the password is known, i.e. after a successful login. That
+// is the case for bcrypt hashes imported from other systems.
+func NeedsRehash(hash []byte) bool {
+	return isBcrypt(hash)
+}
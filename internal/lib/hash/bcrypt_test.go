@@ -0,0 +1,40 @@
+package hash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestComparePassword_Bcrypt(t *testing.T) {
+	h, err := NewHasher(map[int]string{1: "pepper-v1"}, 1)
+	require.NoError(t, err)
+
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		t.Run(prefix, func(t *testing.T) {
+			stored, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+			require.NoError(t, err)
+			stored = append([]byte(prefix), stored[4:]...)
+
+			// Imported hashes come without salt and pepper.
+			assert.NoError(t, h.ComparePassword(testPassword, nil, stored, NoPepper))
+			assert.NoError(t, ComparePassword(testPassword, nil, stored))
+			assert.ErrorIs(t, h.ComparePassword("wrong-password", nil, stored, NoPepper), ErrPasswordMismatch)
+			assert.ErrorIs(t, h.ComparePassword("", nil, stored, NoPepper), ErrEmptyPassword)
+			assert.True(t, NeedsRehash(stored))
+		})
+	}
+
+	assert.ErrorIs(t, h.ComparePassword(testPassword, nil, []byte("$2a$10$tooshort"), NoPepper), ErrMalformedHash)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	passData, err := HashPassword(testPassword)
+	require.NoError(t, err)
+	assert.False(t, NeedsRehash(passData.Hash))
+
+	_, legacy := legacyHash(testPassword)
+	assert.False(t, NeedsRehash(legacy))
+}
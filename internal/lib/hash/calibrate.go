@@ -0,0 +1,69 @@
+package hash
+
+import (
+	"time"
+)
+
+const (
+	// minCalibratedMemory is the least memory Calibrate goes down to, the OWASP
+	// minimum for Argon2id, even if hashing then takes longer than the target.
+	minCalibratedMemory = 19 * 1024
+	// calibrationRuns is how many hashes each measurement takes the fastest of,
+	// to smooth out scheduling noise.
+	calibrationRuns = 3
+)
+
+// Calibrate measures Argon2id hashing on the current hardware and returns
+// parameters that take roughly target per hash, for use with WithParams. It
+// keeps the default memory and threads and raises the time cost; if a single
+// pass already takes longer than target, it lowers the memory instead.
+//
+// Calibrate hashes for a few times target, so run it at startup or from a CLI,
+// not per request. Measurements on a busy machine come out slow and yield
+// cheaper parameters.
+func Calibrate(target time.Duration) Params {
+	return calibrate(target, measure)
+}
+
+// calibrate is Calibrate with the hashing time of parameters taken from measure.
+func calibrate(target time.Duration, measure func(Params) time.Duration) Params {
+	p := defaultParams
+	p.Time = 1
+
+	pass := measure(p)
+	if pass >= target {
+		p.Memory = uint32(max(int64(minCalibratedMemory), int64(p.Memory)*int64(target)/int64(pass)))
+		return p
+	}
+
+	p.Time = scaleTime(p.Time, target, pass)
+
+	// A single pass includes fixed costs, e.g. allocating the memory, so the
+	// first estimate overshoots; correct it once against a full measurement.
+	if p.Time > 1 {
+		p.Time = scaleTime(p.Time, target, measure(p))
+	}
+
+	return p
+}
+
+// scaleTime scales the time cost t, measured to take took, to take target.
+func scaleTime(t uint32, target, took time.Duration) uint32 {
+	scaled := (int64(t)*int64(target) + int64(took)/2) / int64(took)
+
+	return uint32(max(1, scaled))
+}
+
+// measure returns how long the fastest of calibrationRuns hashes with p takes.
+func measure(p Params) time.Duration {
+	var fastest time.Duration
+	for i := range calibrationRuns {
+		start := time.Now()
+		Argon2id.key([]byte("calibration password"), dummySalt, p, keyLength)
+		if took := time.Since(start); i == 0 || took < fastest {
+			fastest = took
+		}
+	}
+
+	return max(fastest, time.Nanosecond)
+}
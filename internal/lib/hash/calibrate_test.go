@@ -0,0 +1,63 @@
+package hash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linearCost models hashing that takes pass per pass over the default memory,
+// scaling with the time and memory costs.
+func linearCost(pass time.Duration) func(Params) time.Duration {
+	return func(p Params) time.Duration {
+		return pass * time.Duration(p.Time) * time.Duration(p.Memory) / time.Duration(defaultParams.Memory)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("calibration hashes on the real hardware")
+	}
+
+	// Only what holds on any machine under any load: timings are not asserted.
+	p := Calibrate(50 * time.Millisecond)
+	require.NoError(t, p.validate())
+	assert.Equal(t, defaultParams.Threads, p.Threads)
+
+	h, err := NewHasher(nil, NoPepper, WithParams(p))
+	require.NoError(t, err)
+	passData, err := h.HashPassword("password")
+	require.NoError(t, err)
+	assert.NoError(t, h.ComparePassword("password", passData.Salt, passData.Hash, NoPepper),
+		"hashes made with calibrated parameters verify")
+}
+
+func TestCalibrate_Scaling(t *testing.T) {
+	measure := linearCost(40 * time.Millisecond)
+
+	var prev Params
+	for _, target := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		p := calibrate(target, measure)
+		require.NoError(t, p.validate())
+		assert.Equal(t, defaultParams.Memory, p.Memory, "fast hardware keeps the memory cost")
+		assert.InDelta(t, target, measure(p), float64(40*time.Millisecond), "target %s", target)
+		assert.Greater(t, p.Time, prev.Time, "costs grow with the target")
+		prev = p
+	}
+}
+
+func TestCalibrate_SlowHardware(t *testing.T) {
+	p := calibrate(250*time.Millisecond, linearCost(500*time.Millisecond))
+	assert.Equal(t, uint32(1), p.Time)
+	assert.Equal(t, defaultParams.Memory/2, p.Memory, "the memory cost is lowered to meet the target")
+
+	p = calibrate(250*time.Millisecond, linearCost(time.Minute))
+	assert.Equal(t, uint32(minCalibratedMemory), p.Memory, "but not below the floor")
+}
+
+func TestNewHasher_InvalidParams(t *testing.T) {
+	_, err := NewHasher(nil, NoPepper, WithParams(Params{Time: 1, Memory: 0, Threads: 1}))
+	assert.ErrorIs(t, err, ErrInvalidParams)
+}
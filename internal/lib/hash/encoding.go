@@ -0,0 +1,184 @@
+package hash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Variant is the Argon2 variant new hashes are made with.
+type Variant string
+
+const (
+	// Argon2id resists both side-channel and GPU attacks; it is the default.
+	Argon2id Variant = "argon2id"
+	// Argon2i resists side-channel attacks; some compliance regimes require it.
+	Argon2i Variant = "argon2i"
+)
+
+// LengthCheck is how strictly stored salt and key lengths are validated before
+// a hash is verified.
+type LengthCheck string
+
+const (
+	// LengthStrict requires legacy hashes to be exactly the sizes this package
+	// makes them, and encoded ones to be at least those sizes. It is the default.
+	LengthStrict LengthCheck = "strict"
+	// LengthLenient accepts any sizes Argon2 allows, e.g. for hashes imported
+	// from systems that used shorter salts or keys.
+	LengthLenient LengthCheck = "lenient"
+)
+
+// The smallest salt and key Argon2 allows (RFC 9106).
+const (
+	minSaltLength = 8
+	minKeyLength  = 4
+)
+
+var (
+	ErrUnknownVariant     = errors.New("unknown argon2 variant")
+	ErrUnknownLengthCheck = errors.New("unknown length check")
+	// ErrMalformedHash means a stored hash looks encoded but can't be parsed.
+	ErrMalformedHash = errors.New("malformed encoded hash")
+)
+
+// ParseVariant returns the variant named s, "argon2id" or "argon2i".
+func ParseVariant(s string) (Variant, error) {
+	switch v := Variant(s); v {
+	case Argon2id, Argon2i:
+		return v, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownVariant, s)
+	}
+}
+
+// ParseLengthCheck returns the length check named s, "strict" or "lenient".
+func ParseLengthCheck(s string) (LengthCheck, error) {
+	switch c := LengthCheck(s); c {
+	case LengthStrict, LengthLenient:
+		return c, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownLengthCheck, s)
+	}
+}
+
+// validate checks the salt and key lengths of e. Legacy hashes record nothing
+// but the key, so strict mode can only hold them to the package sizes; encoded
+// hashes are verified with the key length they were made with.
+func (c LengthCheck) validate(e encoded, legacy bool) error {
+	minSalt, minKey := saltLength, keyLength
+	if c == LengthLenient {
+		minSalt, minKey = minSaltLength, minKeyLength
+	}
+
+	switch {
+	case c == LengthStrict && legacy && len(e.salt) != saltLength:
+		return fmt.Errorf("%w: expected %d, got %d", ErrInvalidSaltLength, saltLength, len(e.salt))
+	case c == LengthStrict && legacy && len(e.key) != keyLength:
+		return fmt.Errorf("%w: expected %d, got %d", ErrInvalidHashLength, keyLength, len(e.key))
+	case len(e.salt) < minSalt:
+		return fmt.Errorf("%w: expected at least %d, got %d", ErrInvalidSaltLength, minSalt, len(e.salt))
+	case len(e.key) < minKey:
+		return fmt.Errorf("%w: expected at least %d, got %d", ErrInvalidHashLength, minKey, len(e.key))
+	}
+
+	return nil
+}
+
+// Params are the Argon2 cost parameters a hash is made with.
+type Params struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// ErrInvalidParams means an Argon2 cost parameter is zero.
+var ErrInvalidParams = errors.New("invalid Argon2 parameters")
+
+func (p Params) validate() error {
+	if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+		return fmt.Errorf("%w: m=%d,t=%d,p=%d", ErrInvalidParams, p.Memory, p.Time, p.Threads)
+	}
+
+	return nil
+}
+
+// defaultParams are the parameters new hashes are made with unless set with
+// WithParams, and the ones of legacy hashes, which don't record them.
+var defaultParams = Params{Time: timeCost, Memory: memoryCost, Threads: parallelism}
+
+func (v Variant) key(input, salt []byte, p Params, keyLen uint32) []byte {
+	if v == Argon2i {
+		return argon2.Key(input, salt, p.Time, p.Memory, p.Threads, keyLen)
+	}
+
+	return argon2.IDKey(input, salt, p.Time, p.Memory, p.Threads, keyLen)
+}
+
+// encodedPrefix starts every encoded hash. Legacy hashes are the bare 32-byte
+// key, with the salt stored next to it.
+var encodedPrefix = []byte("$argon2")
+
+// encoded is a hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key> with unpadded base64 salt and key.
+type encoded struct {
+	variant Variant
+	params  Params
+	salt    []byte
+	key     []byte
+}
+
+func isEncoded(hash []byte) bool {
+	return bytes.HasPrefix(hash, encodedPrefix)
+}
+
+func (e encoded) String() string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		e.variant, argon2.Version, e.params.Memory, e.params.Time, e.params.Threads,
+		base64.RawStdEncoding.EncodeToString(e.salt), base64.RawStdEncoding.EncodeToString(e.key),
+	)
+}
+
+func decode(hash []byte) (encoded, error) {
+	// "", variant, version, params, salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return encoded{}, fmt.Errorf("%w: expected 5 fields", ErrMalformedHash)
+	}
+
+	var e encoded
+
+	variant, err := ParseVariant(parts[1])
+	if err != nil {
+		return encoded{}, err
+	}
+	e.variant = variant
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return encoded{}, fmt.Errorf("%w: version: %v", ErrMalformedHash, err)
+	}
+	if version != argon2.Version {
+		return encoded{}, fmt.Errorf("%w: unsupported version %d", ErrMalformedHash, version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &e.params.Memory, &e.params.Time, &e.params.Threads); err != nil {
+		return encoded{}, fmt.Errorf("%w: parameters: %v", ErrMalformedHash, err)
+	}
+	if e.params.Memory == 0 || e.params.Time == 0 || e.params.Threads == 0 {
+		return encoded{}, fmt.Errorf("%w: zero parameter", ErrMalformedHash)
+	}
+
+	if e.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return encoded{}, fmt.Errorf("%w: salt: %v", ErrMalformedHash, err)
+	}
+	if e.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return encoded{}, fmt.Errorf("%w: key: %v", ErrMalformedHash, err)
+	}
+
+	return e, nil
+}
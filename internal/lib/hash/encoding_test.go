@@ -0,0 +1,134 @@
+package hash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHasher_Variants(t *testing.T) {
+	for _, variant := range []Variant{Argon2id, Argon2i} {
+		t.Run(string(variant), func(t *testing.T) {
+			h, err := NewHasher(map[int]string{1: "pepper-v1"}, 1, WithVariant(variant))
+			require.NoError(t, err)
+
+			passData, err := h.HashPassword(testPassword)
+			require.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(passData.Hash, []byte("$"+string(variant)+"$v=19$m=65536,t=1,p=4$")), "%s", passData.Hash)
+
+			assert.NoError(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+			assert.ErrorIs(t, h.ComparePassword("wrong-password", passData.Salt, passData.Hash, passData.PepperVersion), ErrPasswordMismatch)
+		})
+	}
+}
+
+func TestHasher_VerifiesHashesOfOtherVariants(t *testing.T) {
+	argon2i, err := NewHasher(nil, NoPepper, WithVariant(Argon2i))
+	require.NoError(t, err)
+	passData, err := argon2i.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	argon2id, err := NewHasher(nil, NoPepper)
+	require.NoError(t, err)
+	assert.NoError(t, argon2id.ComparePassword(testPassword, passData.Salt, passData.Hash, NoPepper), "the hash records its variant")
+
+	salt, legacy := legacyHash(testPassword)
+	assert.NoError(t, argon2i.ComparePassword(testPassword, salt, legacy, NoPepper), "legacy hashes are Argon2id")
+}
+
+func TestComparePassword_MismatchedVariant(t *testing.T) {
+	h, err := NewHasher(nil, NoPepper, WithVariant(Argon2i))
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	relabeled := bytes.Replace(passData.Hash, []byte("$argon2i$"), []byte("$argon2id$"), 1)
+
+	assert.ErrorIs(t, h.ComparePassword(testPassword, passData.Salt, relabeled, NoPepper), ErrPasswordMismatch)
+}
+
+func TestParseVariant(t *testing.T) {
+	for _, s := range []string{"argon2id", "argon2i"} {
+		v, err := ParseVariant(s)
+		require.NoError(t, err)
+		assert.Equal(t, Variant(s), v)
+	}
+
+	for _, s := range []string{"", "argon2d", "Argon2id", "bcrypt"} {
+		_, err := ParseVariant(s)
+		assert.ErrorIs(t, err, ErrUnknownVariant, s)
+	}
+
+	_, err := NewHasher(nil, NoPepper, WithVariant("argon2d"))
+	assert.ErrorIs(t, err, ErrUnknownVariant)
+}
+
+func TestParseLengthCheck(t *testing.T) {
+	for _, s := range []string{"strict", "lenient"} {
+		c, err := ParseLengthCheck(s)
+		require.NoError(t, err)
+		assert.Equal(t, LengthCheck(s), c)
+	}
+
+	for _, s := range []string{"", "Strict", "none"} {
+		_, err := ParseLengthCheck(s)
+		assert.ErrorIs(t, err, ErrUnknownLengthCheck, s)
+	}
+
+	_, err := NewHasher(nil, NoPepper, WithLengthCheck("none"))
+	assert.ErrorIs(t, err, ErrUnknownLengthCheck)
+}
+
+// encodedHash returns an encoded Argon2id hash of password with a saltLen-byte
+// salt and a keyLen-byte key.
+func encodedHash(password string, saltLen, keyLen int) []byte {
+	salt := bytes.Repeat([]byte{7}, saltLen)
+	hash := encoded{
+		variant: Argon2id,
+		params:  defaultParams,
+		salt:    salt,
+		key:     Argon2id.key([]byte(password), salt, defaultParams, uint32(keyLen)),
+	}
+
+	return []byte(hash.String())
+}
+
+func TestHasher_LengthCheck(t *testing.T) {
+	legacySalt, legacy := legacyHash(testPassword)
+	shortLegacySalt := legacySalt[:minSaltLength]
+	shortLegacy := argon2.IDKey([]byte(testPassword), shortLegacySalt, timeCost, memoryCost, parallelism, 16)
+
+	for name, tc := range map[string]struct {
+		salt, hash []byte
+		strict     error
+		lenient    error
+	}{
+		"legacy":                    {salt: legacySalt, hash: legacy},
+		"legacy short salt and key": {salt: shortLegacySalt, hash: shortLegacy, strict: ErrInvalidSaltLength},
+		"legacy salt below minimum": {salt: legacySalt[:4], hash: legacy, strict: ErrInvalidSaltLength, lenient: ErrInvalidSaltLength},
+
+		"encoded default sizes":     {hash: encodedHash(testPassword, saltLength, keyLength)},
+		"encoded larger sizes":      {hash: encodedHash(testPassword, 32, 64)},
+		"encoded smaller salt":      {hash: encodedHash(testPassword, minSaltLength, keyLength), strict: ErrInvalidSaltLength},
+		"encoded smaller key":       {hash: encodedHash(testPassword, saltLength, 16), strict: ErrInvalidHashLength},
+		"encoded key below minimum": {hash: encodedHash(testPassword, saltLength, 2), strict: ErrInvalidHashLength, lenient: ErrInvalidHashLength},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for check, want := range map[LengthCheck]error{LengthStrict: tc.strict, LengthLenient: tc.lenient} {
+				h, err := NewHasher(nil, NoPepper, WithLengthCheck(check))
+				require.NoError(t, err)
+
+				err = h.ComparePassword(testPassword, tc.salt, tc.hash, NoPepper)
+				if want == nil {
+					assert.NoError(t, err, check)
+					assert.ErrorIs(t, h.ComparePassword("wrong-password", tc.salt, tc.hash, NoPepper), ErrPasswordMismatch, check)
+				} else {
+					assert.ErrorIs(t, err, want, check)
+				}
+			}
+		})
+	}
+}
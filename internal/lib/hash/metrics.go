@@ -0,0 +1,66 @@
+package hash
+
+import (
+	"errors"
+	"time"
+)
+
+// Operation names a Hasher operation in the timings given to a Recorder.
+type Operation string
+
+const (
+	OpHash   Operation = "hash"
+	OpVerify Operation = "verify"
+	// OpVerifyDummy is CompareDummy, the verification done for unknown users.
+	OpVerifyDummy Operation = "verify_dummy"
+)
+
+// FailureReason says why ComparePassword failed.
+type FailureReason string
+
+const (
+	FailureMismatch FailureReason = "mismatch"
+	FailureBusy     FailureReason = "busy"
+	// FailureCorrupt means the stored hash or salt is not one we can verify.
+	FailureCorrupt FailureReason = "corrupt"
+	FailureOther   FailureReason = "other"
+)
+
+// Recorder receives the timings and failures of a Hasher, e.g. to export them as
+// a duration histogram and a failure counter. It must be safe for concurrent use.
+type Recorder interface {
+	// ObserveDuration is called with the time one operation spent hashing. Time
+	// spent waiting for a slot under WithConcurrencyLimit is not included.
+	ObserveDuration(op Operation, d time.Duration)
+	// VerificationFailed is called for every ComparePassword that fails.
+	VerificationFailed(reason FailureReason)
+}
+
+type noopRecorder struct{}
+
+func (noopRecorder) ObserveDuration(Operation, time.Duration) {}
+func (noopRecorder) VerificationFailed(FailureReason)         {}
+
+// WithRecorder reports the Hasher's timings and failures to r. Without it they
+// are discarded.
+func WithRecorder(r Recorder) Option {
+	return func(h *Hasher) {
+		if r != nil {
+			h.recorder = r
+		}
+	}
+}
+
+func failureReason(err error) FailureReason {
+	switch {
+	case errors.Is(err, ErrPasswordMismatch):
+		return FailureMismatch
+	case errors.Is(err, ErrBusy):
+		return FailureBusy
+	case errors.Is(err, ErrInvalidSaltLength), errors.Is(err, ErrInvalidHashLength),
+		errors.Is(err, ErrMalformedHash), errors.Is(err, ErrUnknownVariant):
+		return FailureCorrupt
+	default:
+		return FailureOther
+	}
+}
@@ -0,0 +1,81 @@
+package hash
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type observation struct {
+	op Operation
+	d  time.Duration
+}
+
+type fakeRecorder struct {
+	mu           sync.Mutex
+	observations []observation
+	failures     []FailureReason
+}
+
+func (r *fakeRecorder) ObserveDuration(op Operation, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observations = append(r.observations, observation{op: op, d: d})
+}
+
+func (r *fakeRecorder) VerificationFailed(reason FailureReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, reason)
+}
+
+func TestHasher_RecordsDurations(t *testing.T) {
+	rec := &fakeRecorder{}
+	h, err := NewHasher(nil, NoPepper, WithRecorder(rec))
+	require.NoError(t, err)
+
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+	require.NoError(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+	require.NoError(t, h.CompareDummy(testPassword))
+
+	require.Len(t, rec.observations, 3)
+	for i, op := range []Operation{OpHash, OpVerify, OpVerifyDummy} {
+		assert.Equal(t, op, rec.observations[i].op)
+		// Argon2 over 64MB takes milliseconds, not microseconds or minutes.
+		assert.Greater(t, rec.observations[i].d, time.Millisecond, op)
+		assert.Less(t, rec.observations[i].d, 10*time.Second, op)
+	}
+	assert.Empty(t, rec.failures)
+}
+
+func TestHasher_RecordsVerificationFailures(t *testing.T) {
+	rec := &fakeRecorder{}
+	h, err := NewHasher(nil, NoPepper, WithRecorder(rec), WithConcurrencyLimit(1, time.Millisecond))
+	require.NoError(t, err)
+	passData, err := h.HashPassword(testPassword)
+	require.NoError(t, err)
+
+	assert.Error(t, h.ComparePassword("wrong-password", passData.Salt, passData.Hash, passData.PepperVersion))
+	assert.Error(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash[:len(passData.Hash)-1], passData.PepperVersion))
+	assert.Error(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, 7))
+
+	release, err := h.acquire()
+	require.NoError(t, err)
+	assert.Error(t, h.ComparePassword(testPassword, passData.Salt, passData.Hash, passData.PepperVersion))
+	release()
+
+	assert.Equal(t, []FailureReason{FailureMismatch, FailureCorrupt, FailureOther, FailureBusy}, rec.failures)
+
+	// Only the two comparisons that got to hash were timed.
+	var verifications int
+	for _, o := range rec.observations {
+		if o.op == OpVerify {
+			verifications++
+		}
+	}
+	assert.Equal(t, 2, verifications)
+}
@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"encoding/pem"
+	"sso/internal/domain/models"
+	"sso/internal/lib/keygen"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEdDSATestApp(t testing.TB) models.App {
+	t.Helper()
+
+	keyPair, err := keygen.GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	return models.App{
+		ID:         1,
+		Name:       "test",
+		PrivateKey: keyPair.PrivateKey,
+		PublicKey:  keyPair.PublicKey,
+		Algorithm:  models.AlgEdDSA,
+	}
+}
+
+func TestNewToken_EdDSA(t *testing.T) {
+	app := newEdDSATestApp(t)
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	token, err := newTestJWT().NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", parsed.Header["alg"])
+
+	claims, err := Verify(token, app.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+
+	verify, err := newTestJWT().TokenVerifier(app)
+	require.NoError(t, err)
+	got, err := verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, user.Email, got.Email)
+}
+
+func TestTokenVerifier_AlgorithmConfusion(t *testing.T) {
+	app := newEdDSATestApp(t)
+	verify, err := newTestJWT().TokenVerifier(app)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{"uid": 7, "app_id": app.ID, "exp": time.Now().Add(time.Hour).Unix()}
+
+	// The public key is no secret, so an HS256 token keyed with it is forgeable.
+	block, _ := pem.Decode([]byte(app.PublicKey))
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(block.Bytes)
+	require.NoError(t, err)
+	_, err = verify(hs256)
+	assert.Error(t, err, "HS256 keyed with the public key")
+
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = verify(none)
+	assert.Error(t, err, "unsigned token")
+
+	// An RS256 token of the same app is rejected even under a matching key.
+	rsaApp := newTestApp(t)
+	rs256, err := newTestJWT().NewToken(models.User{ID: 7}, rsaApp, time.Hour)
+	require.NoError(t, err)
+	_, err = verify(rs256)
+	assert.Error(t, err, "RS256 token for an EdDSA app")
+}
+
+func TestTokenVerifier_KeyDoesNotMatchAlgorithm(t *testing.T) {
+	app := newTestApp(t)
+	app.Algorithm = models.AlgEdDSA
+
+	_, err := newTestJWT().TokenVerifier(app)
+	assert.ErrorContains(t, err, "does not match the app algorithm EdDSA")
+
+	_, err = newTestJWT().NewToken(models.User{ID: 7}, app, time.Hour)
+	assert.Error(t, err, "an RSA private key does not sign EdDSA")
+}
+
+func TestTokenVerifier_RotatedToEdDSA(t *testing.T) {
+	rsaApp := newTestApp(t)
+	old, err := newTestJWT().NewToken(models.User{ID: 7}, rsaApp, time.Hour)
+	require.NoError(t, err)
+
+	app := newEdDSATestApp(t)
+	app.PreviousPublicKey = rsaApp.PublicKey
+	verify, err := newTestJWT().TokenVerifier(app)
+	require.NoError(t, err)
+
+	_, err = verify(old)
+	assert.NoError(t, err, "tokens signed before the rotation verify by the previous key's algorithm")
+}
+
+func TestNewToken_UnsupportedAlgorithm(t *testing.T) {
+	app := newTestApp(t)
+	app.Algorithm = "HS256"
+
+	_, err := newTestJWT().NewToken(models.User{ID: 7}, app, time.Hour)
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestKMSSigner_RejectsEdDSA(t *testing.T) {
+	signer := NewKMSSigner(nil, map[int]string{1: "key"}, 0)
+
+	_, err := signer.Sign(newEdDSATestApp(t), []byte("input"))
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+// BenchmarkSign_Algorithm compares signing with a cached key by algorithm.
+func BenchmarkSign_Algorithm(b *testing.B) {
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	for name, app := range map[string]models.App{
+		"RS256": newBenchRSAApp(b),
+		"EdDSA": newEdDSATestApp(b),
+	} {
+		b.Run(name, func(b *testing.B) {
+			j := newTestJWT()
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := j.NewToken(user, app, time.Hour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVerify_Algorithm compares verifying with prepared keys by algorithm.
+func BenchmarkVerify_Algorithm(b *testing.B) {
+	for name, app := range map[string]models.App{
+		"RS256": newBenchRSAApp(b),
+		"EdDSA": newEdDSATestApp(b),
+	} {
+		token, err := newTestJWT().NewToken(models.User{ID: 7, Email: "user@example.com"}, app, time.Hour)
+		require.NoError(b, err)
+		verify, err := newTestJWT().TokenVerifier(app)
+		require.NoError(b, err)
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := verify(token); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func newBenchRSAApp(b *testing.B) models.App {
+	b.Helper()
+
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(b, err)
+
+	return models.App{ID: 1, Name: "test", PrivateKey: keyPair.PrivateKey, PublicKey: keyPair.PublicKey}
+}
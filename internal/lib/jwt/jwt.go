@@ -1,31 +1,154 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"sso/internal/domain/models"
 	"sso/internal/lib/keygen"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWT is a token provider that generates JWT tokens.
+// Claims are the claims carried by tokens minted by NewToken.
+type Claims struct {
+	UserID int64  `json:"uid"`
+	Email  string `json:"email"`
+	AppID  int    `json:"app_id"`
+	// IsAdmin is the user's admin status at the time the token was minted. It lets
+	// resource servers authorize admin actions without calling the IsAdmin RPC, but
+	// it stays stale until the token expires: a revoked admin keeps the claim for up
+	// to the token TTL. Use the IsAdmin RPC for revocation-sensitive checks.
+	IsAdmin bool `json:"is_admin"`
+	// Confirmation binds the token to a client, see WithBinding; nil if unbound.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Confirmation is the cnf claim (RFC 7800) of a token bound to a client.
+type Confirmation struct {
+	// FingerprintS256 is the base64url SHA-256 of the client fingerprint.
+	FingerprintS256 string `json:"fp#S256"`
+}
+
+// TokenOption adds optional claims to a token minted by NewToken.
+type TokenOption func(claims jwt.MapClaims)
+
+// WithBinding binds the token to the client with the given fingerprint hash by
+// adding it as the cnf claim. Verifiers must then check it against the client
+// presenting the token.
+func WithBinding(fingerprintS256 string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["cnf"] = Confirmation{FingerprintS256: fingerprintS256}
+	}
+}
+
+// JWT is a token provider that generates JWT tokens. It is safe for concurrent
+// use: the auth service shares one provider across all requests.
 type JWT struct {
 	log *slog.Logger
+
+	// issuer and audience are set as the iss and aud claims of minted tokens and
+	// required by TokenVerifier; empty values are neither set nor checked. Apps
+	// with an Issuer of their own use it instead of issuer.
+	issuer   string
+	audience string
+	// maxTTL caps the lifetime of minted tokens; zero leaves it unbounded.
+	maxTTL time.Duration
+	// leeway is the clock skew tolerated when verifying exp, nbf and iat.
+	leeway time.Duration
+	// now is the clock tokens are minted and verified by.
+	now func() time.Time
+
+	signer Signer
+}
+
+// Option configures optional behavior of the JWT provider.
+type Option func(*JWT)
+
+// WithIssuer sets the iss claim of minted tokens and makes TokenVerifier reject
+// tokens with any other issuer, e.g. tokens of another deployment. An app's own
+// Issuer takes precedence.
+func WithIssuer(issuer string) Option {
+	return func(j *JWT) {
+		j.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim of minted tokens and makes TokenVerifier reject
+// tokens not intended for that audience.
+func WithAudience(audience string) Option {
+	return func(j *JWT) {
+		j.audience = audience
+	}
+}
+
+// WithMaxTTL caps the lifetime of minted tokens at maxTTL, whatever duration
+// NewToken is asked for, e.g. by a misconfigured per-app TTL. Zero or less
+// leaves it unbounded.
+func WithMaxTTL(maxTTL time.Duration) Option {
+	return func(j *JWT) {
+		j.maxTTL = maxTTL
+	}
+}
+
+// MaxLeeway bounds WithLeeway: more tolerance would let stolen tokens outlive
+// their expiry noticeably.
+const MaxLeeway = 2 * time.Minute
+
+// WithLeeway makes TokenVerifier tolerate clock skew between this service and
+// its clients: tokens are accepted up to leeway past their exp, and leeway
+// before their nbf and iat. It is capped at MaxLeeway; zero or less allows no
+// skew.
+func WithLeeway(leeway time.Duration) Option {
+	return func(j *JWT) {
+		j.leeway = min(max(leeway, 0), MaxLeeway)
+	}
+}
+
+// WithClock makes the provider mint and verify tokens by now instead of the
+// system clock, e.g. to test expiry deterministically.
+func WithClock(now func() time.Time) Option {
+	return func(j *JWT) {
+		j.now = now
+	}
+}
+
+// WithSigner makes the provider sign tokens with signer instead of a KeySigner,
+// e.g. a KMSSigner keeping private keys out of storage.
+func WithSigner(signer Signer) Option {
+	return func(j *JWT) {
+		j.signer = signer
+	}
 }
 
 // New creates a new JWT token provider.
-func New(log *slog.Logger) *JWT {
-	return &JWT{
-		log: log,
+func New(log *slog.Logger, opts ...Option) *JWT {
+	j := &JWT{
+		log:    log,
+		now:    time.Now,
+		signer: NewKeySigner(),
+	}
+	for _, opt := range opts {
+		opt(j)
 	}
+
+	return j
 }
 
 // NewToken creates a new JWT token for the given user and app with the specified duration.
-// Tokens are signed using RS256 (asymmetric RSA) with the app's RSA private key, and clients
-// must use the corresponding app public key to verify them (this differs from HS256/HMAC).
-func (j *JWT) NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
+// Tokens are signed using the app's asymmetric algorithm, RS256 or EdDSA, by the provider's
+// Signer, by default with the app's private key, and clients must use the corresponding app
+// public key to verify them (this differs from HS256/HMAC).
+func (j *JWT) NewToken(user models.User, app models.App, duration time.Duration, opts ...TokenOption) (string, error) {
 	const op = "jwt.NewToken"
 
 	log := j.log.With(
@@ -34,30 +157,367 @@ func (j *JWT) NewToken(user models.User, app models.App, duration time.Duration)
 		slog.Int("app_id", app.ID),
 	)
 
-	token := jwt.New(jwt.SigningMethodRS256)
+	// A random token ID keeps tokens minted for the same user and app within
+	// the same second distinct, so each identifies its own session.
+	tokenID, err := newTokenID()
+	if err != nil {
+		log.Error("failed to generate token ID", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: failed to generate token ID: %w", op, err)
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
+	// Extra claims can't override the ones set below: saving an app rejects
+	// reserved claims, and so does this, for apps written around SaveApp.
+	extra, err := models.ParseExtraClaims(app.ExtraClaims)
+	if err != nil {
+		log.Error("app has invalid extra claims", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
 
+	claims := jwt.MapClaims{}
+	maps.Copy(claims, extra)
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
 	claims["app_id"] = app.ID
-	claims["exp"] = time.Now().Add(duration).Unix()
+	claims["is_admin"] = user.IsAdmin
+	claims["jti"] = tokenID
+	if j.audience != "" {
+		claims["aud"] = j.audience
+	}
+	for _, opt := range opts {
+		opt(claims)
+	}
 
-	// Parse the private key from PEM format
-	privateKey, err := keygen.ParseRSAPrivateKey(app.PrivateKey)
+	tokenString, err := j.sign(log, op, claims, app, duration)
 	if err != nil {
-		log.Error("failed to parse private key", slog.String("error", err.Error()))
-		return "", fmt.Errorf("%s: failed to parse private key: %w", op, err)
+		return "", err
 	}
 
-	// Sign the token with the private key
-	tokenString, err := token.SignedString(privateKey)
+	log.Info("token generated successfully")
+
+	return tokenString, nil
+}
+
+// NewIDToken creates an OpenID Connect ID token for the given user and app with
+// the specified duration, signed like NewToken. It carries identity claims only:
+// sub (the user ID), email, email_verified and name, with the app ID as aud. It
+// has no uid or app_id claim, so it is not accepted where an access token is.
+func (j *JWT) NewIDToken(user models.User, app models.App, duration time.Duration) (string, error) {
+	const op = "jwt.NewIDToken"
+
+	log := j.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", user.ID),
+		slog.Int("app_id", app.ID),
+	)
+
+	claims := jwt.MapClaims{
+		"sub":            strconv.FormatInt(user.ID, 10),
+		"aud":            strconv.Itoa(app.ID),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.DisplayName,
+	}
+
+	tokenString, err := j.sign(log, op, claims, app, duration)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("ID token generated successfully")
+
+	return tokenString, nil
+}
+
+// sign adds the iss, iat and exp claims to claims, with the lifetime clamped to
+// maxTTL, and returns them as a token signed by the provider's Signer.
+func (j *JWT) sign(log *slog.Logger, op string, claims jwt.MapClaims, app models.App, duration time.Duration) (string, error) {
+	if j.maxTTL > 0 && duration > j.maxTTL {
+		log.Warn("token TTL clamped to the maximum",
+			slog.Duration("requested_ttl", duration),
+			slog.Duration("max_ttl", j.maxTTL),
+		)
+		duration = j.maxTTL
+	}
+
+	now := j.now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	if issuer := j.issuerOf(app); issuer != "" {
+		claims["iss"] = issuer
+	}
+
+	method, err := signingMethod(app.SigningAlgorithm())
+	if err != nil {
+		log.Error("failed to sign token", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	// Lets verifiers holding several keys, e.g. across a rotation, pick the right one.
+	if keyID, err := keygen.KeyID(app.PublicKey); err == nil {
+		token.Header["kid"] = keyID
+	}
+
+	signingInput, err := token.SigningString()
+	if err != nil {
+		log.Error("failed to encode token", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: failed to encode token: %w", op, err)
+	}
+
+	signature, err := j.signer.Sign(app, []byte(signingInput))
 	if err != nil {
 		log.Error("failed to sign token", slog.String("error", err.Error()))
 		return "", fmt.Errorf("%s: failed to sign token: %w", op, err)
 	}
 
-	log.Info("token generated successfully")
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
 
-	return tokenString, nil
+// issuerOf returns the iss claim of the app's tokens: its own Issuer, e.g. of
+// its tenant, or the provider's.
+func (j *JWT) issuerOf(app models.App) string {
+	if app.Issuer != "" {
+		return app.Issuer
+	}
+
+	return j.issuer
+}
+
+// newTokenID returns a random value for the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// signingMethod returns the method tokens are signed with by alg, an app's
+// SigningAlgorithm.
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case models.AlgRS256:
+		return jwt.SigningMethodRS256, nil
+	case models.AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+// verificationKey is a parsed public key with the only algorithm tokens are
+// accepted under, whatever their alg header says: an RSA key as the HMAC
+// secret of an HS256 token must not verify.
+type verificationKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// parseVerificationKey parses a PEM-encoded RSA or Ed25519 public key and pins
+// it to RS256 or EdDSA.
+func parseVerificationKey(publicKeyPEM string) (verificationKey, error) {
+	publicKey, err := keygen.ParsePublicKey(publicKeyPEM)
+	if err != nil {
+		return verificationKey{}, err
+	}
+
+	if _, ok := publicKey.(ed25519.PublicKey); ok {
+		return verificationKey{alg: models.AlgEdDSA, key: publicKey}, nil
+	}
+
+	return verificationKey{alg: models.AlgRS256, key: publicKey}, nil
+}
+
+// Verify checks the token's signature against the PEM-encoded public key of the app
+// that issued it, validates its expiry and returns its claims. The token must be signed
+// with the algorithm of the key: RS256 for an RSA key, EdDSA for an Ed25519 key. Unlike
+// TokenVerifier, it does not check the issuer and audience; see VerifyIssuer.
+func Verify(tokenString string, publicKeyPEM string) (*Claims, error) {
+	const op = "jwt.Verify"
+
+	return verifyPEM(op, tokenString, publicKeyPEM)
+}
+
+// VerifyIssuer is Verify for a resource server that only trusts tokens of one
+// issuer, e.g. its tenant: tokens with another iss claim, or none, are rejected.
+// issuer must not be empty.
+func VerifyIssuer(tokenString string, publicKeyPEM string, issuer string) (*Claims, error) {
+	const op = "jwt.VerifyIssuer"
+
+	// jwt.WithIssuer("") would skip the check and accept any issuer.
+	if issuer == "" {
+		return nil, fmt.Errorf("%s: issuer is required", op)
+	}
+
+	return verifyPEM(op, tokenString, publicKeyPEM, jwt.WithIssuer(issuer))
+}
+
+func verifyPEM(op, tokenString, publicKeyPEM string, opts ...jwt.ParserOption) (*Claims, error) {
+	key, err := parseVerificationKey(publicKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to parse public key: %w", op, err)
+	}
+
+	claims, err := verifyWithKey(tokenString, key, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claims, nil
+}
+
+func verifyWithKey(tokenString string, key verificationKey, opts ...jwt.ParserOption) (*Claims, error) {
+	// Clipped: a TokenVerifier shares its options between concurrent calls.
+	opts = append(slices.Clip(opts), jwt.WithValidMethods([]string{key.alg}), jwt.WithExpirationRequired())
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return key.key, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// TokenAppID returns the app_id claim of the token without verifying it, so the
+// caller can look up the app whose public key the token must be verified with.
+func (j *JWT) TokenAppID(tokenString string) (int, error) {
+	const op = "jwt.TokenAppID"
+
+	claims, err := DecodeUnverified(tokenString)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claims.AppID, nil
+}
+
+// TokenExpiry returns the exp claim of the token without verifying it, e.g. to
+// tell a client when a token just minted for it expires.
+func (j *JWT) TokenExpiry(tokenString string) (time.Time, error) {
+	const op = "jwt.TokenExpiry"
+
+	claims, err := DecodeUnverified(tokenString)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, fmt.Errorf("%s: token has no exp claim", op)
+	}
+
+	return claims.ExpiresAt.Time, nil
+}
+
+// TokenBinding returns the client fingerprint hash in the cnf claim of the
+// token without verifying it, or "" for a token not bound to a client.
+func (j *JWT) TokenBinding(tokenString string) (string, error) {
+	const op = "jwt.TokenBinding"
+
+	claims, err := DecodeUnverified(tokenString)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if claims.Confirmation == nil {
+		return "", nil
+	}
+
+	return claims.Confirmation.FingerprintS256, nil
+}
+
+// DecodeUnverified returns the claims of the token without checking its signature
+// or expiry. The claims must not be trusted; it is meant for routing and debugging.
+func DecodeUnverified(tokenString string) (*Claims, error) {
+	const op = "jwt.DecodeUnverified"
+
+	var claims Claims
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &claims, nil
+}
+
+// TokenVerifier parses the app's public keys once and returns a function verifying
+// tokens of that app and returning the user they were issued to. Tokens minted before
+// a key rotation verify against the app's previous public key. Tokens must be signed with
+// the algorithm of the key they verify against, so the current key must be of the app's
+// algorithm. The configured issuer and audience, if any, must match the token's.
+func (j *JWT) TokenVerifier(app models.App) (func(tokenString string) (models.User, error), error) {
+	const op = "jwt.TokenVerifier"
+
+	verify, err := j.ClaimsVerifier(app)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return func(tokenString string) (models.User, error) {
+		claims, err := verify(tokenString)
+		if err != nil {
+			return models.User{}, err
+		}
+
+		return models.User{
+			ID:      claims.UserID,
+			Email:   claims.Email,
+			IsAdmin: claims.IsAdmin,
+		}, nil
+	}, nil
+}
+
+// ClaimsVerifier is like TokenVerifier but returns all claims of verified tokens.
+func (j *JWT) ClaimsVerifier(app models.App) (func(tokenString string) (*Claims, error), error) {
+	const op = "jwt.ClaimsVerifier"
+
+	pems := []string{app.PublicKey}
+	if app.PreviousPublicKey != "" {
+		pems = append(pems, app.PreviousPublicKey)
+	}
+
+	keys := make([]verificationKey, 0, len(pems))
+	for _, pem := range pems {
+		key, err := parseVerificationKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to parse public key: %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+	// The previous key may be of another algorithm, e.g. after migrating the
+	// app from RS256 to EdDSA.
+	if alg := app.SigningAlgorithm(); keys[0].alg != alg {
+		return nil, fmt.Errorf("%s: public key does not match the app algorithm %s", op, alg)
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithTimeFunc(j.now), jwt.WithLeeway(j.leeway), jwt.WithIssuedAt()}
+	if issuer := j.issuerOf(app); issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(issuer))
+	}
+	if j.audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(j.audience))
+	}
+
+	return func(tokenString string) (*Claims, error) {
+		const op = "jwt.VerifyToken"
+
+		var (
+			claims *Claims
+			err    error
+		)
+		for _, key := range keys {
+			if claims, err = verifyWithKey(tokenString, key, parserOpts...); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if claims.AppID != app.ID {
+			return nil, fmt.Errorf("%s: %w", op, errors.New("token issued for another app"))
+		}
+
+		return claims, nil
+	}, nil
 }
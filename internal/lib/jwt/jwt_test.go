@@ -0,0 +1,574 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/keygen"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKeyBits = 2048
+
+func newTestApp(t *testing.T) models.App {
+	t.Helper()
+
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(t, err)
+
+	return models.App{
+		ID:         1,
+		Name:       "test",
+		PrivateKey: keyPair.PrivateKey,
+		PublicKey:  keyPair.PublicKey,
+	}
+}
+
+func newTestJWT() *JWT {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestNewToken_VerifyRoundTrip(t *testing.T) {
+	app := newTestApp(t)
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	token, err := newTestJWT().NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := Verify(token, app.PublicKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, user.Email, claims.Email)
+	assert.Equal(t, app.ID, claims.AppID)
+}
+
+func TestNewToken_UniqueTokenID(t *testing.T) {
+	app := newTestApp(t)
+	user := models.User{ID: 7, Email: "user@example.com"}
+	j := newTestJWT()
+
+	first, err := j.NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+	second, err := j.NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first, second, "same user, app and second")
+
+	claims, err := Verify(first, app.PublicKey)
+	require.NoError(t, err)
+	assert.NotEmpty(t, claims.ID)
+}
+
+func TestNewToken_IsAdminClaim(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, isAdmin := range []bool{true, false} {
+		user := models.User{ID: 7, Email: "user@example.com", IsAdmin: isAdmin}
+
+		token, err := newTestJWT().NewToken(user, app, time.Hour)
+		require.NoError(t, err)
+
+		claims, err := Verify(token, app.PublicKey)
+		require.NoError(t, err)
+		assert.Equal(t, isAdmin, claims.IsAdmin)
+	}
+}
+
+func TestNewToken_Binding(t *testing.T) {
+	app := newTestApp(t)
+	user := models.User{ID: 7, Email: "user@example.com"}
+	j := newTestJWT()
+
+	bound, err := j.NewToken(user, app, time.Hour, WithBinding("thumbprint"))
+	require.NoError(t, err)
+	claims, err := Verify(bound, app.PublicKey)
+	require.NoError(t, err)
+	require.NotNil(t, claims.Confirmation)
+	assert.Equal(t, "thumbprint", claims.Confirmation.FingerprintS256)
+	binding, err := j.TokenBinding(bound)
+	require.NoError(t, err)
+	assert.Equal(t, "thumbprint", binding)
+
+	unbound, err := j.NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+	binding, err = j.TokenBinding(unbound)
+	require.NoError(t, err)
+	assert.Empty(t, binding)
+}
+
+func TestNewToken_ExtraClaims(t *testing.T) {
+	app := newTestApp(t)
+	app.ExtraClaims = []byte(`{"tenant": "acme", "plan": {"tier": "pro", "seats": 5}}`)
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	token, err := newTestJWT().NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", claims["tenant"])
+	assert.Equal(t, map[string]any{"tier": "pro", "seats": float64(5)}, claims["plan"])
+	assert.Equal(t, float64(user.ID), claims["uid"])
+}
+
+func TestNewToken_ExtraClaimsLargeNumbers(t *testing.T) {
+	app := newTestApp(t)
+	// 2^53 + 1 has no exact float64.
+	app.ExtraClaims = []byte(`{"account": 9007199254740993, "ratio": 0.1}`)
+
+	token, err := newTestJWT().NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser(jwt.WithJSONNumber()).ParseUnverified(token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("9007199254740993"), claims["account"])
+	assert.Equal(t, json.Number("0.1"), claims["ratio"])
+}
+
+func TestNewToken_ExtraClaimsReserved(t *testing.T) {
+	app := newTestApp(t)
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	for _, extra := range []string{`{"uid": 1}`, `{"exp": 4102444800}`, `{"tenant": "acme", "is_admin": true}`, `["tenant"]`} {
+		app.ExtraClaims = []byte(extra)
+		_, err := newTestJWT().NewToken(user, app, time.Hour)
+		assert.Error(t, err, extra)
+	}
+}
+
+func TestVerify_WrongKey(t *testing.T) {
+	app := newTestApp(t)
+	other := newTestApp(t)
+
+	token, err := newTestJWT().NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+
+	_, err = Verify(token, other.PublicKey)
+	assert.Error(t, err)
+}
+
+func TestVerify_Expired(t *testing.T) {
+	app := newTestApp(t)
+
+	token, err := newTestJWT().NewToken(models.User{ID: 7}, app, -time.Minute)
+	require.NoError(t, err)
+
+	_, err = Verify(token, app.PublicKey)
+	assert.Error(t, err)
+}
+
+func TestTokenVerifier_PreviousKey(t *testing.T) {
+	oldApp := newTestApp(t)
+	token, err := newTestJWT().NewToken(models.User{ID: 7, Email: "user@example.com"}, oldApp, time.Hour)
+	require.NoError(t, err)
+
+	rotated := newTestApp(t)
+	rotated.PreviousPublicKey = oldApp.PublicKey
+
+	verify, err := newTestJWT().TokenVerifier(rotated)
+	require.NoError(t, err)
+
+	user, err := verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), user.ID)
+
+	rotated.PreviousPublicKey = ""
+	verify, err = newTestJWT().TokenVerifier(rotated)
+	require.NoError(t, err)
+
+	_, err = verify(token)
+	assert.Error(t, err, "without the previous key the old token is rejected")
+}
+
+func TestTokenVerifier_OtherApp(t *testing.T) {
+	app := newTestApp(t)
+	token, err := newTestJWT().NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+
+	// Same keys, different app: the app_id claim must match too.
+	other := app
+	other.ID = 2
+	verify, err := newTestJWT().TokenVerifier(other)
+	require.NoError(t, err)
+
+	_, err = verify(token)
+	assert.Error(t, err)
+}
+
+func TestTokenVerifier_IssuerAndAudience(t *testing.T) {
+	app := newTestApp(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	user := models.User{ID: 7}
+
+	prod := New(log, WithIssuer("sso-prod"), WithAudience("api"))
+	token, err := prod.NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := Verify(token, app.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, "sso-prod", claims.Issuer)
+	assert.Equal(t, []string{"api"}, []string(claims.Audience))
+
+	verify, err := prod.TokenVerifier(app)
+	require.NoError(t, err)
+	_, err = verify(token)
+	assert.NoError(t, err)
+
+	for name, verifier := range map[string]*JWT{
+		"other issuer":   New(log, WithIssuer("sso-staging"), WithAudience("api")),
+		"other audience": New(log, WithIssuer("sso-prod"), WithAudience("admin")),
+	} {
+		verify, err := verifier.TokenVerifier(app)
+		require.NoError(t, err)
+		_, err = verify(token)
+		assert.Error(t, err, name)
+	}
+
+	// Tokens without the claims are rejected once they are expected.
+	unscoped, err := newTestJWT().NewToken(user, app, time.Hour)
+	require.NoError(t, err)
+	_, err = verify(unscoped)
+	assert.Error(t, err)
+}
+
+func TestNewToken_AppIssuer(t *testing.T) {
+	j := New(slog.New(slog.NewTextHandler(io.Discard, nil)), WithIssuer("sso"))
+	user := models.User{ID: 7}
+
+	acme := newTestApp(t)
+	acme.Issuer = "https://acme.example.com"
+	token, err := j.NewToken(user, acme, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := VerifyIssuer(token, acme.PublicKey, "https://acme.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.example.com", claims.Issuer)
+
+	verify, err := j.TokenVerifier(acme)
+	require.NoError(t, err)
+	_, err = verify(token)
+	assert.NoError(t, err)
+
+	// Apps without an issuer of their own fall back to the global one.
+	plain := newTestApp(t)
+	token, err = j.NewToken(user, plain, time.Hour)
+	require.NoError(t, err)
+	claims, err = Verify(token, plain.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, "sso", claims.Issuer)
+}
+
+func TestVerifyIssuer_OtherTenant(t *testing.T) {
+	j := newTestJWT()
+	user := models.User{ID: 7}
+
+	acme := newTestApp(t)
+	acme.Issuer = "https://acme.example.com"
+	token, err := j.NewToken(user, acme, time.Hour)
+	require.NoError(t, err)
+
+	_, err = VerifyIssuer(token, acme.PublicKey, "https://globex.example.com")
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer, "a resource server of another tenant rejects the token")
+	_, err = VerifyIssuer(token, acme.PublicKey, "")
+	assert.Error(t, err, "an empty issuer does not accept any")
+
+	// An app moved to another tenant no longer accepts the tokens of the old one.
+	moved := acme
+	moved.Issuer = "https://globex.example.com"
+	verify, err := j.TokenVerifier(moved)
+	require.NoError(t, err)
+	_, err = verify(token)
+	assert.Error(t, err)
+
+	// Signed with acme's key, but by a provider and app without an issuer.
+	unscopedApp := acme
+	unscopedApp.Issuer = ""
+	unscoped, err := j.NewToken(user, unscopedApp, time.Hour)
+	require.NoError(t, err)
+	_, err = VerifyIssuer(unscoped, acme.PublicKey, "https://acme.example.com")
+	assert.ErrorIs(t, err, jwt.ErrTokenRequiredClaimMissing, "a token without iss is rejected")
+}
+
+func TestNewToken_MaxTTL(t *testing.T) {
+	app := newTestApp(t)
+	j := New(slog.New(slog.NewTextHandler(io.Discard, nil)), WithMaxTTL(time.Hour))
+
+	for name, tc := range map[string]struct {
+		ttl, want time.Duration
+	}{
+		"above the max is clamped": {ttl: 365 * 24 * time.Hour, want: time.Hour},
+		"below the max is kept":    {ttl: 10 * time.Minute, want: 10 * time.Minute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			before := time.Now()
+			token, err := j.NewToken(models.User{ID: 7}, app, tc.ttl)
+			require.NoError(t, err)
+
+			claims, err := Verify(token, app.PublicKey)
+			require.NoError(t, err)
+			assert.WithinDuration(t, before.Add(tc.want), claims.ExpiresAt.Time, 2*time.Second)
+		})
+	}
+}
+
+func TestNewToken_Clock(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	j := New(slog.New(slog.NewTextHandler(io.Discard, nil)), WithClock(func() time.Time { return now }))
+
+	token, err := j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := DecodeUnverified(token)
+	require.NoError(t, err)
+	assert.Equal(t, now, claims.IssuedAt.UTC())
+	assert.Equal(t, now.Add(time.Hour), claims.ExpiresAt.UTC())
+
+	verify, err := j.ClaimsVerifier(app)
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour - time.Second)
+	_, err = verify(token)
+	assert.NoError(t, err, "a second before expiry")
+
+	now = now.Add(time.Second)
+	_, err = verify(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired, "at expiry")
+}
+
+func TestTokenVerifier_Leeway(t *testing.T) {
+	app := newTestApp(t)
+	minted := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	now := minted
+	j := New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		WithClock(func() time.Time { return now }),
+		WithLeeway(30*time.Second),
+	)
+
+	token, err := j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+	verify, err := j.ClaimsVerifier(app)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		at      time.Time
+		wantErr error
+	}{
+		"just inside past exp":     {at: minted.Add(time.Hour + 29*time.Second)},
+		"just outside past exp":    {at: minted.Add(time.Hour + 31*time.Second), wantErr: jwt.ErrTokenExpired},
+		"just inside before iat":   {at: minted.Add(-29 * time.Second)},
+		"just outside before iat":  {at: minted.Add(-31 * time.Second), wantErr: jwt.ErrTokenUsedBeforeIssued},
+		"well within the lifetime": {at: minted.Add(time.Minute)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			now = tc.at
+			_, err := verify(token)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestWithLeeway_Bounded(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	assert.Equal(t, MaxLeeway, New(log, WithLeeway(time.Hour)).leeway)
+	assert.Zero(t, New(log, WithLeeway(-time.Second)).leeway)
+	assert.Equal(t, 30*time.Second, New(log, WithLeeway(30*time.Second)).leeway)
+}
+
+func TestNewToken_RotatedKeyInvalidatesCache(t *testing.T) {
+	j := newTestJWT()
+	app := newTestApp(t)
+
+	signer := j.signer.(*KeySigner)
+
+	_, err := j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+	cached := signer.keys[app.ID].key
+
+	_, err = j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+	assert.Same(t, cached, signer.keys[app.ID].key, "unchanged key is reused")
+
+	rotated := newTestApp(t)
+	app.PrivateKey, app.PublicKey = rotated.PrivateKey, rotated.PublicKey
+
+	token, err := j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+	assert.NotSame(t, cached, signer.keys[app.ID].key)
+
+	_, err = Verify(token, app.PublicKey)
+	assert.NoError(t, err, "token is signed with the new key")
+}
+
+func TestNewIDToken_Claims(t *testing.T) {
+	app := newTestApp(t)
+	user := models.User{ID: 7, Email: "user@example.com", EmailVerified: true, DisplayName: "Jane Doe"}
+	j := New(newTestJWT().log, WithIssuer("sso-test"))
+
+	idToken, err := j.NewIDToken(user, app, time.Hour)
+	require.NoError(t, err)
+
+	publicKey, err := keygen.ParseRSAPublicKey(app.PublicKey)
+	require.NoError(t, err)
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(*jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "7", claims["sub"])
+	assert.Equal(t, "1", claims["aud"])
+	assert.Equal(t, "sso-test", claims["iss"])
+	assert.Equal(t, user.Email, claims["email"])
+	assert.Equal(t, true, claims["email_verified"])
+	assert.Equal(t, user.DisplayName, claims["name"])
+	assert.Contains(t, claims, "iat")
+	assert.Contains(t, claims, "exp")
+	assert.NotContains(t, claims, "uid")
+	assert.NotContains(t, claims, "app_id")
+
+	verify, err := j.TokenVerifier(app)
+	require.NoError(t, err)
+	_, err = verify(idToken)
+	assert.Error(t, err, "an ID token is not an access token")
+}
+
+// TestNewToken_Concurrent mints tokens for two apps from many goroutines at once,
+// with a key rotation midway, and checks each is signed with its own app's key.
+// Run with -race to check the key cache.
+func TestNewToken_Concurrent(t *testing.T) {
+	const perApp = 32
+
+	j := newTestJWT()
+	first, second := newTestApp(t), newTestApp(t)
+	second.ID = 2
+	rotated := newTestApp(t)
+	rotated.ID = first.ID
+
+	var wg sync.WaitGroup
+	for i := range perApp {
+		for _, app := range []models.App{first, second} {
+			if app.ID == first.ID && i >= perApp/2 {
+				app = rotated
+			}
+			user := models.User{ID: int64(i), Email: fmt.Sprintf("user%d@example.com", i)}
+
+			wg.Go(func() {
+				token, err := j.NewToken(user, app, time.Hour)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				claims, err := Verify(token, app.PublicKey)
+				if assert.NoError(t, err, "app %d", app.ID) {
+					assert.Equal(t, user.ID, claims.UserID)
+					assert.Equal(t, user.Email, claims.Email)
+					assert.Equal(t, app.ID, claims.AppID)
+				}
+			})
+		}
+	}
+	wg.Wait()
+}
+
+// TestTokenVerifier_Concurrent verifies tokens of an app with an issuer from many
+// goroutines with one verifier, as BatchVerifyTokens does. Run with -race to check
+// the verifier does not share state between calls.
+func TestTokenVerifier_Concurrent(t *testing.T) {
+	j := newTestJWT()
+	app := newTestApp(t)
+	app.Issuer = "https://acme.example.com"
+	token, err := j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+
+	verify, err := j.TokenVerifier(app)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Go(func() {
+			user, err := verify(token)
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(7), user.ID)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// BenchmarkNewToken measures token signing per RSA key size, with the parsed key
+// cached (the steady state) and parsed on every call (a cold provider).
+func BenchmarkNewToken(b *testing.B) {
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	for _, bits := range []int{2048, 3072, 4096} {
+		keyPair, err := keygen.GenerateRSAKeyPair(bits)
+		require.NoError(b, err)
+		app := models.App{ID: 1, Name: "test", PrivateKey: keyPair.PrivateKey, PublicKey: keyPair.PublicKey}
+
+		b.Run(fmt.Sprintf("%d/cached", bits), func(b *testing.B) {
+			j := newTestJWT()
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := j.NewToken(user, app, time.Hour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("%d/uncached", bits), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := newTestJWT().NewToken(user, app, time.Hour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseRSAPrivateKey(b *testing.B) {
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := keygen.ParseRSAPrivateKey(keyPair.PrivateKey); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(b, err)
+	app := models.App{ID: 1, Name: "test", PrivateKey: keyPair.PrivateKey, PublicKey: keyPair.PublicKey}
+
+	token, err := newTestJWT().NewToken(models.User{ID: 7, Email: "user@example.com"}, app, time.Hour)
+	require.NoError(b, err)
+	verify, err := newTestJWT().TokenVerifier(app)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := verify(token); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
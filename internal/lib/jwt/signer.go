@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sso/internal/domain/models"
+	"sso/internal/lib/keygen"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAppKeyMissing means the app has no key to sign its tokens with: an empty
+// private key in storage for KeySigner, no configured key for KMSSigner. It is a
+// misconfiguration of the app, not a transient failure.
+var ErrAppKeyMissing = errors.New("app has no signing key")
+
+// ErrUnsupportedAlgorithm means the app's signing algorithm is unknown, or not
+// supported by the Signer.
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// Signer signs the tokens NewToken mints. Tokens are verified with the app's
+// public key, so the signing key must be the private half of app.PublicKey.
+// Implementations must be safe for concurrent use.
+type Signer interface {
+	// Sign returns the signature of signingInput, the encoded header and
+	// payload of a token for app, by app.SigningAlgorithm(): RS256
+	// (RSASSA-PKCS1-v1_5 with SHA-256) or EdDSA (Ed25519).
+	Sign(app models.App, signingInput []byte) ([]byte, error)
+}
+
+// KeySigner signs with the app's PEM-encoded private key from storage. It is
+// the default Signer of New.
+type KeySigner struct {
+	// keys caches parsed private keys by app ID, since parsing a PEM key
+	// costs more than signing with it. Cached keys are only read after they
+	// are stored, so they are shared without holding mu while signing.
+	mu   sync.Mutex
+	keys map[int]cachedKey
+}
+
+// cachedKey is a parsed private key together with the PEM it was parsed from.
+type cachedKey struct {
+	pem string
+	key crypto.Signer
+}
+
+// NewKeySigner creates a KeySigner.
+func NewKeySigner() *KeySigner {
+	return &KeySigner{keys: make(map[int]cachedKey)}
+}
+
+// Sign signs signingInput with app.PrivateKey: a PKCS #1 RSA key for RS256, a
+// PKCS #8 Ed25519 key for EdDSA. An empty or blank key fails with
+// ErrAppKeyMissing.
+func (s *KeySigner) Sign(app models.App, signingInput []byte) ([]byte, error) {
+	if strings.TrimSpace(app.PrivateKey) == "" {
+		return nil, ErrAppKeyMissing
+	}
+
+	key, err := s.privateKey(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.SigningAlgorithm() == models.AlgEdDSA {
+		// Ed25519 hashes the message itself.
+		return key.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(signingInput)
+
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// privateKey returns the app's parsed private key, parsing it only when the app is
+// seen for the first time or its key changed, e.g. after a rotation.
+func (s *KeySigner) privateKey(app models.App) (crypto.Signer, error) {
+	s.mu.Lock()
+	cached, ok := s.keys[app.ID]
+	s.mu.Unlock()
+
+	if ok && cached.pem == app.PrivateKey {
+		return cached.key, nil
+	}
+
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch alg := app.SigningAlgorithm(); alg {
+	case models.AlgRS256:
+		key, err = keygen.ParseRSAPrivateKey(app.PrivateKey)
+	case models.AlgEdDSA:
+		key, err = keygen.ParseEd25519PrivateKey(app.PrivateKey)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	s.mu.Lock()
+	s.keys[app.ID] = cachedKey{pem: app.PrivateKey, key: key}
+	s.mu.Unlock()
+
+	return key, nil
+}
+
+// KMSClient signs SHA-256 digests with an RSA key that never leaves a KMS or
+// HSM, e.g. AWS KMS Sign with MessageType DIGEST and RSASSA_PKCS1_V1_5_SHA_256.
+type KMSClient interface {
+	SignDigest(ctx context.Context, keyID string, digest []byte) ([]byte, error)
+}
+
+// DefaultKMSTimeout bounds each KMS call of a KMSSigner created without a timeout.
+const DefaultKMSTimeout = 5 * time.Second
+
+// KMSSigner delegates signing to a KMS, so private keys need not be stored with
+// the apps at all. Apps keep their public key in storage for verification.
+type KMSSigner struct {
+	client  KMSClient
+	keyIDs  map[int]string
+	timeout time.Duration
+}
+
+// NewKMSSigner creates a KMSSigner signing the tokens of each app with the KMS key
+// keyIDs maps its ID to. Each call to the KMS is bounded by timeout, or by
+// DefaultKMSTimeout if it is zero or less.
+func NewKMSSigner(client KMSClient, keyIDs map[int]string, timeout time.Duration) *KMSSigner {
+	if timeout <= 0 {
+		timeout = DefaultKMSTimeout
+	}
+
+	return &KMSSigner{client: client, keyIDs: keyIDs, timeout: timeout}
+}
+
+// Sign signs signingInput with the app's KMS key. Apps without one fail with
+// ErrAppKeyMissing, and apps signing with another algorithm than RS256 with
+// ErrUnsupportedAlgorithm.
+func (s *KMSSigner) Sign(app models.App, signingInput []byte) ([]byte, error) {
+	if alg := app.SigningAlgorithm(); alg != models.AlgRS256 {
+		return nil, fmt.Errorf("%w %q: kms keys sign RS256 only", ErrUnsupportedAlgorithm, alg)
+	}
+
+	keyID, ok := s.keyIDs[app.ID]
+	if !ok {
+		return nil, fmt.Errorf("%w: no kms key for app %d", ErrAppKeyMissing, app.ID)
+	}
+
+	// Signing has no request context, so the timeout is what keeps a stuck KMS
+	// from holding a login, or the shutdown waiting for it, indefinitely.
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	digest := sha256.Sum256(signingInput)
+
+	signature, err := s.client.SignDigest(ctx, keyID, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("kms key %s: %w", keyID, err)
+	}
+
+	return signature, nil
+}
@@ -0,0 +1,128 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/lib/keygen"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSigner returns a fixed signature and records what it was asked to sign.
+type fakeSigner struct {
+	signature    []byte
+	err          error
+	app          models.App
+	signingInput string
+}
+
+func (s *fakeSigner) Sign(app models.App, signingInput []byte) ([]byte, error) {
+	s.app, s.signingInput = app, string(signingInput)
+	return s.signature, s.err
+}
+
+func TestNewToken_UsesSigner(t *testing.T) {
+	signer := &fakeSigner{signature: []byte("signature")}
+	j := New(newTestJWT().log, WithSigner(signer))
+	app := models.App{ID: 3, Name: "kms-backed"}
+
+	token, err := j.NewToken(models.User{ID: 7, Email: "user@example.com"}, app, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, app, signer.app)
+	assert.Equal(t, signer.signingInput+"."+base64.RawURLEncoding.EncodeToString([]byte("signature")), token,
+		"the token is the signed header and payload followed by the signature")
+
+	claims, err := DecodeUnverified(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), claims.UserID)
+	assert.Equal(t, 3, claims.AppID)
+	assert.True(t, strings.HasPrefix(signer.signingInput, base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"`))))
+}
+
+func TestNewToken_SignerError(t *testing.T) {
+	signerErr := errors.New("kms unavailable")
+	j := New(newTestJWT().log, WithSigner(&fakeSigner{err: signerErr}))
+
+	_, err := j.NewToken(models.User{ID: 7}, models.App{ID: 3}, time.Hour)
+	assert.ErrorIs(t, err, signerErr)
+}
+
+func TestNewToken_MissingPrivateKey(t *testing.T) {
+	for name, key := range map[string]string{
+		"empty":      "",
+		"whitespace": "  \n\t",
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.PrivateKey = key
+
+			_, err := newTestJWT().NewToken(models.User{ID: 7}, app, time.Hour)
+			assert.ErrorIs(t, err, ErrAppKeyMissing)
+		})
+	}
+}
+
+// fakeKMS signs digests with RSA keys it holds by key ID.
+type fakeKMS struct {
+	keys map[string]*rsa.PrivateKey
+}
+
+func (k *fakeKMS) SignDigest(ctx context.Context, keyID string, digest []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("no deadline")
+	}
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest)
+}
+
+func TestKMSSigner(t *testing.T) {
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(t, err)
+	privateKey, err := keygen.ParseRSAPrivateKey(keyPair.PrivateKey)
+	require.NoError(t, err)
+
+	kms := &fakeKMS{keys: map[string]*rsa.PrivateKey{"key-1": privateKey}}
+	signer := NewKMSSigner(kms, map[int]string{1: "key-1", 2: "missing"}, time.Second)
+	j := New(newTestJWT().log, WithSigner(signer))
+
+	// No private key in storage, only the public one.
+	app := models.App{ID: 1, Name: "test", PublicKey: keyPair.PublicKey}
+	token, err := j.NewToken(models.User{ID: 7}, app, time.Hour)
+	require.NoError(t, err)
+
+	verify, err := j.ClaimsVerifier(app)
+	require.NoError(t, err)
+	claims, err := verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), claims.UserID)
+
+	_, err = j.NewToken(models.User{ID: 7}, models.App{ID: 2}, time.Hour)
+	assert.ErrorContains(t, err, "key not found")
+
+	_, err = j.NewToken(models.User{ID: 7}, models.App{ID: 3}, time.Hour)
+	assert.ErrorIs(t, err, ErrAppKeyMissing)
+}
+
+func TestKMSSigner_DefaultTimeout(t *testing.T) {
+	keyPair, err := keygen.GenerateRSAKeyPair(testKeyBits)
+	require.NoError(t, err)
+	privateKey, err := keygen.ParseRSAPrivateKey(keyPair.PrivateKey)
+	require.NoError(t, err)
+
+	// fakeKMS fails calls without a deadline.
+	signer := NewKMSSigner(&fakeKMS{keys: map[string]*rsa.PrivateKey{"key-1": privateKey}}, map[int]string{1: "key-1"}, 0)
+	_, err = signer.Sign(models.App{ID: 1, PublicKey: keyPair.PublicKey}, []byte("header.payload"))
+	assert.NoError(t, err)
+}
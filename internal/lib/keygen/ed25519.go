@@ -0,0 +1,83 @@
+package keygen
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// GenerateEd25519KeyPair generates a new Ed25519 key pair. The private key is
+// PEM-encoded as PKCS #8, the public key as PKIX like RSA public keys.
+func GenerateEd25519KeyPair() (*KeyPair, error) {
+	const op = "lib.keygen.GenerateEd25519KeyPair"
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &KeyPair{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})),
+	}, nil
+}
+
+// ParseEd25519PrivateKey parses a PEM-encoded PKCS #8 Ed25519 private key.
+func ParseEd25519PrivateKey(pemKey string) (ed25519.PrivateKey, error) {
+	block, rest := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse PEM block containing the private key")
+	}
+	if err := checkTrailingData(rest); err != nil {
+		return nil, err
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	edPrivateKey, ok := privateKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 private key")
+	}
+
+	return edPrivateKey, nil
+}
+
+// ParsePublicKey parses a PEM-encoded PKIX public key that is either RSA
+// (*rsa.PublicKey) or Ed25519 (ed25519.PublicKey), the key types apps sign with.
+func ParsePublicKey(pemKey string) (crypto.PublicKey, error) {
+	block, rest := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse PEM block containing the public key")
+	}
+	if err := checkTrailingData(rest); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
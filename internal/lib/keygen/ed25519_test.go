@@ -0,0 +1,38 @@
+package keygen
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateEd25519KeyPair(t *testing.T) {
+	keyPair, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	privateKey, err := ParseEd25519PrivateKey(keyPair.PrivateKey)
+	require.NoError(t, err)
+
+	publicKey, err := ParsePublicKey(keyPair.PublicKey)
+	require.NoError(t, err)
+	require.IsType(t, ed25519.PublicKey{}, publicKey)
+	assert.True(t, privateKey.Public().(ed25519.PublicKey).Equal(publicKey), "the keys are a pair")
+
+	_, err = ParseRSAPublicKey(keyPair.PublicKey)
+	assert.Error(t, err, "an Ed25519 key is not an RSA key")
+}
+
+func TestParsePublicKey_RSA(t *testing.T) {
+	keyPair, err := GenerateRSAKeyPair(MinRSAKeyBits)
+	require.NoError(t, err)
+
+	publicKey, err := ParsePublicKey(keyPair.PublicKey)
+	require.NoError(t, err)
+	assert.IsType(t, &rsa.PublicKey{}, publicKey)
+
+	_, err = ParseEd25519PrivateKey(keyPair.PrivateKey)
+	assert.Error(t, err, "a PKCS #1 RSA key is not an Ed25519 key")
+}
@@ -1,22 +1,51 @@
 package keygen
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"strings"
 )
 
+// MinRSAKeyBits is the smallest RSA key size GenerateRSAKeyPair accepts.
+const MinRSAKeyBits = 2048
+
+// ErrWeakKeySize is returned for RSA key sizes below MinRSAKeyBits.
+var ErrWeakKeySize = errors.New("rsa key size is too small")
+
 // KeyPair represents an RSA key pair
 type KeyPair struct {
 	PrivateKey string // PEM-encoded private key
 	PublicKey  string // PEM-encoded public key
 }
 
-// GenerateRSAKeyPair generates a new RSA key pair with the specified bit size
+// GenerateRSAKeyPair generates a new RSA key pair with the specified bit size.
+// Sizes below MinRSAKeyBits are rejected with ErrWeakKeySize.
 func GenerateRSAKeyPair(bits int) (*KeyPair, error) {
 	const op = "lib.keygen.GenerateRSAKeyPair"
+
+	if bits < MinRSAKeyBits {
+		return nil, fmt.Errorf("%s: %d bits, need at least %d: %w", op, bits, MinRSAKeyBits, ErrWeakKeySize)
+	}
+
+	return generateRSAKeyPair(op, bits)
+}
+
+// GenerateWeakRSAKeyPair is GenerateRSAKeyPair without the minimum size check.
+// It exists for explicitly opted-in tooling and must not be used to provision real apps.
+func GenerateWeakRSAKeyPair(bits int) (*KeyPair, error) {
+	const op = "lib.keygen.GenerateWeakRSAKeyPair"
+
+	return generateRSAKeyPair(op, bits)
+}
+
+func generateRSAKeyPair(op string, bits int) (*KeyPair, error) {
 	// Generate private key
 	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
@@ -56,8 +85,8 @@ func ParseRSAPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
 	if block == nil {
 		return nil, fmt.Errorf("failed to parse PEM block containing the private key")
 	}
-	if len(rest) > 0 {
-		return nil, fmt.Errorf("unexpected data after PEM block")
+	if err := checkTrailingData(rest); err != nil {
+		return nil, err
 	}
 
 	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
@@ -74,8 +103,8 @@ func ParseRSAPublicKey(pemKey string) (*rsa.PublicKey, error) {
 	if block == nil {
 		return nil, fmt.Errorf("failed to parse PEM block containing the public key")
 	}
-	if len(rest) > 0 {
-		return nil, fmt.Errorf("unexpected data after PEM block")
+	if err := checkTrailingData(rest); err != nil {
+		return nil, err
 	}
 
 	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
@@ -90,3 +119,43 @@ func ParseRSAPublicKey(pemKey string) (*rsa.PublicKey, error) {
 
 	return rsaPublicKey, nil
 }
+
+// KeyID identifies a PEM-encoded public key, e.g. in the kid header of tokens
+// signed with its private key: it is the unpadded base64url SHA-256 of the key's
+// DER encoding, so it changes whenever the key is rotated.
+func KeyID(publicKeyPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return "", fmt.Errorf("failed to parse PEM block containing the public key")
+	}
+
+	sum := sha256.Sum256(block.Bytes)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// checkTrailingData accepts what operators commonly leave after a key: whitespace,
+// comments and non-key PEM blocks such as a bundled certificate. It rejects a
+// second key, which would make it ambiguous which one is meant, and PEM blocks
+// that fail to decode.
+func checkTrailingData(rest []byte) error {
+	for {
+		rest = bytes.TrimSpace(rest)
+		if len(rest) == 0 {
+			return nil
+		}
+
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			if bytes.Contains(rest, []byte("-----BEGIN")) {
+				return fmt.Errorf("malformed PEM block after key")
+			}
+			// Anything else is a comment.
+			return nil
+		}
+		if strings.HasSuffix(block.Type, "KEY") {
+			return fmt.Errorf("unexpected second key block %q after key", block.Type)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package keygen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateRSAKeyPair_RejectsWeakSizes(t *testing.T) {
+	for _, bits := range []int{512, 1024, MinRSAKeyBits - 1} {
+		_, err := GenerateRSAKeyPair(bits)
+		assert.ErrorIs(t, err, ErrWeakKeySize, "%d bits", bits)
+	}
+}
+
+func TestGenerateRSAKeyPair_AcceptsStandardSizes(t *testing.T) {
+	for _, bits := range []int{2048, 4096} {
+		keyPair, err := GenerateRSAKeyPair(bits)
+		require.NoError(t, err, "%d bits", bits)
+
+		privateKey, err := ParseRSAPrivateKey(keyPair.PrivateKey)
+		require.NoError(t, err)
+		assert.Equal(t, bits, privateKey.N.BitLen())
+
+		_, err = ParseRSAPublicKey(keyPair.PublicKey)
+		require.NoError(t, err)
+	}
+}
+
+func TestGenerateWeakRSAKeyPair(t *testing.T) {
+	keyPair, err := GenerateWeakRSAKeyPair(1024)
+	require.NoError(t, err)
+
+	privateKey, err := ParseRSAPrivateKey(keyPair.PrivateKey)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, privateKey.N.BitLen())
+}
+
+func TestParseRSAKeys_TrailingData(t *testing.T) {
+	keyPair, err := GenerateRSAKeyPair(MinRSAKeyBits)
+	require.NoError(t, err)
+	other, err := GenerateRSAKeyPair(MinRSAKeyBits)
+	require.NoError(t, err)
+
+	const cert = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+	for _, tc := range []struct {
+		name    string
+		trailer string
+		wantErr bool
+	}{
+		{name: "trailing newlines", trailer: "\n\n  \n"},
+		{name: "trailing comment", trailer: "\n# generated by keygen on 2024-01-01\n"},
+		{name: "bundled certificate", trailer: "\n" + cert},
+		{name: "second key", trailer: other.PublicKey + other.PrivateKey, wantErr: true},
+		{name: "truncated block", trailer: "-----BEGIN CERTIFICATE-----\nMIIB\n", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseRSAPrivateKey(keyPair.PrivateKey + tc.trailer)
+			_, pubErr := ParseRSAPublicKey(keyPair.PublicKey + tc.trailer)
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Error(t, pubErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, pubErr)
+		})
+	}
+
+	_, err = ParseRSAPrivateKey(strings.TrimSuffix(keyPair.PrivateKey, "\n"))
+	assert.NoError(t, err, "missing final newline")
+}
+
+func TestKeyID(t *testing.T) {
+	keyPair, err := GenerateRSAKeyPair(MinRSAKeyBits)
+	require.NoError(t, err)
+	other, err := GenerateRSAKeyPair(MinRSAKeyBits)
+	require.NoError(t, err)
+
+	id, err := KeyID(keyPair.PublicKey)
+	require.NoError(t, err)
+	assert.Len(t, id, 43, "unpadded base64url of 32 bytes")
+
+	again, err := KeyID("# app key\n" + keyPair.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, id, again, "independent of the text around the key")
+
+	otherID, err := KeyID(other.PublicKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, id, otherID)
+
+	_, err = KeyID("not a key")
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sso/internal/lib/logger/slogcute"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	EnvLocal = "local"
+	EnvDev   = "dev"
+	EnvProd  = "prod"
+)
+
+// Option configures a logger made by New.
+type Option func(*options)
+
+type options struct {
+	redactPII bool
+}
+
+// WithRedactPII sets whether emails in log attributes are masked, see
+// RedactHandler. By default they are in prod only.
+func WithRedactPII(enabled bool) Option {
+	return func(o *options) {
+		o.redactPII = enabled
+	}
+}
+
+// New creates a logger for the given environment writing to out.
+// A non-empty level overrides the environment's default level.
+func New(env string, level string, out io.Writer, opts ...Option) *slog.Logger {
+	o := options{redactPII: env == EnvProd}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var (
+		lvl slog.Level
+		err error
+	)
+	if level != "" {
+		lvl, err = ParseLevel(level)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var handler slog.Handler
+	switch env {
+	case EnvLocal:
+		if level == "" {
+			lvl = slog.LevelDebug
+		}
+		handler = cuteHandler(out, lvl)
+	case EnvDev:
+		if level == "" {
+			lvl = slog.LevelDebug
+		}
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: lvl})
+	case EnvProd:
+		if level == "" {
+			lvl = slog.LevelInfo
+		}
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: lvl})
+	default:
+		panic("unknown environment: " + env)
+	}
+
+	if o.redactPII {
+		handler = NewRedactHandler(handler)
+	}
+
+	return slog.New(NewContextHandler(handler))
+}
+
+// ParseLevel converts one of "debug", "info", "warn" or "error" to a slog.Level.
+func ParseLevel(level string) (slog.Level, error) {
+	switch level {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %q", level)
+	}
+}
+
+// Output returns the writer logs should go to: stdout when file is empty,
+// otherwise file, rotated once it grows beyond maxSizeMB megabytes.
+// The returned close function releases the file and is a no-op for stdout.
+func Output(file string, maxSizeMB int) (io.Writer, func() error) {
+	if file == "" {
+		return os.Stdout, func() error { return nil }
+	}
+
+	out := &lumberjack.Logger{
+		Filename: file,
+		MaxSize:  maxSizeMB,
+	}
+
+	return out, out.Close
+}
+
+func cuteHandler(out io.Writer, level slog.Level) slog.Handler {
+	opts := slogcute.CuteHandlerOptions{
+		SlogOptions: &slog.HandlerOptions{
+			Level: level,
+		},
+	}
+
+	return opts.NewCuteHandler(out)
+}
@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutput_Stdout(t *testing.T) {
+	out, closeOut := Output("", 1)
+
+	assert.Same(t, os.Stdout, out)
+	assert.NoError(t, closeOut())
+}
+
+func TestOutput_RotatesPastMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	out, closeOut := Output(filepath.Join(dir, "sso.log"), 1)
+	t.Cleanup(func() { _ = closeOut() })
+
+	line := append(bytes.Repeat([]byte("x"), 1023), '\n')
+	// Slightly more than 1MB, so the last writes must go to a fresh file.
+	for i := 0; i < 1100; i++ {
+		_, err := out.Write(line)
+		require.NoError(t, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestNew_DefaultLevels(t *testing.T) {
+	for _, tt := range []struct {
+		env       string
+		wantDebug bool
+	}{
+		{env: EnvLocal, wantDebug: true},
+		{env: EnvDev, wantDebug: true},
+		{env: EnvProd, wantDebug: false},
+	} {
+		t.Run(tt.env, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := New(tt.env, "", &buf)
+
+			log.Debug("debug message")
+
+			assert.Equal(t, tt.wantDebug, bytes.Contains(buf.Bytes(), []byte("debug message")))
+		})
+	}
+}
+
+func TestNew_LevelOverride(t *testing.T) {
+	for _, env := range []string{EnvLocal, EnvDev, EnvProd} {
+		t.Run(env, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := New(env, "warn", &buf)
+
+			log.Info("info message")
+			log.Warn("warn message")
+
+			assert.NotContains(t, buf.String(), "info message")
+			assert.Contains(t, buf.String(), "warn message")
+		})
+	}
+}
+
+func TestNew_DebugOverrideInProd(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(EnvProd, "debug", &buf)
+
+	log.Debug("debug message")
+
+	assert.Contains(t, buf.String(), "debug message")
+}
+
+func TestParseLevel(t *testing.T) {
+	for level, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		got, err := ParseLevel(level)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestNew_RedactPII(t *testing.T) {
+	for name, tt := range map[string]struct {
+		env        string
+		opts       []Option
+		wantMasked bool
+	}{
+		"prod":           {env: EnvProd, wantMasked: true},
+		"dev":            {env: EnvDev},
+		"local":          {env: EnvLocal},
+		"prod opted out": {env: EnvProd, opts: []Option{WithRedactPII(false)}},
+		"dev opted in":   {env: EnvDev, opts: []Option{WithRedactPII(true)}, wantMasked: true},
+		"local opted in": {env: EnvLocal, opts: []Option{WithRedactPII(true)}, wantMasked: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := New(tt.env, "", &buf, tt.opts...)
+
+			log.With(slog.String("username", "jane@example.com")).Info("login",
+				slog.String("email", "john@example.com"),
+				slog.Group("user", slog.String("email", "joe@example.com")),
+				slog.String("app", "billing@v2"),
+			)
+
+			out := buf.String()
+			assert.Contains(t, out, "billing@v2", "other attributes are kept")
+			for _, email := range []string{"jane@example.com", "john@example.com"} {
+				assert.Equal(t, !tt.wantMasked, strings.Contains(out, email), email)
+				assert.Equal(t, tt.wantMasked, strings.Contains(out, RedactEmail(email)), email)
+			}
+			if tt.wantMasked {
+				assert.NotContains(t, out, "joe@example.com", "emails in groups are masked too")
+			}
+		})
+	}
+}
+
+func TestRedactEmail(t *testing.T) {
+	for email, want := range map[string]string{
+		"jane@example.com":   "j***@example.com",
+		"j@example.com":      "j***@example.com",
+		"élodie@example.com": "é***@example.com",
+		"a@b@example.com":    "a***@example.com",
+		"not-an-email":       "***",
+		"@example.com":       "***",
+		"":                   "***",
+	} {
+		assert.Equal(t, want, RedactEmail(email), email)
+	}
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// OpChainKey is the attribute ContextHandler adds to records logged with a
+// context that carries operations.
+const OpChainKey = "op_chain"
+
+type opsKey struct{}
+
+// WithOp returns a copy of ctx with op pushed onto its operation chain, e.g. the
+// gRPC method a request entered through, then the service method it called.
+func WithOp(ctx context.Context, op string) context.Context {
+	return context.WithValue(ctx, opsKey{}, append(slices.Clip(Ops(ctx)), op))
+}
+
+// Ops returns the operation chain of ctx, outermost first.
+func Ops(ctx context.Context) []string {
+	ops, _ := ctx.Value(opsKey{}).([]string)
+
+	return ops
+}
+
+// ContextHandler adds the operation chain of the context a record is logged
+// with (see WithOp) as the op_chain attribute, so logs from deep in a call,
+// e.g. the storage layer, show which request they belong to. Only the
+// *Context logging methods pass a context.
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps h in a ContextHandler.
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ops := Ops(ctx); len(ops) > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.String(OpChainKey, strings.Join(ops, " > ")))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewContextHandler(h.Handler.WithAttrs(attrs))
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return NewContextHandler(h.Handler.WithGroup(name))
+}
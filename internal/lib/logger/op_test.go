@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithOp(t *testing.T) {
+	ctx := WithOp(context.Background(), "/auth.Auth/Login")
+	login := WithOp(ctx, "Auth.Login")
+	register := WithOp(ctx, "Auth.Register")
+
+	assert.Equal(t, []string{"/auth.Auth/Login"}, Ops(ctx), "the parent chain is left untouched")
+	assert.Equal(t, []string{"/auth.Auth/Login", "Auth.Login"}, Ops(login))
+	assert.Equal(t, []string{"/auth.Auth/Login", "Auth.Register"}, Ops(register), "siblings do not share a chain")
+	assert.Empty(t, Ops(context.Background()))
+}
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(NewContextHandler(slog.NewJSONHandler(&buf, nil))).With(slog.String("op", "storage.sqlite.SaveUser"))
+
+	ctx := WithOp(WithOp(context.Background(), "/auth.Auth/Register"), "Auth.Register")
+	log.InfoContext(ctx, "email is taken")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "/auth.Auth/Register > Auth.Register", record[OpChainKey])
+	assert.Equal(t, "storage.sqlite.SaveUser", record["op"])
+
+	buf.Reset()
+	log.Info("no context")
+	assert.NotContains(t, buf.String(), OpChainKey)
+}
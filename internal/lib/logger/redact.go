@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// emailKeys are the attributes RedactHandler masks with RedactEmail.
+var emailKeys = map[string]bool{
+	"email":    true,
+	"username": true,
+}
+
+// RedactEmail masks all of an email but the first character of its local part
+// and its domain, e.g. jane@example.com becomes j***@example.com. Anything that
+// isn't an email is masked entirely.
+func RedactEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return "***"
+	}
+
+	_, size := utf8.DecodeRuneInString(email)
+
+	return email[:size] + "***" + email[at:]
+}
+
+// RedactHandler masks personal data in the attributes of records before passing
+// them on: emails, under the email and username keys, with RedactEmail.
+type RedactHandler struct {
+	slog.Handler
+}
+
+// NewRedactHandler wraps h in a RedactHandler.
+func NewRedactHandler(h slog.Handler) *RedactHandler {
+	return &RedactHandler{Handler: h}
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+
+	return NewRedactHandler(h.Handler.WithAttrs(redacted))
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return NewRedactHandler(h.Handler.WithGroup(name))
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	switch {
+	case a.Value.Kind() == slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case emailKeys[a.Key] && a.Value.Kind() == slog.KindString:
+		return slog.String(a.Key, RedactEmail(a.Value.String()))
+	default:
+		return a
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	stdLog "log"
 	"log/slog"
+	"os"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 )
 
 type CuteHandlerOptions struct {
@@ -15,21 +17,40 @@ type CuteHandlerOptions struct {
 }
 
 type CuteHandler struct {
-	logger *stdLog.Logger
-	attrs  []slog.Attr
+	logger  *stdLog.Logger
+	attrs   []slog.Attr
+	level   slog.Leveler
+	colored bool
 }
 
+// NewCuteHandler creates a CuteHandler writing to out.
+// Output is colored only when out is a terminal.
 func (opts CuteHandlerOptions) NewCuteHandler(out io.Writer) *CuteHandler {
+	var level slog.Leveler = slog.LevelInfo
+	if opts.SlogOptions != nil && opts.SlogOptions.Level != nil {
+		level = opts.SlogOptions.Level
+	}
+
 	handler := &CuteHandler{
-		logger: stdLog.New(out, "", 0),
+		logger:  stdLog.New(out, "", 0),
+		level:   level,
+		colored: isTerminal(out),
 	}
 	return handler
 }
 
-// Enabled always returns true, indicating that all log levels are enabled.
-func (handler *CuteHandler) Enabled(_ context.Context, _ slog.Level) bool {
-	// Always enabled for all log levels
-	return true
+func isTerminal(out io.Writer) bool {
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// Enabled reports whether the level is at or above the configured minimum level.
+func (handler *CuteHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= handler.level.Level()
 }
 
 // Handle formats and outputs the log record in a cute way.
@@ -38,13 +59,13 @@ func (handler *CuteHandler) Handle(_ context.Context, r slog.Record) error {
 
 	switch r.Level {
 	case slog.LevelDebug:
-		level = color.MagentaString(level)
+		level = handler.paint(color.FgMagenta, level)
 	case slog.LevelInfo:
-		level = color.BlueString(level)
+		level = handler.paint(color.FgBlue, level)
 	case slog.LevelWarn:
-		level = color.YellowString(level)
+		level = handler.paint(color.FgYellow, level)
 	case slog.LevelError:
-		level = color.RedString(level)
+		level = handler.paint(color.FgRed, level)
 	}
 
 	fields := make(map[string]interface{}, r.NumAttrs())
@@ -70,23 +91,37 @@ func (handler *CuteHandler) Handle(_ context.Context, r slog.Record) error {
 	}
 
 	timeStr := r.Time.Format("[15:05:05.000]")
-	msg := color.CyanString(r.Message)
+	msg := handler.paint(color.FgCyan, r.Message)
 
 	handler.logger.Println(
 		timeStr,
 		level,
 		msg,
-		color.WhiteString(string(b)),
+		handler.paint(color.FgWhite, string(b)),
 	)
 
 	return nil
 }
 
+// paint colors s with the given attribute when the handler writes to a terminal.
+func (handler *CuteHandler) paint(attr color.Attribute, s string) string {
+	c := color.New(attr)
+	if handler.colored {
+		c.EnableColor()
+	} else {
+		c.DisableColor()
+	}
+
+	return c.Sprint(s)
+}
+
 // WithAttrs returns a new CuteHandler with the given attributes added.
 func (handler *CuteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &CuteHandler{
-		logger: handler.logger,
-		attrs:  append(handler.attrs, attrs...),
+		logger:  handler.logger,
+		attrs:   append(handler.attrs, attrs...),
+		level:   handler.level,
+		colored: handler.colored,
 	}
 }
 
@@ -94,7 +129,9 @@ func (handler *CuteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 func (handler *CuteHandler) WithGroup(_ string) slog.Handler {
 
 	return &CuteHandler{
-		logger: handler.logger,
-		attrs:  handler.attrs,
+		logger:  handler.logger,
+		attrs:   handler.attrs,
+		level:   handler.level,
+		colored: handler.colored,
 	}
 }
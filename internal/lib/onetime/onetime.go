@@ -0,0 +1,36 @@
+// Package onetime generates single-use secrets such as email verification and
+// password reset tokens. Only their hashes are meant to be stored, so a leaked
+// database does not leak usable tokens.
+package onetime
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// tokenBytes is the amount of randomness in a token.
+const tokenBytes = 32
+
+// New returns a random URL-safe token and the hash to store for it.
+func New() (token string, tokenHash []byte, err error) {
+	const op = "onetime.New"
+
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	return token, Hash(token), nil
+}
+
+// Hash returns the hash under which token is stored. The token is high-entropy,
+// so a plain SHA-256 is enough; it needs no salt or slow hashing.
+func Hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+
+	return sum[:]
+}
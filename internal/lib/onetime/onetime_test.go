@@ -0,0 +1,21 @@
+package onetime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	token, tokenHash, err := New()
+	require.NoError(t, err)
+
+	assert.Len(t, token, 43, "32 bytes, unpadded base64")
+	assert.Equal(t, Hash(token), tokenHash)
+	assert.NotEqual(t, []byte(token), tokenHash)
+
+	other, _, err := New()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxKeys bounds the number of tracked keys. Keys may come from clients (e.g. an
+// app_id in a request), so idle limiters are pruned once the map grows past it.
+const maxKeys = 10000
+
+// Limit is a token bucket: RPS tokens are added per second, up to Burst.
+// A non-positive RPS means unlimited.
+type Limit struct {
+	RPS   float64
+	Burst int
+}
+
+// Keyed keeps an independent token bucket per key, so one key exhausting its
+// limit does not affect the others.
+type Keyed[K comparable] struct {
+	mu        sync.Mutex
+	limit     Limit
+	overrides map[K]Limit
+	limiters  map[K]*rate.Limiter
+}
+
+// NewKeyed creates a limiter applying limit to every key except those in overrides.
+func NewKeyed[K comparable](limit Limit, overrides map[K]Limit) *Keyed[K] {
+	return &Keyed[K]{
+		limit:     limit,
+		overrides: overrides,
+		limiters:  make(map[K]*rate.Limiter),
+	}
+}
+
+// Allow reports whether a request for key may proceed now, consuming a token if so.
+func (k *Keyed[K]) Allow(key K) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	limiter, ok := k.limiters[key]
+	if !ok {
+		limit := k.limitFor(key)
+		if limit.RPS <= 0 {
+			return true
+		}
+
+		if len(k.limiters) >= maxKeys {
+			k.prune(time.Now())
+		}
+
+		limiter = rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst)
+		k.limiters[key] = limiter
+	}
+
+	return limiter.Allow()
+}
+
+func (k *Keyed[K]) limitFor(key K) Limit {
+	if l, ok := k.overrides[key]; ok {
+		return l
+	}
+
+	return k.limit
+}
+
+// prune drops limiters whose bucket has refilled, as they behave exactly like new ones.
+func (k *Keyed[K]) prune(now time.Time) {
+	for key, limiter := range k.limiters {
+		if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+			delete(k.limiters, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyed_IsolatesKeys(t *testing.T) {
+	l := NewKeyed[int](Limit{RPS: 0.001, Burst: 2}, nil)
+
+	assert.True(t, l.Allow(1))
+	assert.True(t, l.Allow(1))
+	assert.False(t, l.Allow(1))
+
+	assert.True(t, l.Allow(2), "another key has its own bucket")
+}
+
+func TestKeyed_Overrides(t *testing.T) {
+	l := NewKeyed(Limit{RPS: 0.001, Burst: 1}, map[int]Limit{
+		2: {RPS: 0.001, Burst: 3},
+		3: {},
+	})
+
+	assert.True(t, l.Allow(1))
+	assert.False(t, l.Allow(1))
+
+	for range 3 {
+		assert.True(t, l.Allow(2))
+	}
+	assert.False(t, l.Allow(2))
+
+	for range 100 {
+		assert.True(t, l.Allow(3), "zero RPS is unlimited")
+	}
+}
+
+func TestKeyed_PrunesRefilledLimiters(t *testing.T) {
+	// Buckets refill almost instantly, so old keys get pruned instead of piling up.
+	l := NewKeyed[int](Limit{RPS: 1e9, Burst: 1}, nil)
+
+	for key := range maxKeys + 10 {
+		assert.True(t, l.Allow(key))
+	}
+
+	assert.LessOrEqual(t, len(l.limiters), maxKeys)
+}
@@ -0,0 +1,100 @@
+package vault
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sso/internal/lib/keygen"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransit serves the transit sign endpoint for keys, checking the request
+// the way Vault would.
+func fakeTransit(t *testing.T, keys map[string]*rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var req signRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.Prehashed)
+		assert.Equal(t, "pkcs1v15", req.SignatureAlgorithm)
+
+		key, ok := keys[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["signing key not found"]}`))
+			return
+		}
+		digest, err := base64.StdEncoding.DecodeString(req.Input)
+		require.NoError(t, err)
+		signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest)
+		require.NoError(t, err)
+
+		_, _ = w.Write([]byte(`{"data":{"signature":"vault:v1:` + base64.StdEncoding.EncodeToString(signature) + `"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestTransit_SignDigest(t *testing.T) {
+	keyPair, err := keygen.GenerateRSAKeyPair(2048)
+	require.NoError(t, err)
+	key, err := keygen.ParseRSAPrivateKey(keyPair.PrivateKey)
+	require.NoError(t, err)
+
+	srv := fakeTransit(t, map[string]*rsa.PrivateKey{"/v1/transit/sign/app-1/sha2-256": key})
+	digest := sha256.Sum256([]byte("header.payload"))
+
+	signature, err := NewTransit(srv.URL+"/", "/transit/", "root", nil).SignDigest(context.Background(), "app-1", digest[:])
+	require.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+
+	_, err = NewTransit(srv.URL, "transit", "root", nil).SignDigest(context.Background(), "app-2", digest[:])
+	assert.ErrorContains(t, err, "signing key not found")
+
+	_, err = NewTransit(srv.URL, "transit", "wrong", nil).SignDigest(context.Background(), "app-1", digest[:])
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestTransit_SignDigest_Context(t *testing.T) {
+	// A stuck Vault: it answers only once the test is over.
+	stuck := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stuck
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(stuck) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := NewTransit(srv.URL, "transit", "root", nil).SignDigest(ctx, "app-1", make([]byte, sha256.Size))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestParseSignature(t *testing.T) {
+	got, err := parseSignature("vault:v2:" + base64.StdEncoding.EncodeToString([]byte("sig")))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("sig"), got)
+
+	for _, s := range []string{"", "sig", "vault:sig", "kms:v1:c2ln", "vault:v1:not base64"} {
+		_, err := parseSignature(s)
+		assert.ErrorIs(t, err, ErrMalformedSignature, s)
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/keygen"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+)
+
+// AppPublicKey is what a resource server needs to verify an app's tokens. It
+// has no field for the private key, so it cannot leak through this type.
+type AppPublicKey struct {
+	AppID int
+	// KeyID is the kid header of tokens signed with the key.
+	KeyID string
+	// PublicKey is the PEM-encoded RSA or Ed25519 public key.
+	PublicKey string
+	// Algorithm is the alg tokens signed with the key must have, RS256 or EdDSA.
+	Algorithm string
+}
+
+// AppPublicKey returns the current public key of the app with appID, or
+// ErrAppNotFound if there is no such app.
+func (a *Auth) AppPublicKey(ctx context.Context, appID int) (AppPublicKey, error) {
+	const op = "Auth.AppPublicKey"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found")
+			return AppPublicKey{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("public key lookup aborted", slog.String("error", err.Error()))
+			return AppPublicKey{}, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to get app", slog.String("error", err.Error()))
+		return AppPublicKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keyID, err := keygen.KeyID(app.PublicKey)
+	if err != nil {
+		log.Error("stored public key is malformed", slog.String("error", err.Error()))
+		return AppPublicKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return AppPublicKey{AppID: app.ID, KeyID: keyID, PublicKey: app.PublicKey, Algorithm: app.SigningAlgorithm()}, nil
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sso/internal/domain/models"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppPublicKey(t *testing.T) {
+	env := newJWTEnv(t)
+	app := env.apps.apps[testAppID]
+
+	key, err := env.auth.AppPublicKey(context.Background(), testAppID)
+	require.NoError(t, err)
+	assert.Equal(t, testAppID, key.AppID)
+	assert.Equal(t, app.PublicKey, key.PublicKey)
+	assert.Equal(t, models.AlgRS256, key.Algorithm)
+	assert.NotContains(t, fmt.Sprintf("%+v", key), "PRIVATE KEY", "the private key is never returned")
+
+	// The key ID is the one tokens of the app are signed under.
+	env.registerUser(t, testEmail, testPassword)
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, key.KeyID, parsed.Header["kid"])
+}
+
+func TestAppPublicKey_UnknownApp(t *testing.T) {
+	env := newJWTEnv(t)
+
+	_, err := env.auth.AppPublicKey(context.Background(), 9999)
+	assert.ErrorIs(t, err, ErrAppNotFound)
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+)
+
+// WithAppScopedRegistration makes users registered through an app belong to
+// it, when enabled. The app must exist and, if allowedAppIDs is not empty, be
+// one of them. Otherwise registration fails with ErrInvalidAppID. When
+// disabled, every user is shared by all apps.
+func WithAppScopedRegistration(enabled bool, allowedAppIDs []int) Option {
+	return func(a *Auth) {
+		a.appScopedRegistration = enabled
+		a.registrationAppIDs = allowedAppIDs
+	}
+}
+
+// RegisterInApp creates a new user account like Register, registered through
+// the app with appID; see WithAppScopedRegistration. An appID of 0 registers a
+// user of all apps.
+func (a *Auth) RegisterInApp(
+	ctx context.Context,
+	email string,
+	password string,
+	appID int,
+) (userID int64, err error) {
+	const op = "Auth.RegisterInApp"
+	ctx = logger.WithOp(ctx, op)
+
+	return a.register(ctx, op, email, password, storage.Profile{AppID: appID}, nil)
+}
+
+// registrationAppID returns the app ID a user registering through appID is saved
+// with: appID itself if app scoping is enabled, 0 otherwise.
+func (a *Auth) registrationAppID(ctx context.Context, appID int) (int, error) {
+	if !a.appScopedRegistration || appID == 0 {
+		return 0, nil
+	}
+
+	if len(a.registrationAppIDs) > 0 && !slices.Contains(a.registrationAppIDs, appID) {
+		return 0, ErrInvalidAppID
+	}
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return 0, ErrInvalidAppID
+		}
+		return 0, err
+	}
+
+	return appID, nil
+}
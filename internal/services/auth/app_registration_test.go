@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterInApp(t *testing.T) {
+	const otherAppID = testAppID + 1
+
+	for name, tc := range map[string]struct {
+		enabled   bool
+		allowed   []int
+		appID     int
+		wantAppID int
+		wantErr   error
+	}{
+		"scoping disabled": {enabled: false, appID: testAppID, wantAppID: 0},
+		"valid app":        {enabled: true, appID: testAppID, wantAppID: testAppID},
+		"allowed app":      {enabled: true, allowed: []int{testAppID}, appID: testAppID, wantAppID: testAppID},
+		"no app":           {enabled: true, appID: 0, wantAppID: 0},
+		"unknown app":      {enabled: true, appID: 9999, wantErr: ErrInvalidAppID},
+		"app not allowed":  {enabled: true, allowed: []int{testAppID}, appID: otherAppID, wantErr: ErrInvalidAppID},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.apps.apps[otherAppID] = env.apps.apps[testAppID]
+			WithAppScopedRegistration(tc.enabled, tc.allowed)(env.auth)
+
+			userID, err := env.auth.RegisterInApp(context.Background(), testEmail, testPassword, tc.appID)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				assert.Empty(t, env.users.users, "no user is saved")
+				return
+			}
+			require.NoError(t, err)
+
+			user, err := env.users.User(context.Background(), testEmail, tc.appID)
+			require.NoError(t, err)
+			assert.Equal(t, userID, user.ID)
+			assert.Equal(t, tc.wantAppID, user.AppID)
+		})
+	}
+}
+
+func TestRegister_IgnoresAppScoping(t *testing.T) {
+	env := newTestEnv(t)
+	WithAppScopedRegistration(true, []int{testAppID})(env.auth)
+
+	_, err := env.auth.Register(context.Background(), testEmail, testPassword)
+	require.NoError(t, err)
+
+	assert.Zero(t, env.users.users[testEmail].AppID)
+}
+
+func TestRegisterInApp_EmailPerApp(t *testing.T) {
+	const otherAppID = testAppID + 1
+	ctx := context.Background()
+
+	env := newTestEnv(t)
+	env.apps.apps[otherAppID] = models.App{ID: otherAppID, Name: "other"}
+	WithAppScopedRegistration(true, nil)(env.auth)
+
+	firstID, err := env.auth.RegisterInApp(ctx, testEmail, "first-password", testAppID)
+	require.NoError(t, err)
+	secondID, err := env.auth.RegisterInApp(ctx, testEmail, "second-password", otherAppID)
+	require.NoError(t, err, "the same email registers independently in another app")
+	assert.NotEqual(t, firstID, secondID)
+
+	_, err = env.auth.RegisterInApp(ctx, testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrUserExists, "but collides within one")
+
+	// Each app logs in its own user.
+	_, err = env.auth.Login(ctx, testEmail, "first-password", testAppID)
+	require.NoError(t, err)
+	assert.Equal(t, firstID, env.tokens.lastUser.ID)
+	_, err = env.auth.Login(ctx, testEmail, "first-password", otherAppID)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = env.auth.Login(ctx, testEmail, "second-password", otherAppID)
+	require.NoError(t, err)
+	assert.Equal(t, secondID, env.tokens.lastUser.ID)
+}
@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"log/slog"
 	"sso/internal/domain/models"
+	"sso/internal/lib/emailhash"
 	"sso/internal/lib/hash"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger"
+	"sso/internal/lib/ratelimit"
 	"sso/internal/storage"
 	"time"
 )
@@ -14,108 +18,389 @@ import (
 // Service defines the interface for authentication operations.
 type Service interface {
 	Login(ctx context.Context, email string, password string, appID int) (token string, err error)
+	LoginWithIDToken(ctx context.Context, email string, password string, appID int) (tokens LoginTokens, err error)
+	LoginWithOptions(ctx context.Context, email string, password string, appID int, opts LoginOptions) (tokens LoginTokens, err error)
+	VerifyPassword(ctx context.Context, email string, password string, appID int) error
 	Register(ctx context.Context, email string, password string) (userID int64, err error)
+	RegisterWithProfile(ctx context.Context, email string, password string, profile storage.Profile) (userID int64, err error)
+	RegisterIdempotent(ctx context.Context, idempotencyKey string, email string, password string) (userID int64, err error)
+	RegisterInApp(ctx context.Context, email string, password string, appID int) (userID int64, err error)
 	IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err error)
+	ImportUsers(ctx context.Context, users []storage.UserImport, skipExisting bool) (results []storage.ImportResult, err error)
+	WhoAmI(ctx context.Context, token string) (user models.User, err error)
+	BatchVerifyTokens(ctx context.Context, checks []TokenCheck) (results []TokenCheckResult, err error)
+	Stats(ctx context.Context) (stats Stats, err error)
+	DeleteUser(ctx context.Context, userID int64) error
+	RestoreUser(ctx context.Context, userID int64) error
+	SetUserEnabled(ctx context.Context, token string, userID int64, enabled bool) error
+	RequestEmailVerification(ctx context.Context, userID int64) (token string, err error)
+	VerifyEmail(ctx context.Context, token string) (userID int64, err error)
+	RequestPasswordReset(ctx context.Context, email string, appID int) (token string, err error)
+	ResetPassword(ctx context.Context, token string, newPassword string) error
+	ChangePassword(ctx context.Context, userID int64, oldPassword string, newPassword string) error
+	ListSessions(ctx context.Context, userID int64) (sessions []models.Session, err error)
+	RevokeSession(ctx context.Context, userID int64, sessionID int64) error
+	AppPublicKey(ctx context.Context, appID int) (key AppPublicKey, err error)
+	CheckEmail(ctx context.Context, email string, appID int) (available bool, err error)
+	WatchAuthEvents(ctx context.Context, token string) (events <-chan AuthEvent, err error)
+	SelfTest(ctx context.Context, token string, appID int) (result SelfTestResult, err error)
+	Diagnostics(ctx context.Context, token string) (report storage.Diagnostics, err error)
 }
 
-// TokenProvider defines the interface for generating authentication tokens.
+// TokenProvider defines the interface for generating and verifying authentication tokens.
 type TokenProvider interface {
-	NewToken(user models.User, app models.App, duration time.Duration) (string, error)
+	NewToken(user models.User, app models.App, duration time.Duration, opts ...jwt.TokenOption) (string, error)
+	// NewIDToken mints an OpenID Connect ID token with the user's identity claims.
+	NewIDToken(user models.User, app models.App, duration time.Duration) (string, error)
+	// TokenAppID returns the unverified app ID a token claims to be issued by.
+	TokenAppID(token string) (int, error)
+	// TokenExpiry returns the unverified expiry of a token.
+	TokenExpiry(token string) (time.Time, error)
+	// TokenBinding returns the unverified client fingerprint hash a token is
+	// bound to, or "" if it is not bound.
+	TokenBinding(token string) (string, error)
+	// TokenVerifier prepares the app's keys once and returns a function verifying its tokens.
+	TokenVerifier(app models.App) (verify func(token string) (models.User, error), err error)
 }
 
 // UserProvider defines the interface for user-related operations.
 type UserProvider interface {
-	SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte) (int64, error)
-	User(ctx context.Context, email string) (models.User, error)
+	SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte, pepperVersion int, profile storage.Profile) (int64, error)
+	SaveUsers(ctx context.Context, users []storage.UserImport, skipExisting bool) ([]storage.ImportResult, error)
+	User(ctx context.Context, email string, appID int) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	CountUsers(ctx context.Context) (int64, error)
+	EmailExists(ctx context.Context, email string, appID int) (bool, error)
+	CountAdmins(ctx context.Context) (int64, error)
+	DeleteUser(ctx context.Context, userID int64) error
+	RestoreUser(ctx context.Context, userID int64) error
+	SetDisabled(ctx context.Context, userID int64, disabled bool) error
+	SaveEmailVerificationToken(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash []byte) (int64, error)
+	SavePasswordResetToken(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) (int64, error)
+	PasswordResetUser(ctx context.Context, tokenHash []byte) (int64, error)
+	PasswordHistory(ctx context.Context, userID int64, limit int) ([]storage.PasswordRecord, error)
+	ChangePassword(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) error
+	UpdatePasswordHash(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int) error
+	IdempotencyRecord(ctx context.Context, key string, notBefore time.Time) (storage.IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, key string, record storage.IdempotencyRecord, notBefore time.Time) error
+	SaveSession(ctx context.Context, session models.Session, tokenHash []byte) (int64, error)
+	ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, sessionID int64) error
+	TouchSession(ctx context.Context, tokenHash []byte) error
+	Diagnostics(ctx context.Context) (storage.Diagnostics, error)
+	WithTx(ctx context.Context, fn func(tx storage.TxStorage) error) error
 }
 
 // AppProvider defines the interface for app-related operations.
 type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
+	CountApps(ctx context.Context) (int64, error)
 }
 
 type Auth struct {
 	log           *slog.Logger
+	hasher        *hash.Hasher
 	userProvider  UserProvider
 	appProvider   AppProvider
 	tokenProvider TokenProvider
 	tokenTTL      time.Duration
+	rememberMeTTL time.Duration
+	idTokens      bool
+
+	requireVerifiedEmail bool
+	verificationTTL      time.Duration
+	passwordResetTTL     time.Duration
+	passwordHistory      int
+	minPasswordAge       time.Duration
+	idempotencyWindow    time.Duration
+	emails               *emailhash.Hasher
+
+	appScopedRegistration bool
+	registrationAppIDs    []int
+
+	emailCheck        bool
+	emailCheckLimiter *ratelimit.Keyed[string]
+
+	maxSessions        int
+	sessionLimitPolicy SessionLimitPolicy
+
+	events *eventHub
 }
 
+// Option configures optional behavior of the Auth service.
+type Option func(*Auth)
+
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidAppID       = errors.New("invalid app ID")
+	ErrAppNotFound        = errors.New("app not found")
+	ErrAppKeyMissing      = errors.New("app has no signing key")
 	ErrUserExists         = errors.New("user already exists")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrCanceled           = errors.New("operation canceled")
+	ErrDeadlineExceeded   = errors.New("operation deadline exceeded")
+	ErrUnavailable        = errors.New("storage temporarily unavailable")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrBatchTooLarge      = errors.New("too many tokens in batch")
+	ErrBusy               = errors.New("too many concurrent requests")
+	ErrEmailNotVerified   = errors.New("email not verified")
+	ErrTokenExpired       = errors.New("token expired")
+	ErrIdempotencyKeyUsed = errors.New("idempotency key already used for another request")
+	ErrInvalidProfile     = errors.New("invalid profile")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrIDTokensDisabled   = errors.New("ID tokens are disabled")
+	ErrTooManySessions    = errors.New("too many active sessions")
+	ErrPasswordReused     = errors.New("password was used recently")
+	ErrPasswordTooRecent  = errors.New("password was changed too recently")
+	ErrAccountDisabled    = errors.New("account disabled")
 )
 
 // New creates a new instance of the Auth service.
 func New(
 	log *slog.Logger,
+	hasher *hash.Hasher,
 	userProvider UserProvider,
 	appProvider AppProvider,
 	tokenProvider TokenProvider,
 	tokenTTL time.Duration,
+	opts ...Option,
 ) *Auth {
-	return &Auth{
-		log:           log,
-		userProvider:  userProvider,
-		appProvider:   appProvider,
-		tokenProvider: tokenProvider,
-		tokenTTL:      tokenTTL,
+	a := &Auth{
+		log:             log,
+		hasher:          hasher,
+		userProvider:    userProvider,
+		appProvider:     appProvider,
+		tokenProvider:   tokenProvider,
+		tokenTTL:        tokenTTL,
+		verificationTTL: DefaultVerificationTTL,
+
+		passwordResetTTL:  DefaultPasswordResetTTL,
+		idempotencyWindow: DefaultIdempotencyWindow,
+
+		events: newEventHub(),
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
 }
 
-// Login authenticates a user and returns a token.
+// Login authenticates a user of the app, or of all apps, and returns a token.
 func (a *Auth) Login(
 	ctx context.Context,
 	email string,
 	password string,
 	appID int,
 ) (token string, err error) {
-	const op = "Auth.Login"
+	tokens, err := a.login(ctx, "Auth.Login", email, password, appID, LoginOptions{})
+
+	return tokens.AccessToken, err
+}
+
+// login authenticates the user and issues an access token, and the optional
+// results opts asks for.
+func (a *Auth) login(
+	ctx context.Context,
+	op string,
+	email string,
+	password string,
+	appID int,
+	opts LoginOptions,
+) (tokens LoginTokens, err error) {
+	ctx = logger.WithOp(ctx, op)
+
+	if opts.IDToken && !a.idTokens {
+		return LoginTokens{}, fmt.Errorf("%s: %w", op, ErrIDTokensDisabled)
+	}
 
 	log := a.log.With(slog.String("op", op), slog.String("username", email))
 
 	log.Info("attempting to log in user")
 
-	user, err := a.userProvider.User(ctx, email)
-	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			log.Warn("user not found", slog.String("error", err.Error()))
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
-		}
+	// Logged on every outcome, with the steps reached, to show where a slow
+	// login spent its time.
+	timer := newStepTimer()
+	defer func() {
+		log.LogAttrs(ctx, slog.LevelDebug, "login timing", timer.attrs())
+	}()
 
-		log.Error("failed to get user", slog.String("error", err.Error()))
-		return "", fmt.Errorf("%s: %w", op, err)
-	}
+	var userID int64
+	defer func() { a.publishLogin(email, appID, userID, err) }()
 
-	if err = hash.ComparePassword(password, user.PasswordSalt, user.PasswordHash); err != nil {
-		log.Info("invalid credentials", slog.String("error", err.Error()))
+	user, err := a.checkCredentials(ctx, log, timer, email, password, appID)
+	if err != nil {
+		return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+	userID = user.ID
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	// Checked after the password, so the response doesn't reveal the
+	// verification status of accounts to callers without their credentials.
+	if a.requireVerifiedEmail && !user.EmailVerified {
+		log.Info("email not verified", slog.Int64("user_id", user.ID))
+		return LoginTokens{}, fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
 	}
+
 	app, err := a.appProvider.App(ctx, appID)
+	timer.done("app_lookup")
 	if err != nil {
 		if errors.Is(err, storage.ErrAppNotFound) {
 			log.Warn("app not found", slog.String("error", err.Error()))
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("login aborted", slog.String("error", err.Error()))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, ctxErr)
 		}
 
 		log.Error("failed to get app", slog.String("error", err.Error()))
-		return "", fmt.Errorf("%s: %w", op, err)
+		return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user logged in successfully", slog.Int64("user_id", user.ID), slog.Int("app_id", app.ID))
+	var tokenOpts []jwt.TokenOption
+	if app.BindTokens {
+		binding, err := clientBinding(ctx)
+		if err != nil {
+			log.Warn("app binds tokens, but the client has no fingerprint", slog.Int("app_id", app.ID))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+		tokenOpts = append(tokenOpts, jwt.WithBinding(binding))
+	}
 
-	token, err = a.tokenProvider.NewToken(user, app, a.tokenTTL)
+	ttl := a.loginTokenTTL(ctx, app)
+	tokens.AccessToken, err = a.tokenProvider.NewToken(user, app, ttl, tokenOpts...)
+	timer.done("token_sign")
 	if err != nil {
+		if errors.Is(err, jwt.ErrAppKeyMissing) {
+			log.Error("app has no signing key, set its private key or KMS key", slog.Int("app_id", app.ID), slog.String("error", err.Error()))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, ErrAppKeyMissing)
+		}
 		log.Error("failed to create token", slog.String("error", err.Error()))
-		return "", fmt.Errorf("%s: %w", op, err)
+		return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if opts.IDToken {
+		tokens.IDToken, err = a.tokenProvider.NewIDToken(user, app, ttl)
+		timer.done("id_token_sign")
+		if err != nil {
+			log.Error("failed to create ID token", slog.String("error", err.Error()))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	sessionID, evicted, err := a.startSession(ctx, user, app, tokens.AccessToken)
+	timer.done("session_save")
+	if err != nil {
+		if errors.Is(err, ErrTooManySessions) {
+			log.Info("user has too many active sessions", slog.Int64("user_id", user.ID), slog.Int("max_sessions", a.maxSessions))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, ErrTooManySessions)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("login aborted", slog.String("error", err.Error()))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+
+		log.Error("failed to save session", slog.String("error", err.Error()))
+		return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+	tokens.EvictedSessions = evicted
+	if len(evicted) > 0 {
+		log.Info("evicted oldest sessions", slog.Int64("user_id", user.ID), slog.Any("session_ids", evicted))
+	}
+
+	if opts.UserInfo {
+		tokens.UserInfo, err = a.userInfo(user, tokens.AccessToken)
+		if err != nil {
+			log.Error("failed to read token expiry", slog.String("error", err.Error()))
+			return LoginTokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	client := clientInfoFromContext(ctx)
+	log.Info("user logged in successfully",
+		slog.Int64("user_id", user.ID),
+		slog.Int("app_id", app.ID),
+		slog.Int64("session_id", sessionID),
+		slog.Bool("remember_me", rememberMe(ctx)),
+		slog.Bool("id_token", opts.IDToken),
+		slog.String("client_ip", client.IP),
+		slog.String("user_agent", client.UserAgent),
+	)
+
+	return tokens, nil
+}
+
+// checkCredentials returns the user of the app, or of all apps, with the email
+// if password is theirs. An unknown email costs the same hashing time as a wrong
+// password and fails the same way, with ErrInvalidCredentials.
+func (a *Auth) checkCredentials(
+	ctx context.Context,
+	log *slog.Logger,
+	timer *stepTimer,
+	email string,
+	password string,
+	appID int,
+) (user models.User, err error) {
+	user, err = a.userProvider.User(ctx, email, appID)
+	timer.done("user_lookup")
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", slog.String("error", err.Error()))
+			// Spend the same hashing time as a wrong password so the
+			// response time doesn't reveal whether the email is registered.
+			err = a.hasher.CompareDummy(password)
+			timer.done("password_compare")
+			if errors.Is(err, hash.ErrBusy) {
+				log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+				return models.User{}, ErrBusy
+			}
+			return models.User{}, ErrInvalidCredentials
+		}
+
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("credential check aborted", slog.String("error", err.Error()))
+			return models.User{}, ctxErr
+		}
+
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return models.User{}, err
+	}
+
+	err = a.hasher.ComparePassword(password, user.PasswordSalt, user.PasswordHash, user.PepperVersion)
+	timer.done("password_compare")
+	if err != nil {
+		if errors.Is(err, hash.ErrBusy) {
+			log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+			return models.User{}, ErrBusy
+		}
+		if errors.Is(err, hash.ErrInvalidSaltLength) || errors.Is(err, hash.ErrInvalidHashLength) ||
+			errors.Is(err, hash.ErrMalformedHash) || errors.Is(err, hash.ErrUnknownVariant) {
+			// Still reported as invalid credentials, the caller can't do anything about it.
+			log.Error("stored password hash is corrupt", slog.Int64("user_id", user.ID), slog.String("error", err.Error()))
+			return models.User{}, ErrInvalidCredentials
+		}
+
+		log.Info("invalid credentials", slog.String("error", err.Error()))
+
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	// Checked after the password: a disabled account costs the same hashing
+	// time as any other and is only revealed to callers with its credentials.
+	if user.Disabled {
+		log.Info("account disabled", slog.Int64("user_id", user.ID))
+		return models.User{}, ErrAccountDisabled
 	}
 
-	return token, nil
+	if hash.NeedsRehash(user.PasswordHash) {
+		a.rehashPassword(ctx, log, user, password)
+	}
+
+	return user, nil
 }
 
 // Register creates a new user account.
@@ -125,38 +410,125 @@ func (a *Auth) Register(
 	password string,
 ) (userID int64, err error) {
 	const op = "Auth.Register"
+	ctx = logger.WithOp(ctx, op)
+
+	return a.register(ctx, op, email, password, storage.Profile{}, nil)
+}
 
+// register hashes the password and saves the user with its profile. A non-nil
+// afterSave runs in the same transaction as the save, and its error undoes it.
+func (a *Auth) register(
+	ctx context.Context,
+	op string,
+	email string,
+	password string,
+	profile storage.Profile,
+	afterSave func(tx storage.TxStorage, userID int64) error,
+) (userID int64, err error) {
 	log := a.log.With(slog.String("op", op), slog.String("email", email))
 
 	log.Info("registering new user")
 
-	passData, err := hash.HashPassword(password)
+	appID := profile.AppID
+	profile.AppID, err = a.registrationAppID(ctx, appID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAppID) {
+			log.Warn("app is not open for registration", slog.Int("app_id", appID))
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("registration aborted", slog.String("error", err.Error()))
+			return 0, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to get app", slog.String("error", err.Error()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passData, err := a.hasher.HashPassword(password)
 	if err != nil {
+		if errors.Is(err, hash.ErrBusy) {
+			log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+			return 0, fmt.Errorf("%s: %w", op, ErrBusy)
+		}
 		log.Error("failed to hash password", slog.String("error", err.Error()))
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	userID, err = a.userProvider.SaveUser(ctx, email, passData.Hash, passData.Salt)
+	err = a.userProvider.WithTx(ctx, func(tx storage.TxStorage) error {
+		userID, err = tx.SaveUser(ctx, email, passData.Hash, passData.Salt, passData.PepperVersion, profile)
+		if err != nil || afterSave == nil {
+			return err
+		}
+		return afterSave(tx, userID)
+	})
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
 			log.Warn("user already exists", slog.String("error", err.Error()))
 			return 0, fmt.Errorf("%s: %w", op, ErrUserExists)
 		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("registration aborted", slog.String("error", err.Error()))
+			return 0, fmt.Errorf("%s: %w", op, ctxErr)
+		}
 		log.Error("failed to save user", slog.String("error", err.Error()))
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user registered", slog.Int64("user_id", userID))
+	log.Info("user registered", slog.Int64("user_id", userID), slog.Int("app_id", profile.AppID))
+	a.events.publish(AuthEvent{Kind: EventRegistered, UserID: userID, Email: email, AppID: profile.AppID})
 
 	return userID, nil
 }
 
+// ImportUsers creates accounts from already hashed credentials, e.g. when onboarding
+// users from another system, and returns the result of every row. The batch is
+// atomic: a duplicate email fails it with ErrUserExists unless skipExisting is
+// set, in which case the row is marked skipped. A failed batch still returns the
+// results, telling which rows failed and why; see storage.ImportResult.
+// Callers exposing this must restrict it to admins.
+func (a *Auth) ImportUsers(
+	ctx context.Context,
+	users []storage.UserImport,
+	skipExisting bool,
+) (results []storage.ImportResult, err error) {
+	const op = "Auth.ImportUsers"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int("count", len(users)), slog.Bool("skip_existing", skipExisting))
+
+	log.Info("importing users")
+
+	results, err = a.userProvider.SaveUsers(ctx, users, skipExisting)
+	for i := range results {
+		if errors.Is(results[i].Err, storage.ErrUserExists) {
+			results[i].Err = ErrUserExists
+		}
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Warn("user already exists", slog.String("error", err.Error()))
+			return results, fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("import aborted", slog.String("error", err.Error()))
+			return results, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to import users", slog.String("error", err.Error()))
+		return results, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("users imported")
+
+	return results, nil
+}
+
 // IsAdmin checks if a user has administrative privileges.
 func (a *Auth) IsAdmin(
 	ctx context.Context,
 	userID int64,
 ) (isAdmin bool, err error) {
 	const op = "Auth.IsAdmin"
+	ctx = logger.WithOp(ctx, op)
 
 	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
 
@@ -168,6 +540,10 @@ func (a *Auth) IsAdmin(
 			log.Warn("user not found", slog.String("error", err.Error()))
 			return false, fmt.Errorf("%s: %w", op, ErrUserNotFound)
 		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("admin check aborted", slog.String("error", err.Error()))
+			return false, fmt.Errorf("%s: %w", op, ctxErr)
+		}
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -175,3 +551,231 @@ func (a *Auth) IsAdmin(
 
 	return isAdmin, nil
 }
+
+// WhoAmI verifies a token with the key of the app that issued it and returns the
+// user it identifies. Email and IsAdmin are as of minting and may be stale.
+// Tokens of revoked sessions are rejected, and so are tokens bound to a client,
+// unless presented by that client, see WithClientInfo.
+func (a *Auth) WhoAmI(
+	ctx context.Context,
+	token string,
+) (user models.User, err error) {
+	const op = "Auth.WhoAmI"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op))
+
+	appID, err := a.tokenProvider.TokenAppID(token)
+	if err != nil {
+		log.Info("malformed token", slog.String("error", err.Error()))
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	log = log.With(slog.Int("app_id", appID))
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("token issued by unknown app", slog.String("error", err.Error()))
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("token verification aborted", slog.String("error", err.Error()))
+			return models.User{}, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to get app", slog.String("error", err.Error()))
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	verify, err := a.tokenProvider.TokenVerifier(app)
+	if err != nil {
+		log.Error("failed to prepare token verifier", slog.String("error", err.Error()))
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err = verify(token)
+	if err != nil {
+		log.Info("invalid token", slog.String("error", err.Error()))
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if err = a.checkBinding(ctx, log, op, token); err != nil {
+		return models.User{}, err
+	}
+
+	if err = a.checkSession(ctx, log, op, token); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// DeleteUser soft-deletes a user: it can no longer log in or be found, but its
+// record is kept and RestoreUser can bring it back. Callers exposing this must
+// restrict it to admins.
+func (a *Auth) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "Auth.DeleteUser"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	if err := a.userProvider.DeleteUser(ctx, userID); err != nil {
+		return a.userUpdateError(log, op, err)
+	}
+
+	log.Info("user deleted")
+
+	return nil
+}
+
+// RestoreUser restores a soft-deleted user. It fails with ErrUserExists if the
+// email has been registered again in the meantime. Callers exposing this must
+// restrict it to admins.
+func (a *Auth) RestoreUser(ctx context.Context, userID int64) error {
+	const op = "Auth.RestoreUser"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	if err := a.userProvider.RestoreUser(ctx, userID); err != nil {
+		return a.userUpdateError(log, op, err)
+	}
+
+	log.Info("user restored")
+
+	return nil
+}
+
+// SetUserEnabled disables or enables the user with userID for an admin
+// identified by token. Unlike DeleteUser it keeps the user, but Login and
+// VerifyPassword fail with ErrAccountDisabled until the user is enabled again.
+// Disabling revokes the user's sessions, so their tokens stop verifying.
+func (a *Auth) SetUserEnabled(ctx context.Context, token string, userID int64, enabled bool) error {
+	const op = "Auth.SetUserEnabled"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	admin, err := a.requireAdmin(ctx, log, op, token)
+	if err != nil {
+		return err
+	}
+
+	if err = a.userProvider.SetDisabled(ctx, userID, !enabled); err != nil {
+		return a.userUpdateError(log, op, err)
+	}
+
+	if !enabled {
+		if err = a.revokeSessions(ctx, userID); err != nil {
+			log.Error("failed to revoke sessions of disabled user", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("user enabled state changed", slog.Bool("enabled", enabled), slog.Int64("admin_id", admin.ID))
+
+	return nil
+}
+
+// userUpdateError logs and maps a storage error of an update to a single user.
+func (a *Auth) userUpdateError(log *slog.Logger, op string, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		log.Warn("user not found", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	case errors.Is(err, storage.ErrUserExists):
+		log.Warn("user already exists", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrUserExists)
+	}
+	if ctxErr := contextError(err); ctxErr != nil {
+		log.Info("update aborted", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+
+	log.Error("failed to update user", slog.String("error", err.Error()))
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// Stats are service-wide totals, e.g. for admin dashboards.
+type Stats struct {
+	Users  int64
+	Admins int64
+	Apps   int64
+}
+
+// Stats returns the number of users, admins and apps. The counts are taken one
+// after another, so they may be slightly inconsistent under concurrent writes.
+func (a *Auth) Stats(ctx context.Context) (stats Stats, err error) {
+	const op = "Auth.Stats"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op))
+
+	for _, c := range []struct {
+		dst   *int64
+		count func(context.Context) (int64, error)
+	}{
+		{dst: &stats.Users, count: a.userProvider.CountUsers},
+		{dst: &stats.Admins, count: a.userProvider.CountAdmins},
+		{dst: &stats.Apps, count: a.appProvider.CountApps},
+	} {
+		if *c.dst, err = c.count(ctx); err != nil {
+			if ctxErr := contextError(err); ctxErr != nil {
+				log.Info("stats aborted", slog.String("error", err.Error()))
+				return Stats{}, fmt.Errorf("%s: %w", op, ctxErr)
+			}
+			log.Error("failed to count", slog.String("error", err.Error()))
+			return Stats{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return stats, nil
+}
+
+// rehashPassword replaces the user's stored hash, just verified against
+// password, with one made by the hasher, e.g. to move a bcrypt hash imported
+// from another system to Argon2. Failures are logged and leave the old hash
+// in place for the next login to retry.
+func (a *Auth) rehashPassword(ctx context.Context, log *slog.Logger, user models.User, password string) {
+	passData, err := a.hasher.HashPassword(password)
+	if err != nil {
+		log.Warn("failed to rehash password", slog.Int64("user_id", user.ID), slog.String("error", err.Error()))
+		return
+	}
+
+	err = a.userProvider.UpdatePasswordHash(ctx, user.ID, user.PasswordHash, passData.Hash, passData.Salt, passData.PepperVersion)
+	if err != nil {
+		log.Warn("failed to save rehashed password", slog.Int64("user_id", user.ID), slog.String("error", err.Error()))
+		return
+	}
+
+	log.Info("password rehashed", slog.Int64("user_id", user.ID))
+}
+
+// appTokenTTL returns the token lifetime for the given app, falling back to
+// the global default when the app does not define its own.
+func (a *Auth) appTokenTTL(app models.App) time.Duration {
+	if app.TokenTTL > 0 {
+		return app.TokenTTL
+	}
+
+	return a.tokenTTL
+}
+
+// contextError returns ErrCanceled or ErrDeadlineExceeded if err was caused by the
+// request context being canceled or timing out, and nil otherwise. These are normal
+// client-driven outcomes and should not be reported as internal errors. Neither is
+// ErrUnavailable, returned if the storage was busy (e.g. a locked database): the
+// client may retry.
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrDeadlineExceeded
+	case storage.IsUnavailable(err):
+		return ErrUnavailable
+	default:
+		return nil
+	}
+}
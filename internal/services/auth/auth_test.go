@@ -0,0 +1,984 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"runtime"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/hash"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/keygen"
+	"sso/internal/storage"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testEmail    = "user@example.com"
+	testPassword = "correct-password"
+	testAppID    = 1
+	defaultTTL   = time.Hour
+)
+
+type mockUserProvider struct {
+	// users are keyed by mockUserKey.
+	users   map[string]models.User
+	admins  map[int64]bool
+	deleted map[int64]models.User
+	nextID  int64
+
+	verificationTokens map[string]mockToken
+	resetTokens        map[string]mockToken
+	idempotencyKeys    map[string]mockIdempotencyRecord
+	sessions           []mockSession
+	// touchMu serializes TouchSession, which BatchVerifyTokens calls concurrently.
+	touchMu sync.Mutex
+	// passwordHistory holds the passwords replaced by resets, by user, oldest first.
+	passwordHistory map[int64][]storage.PasswordRecord
+
+	// saveIdempotencyErr, if set, fails SaveIdempotencyRecord.
+	saveIdempotencyErr error
+	// diagnostics is the report Diagnostics returns.
+	diagnostics storage.Diagnostics
+}
+
+type mockSession struct {
+	models.Session
+	tokenHash []byte
+	revoked   bool
+}
+
+type mockIdempotencyRecord struct {
+	storage.IdempotencyRecord
+	createdAt time.Time
+}
+
+type mockToken struct {
+	userID    int64
+	expiresAt time.Time
+}
+
+func newMockUserProvider() *mockUserProvider {
+	return &mockUserProvider{
+		users:   make(map[string]models.User),
+		admins:  make(map[int64]bool),
+		deleted: make(map[int64]models.User),
+
+		verificationTokens: make(map[string]mockToken),
+		resetTokens:        make(map[string]mockToken),
+		idempotencyKeys:    make(map[string]mockIdempotencyRecord),
+		passwordHistory:    make(map[int64][]storage.PasswordRecord),
+	}
+}
+
+func (m *mockUserProvider) SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte, pepperVersion int, profile storage.Profile) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if m.emailTaken(email, profile.AppID) {
+		return 0, storage.ErrUserExists
+	}
+
+	m.nextID++
+	m.users[mockUserKey(email, profile.AppID)] = models.User{
+		ID:            m.nextID,
+		Email:         email,
+		PasswordHash:  passwordHash,
+		PasswordSalt:  passwordSalt,
+		PepperVersion: pepperVersion,
+		DisplayName:   profile.DisplayName,
+		Metadata:      profile.Metadata,
+		AppID:         profile.AppID,
+	}
+
+	return m.nextID, nil
+}
+
+func (m *mockUserProvider) SaveUsers(ctx context.Context, users []storage.UserImport, skipExisting bool) ([]storage.ImportResult, error) {
+	results := make([]storage.ImportResult, len(users))
+	var firstErr error
+	for i, user := range users {
+		results[i].Index = i
+		if m.emailTaken(user.Email, 0) {
+			if skipExisting {
+				results[i].Skipped = true
+				continue
+			}
+			results[i].Err = storage.ErrUserExists
+			if firstErr == nil {
+				firstErr = fmt.Errorf("row %d: %w", i, storage.ErrUserExists)
+			}
+		}
+	}
+	if firstErr != nil {
+		for i := range results {
+			if results[i].Err == nil && !results[i].Skipped {
+				results[i].Err = storage.ErrBatchRolledBack
+			}
+		}
+		return results, firstErr
+	}
+
+	for i, user := range users {
+		if !results[i].Skipped {
+			results[i].UserID, _ = m.SaveUser(ctx, user.Email, user.PasswordHash, user.PasswordSalt, hash.NoPepper, storage.Profile{})
+		}
+	}
+
+	return results, nil
+}
+
+// emailTaken reports whether a new user of the app with appID can't have the
+// email, as in sqlite.Storage.SaveUser.
+func (m *mockUserProvider) emailTaken(email string, appID int) bool {
+	for _, user := range m.users {
+		if user.Email == email && (user.AppID == appID || user.AppID == 0 || appID == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockUserProvider) EmailExists(_ context.Context, email string, appID int) (bool, error) {
+	return m.emailTaken(email, appID), nil
+}
+
+// mockUserKey keys users of all apps by their email, so tests can index
+// mockUserProvider.users with it, and users of an app by app ID and email.
+func mockUserKey(email string, appID int) string {
+	if appID == 0 {
+		return email
+	}
+	return strconv.Itoa(appID) + "/" + email
+}
+
+func (m *mockUserProvider) User(ctx context.Context, email string, appID int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	user, ok := m.users[mockUserKey(email, appID)]
+	if !ok {
+		user, ok = m.users[email]
+	}
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	user.IsAdmin = m.admins[user.ID]
+
+	return user, nil
+}
+
+func (m *mockUserProvider) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	for _, user := range m.users {
+		if user.ID == userID {
+			return m.admins[userID], nil
+		}
+	}
+
+	return false, storage.ErrUserNotFound
+}
+
+func (m *mockUserProvider) CountUsers(context.Context) (int64, error) {
+	return int64(len(m.users)), nil
+}
+
+func (m *mockUserProvider) CountAdmins(context.Context) (int64, error) {
+	var n int64
+	for _, isAdmin := range m.admins {
+		if isAdmin {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (m *mockUserProvider) DeleteUser(_ context.Context, userID int64) error {
+	for email, user := range m.users {
+		if user.ID == userID {
+			delete(m.users, email)
+			m.deleted[userID] = user
+			return nil
+		}
+	}
+
+	return storage.ErrUserNotFound
+}
+
+func (m *mockUserProvider) RestoreUser(_ context.Context, userID int64) error {
+	user, ok := m.deleted[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	if m.emailTaken(user.Email, user.AppID) {
+		return storage.ErrUserExists
+	}
+
+	delete(m.deleted, userID)
+	m.users[mockUserKey(user.Email, user.AppID)] = user
+
+	return nil
+}
+
+func (m *mockUserProvider) SaveEmailVerificationToken(_ context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error {
+	if _, ok := m.userByID(userID); !ok {
+		return storage.ErrUserNotFound
+	}
+	for key, token := range m.verificationTokens {
+		if token.userID == userID {
+			delete(m.verificationTokens, key)
+		}
+	}
+	m.verificationTokens[string(tokenHash)] = mockToken{userID: userID, expiresAt: expiresAt}
+
+	return nil
+}
+
+func (m *mockUserProvider) VerifyEmail(_ context.Context, tokenHash []byte) (int64, error) {
+	token, ok := m.verificationTokens[string(tokenHash)]
+	if !ok {
+		return 0, storage.ErrTokenNotFound
+	}
+	delete(m.verificationTokens, string(tokenHash))
+	if !time.Now().Before(token.expiresAt) {
+		return 0, storage.ErrTokenExpired
+	}
+
+	user, ok := m.userByID(token.userID)
+	if !ok {
+		return 0, storage.ErrUserNotFound
+	}
+	user.EmailVerified = true
+	m.users[mockUserKey(user.Email, user.AppID)] = user
+
+	return user.ID, nil
+}
+
+func (m *mockUserProvider) SavePasswordResetToken(_ context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error {
+	if _, ok := m.userByID(userID); !ok {
+		return storage.ErrUserNotFound
+	}
+	for key, token := range m.resetTokens {
+		if token.userID == userID {
+			delete(m.resetTokens, key)
+		}
+	}
+	m.resetTokens[string(tokenHash)] = mockToken{userID: userID, expiresAt: expiresAt}
+
+	return nil
+}
+
+func (m *mockUserProvider) ResetPassword(_ context.Context, tokenHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) (int64, error) {
+	token, ok := m.resetTokens[string(tokenHash)]
+	if !ok {
+		return 0, storage.ErrTokenNotFound
+	}
+	delete(m.resetTokens, string(tokenHash))
+	if !time.Now().Before(token.expiresAt) {
+		return 0, storage.ErrTokenExpired
+	}
+
+	user, ok := m.userByID(token.userID)
+	if !ok {
+		return 0, storage.ErrUserNotFound
+	}
+	m.replacePassword(user, passwordHash, passwordSalt, pepperVersion, keepHistory)
+
+	return user.ID, nil
+}
+
+func (m *mockUserProvider) ChangePassword(_ context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) error {
+	user, ok := m.userByID(userID)
+	if !ok || !bytes.Equal(user.PasswordHash, oldHash) {
+		return storage.ErrUserNotFound
+	}
+	m.replacePassword(user, passwordHash, passwordSalt, pepperVersion, keepHistory)
+
+	return nil
+}
+
+func (m *mockUserProvider) replacePassword(user models.User, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) {
+	history := append(m.passwordHistory[user.ID], storage.PasswordRecord{Hash: user.PasswordHash, Salt: user.PasswordSalt, PepperVersion: user.PepperVersion})
+	m.passwordHistory[user.ID] = history[max(len(history)-keepHistory, 0):]
+
+	user.PasswordHash, user.PasswordSalt, user.PepperVersion = passwordHash, passwordSalt, pepperVersion
+	user.PasswordChangedAt = time.Now()
+	m.users[mockUserKey(user.Email, user.AppID)] = user
+}
+
+func (m *mockUserProvider) PasswordResetUser(_ context.Context, tokenHash []byte) (int64, error) {
+	token, ok := m.resetTokens[string(tokenHash)]
+	if !ok {
+		return 0, storage.ErrTokenNotFound
+	}
+	if !time.Now().Before(token.expiresAt) {
+		return 0, storage.ErrTokenExpired
+	}
+
+	return token.userID, nil
+}
+
+func (m *mockUserProvider) PasswordHistory(_ context.Context, userID int64, limit int) ([]storage.PasswordRecord, error) {
+	user, ok := m.userByID(userID)
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+
+	history := []storage.PasswordRecord{{Hash: user.PasswordHash, Salt: user.PasswordSalt, PepperVersion: user.PepperVersion}}
+	for _, record := range slices.Backward(m.passwordHistory[userID]) {
+		history = append(history, record)
+	}
+
+	return history[:min(limit, len(history))], nil
+}
+
+func (m *mockUserProvider) UpdatePasswordHash(_ context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int) error {
+	user, ok := m.userByID(userID)
+	if !ok || !bytes.Equal(user.PasswordHash, oldHash) {
+		return storage.ErrUserNotFound
+	}
+	user.PasswordHash, user.PasswordSalt, user.PepperVersion = passwordHash, passwordSalt, pepperVersion
+	m.users[mockUserKey(user.Email, user.AppID)] = user
+
+	return nil
+}
+
+func (m *mockUserProvider) IdempotencyRecord(_ context.Context, key string, notBefore time.Time) (storage.IdempotencyRecord, error) {
+	record, ok := m.idempotencyKeys[key]
+	if !ok || record.createdAt.Before(notBefore) {
+		return storage.IdempotencyRecord{}, storage.ErrKeyNotFound
+	}
+
+	return record.IdempotencyRecord, nil
+}
+
+func (m *mockUserProvider) SaveIdempotencyRecord(_ context.Context, key string, record storage.IdempotencyRecord, notBefore time.Time) error {
+	if m.saveIdempotencyErr != nil {
+		return m.saveIdempotencyErr
+	}
+	if existing, ok := m.idempotencyKeys[key]; ok && !existing.createdAt.Before(notBefore) {
+		return nil
+	}
+	m.idempotencyKeys[key] = mockIdempotencyRecord{IdempotencyRecord: record, createdAt: time.Now()}
+
+	return nil
+}
+
+func (m *mockUserProvider) SaveSession(_ context.Context, session models.Session, tokenHash []byte) (int64, error) {
+	session.ID = int64(len(m.sessions) + 1)
+	session.CreatedAt = time.Now()
+	session.LastUsedAt = session.CreatedAt
+	m.sessions = append(m.sessions, mockSession{Session: session, tokenHash: tokenHash})
+
+	return session.ID, nil
+}
+
+func (m *mockUserProvider) ListSessions(_ context.Context, userID int64) ([]models.Session, error) {
+	var sessions []models.Session
+	for _, s := range m.sessions {
+		if s.UserID == userID && !s.revoked && s.ExpiresAt.After(time.Now()) {
+			sessions = append(sessions, s.Session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (m *mockUserProvider) RevokeSession(_ context.Context, sessionID int64) error {
+	for i, s := range m.sessions {
+		if s.ID == sessionID && !s.revoked {
+			m.sessions[i].revoked = true
+			return nil
+		}
+	}
+
+	return storage.ErrSessionNotFound
+}
+
+// TouchSession finds the latest session of the token, since the mock token
+// provider mints the same token for every login.
+func (m *mockUserProvider) TouchSession(_ context.Context, tokenHash []byte) error {
+	m.touchMu.Lock()
+	defer m.touchMu.Unlock()
+
+	for i := len(m.sessions) - 1; i >= 0; i-- {
+		if bytes.Equal(m.sessions[i].tokenHash, tokenHash) {
+			if m.sessions[i].revoked {
+				return storage.ErrSessionRevoked
+			}
+			m.sessions[i].LastUsedAt = time.Now()
+			return nil
+		}
+	}
+
+	return storage.ErrSessionNotFound
+}
+
+func (m *mockUserProvider) Diagnostics(context.Context) (storage.Diagnostics, error) {
+	return m.diagnostics, nil
+}
+
+// WithTx restores the users, idempotency keys and sessions if fn fails.
+func (m *mockUserProvider) WithTx(_ context.Context, fn func(tx storage.TxStorage) error) error {
+	users, keys, sessions := maps.Clone(m.users), maps.Clone(m.idempotencyKeys), slices.Clone(m.sessions)
+	nextID := m.nextID
+
+	if err := fn(m); err != nil {
+		m.users, m.idempotencyKeys, m.sessions, m.nextID = users, keys, sessions, nextID
+		return err
+	}
+
+	return nil
+}
+
+func (m *mockUserProvider) SetDisabled(_ context.Context, userID int64, disabled bool) error {
+	user, ok := m.userByID(userID)
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	user.Disabled = disabled
+	m.users[mockUserKey(user.Email, user.AppID)] = user
+
+	return nil
+}
+
+func (m *mockUserProvider) UserByID(_ context.Context, userID int64) (models.User, error) {
+	user, ok := m.userByID(userID)
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (m *mockUserProvider) userByID(userID int64) (models.User, bool) {
+	for _, user := range m.users {
+		if user.ID == userID {
+			return user, true
+		}
+	}
+
+	return models.User{}, false
+}
+
+type mockAppProvider struct {
+	apps map[int]models.App
+}
+
+func (m *mockAppProvider) App(ctx context.Context, appID int) (models.App, error) {
+	if err := ctx.Err(); err != nil {
+		return models.App{}, err
+	}
+	app, ok := m.apps[appID]
+	if !ok {
+		return models.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (m *mockAppProvider) CountApps(context.Context) (int64, error) {
+	return int64(len(m.apps)), nil
+}
+
+type mockTokenProvider struct {
+	lastUser     models.User
+	lastApp      models.App
+	lastDuration time.Duration
+}
+
+func (m *mockTokenProvider) NewToken(user models.User, app models.App, duration time.Duration, _ ...jwt.TokenOption) (string, error) {
+	m.lastUser = user
+	m.lastApp = app
+	m.lastDuration = duration
+
+	return "token", nil
+}
+
+func (m *mockTokenProvider) NewIDToken(user models.User, app models.App, duration time.Duration) (string, error) {
+	return "id-token", nil
+}
+
+func (m *mockTokenProvider) TokenAppID(string) (int, error) {
+	return m.lastApp.ID, nil
+}
+
+func (m *mockTokenProvider) TokenExpiry(string) (time.Time, error) {
+	return time.Now().Add(m.lastDuration), nil
+}
+
+func (m *mockTokenProvider) TokenBinding(string) (string, error) {
+	return "", nil
+}
+
+func (m *mockTokenProvider) TokenVerifier(models.App) (func(string) (models.User, error), error) {
+	return func(string) (models.User, error) {
+		return m.lastUser, nil
+	}, nil
+}
+
+type testEnv struct {
+	auth   *Auth
+	users  *mockUserProvider
+	apps   *mockAppProvider
+	tokens *mockTokenProvider
+}
+
+func newTestEnv(t testing.TB) *testEnv {
+	t.Helper()
+
+	env := &testEnv{
+		users:  newMockUserProvider(),
+		apps:   &mockAppProvider{apps: map[int]models.App{testAppID: {ID: testAppID, Name: "test"}}},
+		tokens: &mockTokenProvider{},
+	}
+	hasher, err := hash.NewHasher(nil, hash.NoPepper)
+	require.NoError(t, err)
+
+	env.auth = New(slog.New(slog.NewTextHandler(io.Discard, nil)), hasher, env.users, env.apps, env.tokens, defaultTTL)
+
+	return env
+}
+
+func (e *testEnv) registerUser(t testing.TB, email, password string) int64 {
+	t.Helper()
+
+	passData, err := hash.HashPassword(password)
+	require.NoError(t, err)
+
+	userID, err := e.users.SaveUser(context.Background(), email, passData.Hash, passData.Salt, passData.PepperVersion, storage.Profile{})
+	require.NoError(t, err)
+
+	return userID
+}
+
+func TestLogin_AppTokenTTLOverridesDefault(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	env.apps.apps[testAppID] = models.App{ID: testAppID, Name: "mobile", TokenTTL: 30 * 24 * time.Hour}
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 30*24*time.Hour, env.tokens.lastDuration)
+}
+
+func TestLogin_ZeroAppTokenTTLFallsBackToDefault(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	assert.Equal(t, defaultTTL, env.tokens.lastDuration)
+}
+
+func TestLogin_Success(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "token", token)
+	assert.Equal(t, userID, env.tokens.lastUser.ID)
+	assert.Equal(t, testAppID, env.tokens.lastApp.ID)
+}
+
+func TestLogin_UserNotFound(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestLogin_WrongPassword(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(context.Background(), testEmail, "wrong-password", testAppID)
+
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestLogin_CorruptStoredHash(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	user := env.users.users[testEmail]
+	user.PasswordHash = user.PasswordHash[:len(user.PasswordHash)-4]
+	env.users.users[testEmail] = user
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestLogin_AppNotFound(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, 42)
+
+	require.ErrorIs(t, err, ErrInvalidAppID)
+}
+
+func TestRegister_Success(t *testing.T) {
+	env := newTestEnv(t)
+
+	userID, err := env.auth.Register(context.Background(), testEmail, testPassword)
+	require.NoError(t, err)
+	assert.NotZero(t, userID)
+
+	saved := env.users.users[testEmail]
+	assert.Equal(t, userID, saved.ID)
+	assert.NoError(t, hash.ComparePassword(testPassword, saved.PasswordSalt, saved.PasswordHash))
+}
+
+func TestRegister_UserExists(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Register(context.Background(), testEmail, testPassword)
+
+	require.ErrorIs(t, err, ErrUserExists)
+}
+
+func TestIsAdmin(t *testing.T) {
+	env := newTestEnv(t)
+	adminID := env.registerUser(t, "admin@example.com", testPassword)
+	userID := env.registerUser(t, testEmail, testPassword)
+	env.users.admins[adminID] = true
+
+	isAdmin, err := env.auth.IsAdmin(context.Background(), adminID)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+
+	isAdmin, err = env.auth.IsAdmin(context.Background(), userID)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+}
+
+func TestIsAdmin_UserNotFound(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.IsAdmin(context.Background(), 42)
+
+	require.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestLogin_MintsTokenWithCurrentAdminStatus(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	assert.False(t, env.tokens.lastUser.IsAdmin)
+
+	env.users.admins[userID] = true
+
+	_, err = env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	assert.True(t, env.tokens.lastUser.IsAdmin)
+}
+
+func TestImportUsers_UserExists(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	results, err := env.auth.ImportUsers(context.Background(), []storage.UserImport{
+		{Email: "new@example.com"},
+		{Email: testEmail},
+	}, false)
+
+	require.ErrorIs(t, err, ErrUserExists)
+	assert.NotContains(t, env.users.users, "new@example.com")
+	assert.Equal(t, []storage.ImportResult{
+		{Index: 0, Err: storage.ErrBatchRolledBack},
+		{Index: 1, Err: ErrUserExists},
+	}, results, "the failing row and its reason are reported")
+}
+
+func TestLogin_UpgradesImportedBcryptHash(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+	_, err = env.auth.ImportUsers(ctx, []storage.UserImport{{Email: testEmail, PasswordHash: bcryptHash}}, false)
+	require.NoError(t, err)
+
+	_, err = env.auth.Login(ctx, testEmail, "wrong-password", testAppID)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, bcryptHash, env.users.users[testEmail].PasswordHash, "a failed login doesn't rehash")
+
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	upgraded := env.users.users[testEmail]
+	assert.True(t, bytes.HasPrefix(upgraded.PasswordHash, []byte("$argon2id$")), "%s", upgraded.PasswordHash)
+	assert.NoError(t, hash.ComparePassword(testPassword, upgraded.PasswordSalt, upgraded.PasswordHash))
+
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.NoError(t, err, "the Argon2 hash verifies")
+}
+
+// allocatedBytes returns how many bytes f allocated. Argon2 allocates its whole
+// memory cost on every run, so this is a deterministic proxy for hashing work.
+func allocatedBytes(f func()) uint64 {
+	var before, after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestLogin_UnknownUserDoesEquivalentHashingWork(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	wrongPassword := allocatedBytes(func() {
+		_, err := env.auth.Login(ctx, testEmail, "wrong-password", testAppID)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	unknownUser := allocatedBytes(func() {
+		_, err := env.auth.Login(ctx, "unknown@example.com", "wrong-password", testAppID)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	assert.InEpsilon(t, wrongPassword, unknownUser, 0.1)
+}
+
+func BenchmarkLogin_WrongPassword(b *testing.B) {
+	env := newTestEnv(b)
+	env.registerUser(b, testEmail, testPassword)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = env.auth.Login(context.Background(), testEmail, "wrong-password", testAppID)
+	}
+}
+
+func BenchmarkLogin_UnknownUser(b *testing.B) {
+	env := newTestEnv(b)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = env.auth.Login(context.Background(), "unknown@example.com", "wrong-password", testAppID)
+	}
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return ctx
+}
+
+func expiredContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+func TestLogin_ContextCanceled(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(canceledContext(), testEmail, testPassword, testAppID)
+	require.ErrorIs(t, err, ErrCanceled)
+
+	_, err = env.auth.Login(expiredContext(t), testEmail, testPassword, testAppID)
+	require.ErrorIs(t, err, ErrDeadlineExceeded)
+}
+
+func TestRegister_ContextCanceled(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.Register(canceledContext(), testEmail, testPassword)
+	require.ErrorIs(t, err, ErrCanceled)
+
+	_, err = env.auth.Register(expiredContext(t), testEmail, testPassword)
+	require.ErrorIs(t, err, ErrDeadlineExceeded)
+}
+
+func TestIsAdmin_ContextCanceled(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.IsAdmin(canceledContext(), userID)
+	require.ErrorIs(t, err, ErrCanceled)
+
+	_, err = env.auth.IsAdmin(expiredContext(t), userID)
+	require.ErrorIs(t, err, ErrDeadlineExceeded)
+}
+
+// newJWTEnv is like newTestEnv but mints and verifies real tokens for an app with real keys.
+func newJWTEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	keyPair, err := keygen.GenerateRSAKeyPair(keygen.MinRSAKeyBits)
+	require.NoError(t, err)
+
+	env := newTestEnv(t)
+	env.apps.apps[testAppID] = models.App{ID: testAppID, Name: "test", PrivateKey: keyPair.PrivateKey, PublicKey: keyPair.PublicKey}
+	env.auth.tokenProvider = jwt.New(env.auth.log)
+
+	return env
+}
+
+func TestWhoAmI_ValidToken(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	env.users.admins[userID] = true
+
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	user, err := env.auth.WhoAmI(context.Background(), token)
+	require.NoError(t, err)
+
+	assert.Equal(t, userID, user.ID)
+	assert.Equal(t, testEmail, user.Email)
+	assert.True(t, user.IsAdmin)
+}
+
+func TestWhoAmI_InvalidToken(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.WhoAmI(context.Background(), "not-a-jwt")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	app := env.apps.apps[testAppID]
+	expired, err := jwt.New(env.auth.log).NewToken(models.User{ID: 1}, app, -time.Minute)
+	require.NoError(t, err)
+
+	_, err = env.auth.WhoAmI(context.Background(), expired)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	delete(env.apps.apps, testAppID)
+	valid, err := jwt.New(env.auth.log).NewToken(models.User{ID: 1}, app, time.Hour)
+	require.NoError(t, err)
+
+	_, err = env.auth.WhoAmI(context.Background(), valid)
+	assert.ErrorIs(t, err, ErrInvalidToken, "token of an unknown app")
+}
+
+func TestLogin_AppKeyMissing(t *testing.T) {
+	for name, key := range map[string]string{
+		"empty":      "",
+		"whitespace": "  \n\t",
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := newJWTEnv(t)
+			env.registerUser(t, testEmail, testPassword)
+			app := env.apps.apps[testAppID]
+			app.PrivateKey = key
+			env.apps.apps[testAppID] = app
+
+			_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+			assert.ErrorIs(t, err, ErrAppKeyMissing)
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, "a@example.com", testPassword)
+	adminID := env.registerUser(t, "b@example.com", testPassword)
+	env.users.admins[adminID] = true
+
+	stats, err := env.auth.Stats(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, Stats{Users: 2, Admins: 1, Apps: 1}, stats)
+}
+
+func TestDeleteUser_BlocksLoginUntilRestored(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	require.NoError(t, env.auth.DeleteUser(ctx, userID))
+	assert.ErrorIs(t, env.auth.DeleteUser(ctx, userID), ErrUserNotFound)
+
+	_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	require.NoError(t, env.auth.RestoreUser(ctx, userID))
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, env.auth.RestoreUser(ctx, userID), ErrUserNotFound, "not deleted")
+}
+
+func TestLogin_DisabledAccount(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	require.NoError(t, env.users.SetDisabled(context.Background(), userID, true))
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrAccountDisabled)
+
+	_, err = env.auth.Login(context.Background(), testEmail, "wrong-password", testAppID)
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "only callers with the password learn the account is disabled")
+
+	err = env.auth.VerifyPassword(context.Background(), testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrAccountDisabled, "the password can't be verified either")
+}
+
+func TestSetUserEnabled(t *testing.T) {
+	env := newJWTEnv(t)
+	token := adminToken(t, env)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+	userToken, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	require.NoError(t, env.auth.SetUserEnabled(ctx, token, userID, false))
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrAccountDisabled)
+	_, err = env.auth.WhoAmI(ctx, userToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "the user's sessions are revoked")
+
+	require.NoError(t, env.auth.SetUserEnabled(ctx, token, userID, true))
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.NoError(t, err)
+
+	err = env.auth.SetUserEnabled(ctx, token, 42, false)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestSetUserEnabled_NotAdmin(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	err = env.auth.SetUserEnabled(context.Background(), token, userID, false)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	user, ok := env.users.userByID(userID)
+	require.True(t, ok)
+	assert.False(t, user.Disabled)
+}
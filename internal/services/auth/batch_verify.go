@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+	"sync"
+)
+
+const (
+	// MaxBatchVerifyTokens caps the number of tokens in one BatchVerifyTokens call.
+	MaxBatchVerifyTokens = 100
+	batchVerifyWorkers   = 8
+)
+
+// TokenCheck is one token to verify. AppID, when set, is the app the caller
+// expects the token to be issued by; 0 trusts the token's app_id claim.
+// Fingerprint identifies the client that presented the token, as in ClientInfo;
+// tokens bound to another client, or to any client when it is empty, fail.
+type TokenCheck struct {
+	Token       string
+	AppID       int
+	Fingerprint string
+}
+
+// TokenCheckResult is the outcome of one TokenCheck. Err is ErrInvalidToken for
+// malformed, expired or foreign tokens, tokens of revoked sessions and tokens
+// bound to another client; User is set only when Err is nil.
+type TokenCheckResult struct {
+	User models.User
+	Err  error
+}
+
+// BatchVerifyTokens verifies tokens concurrently and returns one result per check,
+// in input order. A bad token only fails its own result; the call as a whole fails
+// only if the batch is too large or ctx ends before all tokens are checked.
+func (a *Auth) BatchVerifyTokens(
+	ctx context.Context,
+	checks []TokenCheck,
+) (results []TokenCheckResult, err error) {
+	const op = "Auth.BatchVerifyTokens"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int("count", len(checks)))
+
+	if len(checks) > MaxBatchVerifyTokens {
+		return nil, fmt.Errorf("%s: %w", op, ErrBatchTooLarge)
+	}
+
+	// Apps are looked up and their keys parsed once per call, however many tokens they issued.
+	verifiers := &verifierCache{auth: a, entries: make(map[int]*verifierEntry)}
+
+	results = make([]TokenCheckResult, len(checks))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(batchVerifyWorkers, len(checks)) {
+		wg.Go(func() {
+			for i := range jobs {
+				results[i] = a.verifyCheck(ctx, log, op, checks[i], verifiers)
+			}
+		})
+	}
+	for i := range checks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctxErr := contextError(ctx.Err()); ctxErr != nil {
+		log.Info("batch verification aborted", slog.String("error", ctx.Err().Error()))
+		return nil, fmt.Errorf("%s: %w", op, ctxErr)
+	}
+
+	return results, nil
+}
+
+// verifyCheck verifies one token as WhoAmI does, with the client of the check.
+func (a *Auth) verifyCheck(ctx context.Context, log *slog.Logger, op string, check TokenCheck, verifiers *verifierCache) TokenCheckResult {
+	appID, err := a.tokenProvider.TokenAppID(check.Token)
+	if err != nil {
+		return TokenCheckResult{Err: ErrInvalidToken}
+	}
+	if check.AppID != 0 {
+		// The verifier rejects tokens whose app_id claim differs from the app.
+		appID = check.AppID
+	}
+
+	verify, err := verifiers.get(ctx, appID)
+	if err != nil {
+		return TokenCheckResult{Err: err}
+	}
+
+	user, err := verify(check.Token)
+	if err != nil {
+		return TokenCheckResult{Err: ErrInvalidToken}
+	}
+
+	info := clientInfoFromContext(ctx)
+	info.Fingerprint = check.Fingerprint
+	ctx = WithClientInfo(ctx, info)
+	log = log.With(slog.Int("app_id", appID), slog.Int64("user_id", user.ID))
+
+	if err := a.checkBinding(ctx, log, op, check.Token); err != nil {
+		return TokenCheckResult{Err: ErrInvalidToken}
+	}
+	if err := a.checkSession(ctx, log, op, check.Token); err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return TokenCheckResult{Err: ErrInvalidToken}
+		}
+		return TokenCheckResult{Err: err}
+	}
+
+	return TokenCheckResult{User: user}
+}
+
+// verifierCache holds the token verifier of each app seen during one batch.
+type verifierCache struct {
+	auth    *Auth
+	mu      sync.Mutex
+	entries map[int]*verifierEntry
+}
+
+type verifierEntry struct {
+	once   sync.Once
+	verify func(token string) (models.User, error)
+	err    error
+}
+
+func (c *verifierCache) get(ctx context.Context, appID int) (func(token string) (models.User, error), error) {
+	c.mu.Lock()
+	entry, ok := c.entries[appID]
+	if !ok {
+		entry = &verifierEntry{}
+		c.entries[appID] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.verify, entry.err = c.load(ctx, appID)
+	})
+
+	return entry.verify, entry.err
+}
+
+func (c *verifierCache) load(ctx context.Context, appID int) (func(token string) (models.User, error), error) {
+	app, err := c.auth.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, ErrInvalidToken
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	return c.auth.tokenProvider.TokenVerifier(app)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingAppProvider struct {
+	AppProvider
+	calls atomic.Int32
+}
+
+func (c *countingAppProvider) App(ctx context.Context, appID int) (models.App, error) {
+	c.calls.Add(1)
+	return c.AppProvider.App(ctx, appID)
+}
+
+func TestBatchVerifyTokens_PartialResults(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	valid, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	expired, err := jwt.New(env.auth.log).NewToken(models.User{ID: userID}, env.apps.apps[testAppID], -time.Minute)
+	require.NoError(t, err)
+
+	apps := &countingAppProvider{AppProvider: env.apps}
+	env.auth.appProvider = apps
+
+	results, err := env.auth.BatchVerifyTokens(context.Background(), []TokenCheck{
+		{Token: valid},
+		{Token: expired},
+		{Token: "not-a-jwt"},
+		{Token: valid, AppID: testAppID},
+		{Token: valid, AppID: testAppID + 1},
+		{Token: valid},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, userID, results[0].User.ID)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidToken, "expired")
+	assert.ErrorIs(t, results[2].Err, ErrInvalidToken, "malformed")
+	assert.NoError(t, results[3].Err, "matching app id")
+	assert.ErrorIs(t, results[4].Err, ErrInvalidToken, "unknown app id")
+	assert.NoError(t, results[5].Err)
+
+	// One lookup for the known app and one for the unknown one, not one per token.
+	assert.Equal(t, int32(2), apps.calls.Load())
+}
+
+func TestBatchVerifyTokens_TooLarge(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.BatchVerifyTokens(context.Background(), make([]TokenCheck, MaxBatchVerifyTokens+1))
+
+	require.ErrorIs(t, err, ErrBatchTooLarge)
+}
+
+func TestBatchVerifyTokens_BindingAndSessions(t *testing.T) {
+	env := newJWTEnv(t)
+	app := env.apps.apps[testAppID]
+	app.BindTokens = true
+	env.apps.apps[testAppID] = app
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	laptop := WithClientInfo(context.Background(), ClientInfo{Fingerprint: "device-id:laptop"})
+	revoked, err := env.auth.Login(laptop, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	kept, err := env.auth.Login(laptop, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	sessions, err := env.auth.ListSessions(context.Background(), userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.NoError(t, env.auth.RevokeSession(context.Background(), userID, sessions[0].ID))
+
+	results, err := env.auth.BatchVerifyTokens(context.Background(), []TokenCheck{
+		{Token: kept, Fingerprint: "device-id:laptop"},
+		{Token: kept, Fingerprint: "device-id:phone"},
+		{Token: kept},
+		{Token: revoked, Fingerprint: "device-id:laptop"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	assert.NoError(t, results[0].Err, "the bound client")
+	assert.Equal(t, userID, results[0].User.ID)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidToken, "another client")
+	assert.ErrorIs(t, results[2].Err, ErrInvalidToken, "no client fingerprint")
+	assert.ErrorIs(t, results[3].Err, ErrInvalidToken, "revoked session")
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrFingerprintRequired means the app binds tokens to clients, but the client
+// logging in has no fingerprint to bind to.
+var ErrFingerprintRequired = errors.New("client fingerprint required")
+
+// clientBinding returns the hash of the client fingerprint in ctx that tokens
+// bound to the client carry, or ErrFingerprintRequired if there is none.
+func clientBinding(ctx context.Context) (string, error) {
+	fingerprint := clientInfoFromContext(ctx).Fingerprint
+	if fingerprint == "" {
+		return "", ErrFingerprintRequired
+	}
+
+	sum := sha256.Sum256([]byte(fingerprint))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// checkBinding fails with ErrInvalidToken if a verified token is bound to a
+// client other than the one in ctx. Unbound tokens pass.
+func (a *Auth) checkBinding(ctx context.Context, log *slog.Logger, op string, token string) error {
+	bound, err := a.tokenProvider.TokenBinding(token)
+	if err != nil {
+		log.Info("invalid token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if bound == "" {
+		return nil
+	}
+
+	presented, err := clientBinding(ctx)
+	if err != nil || subtle.ConstantTimeCompare([]byte(bound), []byte(presented)) != 1 {
+		log.Warn("bound token presented by another client", slog.String("client_ip", clientInfoFromContext(ctx).IP))
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	return nil
+}
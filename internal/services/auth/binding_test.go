@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/jwt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhoAmI_BoundToken(t *testing.T) {
+	env := newJWTEnv(t)
+	app := env.apps.apps[testAppID]
+	app.BindTokens = true
+	env.apps.apps[testAppID] = app
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	laptop := WithClientInfo(context.Background(), ClientInfo{Fingerprint: "device-id:laptop"})
+	phone := WithClientInfo(context.Background(), ClientInfo{Fingerprint: "device-id:phone"})
+
+	token, err := env.auth.Login(laptop, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	claims, err := jwt.Verify(token, app.PublicKey)
+	require.NoError(t, err)
+	require.NotNil(t, claims.Confirmation, "the token carries a cnf claim")
+	assert.NotContains(t, claims.Confirmation.FingerprintS256, "laptop", "the fingerprint is hashed")
+
+	user, err := env.auth.WhoAmI(laptop, token)
+	require.NoError(t, err, "the bound client can use the token")
+	assert.Equal(t, userID, user.ID)
+
+	_, err = env.auth.WhoAmI(phone, token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "another client can't")
+	_, err = env.auth.WhoAmI(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "neither can a client without a fingerprint")
+
+	_, err = env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrFingerprintRequired)
+}
+
+func TestWhoAmI_UnboundToken(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	laptop := WithClientInfo(context.Background(), ClientInfo{Fingerprint: "device-id:laptop"})
+	token, err := env.auth.Login(laptop, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	claims, err := jwt.Verify(token, env.apps.apps[testAppID].PublicKey)
+	require.NoError(t, err)
+	assert.Nil(t, claims.Confirmation, "apps don't bind tokens by default")
+
+	phone := WithClientInfo(context.Background(), ClientInfo{Fingerprint: "device-id:phone"})
+	_, err = env.auth.WhoAmI(phone, token)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+)
+
+// Diagnostics runs the storage integrity check for an admin identified by
+// token, to catch corruption before it fails logins. Problems found are
+// reported in the result, not as an error; errors are reserved for the caller
+// being denied (ErrPermissionDenied) and the check failing to run.
+func (a *Auth) Diagnostics(ctx context.Context, token string) (report storage.Diagnostics, err error) {
+	const op = "Auth.Diagnostics"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op))
+
+	if _, err = a.requireAdmin(ctx, log, op, token); err != nil {
+		return storage.Diagnostics{}, err
+	}
+
+	report, err = a.userProvider.Diagnostics(ctx)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("diagnostics aborted", slog.String("error", err.Error()))
+			return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to run diagnostics", slog.String("error", err.Error()))
+		return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if report.OK {
+		log.Info("storage integrity check passed", slog.Int64("page_count", report.PageCount), slog.Int64("free_pages", report.FreePages))
+	} else {
+		log.Error("storage integrity check found problems", slog.Int("problems", len(report.Problems)), slog.Any("details", report.Problems))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/storage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnostics(t *testing.T) {
+	env := newJWTEnv(t)
+	token := adminToken(t, env)
+	env.users.diagnostics = storage.Diagnostics{Problems: []string{"row 3 missing from index"}, PageCount: 10, FreePages: 2}
+
+	report, err := env.auth.Diagnostics(context.Background(), token)
+	require.NoError(t, err, "problems are reported in the result")
+	assert.Equal(t, env.users.diagnostics, report)
+}
+
+func TestDiagnostics_RequiresAdmin(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	_, err = env.auth.Diagnostics(context.Background(), token)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger"
+	"sso/internal/lib/ratelimit"
+	"strings"
+)
+
+var (
+	// ErrEmailCheckDisabled means CheckEmail was not enabled with WithEmailCheck.
+	ErrEmailCheckDisabled = errors.New("email check is disabled")
+	// ErrTooManyRequests means the caller exceeded its rate limit.
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// WithEmailCheck enables CheckEmail, limited to perIP per client IP so it can't
+// be used to enumerate registered emails quickly. A non-positive perIP.RPS
+// leaves it unlimited.
+func WithEmailCheck(enabled bool, perIP ratelimit.Limit) Option {
+	return func(a *Auth) {
+		a.emailCheck = enabled
+		a.emailCheckLimiter = ratelimit.NewKeyed[string](perIP, nil)
+	}
+}
+
+// CheckEmail reports whether email is still available for registering through
+// the app, like Register or RegisterInApp with appID would. Surrounding
+// whitespace is ignored; otherwise emails are compared exactly, as registration
+// stores them. It fails with ErrEmailCheckDisabled unless enabled with
+// WithEmailCheck, and with ErrTooManyRequests once the client IP, see
+// WithClientInfo, exceeds its limit.
+func (a *Auth) CheckEmail(ctx context.Context, email string, appID int) (available bool, err error) {
+	const op = "Auth.CheckEmail"
+	ctx = logger.WithOp(ctx, op)
+
+	if !a.emailCheck {
+		return false, fmt.Errorf("%s: %w", op, ErrEmailCheckDisabled)
+	}
+
+	client := clientInfoFromContext(ctx)
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID), slog.String("client_ip", client.IP))
+
+	if !a.emailCheckLimiter.Allow(client.IP) {
+		log.Warn("email check rate limit exceeded")
+		return false, fmt.Errorf("%s: %w", op, ErrTooManyRequests)
+	}
+
+	appID, err = a.registrationAppID(ctx, appID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAppID) {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("email check aborted", slog.String("error", err.Error()))
+			return false, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to get app", slog.String("error", err.Error()))
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exists, err := a.userProvider.EmailExists(ctx, normalizeEmail(email), appID)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("email check aborted", slog.String("error", err.Error()))
+			return false, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to check email", slog.String("error", err.Error()))
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return !exists, nil
+}
+
+// normalizeEmail strips the whitespace a signup form may leave around an email.
+func normalizeEmail(email string) string {
+	return strings.TrimSpace(email)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/ratelimit"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEmail(t *testing.T) {
+	env := newTestEnv(t)
+	WithEmailCheck(true, ratelimit.Limit{})(env.auth)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	available, err := env.auth.CheckEmail(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+	assert.False(t, available)
+
+	available, err = env.auth.CheckEmail(ctx, "  "+testEmail+"\n", testAppID)
+	require.NoError(t, err)
+	assert.False(t, available, "surrounding whitespace is ignored")
+
+	available, err = env.auth.CheckEmail(ctx, "free@example.com", testAppID)
+	require.NoError(t, err)
+	assert.True(t, available)
+}
+
+func TestCheckEmail_AppScoped(t *testing.T) {
+	env := newTestEnv(t)
+	WithEmailCheck(true, ratelimit.Limit{})(env.auth)
+	WithAppScopedRegistration(true, nil)(env.auth)
+	env.apps.apps[testAppID+1] = env.apps.apps[testAppID]
+	ctx := context.Background()
+
+	_, err := env.auth.RegisterInApp(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	available, err := env.auth.CheckEmail(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+	assert.False(t, available)
+	available, err = env.auth.CheckEmail(ctx, testEmail, testAppID+1)
+	require.NoError(t, err)
+	assert.True(t, available, "the email is free in another app")
+
+	_, err = env.auth.CheckEmail(ctx, testEmail, 9999)
+	assert.ErrorIs(t, err, ErrInvalidAppID)
+}
+
+func TestCheckEmail_RateLimitedPerIP(t *testing.T) {
+	env := newTestEnv(t)
+	WithEmailCheck(true, ratelimit.Limit{RPS: 0.001, Burst: 2})(env.auth)
+	first := WithClientInfo(context.Background(), ClientInfo{IP: "192.0.2.1"})
+	second := WithClientInfo(context.Background(), ClientInfo{IP: "192.0.2.2"})
+
+	for range 2 {
+		_, err := env.auth.CheckEmail(first, testEmail, testAppID)
+		require.NoError(t, err)
+	}
+	_, err := env.auth.CheckEmail(first, testEmail, testAppID)
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+
+	_, err = env.auth.CheckEmail(second, testEmail, testAppID)
+	assert.NoError(t, err, "other IPs have their own limit")
+}
+
+func TestCheckEmail_Disabled(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.CheckEmail(context.Background(), testEmail, testAppID)
+	assert.ErrorIs(t, err, ErrEmailCheckDisabled)
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sso/internal/lib/hash"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.RequestEmailVerification(ctx, userID)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.NotContains(t, env.users.verificationTokens, token, "only the hash is stored")
+
+	verifiedID, err := env.auth.VerifyEmail(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, verifiedID)
+	assert.True(t, env.users.users[testEmail].EmailVerified)
+}
+
+func TestVerifyEmail_TokenReused(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.RequestEmailVerification(ctx, userID)
+	require.NoError(t, err)
+
+	_, err = env.auth.VerifyEmail(ctx, token)
+	require.NoError(t, err)
+
+	_, err = env.auth.VerifyEmail(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestVerifyEmail_SupersededToken(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	first, err := env.auth.RequestEmailVerification(ctx, userID)
+	require.NoError(t, err)
+	second, err := env.auth.RequestEmailVerification(ctx, userID)
+	require.NoError(t, err)
+
+	_, err = env.auth.VerifyEmail(ctx, first)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = env.auth.VerifyEmail(ctx, second)
+	assert.NoError(t, err)
+}
+
+func TestVerifyEmail_Expired(t *testing.T) {
+	env := newTestEnv(t)
+	WithEmailVerification(false, time.Millisecond)(env.auth)
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	token, err := env.auth.RequestEmailVerification(context.Background(), userID)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = env.auth.VerifyEmail(context.Background(), token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.False(t, env.users.users[testEmail].EmailVerified)
+}
+
+func TestRequestEmailVerification_UserNotFound(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.RequestEmailVerification(context.Background(), 42)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestLogin_RequiresVerifiedEmail(t *testing.T) {
+	env := newTestEnv(t)
+	hasher, err := hash.NewHasher(nil, hash.NoPepper)
+	require.NoError(t, err)
+	env.auth = New(slog.New(slog.NewTextHandler(io.Discard, nil)), hasher, env.users, env.apps, env.tokens, defaultTTL,
+		WithEmailVerification(true, 0))
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	_, err = env.auth.Login(ctx, testEmail, "wrong-password", testAppID)
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "the verification status is not revealed without the password")
+
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.ErrorIs(t, err, ErrEmailNotVerified)
+
+	token, err := env.auth.RequestEmailVerification(ctx, userID)
+	require.NoError(t, err)
+	_, err = env.auth.VerifyEmail(ctx, token)
+	require.NoError(t, err)
+
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.NoError(t, err)
+}
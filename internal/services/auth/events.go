@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEventBuffer is how many events a WatchAuthEvents subscriber may fall
+// behind by before further events to it are dropped.
+const DefaultEventBuffer = 256
+
+// ErrPermissionDenied means the caller is authenticated but not allowed to
+// perform the operation, e.g. is not an admin.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// EventKind is the kind of an AuthEvent.
+type EventKind string
+
+const (
+	EventLoginSucceeded EventKind = "login_succeeded"
+	EventLoginFailed    EventKind = "login_failed"
+	EventRegistered     EventKind = "registered"
+)
+
+// AuthEvent is an audit event streamed by WatchAuthEvents as it happens.
+type AuthEvent struct {
+	Kind EventKind
+	Time time.Time
+	// UserID is 0 when there is no known user, e.g. a login with an unknown email.
+	UserID int64
+	Email  string
+	// AppID is 0 for a registration for all apps.
+	AppID int
+	// Reason is why a login failed, e.g. "invalid credentials".
+	Reason string
+}
+
+// WithEventBuffer sets how many events a WatchAuthEvents subscriber may fall
+// behind by. A size of 0 or less keeps DefaultEventBuffer.
+func WithEventBuffer(size int) Option {
+	return func(a *Auth) {
+		if size > 0 {
+			a.events.buffer = size
+		}
+	}
+}
+
+// WatchAuthEvents returns a channel of the audit events that happen from now on,
+// for an admin identified by token. Admin status is checked in storage, not in
+// the token, so a revoked admin can't subscribe with an old token. The channel
+// is closed when ctx is done. A subscriber that falls behind misses events
+// rather than slowing down logins; see DroppedAuthEvents.
+func (a *Auth) WatchAuthEvents(ctx context.Context, token string) (<-chan AuthEvent, error) {
+	const op = "Auth.WatchAuthEvents"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.requireAdmin(ctx, log, op, token)
+	if err != nil {
+		return nil, err
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	events, unsubscribe := a.events.subscribe()
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+		log.Info("auth events watcher left")
+	}()
+
+	log.Info("auth events watcher joined")
+
+	return events, nil
+}
+
+// requireAdmin returns the user identified by token if they are an admin, and
+// fails with ErrPermissionDenied otherwise. Admin status is checked in storage,
+// not in the token, so a revoked admin is denied even with an old token.
+func (a *Auth) requireAdmin(ctx context.Context, log *slog.Logger, op string, token string) (models.User, error) {
+	user, err := a.WhoAmI(ctx, token)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	isAdmin, err := a.userProvider.IsAdmin(ctx, user.ID)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("admin check aborted", slog.String("error", err.Error()))
+			return models.User{}, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Warn("failed to check admin status", slog.Int64("user_id", user.ID), slog.String("error", err.Error()))
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+	if !isAdmin {
+		log.Warn("non-admin caller denied", slog.Int64("user_id", user.ID))
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	return user, nil
+}
+
+// DroppedAuthEvents returns how many events were not delivered to
+// WatchAuthEvents subscribers because they had fallen behind.
+func (a *Auth) DroppedAuthEvents() uint64 {
+	return a.events.dropped.Load()
+}
+
+// publishLogin publishes the outcome of a login. err is the error Login returns.
+func (a *Auth) publishLogin(email string, appID int, userID int64, err error) {
+	event := AuthEvent{Kind: EventLoginSucceeded, UserID: userID, Email: email, AppID: appID}
+	if err != nil {
+		event.Kind = EventLoginFailed
+		event.Reason = loginFailureReason(err)
+	}
+
+	a.events.publish(event)
+}
+
+// loginFailureReason returns the text of the service error a login failed with.
+func loginFailureReason(err error) string {
+	for _, reason := range []error{
+		ErrInvalidCredentials, ErrInvalidAppID, ErrAccountDisabled, ErrEmailNotVerified, ErrAppKeyMissing,
+		ErrFingerprintRequired, ErrTooManySessions, ErrBusy, ErrUnavailable, ErrCanceled, ErrDeadlineExceeded,
+	} {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+
+	return "internal error"
+}
+
+// eventHub fans events out to subscribers without ever blocking the publisher.
+type eventHub struct {
+	buffer  int
+	dropped atomic.Uint64
+
+	mu   sync.Mutex
+	subs map[chan AuthEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{buffer: DefaultEventBuffer, subs: make(map[chan AuthEvent]struct{})}
+}
+
+// subscribe returns a channel of the events published from now on and the
+// function that closes it.
+func (h *eventHub) subscribe() (<-chan AuthEvent, func()) {
+	ch := make(chan AuthEvent, h.buffer)
+
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish hands event to every subscriber with room for it and counts it as
+// dropped for the others.
+func (h *eventHub) publish(event AuthEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			h.dropped.Add(1)
+		}
+	}
+}
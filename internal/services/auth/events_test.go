@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveEvent(t *testing.T, events <-chan AuthEvent) AuthEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "events channel closed")
+		return event
+	case <-time.After(time.Second):
+		require.FailNow(t, "no event received")
+		return AuthEvent{}
+	}
+}
+
+func TestWatchAuthEvents(t *testing.T) {
+	env := newJWTEnv(t)
+	adminID := env.registerUser(t, "admin@example.com", testPassword)
+	env.users.admins[adminID] = true
+	token, err := env.auth.Login(context.Background(), "admin@example.com", testPassword, testAppID)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := env.auth.WatchAuthEvents(ctx, token)
+	require.NoError(t, err)
+
+	userID, err := env.auth.Register(context.Background(), testEmail, testPassword)
+	require.NoError(t, err)
+	event := receiveEvent(t, events)
+	assert.Equal(t, EventRegistered, event.Kind)
+	assert.Equal(t, userID, event.UserID)
+	assert.Equal(t, testEmail, event.Email)
+	assert.False(t, event.Time.IsZero())
+
+	_, err = env.auth.Login(context.Background(), testEmail, "wrong-password", testAppID)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	event = receiveEvent(t, events)
+	assert.Equal(t, EventLoginFailed, event.Kind)
+	assert.Equal(t, ErrInvalidCredentials.Error(), event.Reason)
+	assert.Equal(t, testAppID, event.AppID)
+
+	_, err = env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	event = receiveEvent(t, events)
+	assert.Equal(t, EventLoginSucceeded, event.Kind)
+	assert.Equal(t, userID, event.UserID)
+	assert.Empty(t, event.Reason)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		_, ok := <-events
+		return !ok
+	}, time.Second, 10*time.Millisecond, "the channel is closed once ctx is done")
+}
+
+func TestWatchAuthEvents_RequiresAdmin(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	_, err = env.auth.WatchAuthEvents(context.Background(), token)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	_, err = env.auth.WatchAuthEvents(context.Background(), "not-a-jwt")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestLoginFailureReason(t *testing.T) {
+	for _, err := range []error{ErrTooManySessions, ErrFingerprintRequired, ErrAccountDisabled, ErrUnavailable} {
+		assert.Equal(t, err.Error(), loginFailureReason(fmt.Errorf("auth.Login: %w", err)))
+	}
+	assert.Equal(t, "internal error", loginFailureReason(errors.New("disk full")))
+}
+
+func TestEventHub_DropsForSlowSubscriber(t *testing.T) {
+	hub := newEventHub()
+	hub.buffer = 2
+	slow, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+
+	for range 5 {
+		hub.publish(AuthEvent{Kind: EventRegistered})
+	}
+
+	assert.Len(t, slow, 2)
+	assert.Equal(t, uint64(3), hub.dropped.Load())
+
+	unsubscribe()
+	hub.publish(AuthEvent{Kind: EventRegistered})
+	assert.Equal(t, uint64(3), hub.dropped.Load(), "a closed subscription is not published to")
+}
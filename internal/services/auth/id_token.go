@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+)
+
+// LoginTokens are the tokens issued by LoginWithIDToken.
+type LoginTokens struct {
+	AccessToken string
+	// IDToken is an OpenID Connect ID token with the user's identity claims: sub,
+	// email, email_verified and name. It is not accepted as an access token.
+	IDToken string
+	// UserInfo describes the user and the access token; nil unless asked for
+	// with LoginOptions.UserInfo.
+	UserInfo *UserInfo
+	// EvictedSessions are the IDs of the user's older sessions this login
+	// revoked to stay within the session cap, see WithMaxSessions.
+	EvictedSessions []int64
+}
+
+// WithIDTokens lets LoginWithIDToken issue ID tokens alongside access tokens.
+func WithIDTokens(enabled bool) Option {
+	return func(a *Auth) {
+		a.idTokens = enabled
+	}
+}
+
+// LoginWithIDToken is Login for OIDC-style clients: it also returns an ID token,
+// signed with the app key and expiring with the access token. It fails with
+// ErrIDTokensDisabled unless enabled with WithIDTokens.
+func (a *Auth) LoginWithIDToken(
+	ctx context.Context,
+	email string,
+	password string,
+	appID int,
+) (tokens LoginTokens, err error) {
+	return a.login(ctx, "Auth.LoginWithIDToken", email, password, appID, LoginOptions{IDToken: true})
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginWithIDToken(t *testing.T) {
+	env := newJWTEnv(t)
+	WithIDTokens(true)(env.auth)
+	userID, err := env.auth.RegisterWithProfile(context.Background(), testEmail, testPassword, storage.Profile{DisplayName: "Jane Doe"})
+	require.NoError(t, err)
+
+	tokens, err := env.auth.LoginWithIDToken(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.AccessToken)
+	require.NotEmpty(t, tokens.IDToken)
+
+	app := env.apps.apps[testAppID]
+	access, err := jwt.Verify(tokens.AccessToken, app.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, userID, access.UserID)
+	assert.Equal(t, testAppID, access.AppID)
+
+	id, err := jwt.Verify(tokens.IDToken, app.PublicKey)
+	require.NoError(t, err, "the ID token is signed with the app key")
+	assert.Equal(t, testEmail, id.Email)
+	assert.Zero(t, id.UserID, "the ID token has sub instead of uid")
+	assert.Equal(t, access.ExpiresAt, id.ExpiresAt)
+	subject, err := id.GetSubject()
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(userID, 10), subject)
+
+	_, err = env.auth.WhoAmI(context.Background(), tokens.IDToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "the ID token is not an access token")
+}
+
+func TestLoginWithIDToken_Disabled(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.LoginWithIDToken(context.Background(), testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrIDTokensDisabled)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/emailhash"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long Register remembers idempotency keys by default.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// WithIdempotencyWindow sets how long RegisterIdempotent remembers a key; a
+// non-positive window keeps DefaultIdempotencyWindow.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(a *Auth) {
+		if window > 0 {
+			a.idempotencyWindow = window
+		}
+	}
+}
+
+// WithEmailHashing makes RegisterIdempotent store the email of a request in
+// its idempotency record as hashed by emails, so that with storage email
+// hashing neither table holds plaintext emails. Nil stores them as is.
+func WithEmailHashing(emails *emailhash.Hasher) Option {
+	return func(a *Auth) {
+		a.emails = emails
+	}
+}
+
+// RegisterIdempotent is Register made safe to retry: the first successful call
+// with a key stores the user ID under it, and retries with the same key and
+// email within the idempotency window return that ID instead of ErrUserExists.
+// Reusing a live key for another email fails with ErrIdempotencyKeyUsed.
+func (a *Auth) RegisterIdempotent(
+	ctx context.Context,
+	idempotencyKey string,
+	email string,
+	password string,
+) (userID int64, err error) {
+	const op = "Auth.RegisterIdempotent"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.String("email", email))
+
+	notBefore := time.Now().Add(-a.idempotencyWindow)
+
+	userID, err = a.idempotentResult(ctx, idempotencyKey, email, notBefore)
+	if err == nil {
+		log.Info("registration retried", slog.Int64("user_id", userID))
+		return userID, nil
+	}
+	if !errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, a.idempotencyError(log, op, err)
+	}
+
+	// Saved with the user, so a failure leaves neither behind and a retry
+	// starts over.
+	userID, err = a.register(ctx, op, email, password, storage.Profile{}, func(tx storage.TxStorage, userID int64) error {
+		record := storage.IdempotencyRecord{Email: a.emails.Hash(email), UserID: userID}
+		return tx.SaveIdempotencyRecord(ctx, idempotencyKey, record, notBefore)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrUserExists) {
+			return 0, err
+		}
+		// A concurrent retry may have registered the user first.
+		if userID, keyErr := a.idempotentResult(ctx, idempotencyKey, email, notBefore); keyErr == nil {
+			log.Info("registration retried concurrently", slog.Int64("user_id", userID))
+			return userID, nil
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// idempotentResult returns the user ID stored under key, or storage.ErrKeyNotFound.
+func (a *Auth) idempotentResult(ctx context.Context, key, email string, notBefore time.Time) (int64, error) {
+	record, err := a.userProvider.IdempotencyRecord(ctx, key, notBefore)
+	if err != nil {
+		return 0, err
+	}
+	if record.Email != a.emails.Hash(email) {
+		return 0, ErrIdempotencyKeyUsed
+	}
+
+	return record.UserID, nil
+}
+
+func (a *Auth) idempotencyError(log *slog.Logger, op string, err error) error {
+	if errors.Is(err, ErrIdempotencyKeyUsed) {
+		log.Warn("idempotency key reused for another email")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if ctxErr := contextError(err); ctxErr != nil {
+		log.Info("registration aborted", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+
+	log.Error("failed to look up idempotency key", slog.String("error", err.Error()))
+	return fmt.Errorf("%s: %w", op, err)
+}
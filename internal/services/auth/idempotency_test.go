@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sso/internal/lib/emailhash"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterIdempotent_RetryReturnsOriginalID(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	userID, err := env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	require.NoError(t, err)
+
+	retriedID, err := env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	require.NoError(t, err)
+	assert.Equal(t, userID, retriedID)
+	assert.Len(t, env.users.users, 1)
+
+	_, err = env.auth.RegisterIdempotent(ctx, "key-2", testEmail, testPassword)
+	assert.ErrorIs(t, err, ErrUserExists, "a new key is a new request")
+}
+
+func TestRegisterIdempotent_DistinctKeysCreateDistinctUsers(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	first, err := env.auth.RegisterIdempotent(ctx, "key-1", "first@example.com", testPassword)
+	require.NoError(t, err)
+	second, err := env.auth.RegisterIdempotent(ctx, "key-2", "second@example.com", testPassword)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.Len(t, env.users.users, 2)
+}
+
+func TestRegisterIdempotent_KeyReusedForAnotherEmail(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	_, err := env.auth.RegisterIdempotent(ctx, "key-1", "first@example.com", testPassword)
+	require.NoError(t, err)
+
+	_, err = env.auth.RegisterIdempotent(ctx, "key-1", "second@example.com", testPassword)
+	assert.ErrorIs(t, err, ErrIdempotencyKeyUsed)
+	assert.Len(t, env.users.users, 1)
+}
+
+func TestRegisterIdempotent_EmailHashing(t *testing.T) {
+	env := newTestEnv(t)
+	emails, err := emailhash.New(strings.Repeat("k", emailhash.MinKeyLength))
+	require.NoError(t, err)
+	WithEmailHashing(emails)(env.auth)
+	ctx := context.Background()
+
+	userID, err := env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	require.NoError(t, err)
+	assert.Equal(t, emails.Hash(testEmail), env.users.idempotencyKeys["key-1"].Email, "the record holds no plaintext email")
+
+	retriedID, err := env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	require.NoError(t, err)
+	assert.Equal(t, userID, retriedID)
+
+	_, err = env.auth.RegisterIdempotent(ctx, "key-1", "second@example.com", testPassword)
+	assert.ErrorIs(t, err, ErrIdempotencyKeyUsed)
+}
+
+func TestRegisterIdempotent_ExpiredKey(t *testing.T) {
+	env := newTestEnv(t)
+	WithIdempotencyWindow(time.Millisecond)(env.auth)
+	ctx := context.Background()
+
+	_, err := env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	assert.ErrorIs(t, err, ErrUserExists, "the key is forgotten after the window")
+
+	userID, err := env.auth.RegisterIdempotent(ctx, "key-1", "other@example.com", testPassword)
+	require.NoError(t, err, "an expired key can be reused")
+	assert.Equal(t, userID, env.users.idempotencyKeys["key-1"].UserID)
+}
+
+func TestRegisterIdempotent_FailedKeySaveLeavesNoUser(t *testing.T) {
+	env := newTestEnv(t)
+	env.users.saveIdempotencyErr = errors.New("disk full")
+	ctx := context.Background()
+
+	_, err := env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	require.Error(t, err)
+	assert.Empty(t, env.users.users, "the user is rolled back with the key")
+
+	env.users.saveIdempotencyErr = nil
+	_, err = env.auth.RegisterIdempotent(ctx, "key-1", testEmail, testPassword)
+	assert.NoError(t, err, "a retry starts over")
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sso/internal/domain/models"
+	"time"
+)
+
+// LoginOptions select the optional results of LoginWithOptions. Leaving them
+// off keeps login responses small.
+type LoginOptions struct {
+	// IDToken asks for an ID token, as LoginWithIDToken issues.
+	IDToken bool
+	// UserInfo asks for LoginTokens.UserInfo.
+	UserInfo bool
+}
+
+// UserInfo is what clients would otherwise decode the access token for. The
+// fields match its uid, email, is_admin and exp claims.
+type UserInfo struct {
+	UserID    int64
+	Email     string
+	IsAdmin   bool
+	ExpiresAt time.Time
+}
+
+// LoginWithOptions is Login returning the optional results opts asks for. It
+// fails with ErrIDTokensDisabled if opts asks for an ID token unless enabled
+// with WithIDTokens.
+func (a *Auth) LoginWithOptions(
+	ctx context.Context,
+	email string,
+	password string,
+	appID int,
+	opts LoginOptions,
+) (tokens LoginTokens, err error) {
+	return a.login(ctx, "Auth.LoginWithOptions", email, password, appID, opts)
+}
+
+// userInfo describes the user an access token was just minted for.
+func (a *Auth) userInfo(user models.User, accessToken string) (*UserInfo, error) {
+	expiresAt, err := a.tokenProvider.TokenExpiry(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token expiry: %w", err)
+	}
+
+	return &UserInfo{
+		UserID:    user.ID,
+		Email:     user.Email,
+		IsAdmin:   user.IsAdmin,
+		ExpiresAt: expiresAt,
+	}, nil
+}
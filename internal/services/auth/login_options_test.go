@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/jwt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginWithOptions_UserInfo(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	env.users.admins[userID] = true
+
+	tokens, err := env.auth.LoginWithOptions(context.Background(), testEmail, testPassword, testAppID, LoginOptions{UserInfo: true})
+	require.NoError(t, err)
+	require.NotNil(t, tokens.UserInfo)
+	assert.Empty(t, tokens.IDToken, "an ID token is only issued on request")
+
+	claims, err := jwt.Verify(tokens.AccessToken, env.apps.apps[testAppID].PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, claims.UserID, tokens.UserInfo.UserID)
+	assert.Equal(t, claims.Email, tokens.UserInfo.Email)
+	assert.Equal(t, claims.IsAdmin, tokens.UserInfo.IsAdmin)
+	assert.True(t, claims.ExpiresAt.Equal(tokens.UserInfo.ExpiresAt))
+
+	tokens, err = env.auth.LoginWithOptions(context.Background(), testEmail, testPassword, testAppID, LoginOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.Nil(t, tokens.UserInfo, "user info is only returned on request")
+}
+
+func TestLoginWithOptions_IDTokensDisabled(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.LoginWithOptions(context.Background(), testEmail, testPassword, testAppID, LoginOptions{IDToken: true, UserInfo: true})
+	assert.ErrorIs(t, err, ErrIDTokensDisabled)
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/hash"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+	"time"
+)
+
+// WithMinPasswordAge makes ChangePassword refuse users whose password was reset
+// or changed less than age ago, so that they can't cycle through passwords to
+// get an old one out of the history; zero disables it. ResetPassword is exempt:
+// it is how users who forgot their password get back in.
+func WithMinPasswordAge(age time.Duration) Option {
+	return func(a *Auth) {
+		a.minPasswordAge = max(age, 0)
+	}
+}
+
+// ChangePassword replaces the password of the user with userID, who must know
+// the current one: a wrong oldPassword fails with ErrInvalidCredentials. Within
+// the minimum password age it fails with ErrPasswordTooRecent, see
+// WithMinPasswordAge, and a password in the history with ErrPasswordReused,
+// see WithPasswordHistory. A successful change revokes all the user's sessions,
+// so tokens issued before it stop verifying.
+func (a *Auth) ChangePassword(
+	ctx context.Context,
+	userID int64,
+	oldPassword string,
+	newPassword string,
+) error {
+	const op = "Auth.ChangePassword"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		return lookupError(log, op, err)
+	}
+
+	if err = a.hasher.ComparePassword(oldPassword, user.PasswordSalt, user.PasswordHash, user.PepperVersion); err != nil {
+		if errors.Is(err, hash.ErrBusy) {
+			log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrBusy)
+		}
+		if errors.Is(err, hash.ErrPasswordMismatch) {
+			log.Info("invalid credentials", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		// A corrupt or unsupported stored hash, not a wrong password.
+		log.Error("failed to compare password", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Checked only after the password, so that the age is not revealed to
+	// anyone else.
+	if a.minPasswordAge > 0 && !user.PasswordChangedAt.IsZero() && time.Since(user.PasswordChangedAt) < a.minPasswordAge {
+		log.Info("password changed too recently", slog.Time("changed_at", user.PasswordChangedAt))
+		return fmt.Errorf("%s: %w", op, ErrPasswordTooRecent)
+	}
+
+	if a.passwordHistory > 0 {
+		if err = a.checkPasswordHistory(ctx, log, op, user.ID, newPassword); err != nil {
+			return err
+		}
+	}
+
+	passData, err := a.hasher.HashPassword(newPassword)
+	if err != nil {
+		if errors.Is(err, hash.ErrBusy) {
+			log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrBusy)
+		}
+		log.Error("failed to hash password", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Fails with ErrUserNotFound if the password changed since it was checked.
+	// Sessions are revoked in the same transaction, like on a reset.
+	err = a.userProvider.WithTx(ctx, func(tx storage.TxStorage) error {
+		err := tx.ChangePassword(ctx, user.ID, user.PasswordHash, passData.Hash, passData.Salt, passData.PepperVersion, a.historyToKeep())
+		if err != nil {
+			return err
+		}
+		return revokeSessionsTx(ctx, tx, user.ID)
+	})
+	if err != nil {
+		return a.userUpdateError(log, op, err)
+	}
+
+	log.Info("password changed")
+
+	return nil
+}
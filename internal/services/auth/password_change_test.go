@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/hash"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangePassword(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	require.NoError(t, env.auth.ChangePassword(ctx, userID, testPassword, newPassword))
+
+	_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "the old password no longer works")
+	_, err = env.auth.Login(ctx, testEmail, newPassword, testAppID)
+	assert.NoError(t, err)
+}
+
+func TestChangePassword_WrongPassword(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	err := env.auth.ChangePassword(ctx, userID, "wrong-password", newPassword)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	err = env.auth.ChangePassword(ctx, 42, testPassword, newPassword)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestChangePassword_RevokesSessions(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	stolen, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	require.NoError(t, env.auth.ChangePassword(ctx, userID, testPassword, newPassword))
+
+	_, err = env.auth.WhoAmI(ctx, stolen)
+	assert.ErrorIs(t, err, ErrInvalidToken, "tokens issued before the change stop verifying")
+	sessions, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestChangePassword_CorruptStoredHash(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	user := env.users.users[testEmail]
+	user.PasswordHash = []byte("$argon2id$not-a-hash")
+	env.users.users[testEmail] = user
+
+	err := env.auth.ChangePassword(context.Background(), userID, testPassword, newPassword)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidCredentials, "a corrupt hash is not a wrong password")
+	assert.ErrorIs(t, err, hash.ErrMalformedHash)
+}
+
+func TestChangePassword_MinAge(t *testing.T) {
+	env := newTestEnv(t)
+	WithMinPasswordAge(time.Hour)(env.auth)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	require.NoError(t, env.auth.ChangePassword(ctx, userID, testPassword, newPassword), "the registration password has no age")
+
+	err := env.auth.ChangePassword(ctx, userID, newPassword, "third-password")
+	assert.ErrorIs(t, err, ErrPasswordTooRecent)
+
+	user, ok := env.users.userByID(userID)
+	require.True(t, ok)
+	user.PasswordChangedAt = time.Now().Add(-2 * time.Hour)
+	env.users.users[mockUserKey(user.Email, user.AppID)] = user
+
+	assert.NoError(t, env.auth.ChangePassword(ctx, userID, newPassword, "third-password"))
+}
+
+func TestChangePassword_MinAgeExemptsReset(t *testing.T) {
+	env := newTestEnv(t)
+	WithMinPasswordAge(time.Hour)(env.auth)
+	userID := env.registerUser(t, testEmail, testPassword)
+
+	require.NoError(t, env.auth.ChangePassword(context.Background(), userID, testPassword, newPassword))
+	assert.NoError(t, env.resetTo(t, "third-password"))
+}
+
+func TestChangePassword_PasswordReused(t *testing.T) {
+	env := newTestEnv(t)
+	WithPasswordHistory(2)(env.auth)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	require.NoError(t, env.auth.ChangePassword(ctx, userID, testPassword, newPassword))
+
+	err := env.auth.ChangePassword(ctx, userID, newPassword, testPassword)
+	assert.ErrorIs(t, err, ErrPasswordReused)
+}
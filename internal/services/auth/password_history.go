@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/hash"
+	"sso/internal/storage"
+)
+
+// WithPasswordHistory makes ResetPassword refuse the user's last n passwords,
+// the current one included; n <= 0 allows any. Every remembered password costs
+// one Argon2 verification per reset.
+func WithPasswordHistory(n int) Option {
+	return func(a *Auth) {
+		a.passwordHistory = max(n, 0)
+	}
+}
+
+// historyToKeep is how many replaced passwords storage keeps per user: the
+// history holds the current password too.
+func (a *Auth) historyToKeep() int {
+	return max(a.passwordHistory-1, 0)
+}
+
+// checkPasswordHistory fails with ErrPasswordReused if password is among the
+// recent passwords of the user.
+func (a *Auth) checkPasswordHistory(ctx context.Context, log *slog.Logger, op string, userID int64, password string) error {
+	history, err := a.userProvider.PasswordHistory(ctx, userID, a.passwordHistory)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		return lookupError(log, op, err)
+	}
+
+	for _, old := range history {
+		err := a.hasher.ComparePassword(password, old.Salt, old.Hash, old.PepperVersion)
+		if err == nil {
+			log.Info("password reused", slog.Int64("user_id", userID))
+			return fmt.Errorf("%s: %w", op, ErrPasswordReused)
+		}
+		if errors.Is(err, hash.ErrBusy) {
+			log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrBusy)
+		}
+		// Any other error, e.g. a retired pepper, means the password can't be
+		// shown to match, which is all the history check needs.
+	}
+
+	return nil
+}
+
+// lookupError logs and maps a storage error of a read done on the way to
+// replacing a password.
+func lookupError(log *slog.Logger, op string, err error) error {
+	if ctxErr := contextError(err); ctxErr != nil {
+		log.Info("password update aborted", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+
+	log.Error("failed to read password data", slog.String("error", err.Error()))
+	return fmt.Errorf("%s: %w", op, err)
+}
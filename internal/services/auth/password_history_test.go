@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetTo resets the password of testEmail to password with a fresh token.
+func (e *testEnv) resetTo(t *testing.T, password string) error {
+	t.Helper()
+
+	token, err := e.auth.RequestPasswordReset(context.Background(), testEmail, testAppID)
+	require.NoError(t, err)
+
+	return e.auth.ResetPassword(context.Background(), token, password)
+}
+
+func TestResetPassword_PasswordReused(t *testing.T) {
+	env := newTestEnv(t)
+	WithPasswordHistory(3)(env.auth)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.RequestPasswordReset(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+
+	err = env.auth.ResetPassword(ctx, token, testPassword)
+	assert.ErrorIs(t, err, ErrPasswordReused, "the current password is part of the history")
+
+	require.NoError(t, env.auth.ResetPassword(ctx, token, "second-password"), "a refused reset leaves the token usable")
+	assert.ErrorIs(t, env.resetTo(t, testPassword), ErrPasswordReused)
+	assert.ErrorIs(t, env.resetTo(t, "second-password"), ErrPasswordReused)
+
+	_, err = env.auth.Login(ctx, testEmail, "second-password", testAppID)
+	assert.NoError(t, err)
+}
+
+func TestResetPassword_OldPasswordAllowed(t *testing.T) {
+	env := newTestEnv(t)
+	WithPasswordHistory(3)(env.auth)
+	env.registerUser(t, testEmail, testPassword)
+
+	for _, password := range []string{"second-password", "third-password"} {
+		require.NoError(t, env.resetTo(t, password))
+	}
+	assert.ErrorIs(t, env.resetTo(t, testPassword), ErrPasswordReused)
+
+	require.NoError(t, env.resetTo(t, "fourth-password"))
+	assert.NoError(t, env.resetTo(t, testPassword), "passwords older than the history are allowed")
+	assert.Len(t, env.users.passwordHistory[1], 2, "the history is trimmed")
+}
+
+func TestResetPassword_NoHistory(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	assert.NoError(t, env.resetTo(t, testPassword))
+	assert.Empty(t, env.users.passwordHistory[1])
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/hash"
+	"sso/internal/lib/logger"
+	"sso/internal/lib/onetime"
+	"sso/internal/storage"
+	"time"
+)
+
+// DefaultPasswordResetTTL is how long a password reset token stays valid by default.
+const DefaultPasswordResetTTL = time.Hour
+
+// WithPasswordResetTTL sets the lifetime of password reset tokens; a non-positive
+// ttl keeps DefaultPasswordResetTTL.
+func WithPasswordResetTTL(ttl time.Duration) Option {
+	return func(a *Auth) {
+		if ttl > 0 {
+			a.passwordResetTTL = ttl
+		}
+	}
+}
+
+// RequestPasswordReset issues a single-use password reset token for the user with
+// the given email in the app with appID, found as by Login, replacing any token
+// issued before. Only its hash is stored.
+//
+// To not reveal which emails are registered, an unknown email is not an error:
+// the token is then empty and there is nothing to deliver. Callers must report
+// success either way and deliver the token only to the email address.
+func (a *Auth) RequestPasswordReset(
+	ctx context.Context,
+	email string,
+	appID int,
+) (token string, err error) {
+	const op = "Auth.RequestPasswordReset"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.String("email", email))
+
+	user, err := a.userProvider.User(ctx, email, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("password reset requested for unknown email")
+			return "", nil
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("password reset request aborted", slog.String("error", err.Error()))
+			return "", fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	token, tokenHash, err := onetime.New()
+	if err != nil {
+		log.Error("failed to generate reset token", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	expiresAt := time.Now().Add(a.passwordResetTTL)
+	if err = a.userProvider.SavePasswordResetToken(ctx, user.ID, tokenHash, expiresAt); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// Deleted since the lookup; treated like an unknown email.
+			log.Info("user deleted during password reset request")
+			return "", nil
+		}
+		return "", a.userUpdateError(log, op, err)
+	}
+
+	log.Info("password reset requested", slog.Time("expires_at", expiresAt))
+
+	return token, nil
+}
+
+// ResetPassword consumes a token issued by RequestPasswordReset and replaces the
+// password of its user. Unknown and already used tokens fail with ErrInvalidToken,
+// expired ones with ErrTokenExpired. With WithPasswordHistory, a password used
+// recently fails with ErrPasswordReused and leaves the token usable. A
+// successful reset leaves the user with no outstanding reset tokens and revokes
+// all their sessions, so tokens issued before it stop verifying.
+func (a *Auth) ResetPassword(
+	ctx context.Context,
+	token string,
+	newPassword string,
+) error {
+	const op = "Auth.ResetPassword"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op))
+
+	if a.passwordHistory > 0 {
+		// Checked before the token is consumed, so a refused password can be
+		// retried with another one.
+		userID, err := a.userProvider.PasswordResetUser(ctx, onetime.Hash(token))
+		if err != nil {
+			if tokenErr := resetTokenError(log, err); tokenErr != nil {
+				return fmt.Errorf("%s: %w", op, tokenErr)
+			}
+			return lookupError(log, op, err)
+		}
+		if err := a.checkPasswordHistory(ctx, log, op, userID, newPassword); err != nil {
+			return err
+		}
+	}
+
+	// Hash first, so the token is consumed and the password replaced atomically.
+	passData, err := a.hasher.HashPassword(newPassword)
+	if err != nil {
+		if errors.Is(err, hash.ErrBusy) {
+			log.Warn("password hashing is saturated", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrBusy)
+		}
+		log.Error("failed to hash password", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sessions are revoked in the same transaction, so a reset never leaves a
+	// stolen token working. An expired token's deletion is rolled back with it
+	// and left to the janitor.
+	var userID int64
+	err = a.userProvider.WithTx(ctx, func(tx storage.TxStorage) error {
+		var err error
+		userID, err = tx.ResetPassword(ctx, onetime.Hash(token), passData.Hash, passData.Salt, passData.PepperVersion, a.historyToKeep())
+		if err != nil {
+			return err
+		}
+		return revokeSessionsTx(ctx, tx, userID)
+	})
+	if err != nil {
+		if tokenErr := resetTokenError(log, err); tokenErr != nil {
+			return fmt.Errorf("%s: %w", op, tokenErr)
+		}
+		return a.userUpdateError(log, op, err)
+	}
+
+	log.Info("password reset", slog.Int64("user_id", userID))
+
+	return nil
+}
+
+// resetTokenError logs and maps the storage errors of an unusable reset token;
+// it returns nil for other errors.
+func resetTokenError(log *slog.Logger, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrTokenNotFound):
+		log.Info("unknown reset token", slog.String("error", err.Error()))
+		return ErrInvalidToken
+	case errors.Is(err, storage.ErrTokenExpired):
+		log.Info("reset token expired", slog.String("error", err.Error()))
+		return ErrTokenExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const newPassword = "new-password"
+
+func TestResetPassword(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.RequestPasswordReset(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.NotContains(t, env.users.resetTokens, token, "only the hash is stored")
+
+	require.NoError(t, env.auth.ResetPassword(ctx, token, newPassword))
+
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "the old password no longer works")
+	_, err = env.auth.Login(ctx, testEmail, newPassword, testAppID)
+	assert.NoError(t, err)
+	assert.Empty(t, env.users.resetTokens)
+}
+
+func TestResetPassword_RevokesSessions(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	stolen, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	token, err := env.auth.RequestPasswordReset(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+	require.NoError(t, env.auth.ResetPassword(ctx, token, newPassword))
+
+	_, err = env.auth.WhoAmI(ctx, stolen)
+	assert.ErrorIs(t, err, ErrInvalidToken, "tokens issued before the reset stop verifying")
+	sessions, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	fresh, err := env.auth.Login(ctx, testEmail, newPassword, testAppID)
+	require.NoError(t, err)
+	_, err = env.auth.WhoAmI(ctx, fresh)
+	assert.NoError(t, err)
+}
+
+func TestResetPassword_TokenReused(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.RequestPasswordReset(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+	require.NoError(t, env.auth.ResetPassword(ctx, token, newPassword))
+
+	err = env.auth.ResetPassword(ctx, token, "another-password")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = env.auth.Login(ctx, testEmail, newPassword, testAppID)
+	assert.NoError(t, err)
+}
+
+func TestResetPassword_Expired(t *testing.T) {
+	env := newTestEnv(t)
+	WithPasswordResetTTL(time.Millisecond)(env.auth)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.RequestPasswordReset(ctx, testEmail, testAppID)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	err = env.auth.ResetPassword(ctx, token, newPassword)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.NoError(t, err, "the password is unchanged")
+}
+
+func TestRequestPasswordReset_UnknownEmail(t *testing.T) {
+	env := newTestEnv(t)
+
+	token, err := env.auth.RequestPasswordReset(context.Background(), "nobody@example.com", testAppID)
+	require.NoError(t, err, "unknown emails are not revealed")
+	assert.Empty(t, token)
+	assert.Empty(t, env.users.resetTokens)
+}
+
+func TestResetPassword_UnknownToken(t *testing.T) {
+	env := newTestEnv(t)
+
+	err := env.auth.ResetPassword(context.Background(), "not-a-token", newPassword)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+	"unicode/utf8"
+)
+
+const (
+	// MaxDisplayNameLength is the longest display name accepted, in characters.
+	MaxDisplayNameLength = 100
+	// MaxMetadataSize is the largest metadata object accepted, in bytes.
+	MaxMetadataSize = 4 << 10
+)
+
+// RegisterWithProfile creates a new user account like Register and stores the
+// optional display name and metadata with it. Metadata must be a JSON object of
+// at most MaxMetadataSize bytes; invalid profiles fail with ErrInvalidProfile.
+func (a *Auth) RegisterWithProfile(
+	ctx context.Context,
+	email string,
+	password string,
+	profile storage.Profile,
+) (userID int64, err error) {
+	const op = "Auth.RegisterWithProfile"
+	ctx = logger.WithOp(ctx, op)
+
+	if err = validateProfile(profile); err != nil {
+		a.log.Info("invalid profile", slog.String("op", op), slog.String("error", err.Error()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return a.register(ctx, op, email, password, profile, nil)
+}
+
+func validateProfile(profile storage.Profile) error {
+	if !utf8.ValidString(profile.DisplayName) {
+		return fmt.Errorf("%w: display name is not valid UTF-8", ErrInvalidProfile)
+	}
+	if n := utf8.RuneCountInString(profile.DisplayName); n > MaxDisplayNameLength {
+		return fmt.Errorf("%w: display name is %d characters, at most %d allowed", ErrInvalidProfile, n, MaxDisplayNameLength)
+	}
+
+	if len(profile.Metadata) == 0 {
+		return nil
+	}
+	if len(profile.Metadata) > MaxMetadataSize {
+		return fmt.Errorf("%w: metadata is %d bytes, at most %d allowed", ErrInvalidProfile, len(profile.Metadata), MaxMetadataSize)
+	}
+	if !json.Valid(profile.Metadata) || !bytes.HasPrefix(bytes.TrimSpace(profile.Metadata), []byte("{")) {
+		return fmt.Errorf("%w: metadata must be a JSON object", ErrInvalidProfile)
+	}
+
+	return nil
+}
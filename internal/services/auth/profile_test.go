@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"sso/internal/storage"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterWithProfile(t *testing.T) {
+	env := newTestEnv(t)
+	profile := storage.Profile{
+		DisplayName: "Ada Lovelace",
+		Metadata:    json.RawMessage(`{"plan":"pro","referrer":"newsletter"}`),
+	}
+
+	userID, err := env.auth.RegisterWithProfile(context.Background(), testEmail, testPassword, profile)
+	require.NoError(t, err)
+
+	user := env.users.users[testEmail]
+	assert.Equal(t, userID, user.ID)
+	assert.Equal(t, "Ada Lovelace", user.DisplayName)
+	assert.JSONEq(t, `{"plan":"pro","referrer":"newsletter"}`, string(user.Metadata))
+
+	_, err = env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	assert.NoError(t, err)
+}
+
+func TestRegister_WithoutProfile(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.auth.Register(context.Background(), testEmail, testPassword)
+	require.NoError(t, err)
+
+	user := env.users.users[testEmail]
+	assert.Empty(t, user.DisplayName)
+	assert.Nil(t, user.Metadata)
+}
+
+func TestRegisterWithProfile_Invalid(t *testing.T) {
+	for name, profile := range map[string]storage.Profile{
+		"oversized metadata":  {Metadata: json.RawMessage(`{"blob":"` + strings.Repeat("x", MaxMetadataSize) + `"}`)},
+		"metadata not json":   {Metadata: json.RawMessage(`{plan: pro}`)},
+		"metadata not object": {Metadata: json.RawMessage(`["pro"]`)},
+		"long display name":   {DisplayName: strings.Repeat("é", MaxDisplayNameLength+1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := newTestEnv(t)
+
+			_, err := env.auth.RegisterWithProfile(context.Background(), testEmail, testPassword, profile)
+			assert.ErrorIs(t, err, ErrInvalidProfile)
+			assert.Empty(t, env.users.users, "nothing is saved")
+		})
+	}
+
+	env := newTestEnv(t)
+	_, err := env.auth.RegisterWithProfile(context.Background(), testEmail, testPassword,
+		storage.Profile{DisplayName: strings.Repeat("é", MaxDisplayNameLength)})
+	assert.NoError(t, err, "the limit counts characters, not bytes")
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"time"
+)
+
+// WithRememberMeTTL sets the token lifetime of logins made with remember-me.
+// A non-positive ttl, or one shorter than an app's own token TTL, gives them
+// the normal lifetime.
+func WithRememberMeTTL(ttl time.Duration) Option {
+	return func(a *Auth) {
+		if ttl > 0 {
+			a.rememberMeTTL = ttl
+		}
+	}
+}
+
+type rememberMeKey struct{}
+
+// WithRememberMe returns a context asking Login for a long-lived token, as
+// configured with WithRememberMeTTL.
+func WithRememberMe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rememberMeKey{}, true)
+}
+
+func rememberMe(ctx context.Context) bool {
+	remember, _ := ctx.Value(rememberMeKey{}).(bool)
+
+	return remember
+}
+
+// loginTokenTTL returns the lifetime of a token issued by Login for app.
+func (a *Auth) loginTokenTTL(ctx context.Context, app models.App) time.Duration {
+	ttl := a.appTokenTTL(app)
+	if rememberMe(ctx) && a.rememberMeTTL > ttl {
+		return a.rememberMeTTL
+	}
+
+	return ttl
+}
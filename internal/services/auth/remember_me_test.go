@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogin_RememberMe(t *testing.T) {
+	const rememberMeTTL = 30 * 24 * time.Hour
+
+	for name, tc := range map[string]struct {
+		ctx     context.Context
+		appTTL  time.Duration
+		wantTTL time.Duration
+	}{
+		"default":                  {ctx: context.Background(), wantTTL: defaultTTL},
+		"remember me":              {ctx: WithRememberMe(context.Background()), wantTTL: rememberMeTTL},
+		"app ttl":                  {ctx: context.Background(), appTTL: 2 * time.Hour, wantTTL: 2 * time.Hour},
+		"remember me with app ttl": {ctx: WithRememberMe(context.Background()), appTTL: 2 * time.Hour, wantTTL: rememberMeTTL},
+		// Remember-me never shortens a token.
+		"app ttl above remember me": {ctx: WithRememberMe(context.Background()), appTTL: 90 * 24 * time.Hour, wantTTL: 90 * 24 * time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := newTestEnv(t)
+			WithRememberMeTTL(rememberMeTTL)(env.auth)
+			userID := env.registerUser(t, testEmail, testPassword)
+			env.apps.apps[testAppID] = models.App{ID: testAppID, Name: "test", TokenTTL: tc.appTTL}
+
+			_, err := env.auth.Login(tc.ctx, testEmail, testPassword, testAppID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantTTL, env.tokens.lastDuration)
+
+			sessions, err := env.auth.ListSessions(context.Background(), userID)
+			require.NoError(t, err)
+			require.Len(t, sessions, 1)
+			assert.WithinDuration(t, sessions[0].CreatedAt.Add(tc.wantTTL), sessions[0].ExpiresAt, time.Second)
+		})
+	}
+}
+
+func TestLogin_RememberMeWithoutTTL(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(WithRememberMe(context.Background()), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	assert.Equal(t, defaultTTL, env.tokens.lastDuration)
+}
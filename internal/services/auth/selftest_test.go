@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/keygen"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminToken(t *testing.T, env *testEnv) string {
+	t.Helper()
+
+	adminID := env.registerUser(t, "admin@example.com", testPassword)
+	env.users.admins[adminID] = true
+	token, err := env.auth.Login(context.Background(), "admin@example.com", testPassword, testAppID)
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestSelfTest_ValidKeys(t *testing.T) {
+	env := newJWTEnv(t)
+	token := adminToken(t, env)
+
+	result, err := env.auth.SelfTest(context.Background(), token, testAppID)
+	require.NoError(t, err)
+
+	assert.True(t, result.Passed())
+	assert.Equal(t, SelfTestResult{AppID: testAppID, KeyParsed: true, Signed: true, Verified: true}, result)
+}
+
+func TestSelfTest_MismatchedKeyPair(t *testing.T) {
+	env := newJWTEnv(t)
+	token := adminToken(t, env)
+
+	other, err := keygen.GenerateRSAKeyPair(keygen.MinRSAKeyBits)
+	require.NoError(t, err)
+	const appID = testAppID + 1
+	app := env.apps.apps[testAppID]
+	app.ID = appID
+	// The signing key only matches the previous public key, as after a
+	// rotation that updated the public key but not the private one.
+	app.PreviousPublicKey = app.PublicKey
+	app.PublicKey = other.PublicKey
+	env.apps.apps[appID] = app
+
+	result, err := env.auth.SelfTest(context.Background(), token, appID)
+	require.NoError(t, err, "a failing step is reported in the result")
+
+	assert.False(t, result.Passed())
+	assert.True(t, result.KeyParsed)
+	assert.True(t, result.Signed)
+	assert.False(t, result.Verified)
+	assert.Contains(t, result.Error, "verify token")
+}
+
+func TestSelfTest_MalformedPublicKey(t *testing.T) {
+	env := newJWTEnv(t)
+	token := adminToken(t, env)
+	app := env.apps.apps[testAppID]
+	app.ID = testAppID + 1
+	app.PublicKey = "not a key"
+	env.apps.apps[app.ID] = app
+
+	result, err := env.auth.SelfTest(context.Background(), token, app.ID)
+	require.NoError(t, err)
+
+	assert.False(t, result.KeyParsed)
+	assert.False(t, result.Signed, "later steps are skipped")
+	assert.Contains(t, result.Error, "parse public key")
+}
+
+func TestSelfTest_Denied(t *testing.T) {
+	env := newJWTEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	token, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	_, err = env.auth.SelfTest(context.Background(), token, testAppID)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	_, err = env.auth.SelfTest(context.Background(), adminToken(t, env), 9999)
+	assert.ErrorIs(t, err, ErrAppNotFound)
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger"
+	"sso/internal/lib/onetime"
+	"sso/internal/storage"
+)
+
+// ClientInfo describes the client a call comes from, as far as the transport
+// knows it. Fields it doesn't know are empty.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	// Fingerprint identifies the client itself, e.g. by its TLS certificate or
+	// a device ID it sends, for binding tokens to it; see models.App.BindTokens.
+	Fingerprint string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo returns a context carrying info, which Login records in the
+// session it starts and binds tokens to, and WhoAmI checks bound tokens with.
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+func clientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+
+	return info
+}
+
+// SessionLimitPolicy is what Login does when the user already has the maximum
+// number of active sessions, see WithMaxSessions.
+type SessionLimitPolicy string
+
+const (
+	// SessionLimitEvictOldest revokes the user's oldest sessions to make room.
+	SessionLimitEvictOldest SessionLimitPolicy = "evict_oldest"
+	// SessionLimitReject fails the login with ErrTooManySessions.
+	SessionLimitReject SessionLimitPolicy = "reject"
+)
+
+// WithMaxSessions caps the active sessions of a user, across apps, at max. A
+// login over the cap evicts the oldest sessions or is rejected, as policy says.
+// A max of 0 or less leaves sessions unlimited.
+func WithMaxSessions(max int, policy SessionLimitPolicy) Option {
+	return func(a *Auth) {
+		a.maxSessions = max
+		a.sessionLimitPolicy = policy
+	}
+}
+
+// startSession records the login that token was issued for. Only the token's
+// hash is stored, and the session expires with the token, whose lifetime the
+// token provider may have clamped. With a session cap, the user's active
+// sessions are checked and the oldest evicted in the same transaction, so
+// concurrent logins can't overshoot it; the IDs of evicted sessions are returned.
+func (a *Auth) startSession(ctx context.Context, user models.User, app models.App, token string) (sessionID int64, evicted []int64, err error) {
+	expiresAt, err := a.tokenProvider.TokenExpiry(token)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	info := clientInfoFromContext(ctx)
+	session := models.Session{
+		UserID:    user.ID,
+		AppID:     app.ID,
+		ClientIP:  info.IP,
+		UserAgent: info.UserAgent,
+		ExpiresAt: expiresAt,
+	}
+
+	if a.maxSessions <= 0 {
+		sessionID, err = a.userProvider.SaveSession(ctx, session, onetime.Hash(token))
+		return sessionID, nil, err
+	}
+
+	err = a.userProvider.WithTx(ctx, func(tx storage.TxStorage) error {
+		evicted = nil
+
+		active, err := tx.ListSessions(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if over := len(active) - a.maxSessions + 1; over > 0 {
+			if a.sessionLimitPolicy == SessionLimitReject {
+				return ErrTooManySessions
+			}
+			// Active sessions are listed oldest first.
+			for _, s := range active[:over] {
+				if err := tx.RevokeSession(ctx, s.ID); err != nil {
+					return err
+				}
+				evicted = append(evicted, s.ID)
+			}
+		}
+
+		sessionID, err = tx.SaveSession(ctx, session, onetime.Hash(token))
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return sessionID, evicted, nil
+}
+
+// revokeSessions revokes all active sessions of the user in one transaction.
+func (a *Auth) revokeSessions(ctx context.Context, userID int64) error {
+	return a.userProvider.WithTx(ctx, func(tx storage.TxStorage) error {
+		return revokeSessionsTx(ctx, tx, userID)
+	})
+}
+
+// revokeSessionsTx revokes all active sessions of the user within tx.
+func revokeSessionsTx(ctx context.Context, tx storage.TxStorage, userID int64) error {
+	sessions, err := tx.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if err := tx.RevokeSession(ctx, s.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSession records a use of a verified token and fails with ErrInvalidToken
+// if its session was revoked. Tokens minted before sessions were recorded have
+// none and are accepted.
+func (a *Auth) checkSession(ctx context.Context, log *slog.Logger, op string, token string) error {
+	err := a.userProvider.TouchSession(ctx, onetime.Hash(token))
+	switch {
+	case err == nil, errors.Is(err, storage.ErrSessionNotFound):
+		return nil
+	case errors.Is(err, storage.ErrSessionRevoked):
+		log.Info("token of a revoked session")
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if ctxErr := contextError(err); ctxErr != nil {
+		log.Info("token verification aborted", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+
+	log.Error("failed to update session", slog.String("error", err.Error()))
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// ListSessions returns the user's active sessions, oldest first. They carry no
+// token, so listing them hands out nothing a caller could log in with.
+func (a *Auth) ListSessions(ctx context.Context, userID int64) (sessions []models.Session, err error) {
+	const op = "Auth.ListSessions"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	sessions, err = a.userProvider.ListSessions(ctx, userID)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("listing sessions aborted", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to list sessions", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions: WhoAmI rejects its token from
+// then on. Apps verifying tokens themselves keep accepting it until it expires.
+// Sessions that are not the user's, or no longer active, fail with
+// ErrSessionNotFound.
+func (a *Auth) RevokeSession(ctx context.Context, userID int64, sessionID int64) error {
+	const op = "Auth.RevokeSession"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID), slog.Int64("session_id", sessionID))
+
+	// Sessions never change owner, so checking first leaves no window to
+	// revoke another user's session.
+	sessions, err := a.userProvider.ListSessions(ctx, userID)
+	if err == nil {
+		if !slices.ContainsFunc(sessions, func(s models.Session) bool { return s.ID == sessionID }) {
+			log.Warn("session not found")
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		err = a.userProvider.RevokeSession(ctx, sessionID)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Warn("session not found", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		if ctxErr := contextError(err); ctxErr != nil {
+			log.Info("revoking session aborted", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to revoke session", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session revoked")
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/lib/jwt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListSessions(t *testing.T) {
+	env := newTestEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	env.registerUser(t, "other@example.com", testPassword)
+
+	ctx := WithClientInfo(context.Background(), ClientInfo{IP: "192.0.2.1", UserAgent: "app/1.0"})
+	for range 2 {
+		_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+		require.NoError(t, err)
+	}
+	_, err := env.auth.Login(context.Background(), "other@example.com", testPassword, testAppID)
+	require.NoError(t, err)
+
+	sessions, err := env.auth.ListSessions(context.Background(), userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	for _, s := range sessions {
+		assert.Equal(t, userID, s.UserID)
+		assert.Equal(t, testAppID, s.AppID)
+		assert.Equal(t, "192.0.2.1", s.ClientIP)
+		assert.Equal(t, "app/1.0", s.UserAgent)
+		assert.WithinDuration(t, s.CreatedAt.Add(defaultTTL), s.ExpiresAt, time.Second)
+	}
+}
+
+func TestLogin_SessionExpiresWithClampedToken(t *testing.T) {
+	env := newJWTEnv(t)
+	env.auth.tokenProvider = jwt.New(env.auth.log, jwt.WithMaxTTL(time.Hour))
+	app := env.apps.apps[testAppID]
+	app.TokenTTL = 24 * time.Hour
+	env.apps.apps[testAppID] = app
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	token, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	exp, err := env.auth.tokenProvider.TokenExpiry(token)
+	require.NoError(t, err)
+
+	sessions, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.True(t, exp.Equal(sessions[0].ExpiresAt), "session expires at %v, its token at %v", sessions[0].ExpiresAt, exp)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), sessions[0].ExpiresAt, time.Minute, "the app TTL is clamped to the max TTL")
+}
+
+func TestRevokeSession(t *testing.T) {
+	env := newJWTEnv(t)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	revoked, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+	kept, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	sessions, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	require.NoError(t, env.auth.RevokeSession(ctx, userID, sessions[0].ID))
+
+	_, err = env.auth.WhoAmI(ctx, revoked)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = env.auth.WhoAmI(ctx, kept)
+	assert.NoError(t, err, "other sessions are unaffected")
+
+	remaining, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, sessions[1].ID, remaining[0].ID)
+
+	assert.ErrorIs(t, env.auth.RevokeSession(ctx, userID, sessions[0].ID), ErrSessionNotFound, "already revoked")
+}
+
+func TestRevokeSession_OtherUsersSession(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	otherID := env.registerUser(t, "other@example.com", testPassword)
+	ctx := context.Background()
+
+	_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, env.auth.RevokeSession(ctx, otherID, 1), ErrSessionNotFound)
+	assert.False(t, env.users.sessions[0].revoked)
+}
+
+func TestLogin_MaxSessionsEvictsOldest(t *testing.T) {
+	env := newJWTEnv(t)
+	WithMaxSessions(2, SessionLimitEvictOldest)(env.auth)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	var tokens []LoginTokens
+	for range 3 {
+		login, err := env.auth.LoginWithOptions(ctx, testEmail, testPassword, testAppID, LoginOptions{})
+		require.NoError(t, err)
+		tokens = append(tokens, login)
+	}
+	assert.Empty(t, tokens[0].EvictedSessions)
+	assert.Empty(t, tokens[1].EvictedSessions, "the cap is not reached yet")
+	require.Len(t, tokens[2].EvictedSessions, 1)
+
+	sessions, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.NotContains(t, []int64{sessions[0].ID, sessions[1].ID}, tokens[2].EvictedSessions[0])
+
+	_, err = env.auth.WhoAmI(ctx, tokens[0].AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "the oldest session is revoked")
+	for _, kept := range tokens[1:] {
+		_, err = env.auth.WhoAmI(ctx, kept.AccessToken)
+		assert.NoError(t, err)
+	}
+}
+
+func TestLogin_MaxSessionsReject(t *testing.T) {
+	env := newJWTEnv(t)
+	WithMaxSessions(2, SessionLimitReject)(env.auth)
+	userID := env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+		require.NoError(t, err)
+	}
+	_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.ErrorIs(t, err, ErrTooManySessions)
+
+	sessions, err := env.auth.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2, "the rejected login starts no session and revokes none")
+
+	require.NoError(t, env.auth.RevokeSession(ctx, userID, sessions[0].ID))
+	_, err = env.auth.Login(ctx, testEmail, testPassword, testAppID)
+	assert.NoError(t, err, "revoking a session makes room")
+}
+
+func TestLogin_MaxSessionsIgnoresExpiredTokens(t *testing.T) {
+	for _, policy := range []SessionLimitPolicy{SessionLimitReject, SessionLimitEvictOldest} {
+		t.Run(string(policy), func(t *testing.T) {
+			env := newJWTEnv(t)
+			WithMaxSessions(2, policy)(env.auth)
+			// The app asks for a day, but tokens are clamped to an hour, minted
+			// two hours ago: they are long expired, and so must their sessions be.
+			now := time.Now().Add(-2 * time.Hour)
+			env.auth.tokenProvider = jwt.New(env.auth.log, jwt.WithMaxTTL(time.Hour), jwt.WithClock(func() time.Time { return now }))
+			app := env.apps.apps[testAppID]
+			app.TokenTTL = 24 * time.Hour
+			env.apps.apps[testAppID] = app
+			userID := env.registerUser(t, testEmail, testPassword)
+			ctx := context.Background()
+
+			for range 2 {
+				_, err := env.auth.Login(ctx, testEmail, testPassword, testAppID)
+				require.NoError(t, err)
+			}
+
+			now = time.Now()
+			login, err := env.auth.LoginWithOptions(ctx, testEmail, testPassword, testAppID, LoginOptions{})
+			require.NoError(t, err)
+			assert.Empty(t, login.EvictedSessions)
+
+			sessions, err := env.auth.ListSessions(ctx, userID)
+			require.NoError(t, err)
+			assert.Len(t, sessions, 1)
+			for _, s := range env.users.sessions {
+				assert.False(t, s.revoked)
+			}
+		})
+	}
+}
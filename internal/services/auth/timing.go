@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"log/slog"
+	"time"
+)
+
+// stepTimer measures consecutive steps of a request for debug logs.
+type stepTimer struct {
+	start time.Time
+	last  time.Time
+	steps []slog.Attr
+}
+
+func newStepTimer() *stepTimer {
+	now := time.Now()
+
+	return &stepTimer{start: now, last: now}
+}
+
+// done records the time since the previous step, or the start, as step.
+func (t *stepTimer) done(step string) {
+	now := time.Now()
+	t.steps = append(t.steps, slog.Duration(step, now.Sub(t.last)))
+	t.last = now
+}
+
+// attrs returns the recorded steps and the total in a "timing" group.
+func (t *stepTimer) attrs() slog.Attr {
+	return slog.Attr{
+		Key:   "timing",
+		Value: slog.GroupValue(append(t.steps, slog.Duration("total", time.Since(t.start)))...),
+	}
+}
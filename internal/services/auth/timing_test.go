@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginTiming returns the timing group of the "login timing" debug record in logs.
+func loginTiming(t *testing.T, logs *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	for line := range bytes.Lines(logs.Bytes()) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == "login timing" {
+			timing, ok := record["timing"].(map[string]any)
+			require.True(t, ok, "timing is a group")
+			return timing
+		}
+	}
+	t.Fatal("no login timing record")
+
+	return nil
+}
+
+func TestLogin_LogsTimingBreakdown(t *testing.T) {
+	env := newTestEnv(t)
+	var logs bytes.Buffer
+	env.auth.log = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	env.registerUser(t, testEmail, testPassword)
+
+	_, err := env.auth.Login(context.Background(), testEmail, testPassword, testAppID)
+	require.NoError(t, err)
+
+	timing := loginTiming(t, &logs)
+	for _, step := range []string{"user_lookup", "password_compare", "app_lookup", "token_sign", "session_save", "total"} {
+		assert.Contains(t, timing, step)
+	}
+	assert.Greater(t, timing["password_compare"], float64(0), "Argon2 takes measurable time")
+
+	// Failed logins report the steps they reached.
+	logs.Reset()
+	_, err = env.auth.Login(context.Background(), testEmail, "wrong-password", testAppID)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	timing = loginTiming(t, &logs)
+	assert.Contains(t, timing, "password_compare")
+	assert.NotContains(t, timing, "app_lookup")
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/logger"
+)
+
+// VerifyPassword checks the credentials of a user of the app, or of all apps,
+// like Login, without issuing a token or starting a session, e.g. to confirm a
+// sensitive action. A wrong password or unknown email fails with
+// ErrInvalidCredentials, a disabled account with ErrAccountDisabled.
+func (a *Auth) VerifyPassword(
+	ctx context.Context,
+	email string,
+	password string,
+	appID int,
+) error {
+	const op = "Auth.VerifyPassword"
+	ctx = logger.WithOp(ctx, op)
+
+	log := a.log.With(slog.String("op", op), slog.String("username", email))
+
+	timer := newStepTimer()
+	defer func() {
+		log.LogAttrs(ctx, slog.LevelDebug, "password verification timing", timer.attrs())
+	}()
+
+	user, err := a.checkCredentials(ctx, log, timer, email, password, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password verified", slog.Int64("user_id", user.ID))
+
+	return nil
+}
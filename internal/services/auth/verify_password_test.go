@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	require.NoError(t, env.auth.VerifyPassword(ctx, testEmail, testPassword, testAppID))
+	assert.ErrorIs(t, env.auth.VerifyPassword(ctx, testEmail, "wrong-password", testAppID), ErrInvalidCredentials)
+	assert.ErrorIs(t, env.auth.VerifyPassword(ctx, "unknown@example.com", testPassword, testAppID), ErrInvalidCredentials)
+
+	assert.Zero(t, env.tokens.lastUser, "no token is issued")
+	assert.Empty(t, env.users.sessions, "no session is started")
+}
+
+func TestVerifyPassword_UnknownUserDoesEquivalentHashingWork(t *testing.T) {
+	env := newTestEnv(t)
+	env.registerUser(t, testEmail, testPassword)
+	ctx := context.Background()
+
+	wrongPassword := allocatedBytes(func() {
+		require.ErrorIs(t, env.auth.VerifyPassword(ctx, testEmail, "wrong-password", testAppID), ErrInvalidCredentials)
+	})
+	unknownUser := allocatedBytes(func() {
+		require.ErrorIs(t, env.auth.VerifyPassword(ctx, "unknown@example.com", "wrong-password", testAppID), ErrInvalidCredentials)
+	})
+
+	assert.InEpsilon(t, wrongPassword, unknownUser, 0.1)
+}
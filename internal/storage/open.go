@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sso/internal/config"
+	"sync"
+	"time"
+)
+
+// Drivers name the storage backends Open can construct. Only the ones
+// registered in the binary with Register can actually be opened.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+var knownDrivers = []string{DriverSQLite, DriverPostgres, DriverMemory}
+
+var (
+	ErrUnknownDriver = errors.New("unknown storage driver")
+	// ErrDriverUnavailable means the driver is a known one, but its backend is
+	// not registered in this binary.
+	ErrDriverUnavailable = errors.New("storage driver is not available")
+)
+
+// Config is everything Open needs to construct a backend: the storage section
+// of the config, which selects the driver, and where the data lives.
+type Config struct {
+	config.StorageConfig
+	// Path locates the database, e.g. the file of the sqlite driver.
+	Path string
+	// ReplicaPath optionally locates a read replica of Path.
+	ReplicaPath string
+	// OperationTimeout is the longest an operation may use the storage for.
+	// Backends replacing their connections keep the old ones open that long;
+	// zero leaves it to the backend.
+	OperationTimeout time.Duration
+	// Log is handed to the backend; nil discards its logs.
+	Log *slog.Logger
+}
+
+// OpenFunc constructs the backend of a driver.
+type OpenFunc func(cfg Config) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]OpenFunc)
+)
+
+// Register makes a backend available to Open under the name driver. Backends
+// register themselves when their package is imported, like database/sql
+// drivers. It panics if driver is registered twice or open is nil.
+func Register(driver string, open OpenFunc) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if open == nil {
+		panic("storage: Register open func is nil for driver " + driver)
+	}
+	if _, dup := drivers[driver]; dup {
+		panic("storage: Register called twice for driver " + driver)
+	}
+	drivers[driver] = open
+}
+
+var (
+	unavailableMu sync.RWMutex
+	unavailable   []func(error) bool
+)
+
+// RegisterUnavailable makes IsUnavailable recognize the errors with which a
+// backend reports that it cannot serve right now, e.g. a database still locked
+// by another writer. Backends register it next to their OpenFunc.
+func RegisterUnavailable(is func(err error) bool) {
+	unavailableMu.Lock()
+	defer unavailableMu.Unlock()
+
+	if is == nil {
+		panic("storage: RegisterUnavailable func is nil")
+	}
+	unavailable = append(unavailable, is)
+}
+
+// IsUnavailable reports whether err means the storage was temporarily unable
+// to serve the operation, which may succeed if retried later.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	unavailableMu.RLock()
+	defer unavailableMu.RUnlock()
+
+	for _, is := range unavailable {
+		if is(err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Drivers returns the sorted names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Open constructs the backend selected by cfg.Driver. It fails with
+// ErrDriverUnavailable for a known driver whose backend is not registered, and
+// with ErrUnknownDriver for any other name.
+func Open(cfg Config) (Storage, error) {
+	const op = "storage.Open"
+
+	driversMu.RLock()
+	open, ok := drivers[cfg.Driver]
+	driversMu.RUnlock()
+
+	if !ok {
+		if slices.Contains(knownDrivers, cfg.Driver) {
+			return nil, fmt.Errorf("%s: %w: %q, available: %v", op, ErrDriverUnavailable, cfg.Driver, Drivers())
+		}
+		return nil, fmt.Errorf("%s: %w %q, want one of %v", op, ErrUnknownDriver, cfg.Driver, knownDrivers)
+	}
+
+	s, err := open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", op, cfg.Driver, err)
+	}
+
+	return s, nil
+}
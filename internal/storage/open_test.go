@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"errors"
+	"sso/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStorage is the backend of the test driver.
+type fakeStorage struct {
+	Storage
+	cfg Config
+}
+
+func TestOpen_DispatchesOnDriver(t *testing.T) {
+	errBroken := errors.New("broken")
+	Register("test-ok", func(cfg Config) (Storage, error) {
+		return &fakeStorage{cfg: cfg}, nil
+	})
+	Register("test-broken", func(Config) (Storage, error) {
+		return nil, errBroken
+	})
+
+	s, err := Open(Config{StorageConfig: config.StorageConfig{Driver: "test-ok"}, Path: "/data/sso.db"})
+	require.NoError(t, err)
+	require.IsType(t, &fakeStorage{}, s)
+	assert.Equal(t, "/data/sso.db", s.(*fakeStorage).cfg.Path, "the driver gets the config")
+
+	_, err = Open(Config{StorageConfig: config.StorageConfig{Driver: "test-broken"}})
+	assert.ErrorIs(t, err, errBroken)
+	assert.ErrorContains(t, err, "storage.Open: test-broken: broken")
+
+	assert.Panics(t, func() {
+		Register("test-ok", func(Config) (Storage, error) { return nil, nil })
+	}, "a driver can be registered only once")
+}
+
+func TestOpen_UnavailableDriver(t *testing.T) {
+	for _, driver := range []string{DriverPostgres, DriverMemory} {
+		t.Run(driver, func(t *testing.T) {
+			_, err := Open(Config{StorageConfig: config.StorageConfig{Driver: driver}})
+			assert.ErrorIs(t, err, ErrDriverUnavailable)
+			assert.ErrorContains(t, err, driver)
+		})
+	}
+}
+
+func TestOpen_UnknownDriver(t *testing.T) {
+	for _, driver := range []string{"", "mysql", "SQLite"} {
+		_, err := Open(Config{StorageConfig: config.StorageConfig{Driver: driver}})
+		assert.ErrorIs(t, err, ErrUnknownDriver, "driver %q", driver)
+		assert.ErrorContains(t, err, `want one of [sqlite postgres memory]`)
+	}
+}
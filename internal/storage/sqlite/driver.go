@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"sso/internal/lib/emailhash"
+	"sso/internal/storage"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	storage.Register(storage.DriverSQLite, openDriver)
+	storage.RegisterUnavailable(isLocked)
+}
+
+// isLocked reports whether err is SQLITE_BUSY or SQLITE_LOCKED, i.e. the
+// database stayed locked by another connection past the busy timeout.
+func isLocked(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// openDriver is the storage.OpenFunc of the sqlite driver.
+func openDriver(cfg storage.Config) (storage.Storage, error) {
+	var emails *emailhash.Hasher
+	if cfg.HashEmails {
+		var err error
+		if emails, err = emailhash.New(cfg.EmailHashKey); err != nil {
+			return nil, fmt.Errorf("storage.sqlite.openDriver: %w", err)
+		}
+	}
+
+	return NewWithOptions(cfg.Path, Options{
+		ReplicaPath:     cfg.ReplicaPath,
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+		ReconnectGrace:  cfg.OperationTimeout,
+
+		ReuseDeletedEmails: cfg.ReuseDeletedEmails,
+		EmailHasher:        emails,
+		JournalMode:        cfg.JournalMode,
+		BusyTimeout:        cfg.BusyTimeout,
+		Synchronous:        cfg.Synchronous,
+		DisableForeignKeys: cfg.DisableForeignKeys,
+		Log:                cfg.Log,
+	})
+}
@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// pools holds the connection pools of a Storage, which Reconnect swaps while
+// queries run.
+type pools struct {
+	mu sync.RWMutex
+	db *sql.DB
+	// replica serves read-only queries when configured; nil routes everything to db.
+	replica *sql.DB
+
+	// retired are the pools replaced by reconnects that stay open for
+	// Options.ReconnectGrace, guarded by mu.
+	retired []*sql.DB
+
+	// reconnecting serializes reconnects, so concurrent ones don't open
+	// pools only to close them again.
+	reconnecting sync.Mutex
+	path         string
+	opts         Options
+}
+
+func (p *pools) get() (db, replica *sql.DB) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.db, p.replica
+}
+
+// open opens the primary pool and, when configured, the replica pool.
+func (p *pools) open() (db, replica *sql.DB, err error) {
+	// _txlock=immediate takes the write lock when a transaction begins, so one
+	// that reads before it writes waits for the busy timeout instead of failing
+	// with "database is locked" when another writer got in between.
+	db, err = open(p.path, "&_txlock=immediate", p.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.opts.ReplicaPath == "" {
+		return db, nil, nil
+	}
+
+	// _query_only=1 makes any accidental write through the replica pool fail.
+	replica, err = open(p.opts.ReplicaPath, "&_query_only=1", p.opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("replica: %w", errors.Join(err, db.Close()))
+	}
+
+	return db, replica, nil
+}
+
+func (p *pools) reconnect(ctx context.Context, log *slog.Logger) error {
+	p.reconnecting.Lock()
+	defer p.reconnecting.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db, replica, err := p.open()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	old := []*sql.DB{p.db}
+	if p.replica != nil {
+		old = append(old, p.replica)
+	}
+	p.db, p.replica = db, replica
+	p.retired = append(p.retired, old...)
+	p.mu.Unlock()
+
+	// Callers may have got the old pools without starting a query on them yet,
+	// which fails once they are closed. Such operations end within their
+	// timeout, so the old pools are closed only after it; Close then still
+	// waits for queries that are running.
+	time.AfterFunc(p.opts.ReconnectGrace, func() {
+		for _, db := range p.release(old) {
+			if err := db.Close(); err != nil {
+				log.Warn("failed to close replaced pool", slog.String("error", err.Error()))
+			}
+		}
+	})
+
+	log.Info("storage reconnected")
+
+	return nil
+}
+
+// release removes dbs from the retired pools and returns those that were
+// still there, i.e. not closed by close yet.
+func (p *pools) release(dbs []*sql.DB) []*sql.DB {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var released []*sql.DB
+	p.retired = slices.DeleteFunc(p.retired, func(db *sql.DB) bool {
+		if slices.Contains(dbs, db) {
+			released = append(released, db)
+			return true
+		}
+		return false
+	})
+
+	return released
+}
+
+// close closes the pools in use and the retired ones.
+func (p *pools) close() error {
+	p.mu.Lock()
+	dbs := append([]*sql.DB{p.db}, p.retired...)
+	if p.replica != nil {
+		dbs = append(dbs, p.replica)
+	}
+	p.retired = nil
+	p.mu.Unlock()
+
+	var errs []error
+	for _, db := range dbs {
+		errs = append(errs, db.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -3,10 +3,18 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
 	"sso/internal/domain/models"
+	"sso/internal/lib/emailhash"
 	"sso/internal/storage"
+	"strings"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
@@ -14,66 +22,451 @@ import (
 
 // Storage implements the storage.Storage interface using SQLite as the backend.
 type Storage struct {
-	db *sql.DB
+	pools *pools
+	// tx is the transaction a Storage handed out by WithTx runs all queries in.
+	tx  *sql.Tx
+	log *slog.Logger
+
+	reuseDeletedEmails bool
+	// emails maps emails to what the users table stores; nil stores them as is.
+	emails *emailhash.Hasher
+}
+
+// Options configures the SQLite storage. Zero pool fields use the defaults of DefaultOptions.
+type Options struct {
+	// ReplicaPath points reads (User, IsAdmin, App) at a replica of the primary,
+	// e.g. a LiteFS or Litestream replica. Empty routes reads to the primary.
+	// The replica lags the primary, so a user may not be visible right after registration.
+	ReplicaPath string
+
+	// MaxOpenConns caps open connections per pool. SQLite allows one writer at a
+	// time, so concurrent writers wait on busy_timeout; 1 serializes all access
+	// in the pool and avoids "database is locked" errors at the cost of throughput.
+	MaxOpenConns int
+	// MaxIdleConns caps idle connections per pool; a negative value keeps none.
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// ReconnectGrace is how long Reconnect keeps the replaced pools open for
+	// operations still using them. Set it to the longest operation timeout.
+	ReconnectGrace time.Duration
+
+	// ReuseDeletedEmails lets a new user register with the email of a soft-deleted
+	// one. Otherwise the email stays reserved and SaveUser fails with ErrUserExists.
+	ReuseDeletedEmails bool
+	// EmailHasher, if set, makes users be stored and looked up by a keyed hash
+	// of their email instead of the email itself. Users saved without it, or
+	// with another key, are not found by email then.
+	EmailHasher *emailhash.Hasher
+
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF. WAL
+	// lets readers run alongside the writer but needs shared memory, so use e.g.
+	// DELETE on network filesystems.
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock before failing with
+	// "database is locked". A negative value fails at once.
+	BusyTimeout time.Duration
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA.
+	Synchronous        string
+	DisableForeignKeys bool
+
+	// Log receives debug logs about rejected writes, e.g. a taken email. They are
+	// logged with the caller's context, so a logger.ContextHandler adds the
+	// operation the call originated from. Nil discards them.
+	Log *slog.Logger
+}
+
+// DefaultOptions returns the pool settings and pragmas used when none are
+// configured. NewWithOptions uses them for the zero fields of its Options, so
+// settings whose zero would make sense, like no idle connections or no busy
+// timeout, take a negative value instead.
+func DefaultOptions() Options {
+	return Options{
+		MaxOpenConns:    25,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+		ReconnectGrace:  30 * time.Second,
+		JournalMode:     "WAL",
+		BusyTimeout:     5 * time.Second,
+		Synchronous:     "NORMAL",
+	}
+}
+
+var (
+	journalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	syncLevels   = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// buildDSN builds the connection string for path from the pragma options.
+func buildDSN(path, extraParams string, opts Options) (string, error) {
+	journalMode := strings.ToUpper(opts.JournalMode)
+	if !slices.Contains(journalModes, journalMode) {
+		return "", fmt.Errorf("invalid journal mode %q, want one of %v", opts.JournalMode, journalModes)
+	}
+	synchronous := strings.ToUpper(opts.Synchronous)
+	if !slices.Contains(syncLevels, synchronous) {
+		return "", fmt.Errorf("invalid synchronous level %q, want one of %v", opts.Synchronous, syncLevels)
+	}
+
+	foreignKeys := "ON"
+	if opts.DisableForeignKeys {
+		foreignKeys = "OFF"
+	}
+
+	return fmt.Sprintf("%s?_journal_mode=%s&_busy_timeout=%d&_synchronous=%s&_foreign_keys=%s%s",
+		path, journalMode, max(opts.BusyTimeout, 0).Milliseconds(), synchronous, foreignKeys, extraParams,
+	), nil
 }
 
 // New creates a new instance of SQLite storage.
 func New(storagePath string) (*Storage, error) {
+	return NewWithOptions(storagePath, DefaultOptions())
+}
+
+// NewWithOptions creates SQLite storage at storagePath configured by opts.
+// A leading ~ and environment variables in the paths are expanded, and the
+// directory of storagePath is created (0700) if it does not exist yet.
+func NewWithOptions(storagePath string, opts Options) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	// Add SQLite pragmas for better performance and reliability
-	// _journal_mode=WAL: Write-Ahead Logging for better concurrency
-	// _busy_timeout=5000: Wait up to 5 seconds if database is locked
-	// _synchronous=NORMAL: Balance between safety and performance
-	// _foreign_keys=ON: Enable foreign key constraints
-	dsn := storagePath + "?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_foreign_keys=ON"
+	opts = opts.withDefaults()
 
-	db, err := sql.Open("sqlite3", dsn)
+	storagePath, err := expandPath(storagePath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	if opts.ReplicaPath, err = expandPath(opts.ReplicaPath); err != nil {
+		return nil, fmt.Errorf("%s: replica: %w", op, err)
+	}
+
+	if storagePath != ":memory:" && !strings.HasPrefix(storagePath, "file:") {
+		if err := os.MkdirAll(filepath.Dir(storagePath), 0o700); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	p := &pools{path: storagePath, opts: opts}
+	if p.db, p.replica, err = p.open(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{pools: p, log: opts.Log, reuseDeletedEmails: opts.ReuseDeletedEmails, emails: opts.EmailHasher}, nil
+}
+
+// expandPath expands environment variables and a leading ~ in path.
+func expandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, path[1:]), nil
+}
+
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.MaxOpenConns == 0 {
+		o.MaxOpenConns = defaults.MaxOpenConns
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if o.ConnMaxLifetime == 0 {
+		o.ConnMaxLifetime = defaults.ConnMaxLifetime
+	}
+	if o.ReconnectGrace == 0 {
+		o.ReconnectGrace = defaults.ReconnectGrace
+	}
+	if o.JournalMode == "" {
+		o.JournalMode = defaults.JournalMode
+	}
+	if o.BusyTimeout == 0 {
+		o.BusyTimeout = defaults.BusyTimeout
+	}
+	if o.Synchronous == "" {
+		o.Synchronous = defaults.Synchronous
+	}
+	if o.Log == nil {
+		o.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return o
+}
+
+func open(path, extraParams string, opts Options) (*sql.DB, error) {
+	// By default: WAL for concurrent readers, a 5s wait on locks, NORMAL
+	// synchronous to balance safety and performance, and foreign keys on.
+	dsn, err := buildDSN(path, extraParams, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
 
 	// Configure connection pool to prevent resource exhaustion
-	db.SetMaxOpenConns(25)                 // Maximum number of open connections
-	db.SetMaxIdleConns(5)                  // Maximum number of idle connections
-	db.SetConnMaxLifetime(5 * time.Minute) // Maximum connection lifetime
+	db.SetMaxOpenConns(opts.MaxOpenConns)       // Maximum number of open connections
+	db.SetMaxIdleConns(opts.MaxIdleConns)       // Maximum number of idle connections
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime) // Maximum connection lifetime
 
 	// Verify connection is working
 	if pingErr := db.Ping(); pingErr != nil {
 		if closeErr := db.Close(); closeErr != nil {
-			return nil, fmt.Errorf("%s: failed to ping database: %w", op, errors.Join(pingErr, closeErr))
+			return nil, fmt.Errorf("failed to ping database: %w", errors.Join(pingErr, closeErr))
+		}
+
+		return nil, fmt.Errorf("failed to ping database: %w", pingErr)
+	}
+
+	return db, nil
+}
+
+// querier is what queries run on: the primary pool, the replica or a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// conn returns where queries against the primary run: the transaction of a
+// Storage handed out by WithTx, the primary pool otherwise.
+func (s *Storage) conn() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+
+	return s.primary()
+}
+
+// reader returns where read-only queries run. Inside a transaction that is
+// the transaction, so reads see its own writes.
+func (s *Storage) reader() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+	if replica := s.replica(); replica != nil {
+		return replica
+	}
+
+	return s.primary()
+}
+
+// WithTx runs fn with a Storage whose queries all run in one transaction,
+// committed if fn returns nil and rolled back otherwise. Methods and WithTx
+// calls nested in fn join the transaction, so the writes of a nested call that
+// failed are only undone if fn fails too. A write transaction holds SQLite's write lock, so fn
+// should not do slow work such as password hashing.
+func (s *Storage) WithTx(ctx context.Context, fn func(tx storage.TxStorage) error) (err error) {
+	const op = "storage.sqlite.WithTx"
+
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.primary().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
 		}
+	}()
+
+	txStorage := *s
+	txStorage.tx = tx
+	if err = fn(&txStorage); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ownedTx is a transaction a method started itself, or, inside WithTx, the
+// enclosing one, whose Commit and Rollback are then left to WithTx.
+type ownedTx struct {
+	*sql.Tx
+	joined bool
+}
+
+func (t ownedTx) Commit() error {
+	if t.joined {
+		return nil
+	}
+
+	return t.Tx.Commit()
+}
+
+func (t ownedTx) Rollback() error {
+	if t.joined {
+		return nil
+	}
+
+	return t.Tx.Rollback()
+}
 
-		return nil, fmt.Errorf("%s: failed to ping database: %w", op, pingErr)
+// beginTx starts a transaction for a multi-statement method, or joins the one
+// s runs in.
+func (s *Storage) beginTx(ctx context.Context) (ownedTx, error) {
+	if s.tx != nil {
+		return ownedTx{Tx: s.tx, joined: true}, nil
 	}
 
-	return &Storage{db: db}, nil
+	tx, err := s.primary().BeginTx(ctx, nil)
+
+	return ownedTx{Tx: tx}, err
 }
 
-// Close closes the database connection.
+// Close closes the database connections, including those of pools replaced by
+// Reconnect that are still open.
 func (s *Storage) Close() error {
-	return s.db.Close()
+	return s.pools.close()
+}
+
+// Ping checks that the primary and, when configured, the replica are reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	db, replica := s.pools.get()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if replica != nil {
+		if err := replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("%s: replica: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// maxIntegrityProblems caps the problems Diagnostics reports; a badly corrupted
+// database can have one per page.
+const maxIntegrityProblems = 100
+
+// Diagnostics runs PRAGMA integrity_check on the primary database and reports
+// its page usage. The check reads every page, so it takes a while on large
+// databases.
+func (s *Storage) Diagnostics(ctx context.Context) (storage.Diagnostics, error) {
+	const op = "storage.sqlite.Diagnostics"
+
+	db := s.primary()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA integrity_check(%d)", maxIntegrityProblems))
+	if err != nil {
+		return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var report storage.Diagnostics
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, err)
+		}
+		// A healthy database yields a single "ok" row.
+		if line != "ok" {
+			report.Problems = append(report.Problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, err)
+	}
+	report.OK = len(report.Problems) == 0
+
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&report.PageCount); err != nil {
+		return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&report.FreePages); err != nil {
+		return storage.Diagnostics{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return report, nil
+}
+
+// Reconnect replaces the connection pools with freshly opened ones, e.g. after
+// the database file was replaced or its connections went bad. New queries use
+// the new pools. The old ones stay open for Options.ReconnectGrace, so
+// operations that already picked them up finish there. If opening fails, the
+// old pools stay in use.
+func (s *Storage) Reconnect(ctx context.Context) error {
+	const op = "storage.sqlite.Reconnect"
+
+	if err := s.pools.reconnect(ctx, s.log); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stats returns primary connection pool statistics (open, in-use and idle connections,
+// wait count and duration), e.g. to check whether the pool limits are a bottleneck.
+func (s *Storage) Stats() sql.DBStats {
+	return s.primary().Stats()
+}
+
+// primary returns the primary pool.
+func (s *Storage) primary() *sql.DB {
+	db, _ := s.pools.get()
+
+	return db
+}
+
+// replica returns the replica pool, or nil if none is configured.
+func (s *Storage) replica() *sql.DB {
+	_, replica := s.pools.get()
+
+	return replica
 }
 
-// SaveUser saves a new user and returns its ID.
-func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte) (int64, error) {
+// SaveUser saves a new user with its optional profile and returns its ID. Emails
+// are unique per app, with users of all apps counting as users of every app: it
+// fails with storage.ErrUserExists if such a user already has the email.
+func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte, pepperVersion int, profile storage.Profile) (int64, error) {
 	const op = "storage.sqlite.SaveUser"
 
-	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO users (email, password_hash, password_salt) VALUES (?, ?, ?)`)
+	stmt, err := s.conn().PrepareContext(ctx, s.insertUserQuery())
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	defer func() { _ = stmt.Close() }()
 
-	res, err := stmt.ExecContext(ctx, email, passwordHash, passwordSalt)
+	res, err := stmt.ExecContext(ctx, s.emails.Hash(email), passwordHash, passwordSalt, pepperVersion, profile.DisplayName, nullableJSON(profile.Metadata), nullableInt(profile.AppID))
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			s.log.DebugContext(ctx, "email is taken", slog.String("op", op))
 			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
 		}
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		s.log.DebugContext(ctx, "email is taken or reserved by a deleted user", slog.String("op", op))
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+	}
+
 	id, err := res.LastInsertId()
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
@@ -82,26 +475,226 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passwordHash []byt
 	return id, nil
 }
 
-// User returns user by email.
-func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+// SaveUsers saves a batch of users in a single transaction and returns the
+// result of every row in input order. A duplicate email fails its row with
+// storage.ErrUserExists, unless skipExisting is set, in which case the
+// duplicate is left untouched and its row is marked skipped. Emails reserved
+// by soft-deleted users count as duplicates.
+//
+// Rows that fail don't stop the batch, so one call reports all of them, but
+// any failure rolls the whole batch back: the other rows then fail with
+// storage.ErrBatchRolledBack, and the returned error wraps the first failure.
+// The results are returned along with it. Errors not caused by a row, e.g. a
+// canceled context, abort the batch and return no results.
+func (s *Storage) SaveUsers(ctx context.Context, users []storage.UserImport, skipExisting bool) (results []storage.ImportResult, err error) {
+	const op = "storage.sqlite.SaveUsers"
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, s.insertUserQuery())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	var (
+		failed   int
+		firstErr error
+	)
+	results = make([]storage.ImportResult, len(users))
+	for i, user := range users {
+		results[i].Index = i
+		user.Email = s.emails.Hash(user.Email)
+
+		rowErr, err := saveImportedUser(ctx, stmt, user, skipExisting, &results[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: row %d: %w", op, i, err)
+		}
+		if rowErr != nil {
+			results[i].Err = rowErr
+			if failed == 0 {
+				firstErr = fmt.Errorf("row %d: %w", i, rowErr)
+			}
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		for i := range results {
+			if results[i].Err == nil && !results[i].Skipped {
+				results[i] = storage.ImportResult{Index: i, Err: storage.ErrBatchRolledBack}
+			}
+		}
+		err = fmt.Errorf("%s: %d of %d rows failed, %w", op, failed, len(users), firstErr)
+		return results, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// saveImportedUser inserts one row of a SaveUsers batch with stmt and records
+// its outcome in result. rowErr is why the row itself failed; err is a failure
+// that aborts the batch.
+func saveImportedUser(
+	ctx context.Context,
+	stmt *sql.Stmt,
+	user storage.UserImport,
+	skipExisting bool,
+	result *storage.ImportResult,
+) (rowErr, err error) {
+	res, err := stmt.ExecContext(ctx, user.Email, user.PasswordHash, user.PasswordSalt, 0, "", nil, nil)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if !errors.As(err, &sqliteErr) {
+			return nil, err
+		}
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return storage.ErrUserExists, nil
+		}
+		if sqliteErr.Code == sqlite3.ErrConstraint {
+			return err, nil
+		}
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		if !skipExisting {
+			// The email is taken, or reserved by a soft-deleted user.
+			return storage.ErrUserExists, nil
+		}
+		result.Skipped = true
+		return nil, nil
+	}
+
+	if result.UserID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// insertUserQuery returns the statement inserting a user from (email, password_hash,
+// password_salt, pepper_version, display_name, metadata, app_id). It affects no
+// rows when a user of the same app, or of all apps if either app_id is NULL,
+// has the email, counting soft-deleted users unless their emails may be reused.
+func (s *Storage) insertUserQuery() string {
+	query := `INSERT INTO users (email, password_hash, password_salt, pepper_version, display_name, metadata, app_id) SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE email = ?1 AND (app_id IS ?7 OR app_id IS NULL OR ?7 IS NULL)`
+	if s.reuseDeletedEmails {
+		query += ` AND deleted_at IS NULL`
+	}
+
+	return query + `)`
+}
+
+// nullableJSON stores empty JSON as NULL.
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	return string(raw)
+}
+
+// nullableInt stores zero as NULL.
+func nullableInt(v int) any {
+	if v == 0 {
+		return nil
+	}
+
+	return v
+}
+
+// nullableString stores an empty string as NULL.
+func nullableString(v string) any {
+	if v == "" {
+		return nil
+	}
+
+	return v
+}
+
+// User returns the user with the email among the users of the app with appID
+// and those of all apps. An appID of 0 only finds users of all apps.
+// Soft-deleted users are not found. With email hashing, the user's Email is
+// the normalized email looked up.
+func (s *Storage) User(ctx context.Context, email string, appID int) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.PrepareContext(ctx, `SELECT id, email, password_hash, password_salt FROM users WHERE email = ?`)
+	user, err := s.user(ctx, op, userByEmail, s.emails.Hash(email), appID)
+	if err == nil && s.emails != nil {
+		user.Email = emailhash.Normalize(email)
+	}
+
+	return user, err
+}
+
+// userByEmail finds a user of an app, or of all apps, by email on every login,
+// so it must stay a search of idx_users_email. SaveUser keeps it from matching
+// both a user of the app and one of all apps.
+const userByEmail = `email = ? AND (app_id IS NULL OR app_id = ?)`
+
+// userQuery selects the active user matching where.
+func userQuery(where string) string {
+	return `SELECT id, email, password_hash, password_salt, pepper_version, is_admin, email_verified, display_name, metadata, app_id, password_changed_at, disabled FROM users WHERE deleted_at IS NULL AND ` + where
+}
+
+// UserByID returns user by ID. Soft-deleted users are not found. With email
+// hashing, the email can't be recovered and the user's Email is empty.
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+
+	return s.user(ctx, op, `id = ?`, userID)
+}
+
+func (s *Storage) user(ctx context.Context, op, where string, args ...any) (models.User, error) {
+	stmt, err := s.reader().PrepareContext(ctx, userQuery(where))
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 	defer func() { _ = stmt.Close() }()
 
-	row := stmt.QueryRowContext(ctx, email)
+	row := stmt.QueryRowContext(ctx, args...)
 
-	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.PasswordSalt)
+	var (
+		user      models.User
+		metadata  sql.NullString
+		appID     sql.NullInt64
+		changedAt sql.NullInt64
+	)
+	err = row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.PasswordSalt, &user.PepperVersion, &user.IsAdmin, &user.EmailVerified, &user.DisplayName, &metadata, &appID, &changedAt, &user.Disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return user, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 		}
 		return user, fmt.Errorf("%s: %w", op, err)
 	}
+	if metadata.Valid {
+		user.Metadata = json.RawMessage(metadata.String)
+	}
+	user.AppID = int(appID.Int64)
+	if changedAt.Valid {
+		user.PasswordChangedAt = time.Unix(changedAt.Int64, 0)
+	}
+	if s.emails != nil {
+		user.Email = ""
+	}
 
 	return user, nil
 }
@@ -109,7 +702,7 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "storage.sqlite.IsAdmin"
 
-	stmt, err := s.db.PrepareContext(ctx, `SELECT is_admin FROM users WHERE id = ?`)
+	stmt, err := s.reader().PrepareContext(ctx, `SELECT is_admin FROM users WHERE id = ? AND deleted_at IS NULL`)
 	if err != nil {
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
@@ -129,25 +722,794 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	return isAdmin, nil
 }
 
-func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
-	const op = "storage.sqlite.App"
+// SetAdmin grants or revokes the admin role of the user.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.sqlite.SetAdmin"
 
-	stmt, err := s.db.PrepareContext(ctx, `SELECT id, name, private_key, public_key FROM apps WHERE id = ?`)
+	res, err := s.conn().ExecContext(ctx, `UPDATE users SET is_admin = ? WHERE id = ? AND deleted_at IS NULL`, isAdmin, userID)
 	if err != nil {
-		return models.App{}, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	defer func() { _ = stmt.Close() }()
 
-	row := stmt.QueryRowContext(ctx, appID)
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
 
-	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.PrivateKey, &app.PublicKey)
+	return nil
+}
+
+// SetDisabled disables or enables the user. Disabled users are still found by
+// reads, with Disabled set. Unknown and soft-deleted users fail with
+// storage.ErrUserNotFound.
+func (s *Storage) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	const op = "storage.sqlite.SetDisabled"
+
+	res, err := s.conn().ExecContext(ctx, `UPDATE users SET disabled = ? WHERE id = ? AND deleted_at IS NULL`, disabled, userID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return app, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// DeleteUser soft-deletes the user: the row is kept for auditing but the user is
+// no longer found by reads and cannot log in.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.DeleteUser"
+
+	res, err := s.conn().ExecContext(ctx, `UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now().Unix(), userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// RestoreUser undoes DeleteUser. It fails with storage.ErrUserExists if the email
+// has since been taken by another user, as SaveUser would.
+func (s *Storage) RestoreUser(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.RestoreUser"
+
+	res, err := s.conn().ExecContext(ctx, `
+		UPDATE users SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM users AS taken WHERE taken.email = users.email AND taken.deleted_at IS NULL
+			AND (taken.app_id IS users.app_id OR taken.app_id IS NULL OR users.app_id IS NULL)
+		)`, userID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
 		}
-		return app, fmt.Errorf("%s: %w", op, err)
+
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	return app, nil
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		// Either there is no such deleted user or its email is taken.
+		var deleted bool
+		err = s.conn().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NOT NULL)`, userID).Scan(&deleted)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if deleted {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// Tables of single-use tokens. They share the (token_hash, user_id, expires_at) layout.
+const (
+	emailVerificationTokens = "email_verification_tokens"
+	passwordResetTokens     = "password_reset_tokens"
+)
+
+// SaveEmailVerificationToken stores the hash of a verification token for the user,
+// replacing any token issued before, so only the latest one can be used.
+func (s *Storage) SaveEmailVerificationToken(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error {
+	const op = "storage.sqlite.SaveEmailVerificationToken"
+
+	return s.saveToken(ctx, op, emailVerificationTokens, userID, tokenHash, expiresAt)
+}
+
+// VerifyEmail consumes the verification token with the given hash and marks its
+// user's email as verified, returning the user ID.
+func (s *Storage) VerifyEmail(ctx context.Context, tokenHash []byte) (int64, error) {
+	const op = "storage.sqlite.VerifyEmail"
+
+	return s.consumeToken(ctx, op, emailVerificationTokens, tokenHash, func(tx *sql.Tx, userID int64) (sql.Result, error) {
+		return tx.ExecContext(ctx, `UPDATE users SET email_verified = TRUE WHERE id = ? AND deleted_at IS NULL`, userID)
+	})
+}
+
+// SavePasswordResetToken stores the hash of a password reset token for the user,
+// replacing any token issued before, so only the latest one can be used.
+func (s *Storage) SavePasswordResetToken(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error {
+	const op = "storage.sqlite.SavePasswordResetToken"
+
+	return s.saveToken(ctx, op, passwordResetTokens, userID, tokenHash, expiresAt)
+}
+
+// ResetPassword consumes the reset token with the given hash and replaces the
+// password of its user, returning the user ID. The replaced password goes to the
+// user's password history, of which the keepHistory most recent entries are kept.
+func (s *Storage) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) (int64, error) {
+	const op = "storage.sqlite.ResetPassword"
+
+	password := storage.PasswordRecord{Hash: passwordHash, Salt: passwordSalt, PepperVersion: pepperVersion}
+
+	return s.consumeToken(ctx, op, passwordResetTokens, tokenHash, func(tx *sql.Tx, userID int64) (sql.Result, error) {
+		return replacePassword(ctx, tx, userID, nil, password, keepHistory)
+	})
+}
+
+// ChangePassword replaces the user's password like ResetPassword, provided the
+// stored hash is still oldHash. It fails with storage.ErrUserNotFound for
+// unknown and soft-deleted users, and for users whose password changed meanwhile.
+func (s *Storage) ChangePassword(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) (err error) {
+	const op = "storage.sqlite.ChangePassword"
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	password := storage.PasswordRecord{Hash: passwordHash, Salt: passwordSalt, PepperVersion: pepperVersion}
+	res, err := replacePassword(ctx, tx.Tx, userID, oldHash, password, keepHistory)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// replacePassword sets the password of an active user and its
+// password_changed_at, moving the replaced password to the user's history and
+// keeping the keepHistory most recent entries there. A non-nil oldHash limits it
+// to a user whose stored hash is still oldHash.
+func replacePassword(ctx context.Context, tx *sql.Tx, userID int64, oldHash []byte, password storage.PasswordRecord, keepHistory int) (sql.Result, error) {
+	where, args := `id = ? AND deleted_at IS NULL`, []any{userID}
+	if oldHash != nil {
+		where += ` AND password_hash = ?`
+		args = append(args, oldHash)
+	}
+	now := time.Now().Unix()
+
+	if keepHistory > 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO password_history (user_id, password_hash, password_salt, pepper_version, replaced_at)
+			SELECT id, password_hash, password_salt, pepper_version, ? FROM users WHERE `+where,
+			append([]any{now}, args...)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)`, userID, userID, keepHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.ExecContext(ctx,
+		`UPDATE users SET password_hash = ?, password_salt = ?, pepper_version = ?, password_changed_at = ? WHERE `+where,
+		append([]any{password.Hash, password.Salt, password.PepperVersion, now}, args...)...,
+	)
+}
+
+// PasswordResetUser returns the user of the reset token with the given hash
+// without consuming it. Unknown tokens fail with storage.ErrTokenNotFound,
+// expired ones with storage.ErrTokenExpired.
+func (s *Storage) PasswordResetUser(ctx context.Context, tokenHash []byte) (int64, error) {
+	const op = "storage.sqlite.PasswordResetUser"
+
+	var userID, expiresAt int64
+	err := s.conn().QueryRowContext(ctx,
+		`SELECT user_id, expires_at FROM password_reset_tokens WHERE token_hash = ?`, tokenHash,
+	).Scan(&userID, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if time.Now().Unix() >= expiresAt {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrTokenExpired)
+	}
+
+	return userID, nil
+}
+
+// PasswordHistory returns the current password of the user followed by the
+// ones replaced by resets, newest first, at most limit in total. Unknown and
+// soft-deleted users fail with storage.ErrUserNotFound.
+func (s *Storage) PasswordHistory(ctx context.Context, userID int64, limit int) ([]storage.PasswordRecord, error) {
+	const op = "storage.sqlite.PasswordHistory"
+
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	var current storage.PasswordRecord
+	err := s.conn().QueryRowContext(ctx,
+		`SELECT password_hash, password_salt, pepper_version FROM users WHERE id = ? AND deleted_at IS NULL`, userID,
+	).Scan(&current.Hash, &current.Salt, &current.PepperVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.conn().QueryContext(ctx,
+		`SELECT password_hash, password_salt, pepper_version FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?`,
+		userID, limit-1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	history := []storage.PasswordRecord{current}
+	for rows.Next() {
+		var record storage.PasswordRecord
+		if err = rows.Scan(&record.Hash, &record.Salt, &record.PepperVersion); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		history = append(history, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return history, nil
+}
+
+// UpdatePasswordHash replaces the user's password hash, salt and pepper
+// version, provided the stored hash is still oldHash. It fails with
+// storage.ErrUserNotFound for unknown and soft-deleted users, and for users
+// whose password changed meanwhile, e.g. by a concurrent reset.
+func (s *Storage) UpdatePasswordHash(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int) error {
+	const op = "storage.sqlite.UpdatePasswordHash"
+
+	res, err := s.conn().ExecContext(ctx,
+		`UPDATE users SET password_hash = ?, password_salt = ?, pepper_version = ? WHERE id = ? AND password_hash = ? AND deleted_at IS NULL`,
+		passwordHash, passwordSalt, pepperVersion, userID, oldHash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// saveToken stores a token hash for the user in table, deleting the user's
+// previous tokens there. It fails with storage.ErrUserNotFound for unknown or
+// soft-deleted users.
+func (s *Storage) saveToken(ctx context.Context, op, table string, userID int64, tokenHash []byte, expiresAt time.Time) (err error) {
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO `+table+` (token_hash, user_id, expires_at) SELECT ?, id, ? FROM users WHERE id = ? AND deleted_at IS NULL`,
+		tokenHash, expiresAt.Unix(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// consumeToken deletes the token with the given hash from table and, in the same
+// transaction, applies update to its user, which must affect the user's row.
+// Tokens are single-use: an expired token is deleted as well and fails with
+// storage.ErrTokenExpired; unknown or used ones fail with storage.ErrTokenNotFound.
+func (s *Storage) consumeToken(
+	ctx context.Context,
+	op, table string,
+	tokenHash []byte,
+	update func(tx *sql.Tx, userID int64) (sql.Result, error),
+) (userID int64, err error) {
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var expiresAt int64
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM `+table+` WHERE token_hash = ? RETURNING user_id, expires_at`, tokenHash,
+	).Scan(&userID, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.DebugContext(ctx, "token not found", slog.String("op", op))
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if time.Now().Unix() >= expiresAt {
+		s.log.DebugContext(ctx, "token expired", slog.String("op", op), slog.Int64("user_id", userID))
+		// Commit the deletion: an expired token is of no further use.
+		if err = tx.Commit(); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrTokenExpired)
+	}
+
+	res, err := update(tx.Tx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return userID, nil
+}
+
+// IdempotencyRecord returns the record stored under key, unless it was stored
+// before notBefore, i.e. has expired.
+func (s *Storage) IdempotencyRecord(ctx context.Context, key string, notBefore time.Time) (storage.IdempotencyRecord, error) {
+	const op = "storage.sqlite.IdempotencyRecord"
+
+	var record storage.IdempotencyRecord
+	err := s.conn().QueryRowContext(ctx,
+		`SELECT email, user_id FROM idempotency_keys WHERE key = ? AND created_at >= ?`, key, notBefore.Unix(),
+	).Scan(&record.Email, &record.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return record, fmt.Errorf("%s: %w", op, storage.ErrKeyNotFound)
+		}
+		return record, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return record, nil
+}
+
+// SaveIdempotencyRecord stores record under key. A live record already stored
+// under the key is kept; one stored before notBefore is replaced.
+func (s *Storage) SaveIdempotencyRecord(ctx context.Context, key string, record storage.IdempotencyRecord, notBefore time.Time) error {
+	const op = "storage.sqlite.SaveIdempotencyRecord"
+
+	_, err := s.conn().ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, email, user_id, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET email = excluded.email, user_id = excluded.user_id, created_at = excluded.created_at
+		WHERE created_at < ?`,
+		key, record.Email, record.UserID, time.Now().Unix(), notBefore.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveSession records a login, identified by the hash of the token issued for
+// it, and returns the session ID. session.ID, CreatedAt and LastUsedAt are
+// ignored; both times are set to now.
+func (s *Storage) SaveSession(ctx context.Context, session models.Session, tokenHash []byte) (int64, error) {
+	const op = "storage.sqlite.SaveSession"
+
+	now := time.Now().Unix()
+	res, err := s.conn().ExecContext(ctx,
+		`INSERT INTO sessions (user_id, app_id, token_hash, client_ip, user_agent, created_at, last_used_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.UserID, session.AppID, tokenHash, nullableString(session.ClientIP), nullableString(session.UserAgent),
+		now, now, session.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// ListSessions returns the user's active sessions, i.e. neither revoked nor
+// expired, oldest first. It reads from the primary, so a session revoked a
+// moment ago is not listed.
+func (s *Storage) ListSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "storage.sqlite.ListSessions"
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, user_id, app_id, client_ip, user_agent, created_at, last_used_at, expires_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at, id`,
+		userID, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var (
+			session                          models.Session
+			clientIP, userAgent              sql.NullString
+			createdAt, lastUsedAt, expiresAt int64
+		)
+		err = rows.Scan(&session.ID, &session.UserID, &session.AppID, &clientIP, &userAgent, &createdAt, &lastUsedAt, &expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		session.ClientIP = clientIP.String
+		session.UserAgent = userAgent.String
+		session.CreatedAt = time.Unix(createdAt, 0)
+		session.LastUsedAt = time.Unix(lastUsedAt, 0)
+		session.ExpiresAt = time.Unix(expiresAt, 0)
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends a session before its token expires. Unknown, expired and
+// already revoked sessions fail with storage.ErrSessionNotFound.
+func (s *Storage) RevokeSession(ctx context.Context, sessionID int64) error {
+	const op = "storage.sqlite.RevokeSession"
+
+	now := time.Now().Unix()
+	res, err := s.conn().ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL AND expires_at > ?`, now, sessionID, now,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+// TouchSession records a use of the token with the given hash in its session.
+// It fails with storage.ErrSessionRevoked if the session was revoked and with
+// storage.ErrSessionNotFound if the token was issued without one.
+func (s *Storage) TouchSession(ctx context.Context, tokenHash []byte) error {
+	const op = "storage.sqlite.TouchSession"
+
+	var revoked bool
+	err := s.conn().QueryRowContext(ctx, `
+		UPDATE sessions SET last_used_at = CASE WHEN revoked_at IS NULL THEN ? ELSE last_used_at END
+		WHERE token_hash = ? RETURNING revoked_at IS NOT NULL`,
+		time.Now().Unix(), tokenHash,
+	).Scan(&revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if revoked {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionRevoked)
+	}
+
+	return nil
+}
+
+// PurgeExpired deletes sessions, revoked or not, and email verification and
+// password reset tokens that expired before expiredBefore, and idempotency keys
+// stored before keysBefore. Each table is purged in its own statement, so a
+// failure leaves the tables purged before it purged.
+func (s *Storage) PurgeExpired(ctx context.Context, expiredBefore time.Time, keysBefore time.Time) (storage.PurgeResult, error) {
+	const op = "storage.sqlite.PurgeExpired"
+
+	var result storage.PurgeResult
+	for _, purge := range []struct {
+		query   string
+		before  time.Time
+		deleted *int64
+	}{
+		{`DELETE FROM sessions WHERE expires_at < ?`, expiredBefore, &result.Sessions},
+		{`DELETE FROM email_verification_tokens WHERE expires_at < ?`, expiredBefore, &result.EmailVerificationTokens},
+		{`DELETE FROM password_reset_tokens WHERE expires_at < ?`, expiredBefore, &result.PasswordResetTokens},
+		{`DELETE FROM idempotency_keys WHERE created_at < ?`, keysBefore, &result.IdempotencyKeys},
+	} {
+		res, err := s.conn().ExecContext(ctx, purge.query, purge.before.Unix())
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", op, err)
+		}
+		if *purge.deleted, err = res.RowsAffected(); err != nil {
+			return result, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return result, nil
+}
+
+// EmailExists reports whether email is taken for a user of the app, or of all
+// apps with appID 0, i.e. whether SaveUser would fail with storage.ErrUserExists.
+func (s *Storage) EmailExists(ctx context.Context, email string, appID int) (bool, error) {
+	const op = "storage.sqlite.EmailExists"
+
+	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email = ?1 AND (app_id IS ?2 OR app_id IS NULL OR ?2 IS NULL)`
+	if s.reuseDeletedEmails {
+		query += ` AND deleted_at IS NULL`
+	}
+
+	var exists bool
+	if err := s.reader().QueryRowContext(ctx, query+`)`, s.emails.Hash(email), nullableInt(appID)).Scan(&exists); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+// HasAdmin reports whether at least one admin exists. It reads from the
+// primary, since it guards writes.
+func (s *Storage) HasAdmin(ctx context.Context) (bool, error) {
+	const op = "storage.sqlite.HasAdmin"
+
+	var exists bool
+	err := s.conn().QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE is_admin = 1 AND deleted_at IS NULL)`).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exists, nil
+}
+
+// CountUsers returns the number of users, not counting soft-deleted ones.
+func (s *Storage) CountUsers(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.CountUsers"
+
+	return s.count(ctx, op, `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL`)
+}
+
+// CountAdmins returns the number of users with the admin role.
+func (s *Storage) CountAdmins(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.CountAdmins"
+
+	return s.count(ctx, op, `SELECT COUNT(*) FROM users WHERE is_admin = 1 AND deleted_at IS NULL`)
+}
+
+// CountApps returns the number of apps.
+func (s *Storage) CountApps(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.CountApps"
+
+	return s.count(ctx, op, `SELECT COUNT(*) FROM apps`)
+}
+
+func (s *Storage) count(ctx context.Context, op, query string) (int64, error) {
+	var n int64
+	if err := s.reader().QueryRowContext(ctx, query).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.sqlite.App"
+
+	stmt, err := s.reader().PrepareContext(ctx, `SELECT id, name, private_key, public_key, previous_public_key, token_ttl, bind_tokens, algorithm, issuer, extra_claims, allowed_callbacks FROM apps WHERE id = ?`)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	row := stmt.QueryRowContext(ctx, appID)
+
+	var (
+		app          models.App
+		tokenTTLSecs int64
+		extraClaims  sql.NullString
+		callbacks    sql.NullString
+	)
+	err = row.Scan(&app.ID, &app.Name, &app.PrivateKey, &app.PublicKey, &app.PreviousPublicKey, &tokenTTLSecs, &app.BindTokens, &app.Algorithm, &app.Issuer, &extraClaims, &callbacks)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return app, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		return app, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// token_ttl is stored in seconds; 0 means the service-wide default applies.
+	app.TokenTTL = time.Duration(tokenTTLSecs) * time.Second
+	if extraClaims.Valid {
+		app.ExtraClaims = json.RawMessage(extraClaims.String)
+	}
+	if app.AllowedCallbacks, err = decodeCallbacks(callbacks); err != nil {
+		return app, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// SaveApp creates an app and returns its ID; a zero app.ID picks the next free
+// one. App names are unique regardless of ASCII case: a name or ID already in
+// use fails with storage.ErrAppExists. Extra claims that are not a JSON object
+// or set a reserved claim fail with storage.ErrInvalidExtraClaims, malformed
+// allowed callbacks with storage.ErrInvalidCallbacks.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.sqlite.SaveApp"
+
+	if _, err := models.ParseExtraClaims(app.ExtraClaims); err != nil {
+		return 0, fmt.Errorf("%s: %w: %w", op, storage.ErrInvalidExtraClaims, err)
+	}
+	if err := models.ValidateCallbackPatterns(app.AllowedCallbacks); err != nil {
+		return 0, fmt.Errorf("%s: %w: %w", op, storage.ErrInvalidCallbacks, err)
+	}
+
+	var callbacks any
+	if len(app.AllowedCallbacks) > 0 {
+		raw, err := json.Marshal(app.AllowedCallbacks)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		callbacks = string(raw)
+	}
+
+	var id any
+	if app.ID != 0 {
+		id = app.ID
+	}
+
+	res, err := s.conn().ExecContext(ctx,
+		`INSERT INTO apps (id, name, private_key, public_key, previous_public_key, token_ttl, bind_tokens, algorithm, issuer, extra_claims, allowed_callbacks) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, app.Name, app.PrivateKey, app.PublicKey, app.PreviousPublicKey, int64(app.TokenTTL/time.Second), app.BindTokens, app.SigningAlgorithm(), app.Issuer, nullableJSON(app.ExtraClaims), callbacks,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	appID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(appID), nil
+}
+
+// ValidateCallback reports whether uri is one of the allowed callbacks of the
+// app; an unknown app fails with storage.ErrAppNotFound.
+func (s *Storage) ValidateCallback(ctx context.Context, appID int, uri string) (bool, error) {
+	const op = "storage.sqlite.ValidateCallback"
+
+	var callbacks sql.NullString
+	err := s.reader().QueryRowContext(ctx, `SELECT allowed_callbacks FROM apps WHERE id = ?`, appID).Scan(&callbacks)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	patterns, err := decodeCallbacks(callbacks)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.CallbackAllowed(patterns, uri), nil
+}
+
+// decodeCallbacks decodes the allowed_callbacks column; NULL allows none.
+func decodeCallbacks(raw sql.NullString) ([]string, error) {
+	if !raw.Valid {
+		return nil, nil
+	}
+
+	var callbacks []string
+	if err := json.Unmarshal([]byte(raw.String), &callbacks); err != nil {
+		return nil, fmt.Errorf("decode allowed callbacks: %w", err)
+	}
+
+	return callbacks, nil
 }
@@ -0,0 +1,1474 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/emailhash"
+	"sso/internal/lib/logger"
+	"sso/internal/storage"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const migrationsPath = "../../../migrations"
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s, err := New(newTestDB(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+// newTestDB creates a migrated database file and returns its path.
+func newTestDB(t *testing.T) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://"+migrationsPath, "sqlite3://"+dbPath)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	srcErr, dbErr := m.Close()
+	require.NoError(t, srcErr)
+	require.NoError(t, dbErr)
+
+	return dbPath
+}
+
+func userImports(emails ...string) []storage.UserImport {
+	users := make([]storage.UserImport, 0, len(emails))
+	for _, email := range emails {
+		users = append(users, storage.UserImport{
+			Email:        email,
+			PasswordHash: []byte("hash"),
+			PasswordSalt: []byte("salt"),
+		})
+	}
+
+	return users
+}
+
+func TestSaveUsers(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	results, err := s.SaveUsers(ctx, userImports("a@example.com", "b@example.com"), false)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for i, result := range results {
+		assert.Equal(t, i, result.Index)
+		assert.NotZero(t, result.UserID)
+		assert.NoError(t, result.Err)
+	}
+
+	user, err := s.User(ctx, "b@example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, results[1].UserID, user.ID)
+}
+
+func TestSaveUsers_ConflictRollsBack(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	_, err := s.SaveUser(ctx, "taken@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	_, err = s.SaveUsers(ctx, userImports("new@example.com", "taken@example.com"), false)
+	require.ErrorIs(t, err, storage.ErrUserExists)
+
+	_, err = s.User(ctx, "new@example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestSaveUsers_ReportsFailingRows(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	_, err := s.SaveUser(ctx, "taken@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	batch := userImports("a@example.com", "taken@example.com", "b@example.com", "a@example.com")
+	batch[2].PasswordHash = nil
+
+	results, err := s.SaveUsers(ctx, batch, false)
+	require.ErrorIs(t, err, storage.ErrUserExists, "the error wraps the first failure")
+	assert.ErrorContains(t, err, "3 of 4 rows failed, row 1:")
+	require.Len(t, results, 4, "results are reported for the failed batch")
+
+	assert.Equal(t, storage.ImportResult{Index: 0, Err: storage.ErrBatchRolledBack}, results[0], "a valid row is rolled back")
+	assert.Equal(t, storage.ImportResult{Index: 1, Err: storage.ErrUserExists}, results[1])
+	assert.Equal(t, 2, results[2].Index)
+	assert.ErrorContains(t, results[2].Err, "NOT NULL constraint failed: users.password_hash")
+	assert.Equal(t, storage.ImportResult{Index: 3, Err: storage.ErrUserExists}, results[3], "a duplicate within the batch")
+
+	n, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "nothing of the batch is saved")
+}
+
+func TestSaveUsers_SkipExisting(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	takenID, err := s.SaveUser(ctx, "taken@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	results, err := s.SaveUsers(ctx, userImports("new@example.com", "taken@example.com"), true)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.NotZero(t, results[0].UserID)
+	assert.Equal(t, storage.ImportResult{Index: 1, Skipped: true}, results[1])
+
+	existing, err := s.User(ctx, "taken@example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, takenID, existing.ID)
+}
+
+func TestStats_ReflectsInUseConnections(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	const concurrent = 3
+
+	// Each held connection is pinned out of the pool.
+	conns := make([]*sql.Conn, 0, concurrent)
+	for i := 0; i < concurrent; i++ {
+		conn, err := s.primary().Conn(ctx)
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+
+	stats := s.Stats()
+	assert.Equal(t, concurrent, stats.InUse)
+	assert.GreaterOrEqual(t, stats.OpenConnections, concurrent)
+
+	for _, conn := range conns {
+		require.NoError(t, conn.Close())
+	}
+
+	stats = s.Stats()
+	assert.Zero(t, stats.InUse)
+	assert.Equal(t, stats.OpenConnections, stats.Idle)
+}
+
+func TestNewWithOptions_RoutesReadsToReplica(t *testing.T) {
+	primaryPath, replicaPath := newTestDB(t), newTestDB(t)
+
+	s, err := NewWithOptions(primaryPath, Options{ReplicaPath: replicaPath})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	// Writes land on the primary only.
+	id, err := s.SaveUser(ctx, "primary@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, s.primary().QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id = ?`, id).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	_, err = s.User(ctx, "primary@example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "reads must not hit the primary")
+
+	// Reads come from the replica.
+	replica, err := New(replicaPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = replica.Close() })
+	_, err = replica.SaveUser(ctx, "replica@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, "replica@example.com", 0)
+	require.NoError(t, err)
+	_, err = s.IsAdmin(ctx, user.ID)
+	assert.NoError(t, err)
+
+	_, err = s.replica().ExecContext(ctx, `DELETE FROM users`)
+	assert.Error(t, err, "replica pool must be read-only")
+}
+
+func TestNew_WithoutReplicaReadsPrimary(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	_, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	_, err = s.User(ctx, "user@example.com", 0)
+	assert.NoError(t, err)
+}
+
+func TestNewWithOptions_AppliesPoolSettings(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{
+		MaxOpenConns:    3,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	assert.Equal(t, 3, s.Stats().MaxOpenConnections)
+
+	conns := make([]*sql.Conn, 0, 3)
+	for range 3 {
+		conn, err := s.primary().Conn(ctx)
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		require.NoError(t, conn.Close())
+	}
+
+	stats := s.Stats()
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, int64(2), stats.MaxIdleClosed)
+
+	// The idle connection outlives ConnMaxLifetime and is replaced on next use.
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, s.primary().PingContext(ctx))
+	assert.Positive(t, s.Stats().MaxLifetimeClosed)
+}
+
+func TestNewWithOptions_ZeroUsesDefaults(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	assert.Equal(t, DefaultOptions().MaxOpenConns, s.Stats().MaxOpenConnections)
+}
+
+func TestCounts(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	counts := func() (users, admins, apps int64) {
+		t.Helper()
+
+		var err error
+		users, err = s.CountUsers(ctx)
+		require.NoError(t, err)
+		admins, err = s.CountAdmins(ctx)
+		require.NoError(t, err)
+		apps, err = s.CountApps(ctx)
+		require.NoError(t, err)
+
+		return users, admins, apps
+	}
+
+	users, admins, apps := counts()
+	assert.Zero(t, users)
+	assert.Zero(t, admins)
+	assert.Zero(t, apps)
+
+	_, err := s.SaveUsers(ctx, userImports("a@example.com", "b@example.com", "c@example.com"), false)
+	require.NoError(t, err)
+	user, err := s.User(ctx, "b@example.com", 0)
+	require.NoError(t, err)
+	require.NoError(t, s.SetAdmin(ctx, user.ID, true))
+	_, err = s.primary().ExecContext(ctx, `INSERT INTO apps (id, name, private_key, public_key) VALUES (1, 'a', '', ''), (2, 'b', '', '')`)
+	require.NoError(t, err)
+
+	users, admins, apps = counts()
+	assert.Equal(t, int64(3), users)
+	assert.Equal(t, int64(1), admins)
+	assert.Equal(t, int64(2), apps)
+}
+
+func TestDeleteUser_FiltersReads(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, s.SetAdmin(ctx, id, true))
+
+	require.NoError(t, s.DeleteUser(ctx, id))
+	assert.ErrorIs(t, s.DeleteUser(ctx, id), storage.ErrUserNotFound, "already deleted")
+
+	_, err = s.User(ctx, "user@example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	_, err = s.UserByID(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	_, err = s.IsAdmin(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	hasAdmin, err := s.HasAdmin(ctx)
+	require.NoError(t, err)
+	assert.False(t, hasAdmin)
+	users, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, users)
+
+	// The row is kept.
+	var deletedAt int64
+	require.NoError(t, s.primary().QueryRowContext(ctx, `SELECT deleted_at FROM users WHERE id = ?`, id).Scan(&deletedAt))
+	assert.NotZero(t, deletedAt)
+}
+
+func TestRestoreUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.RestoreUser(ctx, id), storage.ErrUserNotFound, "not deleted")
+
+	require.NoError(t, s.DeleteUser(ctx, id))
+	require.NoError(t, s.RestoreUser(ctx, id))
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", user.Email)
+}
+
+func TestSaveUser_DeletedEmailReserved(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteUser(ctx, id))
+
+	_, err = s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	_, err = s.SaveUsers(ctx, userImports("user@example.com"), false)
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	results, err := s.SaveUsers(ctx, userImports("user@example.com"), true)
+	require.NoError(t, err)
+	assert.True(t, results[0].Skipped)
+	assert.Zero(t, results[0].UserID)
+}
+
+func TestSaveUser_EmailPerApp(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	save := func(email string, appID int) (int64, error) {
+		return s.SaveUser(ctx, email, []byte("hash"), []byte("salt"), 0, storage.Profile{AppID: appID})
+	}
+
+	firstID, err := save("user@example.com", 1)
+	require.NoError(t, err)
+	secondID, err := save("user@example.com", 2)
+	require.NoError(t, err, "the same email registers independently in another app")
+
+	_, err = save("user@example.com", 1)
+	assert.ErrorIs(t, err, storage.ErrUserExists, "but collides within one")
+	_, err = save("user@example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrUserExists, "a user of all apps collides with users of any app")
+
+	user, err := s.User(ctx, "user@example.com", 1)
+	require.NoError(t, err)
+	assert.Equal(t, firstID, user.ID)
+	user, err = s.User(ctx, "user@example.com", 2)
+	require.NoError(t, err)
+	assert.Equal(t, secondID, user.ID)
+	_, err = s.User(ctx, "user@example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	_, err = s.User(ctx, "user@example.com", 3)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	globalID, err := save("global@example.com", 0)
+	require.NoError(t, err)
+	_, err = save("global@example.com", 1)
+	assert.ErrorIs(t, err, storage.ErrUserExists, "and the other way round")
+	for _, appID := range []int{0, 1, 3} {
+		user, err := s.User(ctx, "global@example.com", appID)
+		require.NoError(t, err, appID)
+		assert.Equal(t, globalID, user.ID, "users of all apps are found from any app")
+	}
+
+	// Soft-deleted users reserve their email in their own app only.
+	require.NoError(t, s.DeleteUser(ctx, firstID))
+	_, err = save("user@example.com", 1)
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+	_, err = save("user@example.com", 3)
+	assert.NoError(t, err)
+}
+
+func TestEmailHashing(t *testing.T) {
+	emails, err := emailhash.New(strings.Repeat("k", emailhash.MinKeyLength))
+	require.NoError(t, err)
+	opts := DefaultOptions()
+	opts.EmailHasher = emails
+	s, err := NewWithOptions(newTestDB(t), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	userID, err := s.SaveUser(ctx, "Jane.Doe@Example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	_, err = s.SaveUsers(ctx, []storage.UserImport{{Email: "imported@example.com", PasswordHash: []byte("hash"), PasswordSalt: []byte("salt")}}, false)
+	require.NoError(t, err)
+
+	var stored []string
+	rows, err := s.primary().QueryContext(ctx, `SELECT email FROM users`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var email string
+		require.NoError(t, rows.Scan(&email))
+		stored = append(stored, email)
+	}
+	require.NoError(t, rows.Err())
+	require.Len(t, stored, 2)
+	for _, email := range stored {
+		assert.NotContains(t, email, "@", "no plaintext email is stored")
+	}
+
+	user, err := s.User(ctx, " jane.doe@example.COM", 0)
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.ID)
+	assert.Equal(t, "jane.doe@example.com", user.Email, "the normalized email looked up")
+
+	exists, err := s.EmailExists(ctx, "JANE.DOE@example.com", 0)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	_, err = s.SaveUser(ctx, "jane.doe@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	byID, err := s.UserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, byID.Email, "the email can't be recovered from its hash")
+}
+
+func TestUser_EmailLookupUsesIndex(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	emails := make([]string, 5000)
+	for i := range emails {
+		emails[i] = fmt.Sprintf("user%d@example.com", i)
+	}
+	results, err := s.SaveUsers(ctx, userImports(emails...), false)
+	require.NoError(t, err)
+	_, err = s.conn().ExecContext(ctx, `ANALYZE`)
+	require.NoError(t, err)
+
+	rows, err := s.reader().QueryContext(ctx, `EXPLAIN QUERY PLAN `+userQuery(userByEmail), "user4321@example.com", 1)
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		require.NoError(t, rows.Scan(&id, &parent, &unused, &detail))
+		plan = append(plan, detail)
+	}
+	require.NoError(t, rows.Err())
+	require.Len(t, plan, 1, "%q", plan)
+	assert.Regexp(t, `^SEARCH users USING (COVERING )?INDEX idx_users_\w+ \(`, plan[0], "the login lookup must not scan users")
+
+	user, err := s.User(ctx, "user4321@example.com", 1)
+	require.NoError(t, err)
+	assert.Equal(t, results[4321].UserID, user.ID)
+}
+
+func TestEmailExists(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reuse deleted emails %t", reuse), func(t *testing.T) {
+			s, err := NewWithOptions(newTestDB(t), Options{ReuseDeletedEmails: reuse})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = s.Close() })
+			ctx := context.Background()
+
+			_, err = s.SaveUser(ctx, "app@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{AppID: 1})
+			require.NoError(t, err)
+			_, err = s.SaveUser(ctx, "global@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+			require.NoError(t, err)
+			deletedID, err := s.SaveUser(ctx, "deleted@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{AppID: 1})
+			require.NoError(t, err)
+			require.NoError(t, s.DeleteUser(ctx, deletedID))
+
+			for _, tc := range []struct {
+				email string
+				appID int
+				want  bool
+			}{
+				{email: "app@example.com", appID: 1, want: true},
+				{email: "app@example.com", appID: 2, want: false},
+				{email: "app@example.com", appID: 0, want: true},
+				{email: "global@example.com", appID: 1, want: true},
+				{email: "global@example.com", appID: 0, want: true},
+				{email: "nobody@example.com", appID: 1, want: false},
+				{email: "APP@example.com", appID: 1, want: false},
+				{email: "deleted@example.com", appID: 1, want: !reuse},
+			} {
+				exists, err := s.EmailExists(ctx, tc.email, tc.appID)
+				require.NoError(t, err)
+				assert.Equal(t, tc.want, exists, "%s in app %d", tc.email, tc.appID)
+
+				_, err = s.SaveUser(ctx, tc.email, []byte("hash"), []byte("salt"), 0, storage.Profile{AppID: tc.appID})
+				if tc.want {
+					assert.ErrorIs(t, err, storage.ErrUserExists, "%s in app %d", tc.email, tc.appID)
+				} else {
+					assert.NoError(t, err, "%s in app %d", tc.email, tc.appID)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreUser_EmailTakenInAnotherNamespace(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{ReuseDeletedEmails: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	appUserID, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{AppID: 1})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteUser(ctx, appUserID))
+	_, err = s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.RestoreUser(ctx, appUserID), storage.ErrUserExists)
+	assert.ErrorIs(t, s.RestoreUser(ctx, 9999), storage.ErrUserNotFound)
+}
+
+func TestSaveUser_ReuseDeletedEmails(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{ReuseDeletedEmails: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	oldID, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteUser(ctx, oldID))
+
+	newID, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	assert.NotEqual(t, oldID, newID)
+
+	_, err = s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	assert.ErrorIs(t, err, storage.ErrUserExists, "active users still have unique emails")
+
+	// The old account cannot come back while its email is taken.
+	assert.ErrorIs(t, s.RestoreUser(ctx, oldID), storage.ErrUserExists)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	require.False(t, user.EmailVerified, "new users are unverified")
+
+	require.NoError(t, s.SaveEmailVerificationToken(ctx, id, []byte("old"), time.Now().Add(time.Hour)))
+	require.NoError(t, s.SaveEmailVerificationToken(ctx, id, []byte("new"), time.Now().Add(time.Hour)))
+
+	_, err = s.VerifyEmail(ctx, []byte("old"))
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound, "superseded by the newer token")
+
+	verifiedID, err := s.VerifyEmail(ctx, []byte("new"))
+	require.NoError(t, err)
+	assert.Equal(t, id, verifiedID)
+
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+
+	_, err = s.VerifyEmail(ctx, []byte("new"))
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound, "tokens are single-use")
+}
+
+func TestVerifyEmail_Expired(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmailVerificationToken(ctx, id, []byte("token"), time.Now().Add(-time.Second)))
+
+	_, err = s.VerifyEmail(ctx, []byte("token"))
+	assert.ErrorIs(t, err, storage.ErrTokenExpired)
+
+	_, err = s.VerifyEmail(ctx, []byte("token"))
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound, "expired tokens are consumed too")
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, user.EmailVerified)
+}
+
+func TestSaveEmailVerificationToken_UserNotFound(t *testing.T) {
+	s := newTestStorage(t)
+
+	err := s.SaveEmailVerificationToken(context.Background(), 42, []byte("token"), time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestSetDisabled(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetDisabled(ctx, id, true))
+	user, err := s.User(ctx, "user@example.com", 0)
+	require.NoError(t, err, "disabled users are still found")
+	assert.True(t, user.Disabled)
+
+	require.NoError(t, s.SetDisabled(ctx, id, false))
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, user.Disabled)
+
+	assert.ErrorIs(t, s.SetDisabled(ctx, 42, true), storage.ErrUserNotFound)
+}
+
+func TestResetPassword(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, s.SavePasswordResetToken(ctx, id, []byte("token"), time.Now().Add(time.Hour)))
+
+	resetID, err := s.ResetPassword(ctx, []byte("token"), []byte("new-hash"), []byte("new-salt"), 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, id, resetID)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new-hash"), user.PasswordHash)
+	assert.Equal(t, []byte("new-salt"), user.PasswordSalt)
+	assert.Equal(t, 1, user.PepperVersion)
+
+	_, err = s.ResetPassword(ctx, []byte("token"), []byte("other"), []byte("other"), 0, 0)
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound, "tokens are single-use")
+}
+
+func TestPasswordHistory(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash-0"), []byte("salt-0"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, s.SavePasswordResetToken(ctx, id, []byte("token"), time.Now().Add(time.Hour)))
+
+		userID, err := s.PasswordResetUser(ctx, []byte("token"))
+		require.NoError(t, err)
+		assert.Equal(t, id, userID)
+
+		_, err = s.ResetPassword(ctx, []byte("token"), fmt.Appendf(nil, "hash-%d", i), fmt.Appendf(nil, "salt-%d", i), i, 2)
+		require.NoError(t, err)
+	}
+
+	history, err := s.PasswordHistory(ctx, id, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 3, "the current password and the two kept")
+	assert.Equal(t, storage.PasswordRecord{Hash: []byte("hash-3"), Salt: []byte("salt-3"), PepperVersion: 3}, history[0])
+	assert.Equal(t, []byte("hash-2"), history[1].Hash)
+	assert.Equal(t, []byte("hash-1"), history[2].Hash)
+
+	history, err = s.PasswordHistory(ctx, id, 2)
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	_, err = s.PasswordHistory(ctx, 42, 2)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, user.PasswordChangedAt.IsZero(), "not changed since registration")
+
+	err = s.ChangePassword(ctx, id, []byte("other-hash"), []byte("new-hash"), []byte("new-salt"), 0, 1)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "the stored hash must still be the old one")
+
+	require.NoError(t, s.ChangePassword(ctx, id, []byte("hash"), []byte("new-hash"), []byte("new-salt"), 1, 1))
+
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new-hash"), user.PasswordHash)
+	assert.WithinDuration(t, time.Now(), user.PasswordChangedAt, 2*time.Second)
+
+	history, err := s.PasswordHistory(ctx, id, 2)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, []byte("hash"), history[1].Hash)
+}
+
+func TestPasswordResetUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	_, err = s.PasswordResetUser(ctx, []byte("token"))
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	require.NoError(t, s.SavePasswordResetToken(ctx, id, []byte("token"), time.Now().Add(-time.Second)))
+	_, err = s.PasswordResetUser(ctx, []byte("token"))
+	assert.ErrorIs(t, err, storage.ErrTokenExpired)
+}
+
+func TestResetPassword_Expired(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, s.SavePasswordResetToken(ctx, id, []byte("token"), time.Now().Add(-time.Second)))
+
+	_, err = s.ResetPassword(ctx, []byte("token"), []byte("new-hash"), []byte("new-salt"), 0, 0)
+	assert.ErrorIs(t, err, storage.ErrTokenExpired)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hash"), user.PasswordHash)
+}
+
+func TestIdempotencyRecord(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	record := storage.IdempotencyRecord{Email: "user@example.com", UserID: 7}
+
+	_, err := s.IdempotencyRecord(ctx, "key", time.Time{})
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	require.NoError(t, s.SaveIdempotencyRecord(ctx, "key", record, time.Time{}))
+
+	got, err := s.IdempotencyRecord(ctx, "key", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+
+	// A live record is not overwritten.
+	require.NoError(t, s.SaveIdempotencyRecord(ctx, "key", storage.IdempotencyRecord{Email: "other@example.com", UserID: 8}, time.Now().Add(-time.Hour)))
+	got, err = s.IdempotencyRecord(ctx, "key", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+
+	// Once expired, it is not found and can be replaced.
+	future := time.Now().Add(time.Hour)
+	_, err = s.IdempotencyRecord(ctx, "key", future)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	replacement := storage.IdempotencyRecord{Email: "other@example.com", UserID: 8}
+	require.NoError(t, s.SaveIdempotencyRecord(ctx, "key", replacement, future))
+	got, err = s.IdempotencyRecord(ctx, "key", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, replacement, got)
+}
+
+func TestSaveApp(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveApp(ctx, models.App{Name: "mobile", PrivateKey: "private", PublicKey: "public", TokenTTL: time.Hour, BindTokens: true, Issuer: "https://acme.example.com"})
+	require.NoError(t, err)
+
+	app, err := s.App(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.App{ID: id, Name: "mobile", PrivateKey: "private", PublicKey: "public", TokenTTL: time.Hour, BindTokens: true, Algorithm: models.AlgRS256, Issuer: "https://acme.example.com"}, app)
+
+	other, err := s.SaveApp(ctx, models.App{Name: "web", Algorithm: models.AlgEdDSA})
+	require.NoError(t, err)
+	assert.NotEqual(t, id, other)
+	app, err = s.App(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, models.AlgEdDSA, app.Algorithm)
+
+	_, err = s.SaveApp(ctx, models.App{ID: id, Name: "desktop"})
+	assert.ErrorIs(t, err, storage.ErrAppExists, "duplicate id")
+}
+
+func TestSaveApp_ExtraClaims(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveApp(ctx, models.App{Name: "mobile", ExtraClaims: []byte(`{"tenant":"acme"}`)})
+	require.NoError(t, err)
+	app, err := s.App(ctx, id)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tenant":"acme"}`, string(app.ExtraClaims))
+
+	for _, extra := range []string{`{"uid": 1}`, `{"aud": "other"}`, `"acme"`, `{"tenant":`} {
+		_, err = s.SaveApp(ctx, models.App{Name: "web", ExtraClaims: []byte(extra)})
+		assert.ErrorIs(t, err, storage.ErrInvalidExtraClaims, extra)
+	}
+
+	// Apps written around SaveApp are held to a JSON object by the schema.
+	_, err = s.primary().ExecContext(ctx, `UPDATE apps SET extra_claims = '[1]' WHERE id = ?`, id)
+	assert.Error(t, err)
+}
+
+func TestValidateCallback(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveApp(ctx, models.App{Name: "web", AllowedCallbacks: []string{
+		"https://app.example.com/callback",
+		"https://admin.example.com/oauth/*",
+	}})
+	require.NoError(t, err)
+	app, err := s.App(ctx, id)
+	require.NoError(t, err)
+	assert.Len(t, app.AllowedCallbacks, 2)
+
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"https://app.example.com/callback/extra", false},
+		{"https://app.example.com/other", false},
+		{"http://app.example.com/callback", false},
+		{"https://admin.example.com/oauth/", true},
+		{"https://admin.example.com/oauth/done?state=1", true},
+		{"https://admin.example.com/other", false},
+		{"https://evil.example.net/callback", false},
+	}
+	for _, tt := range tests {
+		ok, err := s.ValidateCallback(ctx, id, tt.uri)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, ok, tt.uri)
+	}
+
+	// Apps without callbacks allow none.
+	noneID, err := s.SaveApp(ctx, models.App{Name: "mobile"})
+	require.NoError(t, err)
+	ok, err := s.ValidateCallback(ctx, noneID, "https://app.example.com/callback")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = s.ValidateCallback(ctx, 999, "https://app.example.com/callback")
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+}
+
+func TestSaveApp_InvalidCallbacks(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	for _, callback := range []string{"/callback", "example.com/callback", "https://example.com*", "https://*.example.com/", ""} {
+		_, err := s.SaveApp(ctx, models.App{Name: "web", AllowedCallbacks: []string{callback}})
+		assert.ErrorIs(t, err, storage.ErrInvalidCallbacks, callback)
+	}
+}
+
+func TestSaveApp_DuplicateName(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	_, err := s.SaveApp(ctx, models.App{Name: "mobile"})
+	require.NoError(t, err)
+
+	for _, name := range []string{"mobile", "Mobile", "MOBILE"} {
+		_, err = s.SaveApp(ctx, models.App{Name: name})
+		assert.ErrorIs(t, err, storage.ErrAppExists, name)
+	}
+
+	apps, err := s.CountApps(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), apps)
+}
+
+func TestStorageLogs_IncludeOriginatingOp(t *testing.T) {
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Log = slog.New(logger.NewContextHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	s, err := NewWithOptions(newTestDB(t), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	ctx := logger.WithOp(logger.WithOp(context.Background(), "/auth.Auth/Register"), "Auth.Register")
+	_, err = s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	_, err = s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.ErrorIs(t, err, storage.ErrUserExists)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "storage.sqlite.SaveUser", record["op"])
+	assert.Equal(t, "/auth.Auth/Register > Auth.Register", record[logger.OpChainKey])
+}
+
+func TestPing(t *testing.T) {
+	s, err := New(newTestDB(t))
+	require.NoError(t, err)
+	require.NoError(t, s.Ping(context.Background()))
+
+	require.NoError(t, s.Close())
+	assert.Error(t, s.Ping(context.Background()))
+}
+
+func TestDiagnostics(t *testing.T) {
+	s := newTestStorage(t)
+
+	report, err := s.Diagnostics(context.Background())
+	require.NoError(t, err)
+
+	assert.True(t, report.OK)
+	assert.Empty(t, report.Problems)
+	assert.Positive(t, report.PageCount)
+	assert.GreaterOrEqual(t, report.FreePages, int64(0))
+	assert.LessOrEqual(t, report.FreePages, report.PageCount)
+}
+
+func TestNew_CreatesMissingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "data")
+	dbPath := filepath.Join(dir, "sso.db")
+
+	s, err := New(dbPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	assert.FileExists(t, dbPath)
+
+	// An existing directory is reused as is.
+	again, err := New(filepath.Join(dir, "other.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = again.Close() })
+}
+
+func TestNew_ExpandsPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSO_DB_DIR", "sso-data")
+
+	s, err := New("~/$SSO_DB_DIR/sso.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	assert.FileExists(t, filepath.Join(home, "sso-data", "sso.db"))
+}
+
+func TestSaveUser_Profile(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	profile := storage.Profile{DisplayName: "Ada", Metadata: json.RawMessage(`{"plan":"pro"}`)}
+	id, err := s.SaveUser(ctx, "ada@example.com", []byte("hash"), []byte("salt"), 0, profile)
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Ada", user.DisplayName)
+	assert.JSONEq(t, `{"plan":"pro"}`, string(user.Metadata))
+
+	_, err = s.SaveUser(ctx, "plain@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	user, err = s.User(ctx, "plain@example.com", 0)
+	require.NoError(t, err)
+	assert.Empty(t, user.DisplayName)
+	assert.Nil(t, user.Metadata)
+}
+
+func TestSaveUser_AppID(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "app@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{AppID: 3})
+	require.NoError(t, err)
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, 3, user.AppID)
+
+	_, err = s.SaveUser(ctx, "global@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	var appID sql.NullInt64
+	require.NoError(t, s.primary().QueryRowContext(ctx, `SELECT app_id FROM users WHERE email = ?`, "global@example.com").Scan(&appID))
+	assert.False(t, appID.Valid, "users of all apps have no app_id")
+}
+
+func TestBuildDSN(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts Options
+		want string
+	}{
+		"defaults": {
+			opts: DefaultOptions(),
+			want: "sso.db?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_foreign_keys=ON",
+		},
+		"network filesystem": {
+			opts: Options{JournalMode: "delete", BusyTimeout: 30 * time.Second, Synchronous: "full", DisableForeignKeys: true},
+			want: "sso.db?_journal_mode=DELETE&_busy_timeout=30000&_synchronous=FULL&_foreign_keys=OFF",
+		},
+		"zero values use defaults": {
+			opts: Options{}.withDefaults(),
+			want: "sso.db?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_foreign_keys=ON",
+		},
+		"negative busy timeout fails at once": {
+			opts: Options{BusyTimeout: -1}.withDefaults(),
+			want: "sso.db?_journal_mode=WAL&_busy_timeout=0&_synchronous=NORMAL&_foreign_keys=ON",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := buildDSN("sso.db", "", tc.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	got, err := buildDSN("replica.db", "&_query_only=1", DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, "replica.db?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_foreign_keys=ON&_query_only=1", got)
+}
+
+func TestBuildDSN_Invalid(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"journal mode":    {JournalMode: "WAL2", BusyTimeout: time.Second, Synchronous: "NORMAL"},
+		"synchronous":     {JournalMode: "WAL", BusyTimeout: time.Second, Synchronous: "SOMETIMES"},
+		"injected pragma": {JournalMode: "WAL&_foreign_keys=OFF", BusyTimeout: time.Second, Synchronous: "NORMAL"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := buildDSN("sso.db", "", opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewWithOptions_NoIdleConns(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{MaxIdleConns: -1})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	require.NoError(t, s.primary().PingContext(context.Background()))
+	assert.Zero(t, s.Stats().Idle, "connections are closed once released")
+}
+
+func TestNewWithOptions_JournalMode(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{JournalMode: "DELETE"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	var mode string
+	require.NoError(t, s.primary().QueryRow(`PRAGMA journal_mode`).Scan(&mode))
+	assert.Equal(t, "delete", mode)
+}
+
+func TestSessions_ListAndRevoke(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	live := time.Now().Add(time.Hour)
+	first, err := s.SaveSession(ctx, models.Session{UserID: id, AppID: 1, ClientIP: "192.0.2.1", ExpiresAt: live}, []byte("first"))
+	require.NoError(t, err)
+	second, err := s.SaveSession(ctx, models.Session{UserID: id, AppID: 2, ExpiresAt: live}, []byte("second"))
+	require.NoError(t, err)
+	_, err = s.SaveSession(ctx, models.Session{UserID: id, AppID: 1, ExpiresAt: time.Now().Add(-time.Second)}, []byte("expired"))
+	require.NoError(t, err)
+
+	sessions, err := s.ListSessions(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2, "expired sessions are not listed")
+	assert.Equal(t, first, sessions[0].ID)
+	assert.Equal(t, "192.0.2.1", sessions[0].ClientIP)
+	assert.Equal(t, second, sessions[1].ID)
+	assert.Equal(t, 2, sessions[1].AppID)
+	assert.Empty(t, sessions[1].ClientIP)
+	assert.Equal(t, live.Unix(), sessions[1].ExpiresAt.Unix())
+
+	require.NoError(t, s.RevokeSession(ctx, first))
+	assert.ErrorIs(t, s.RevokeSession(ctx, first), storage.ErrSessionNotFound)
+
+	sessions, err = s.ListSessions(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, second, sessions[0].ID)
+
+	assert.ErrorIs(t, s.TouchSession(ctx, []byte("first")), storage.ErrSessionRevoked)
+	assert.NoError(t, s.TouchSession(ctx, []byte("second")))
+	assert.ErrorIs(t, s.TouchSession(ctx, []byte("unknown")), storage.ErrSessionNotFound)
+}
+
+func TestPurgeExpired(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	live, err := s.SaveUser(ctx, "live@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	expired, err := s.SaveUser(ctx, "expired@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	_, err = s.SaveSession(ctx, models.Session{UserID: live, AppID: 1, ExpiresAt: now.Add(time.Hour)}, []byte("live"))
+	require.NoError(t, err)
+	_, err = s.SaveSession(ctx, models.Session{UserID: live, AppID: 1, ExpiresAt: now.Add(-time.Hour)}, []byte("expired"))
+	require.NoError(t, err)
+	revoked, err := s.SaveSession(ctx, models.Session{UserID: live, AppID: 1, ExpiresAt: now.Add(-time.Hour)}, []byte("revoked"))
+	require.NoError(t, err)
+	_, err = s.primary().ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE id = ?`, now.Unix(), revoked)
+	require.NoError(t, err)
+
+	require.NoError(t, s.SaveEmailVerificationToken(ctx, live, []byte("live"), now.Add(time.Hour)))
+	require.NoError(t, s.SaveEmailVerificationToken(ctx, expired, []byte("expired"), now.Add(-time.Hour)))
+	require.NoError(t, s.SavePasswordResetToken(ctx, live, []byte("live"), now.Add(time.Hour)))
+	require.NoError(t, s.SavePasswordResetToken(ctx, expired, []byte("expired"), now.Add(-time.Hour)))
+
+	require.NoError(t, s.SaveIdempotencyRecord(ctx, "live", storage.IdempotencyRecord{Email: "live@example.com", UserID: live}, now.Add(-time.Hour)))
+	require.NoError(t, s.SaveIdempotencyRecord(ctx, "old", storage.IdempotencyRecord{Email: "expired@example.com", UserID: expired}, now.Add(-time.Hour)))
+	_, err = s.primary().ExecContext(ctx, `UPDATE idempotency_keys SET created_at = ? WHERE key = 'old'`, now.Add(-48*time.Hour).Unix())
+	require.NoError(t, err)
+
+	result, err := s.PurgeExpired(ctx, now, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, storage.PurgeResult{
+		Sessions:                2,
+		EmailVerificationTokens: 1,
+		PasswordResetTokens:     1,
+		IdempotencyKeys:         1,
+	}, result)
+	assert.Equal(t, int64(5), result.Total())
+
+	assert.NoError(t, s.TouchSession(ctx, []byte("live")), "live sessions are kept")
+	assert.ErrorIs(t, s.TouchSession(ctx, []byte("expired")), storage.ErrSessionNotFound)
+	verified, err := s.VerifyEmail(ctx, []byte("live"))
+	require.NoError(t, err, "live tokens are kept")
+	assert.Equal(t, live, verified)
+	_, err = s.IdempotencyRecord(ctx, "live", now.Add(-24*time.Hour))
+	assert.NoError(t, err, "recent idempotency keys are kept")
+
+	result, err = s.PurgeExpired(ctx, now, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, result.Total(), "nothing left to purge")
+}
+
+func TestSaveSession_UserNotFound(t *testing.T) {
+	s := newTestStorage(t)
+
+	_, err := s.SaveSession(context.Background(), models.Session{UserID: 42, AppID: 1, ExpiresAt: time.Now().Add(time.Hour)}, []byte("token"))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestSaveSession_UserAgent(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	withUA, err := s.SaveSession(ctx, models.Session{UserID: id, AppID: 1, UserAgent: "app/1.0 grpc-go/1.70.0", ExpiresAt: expiresAt}, []byte("a"))
+	require.NoError(t, err)
+	withoutUA, err := s.SaveSession(ctx, models.Session{UserID: id, AppID: 1, ExpiresAt: expiresAt}, []byte("b"))
+	require.NoError(t, err)
+
+	sessions, err := s.ListSessions(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "app/1.0 grpc-go/1.70.0", sessions[0].UserAgent)
+	assert.Empty(t, sessions[1].UserAgent)
+
+	userAgent := func(sessionID int64) sql.NullString {
+		var ua sql.NullString
+		require.NoError(t, s.primary().QueryRowContext(ctx, `SELECT user_agent FROM sessions WHERE id = ?`, sessionID).Scan(&ua))
+		return ua
+	}
+	assert.Equal(t, sql.NullString{String: "app/1.0 grpc-go/1.70.0", Valid: true}, userAgent(withUA))
+	assert.False(t, userAgent(withoutUA).Valid, "stored as NULL")
+}
+
+func TestWithTx(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	var userID int64
+	err := s.WithTx(ctx, func(tx storage.TxStorage) error {
+		var err error
+		userID, err = tx.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+		if err != nil {
+			return err
+		}
+
+		user, err := tx.User(ctx, "user@example.com", 0)
+		require.NoError(t, err, "reads see the transaction's writes")
+		assert.Equal(t, userID, user.ID)
+
+		return tx.SaveIdempotencyRecord(ctx, "key", storage.IdempotencyRecord{Email: "user@example.com", UserID: userID}, time.Now())
+	})
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, "user@example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.ID)
+}
+
+func TestWithTx_RollsBackOnError(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	err := s.WithTx(ctx, func(tx storage.TxStorage) error {
+		userID, err := tx.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+		require.NoError(t, err)
+
+		record := storage.IdempotencyRecord{Email: "user@example.com", UserID: userID}
+		require.NoError(t, tx.SaveIdempotencyRecord(ctx, "key", record, time.Now()))
+		_, err = tx.SaveSession(ctx, models.Session{UserID: userID, AppID: 1, ExpiresAt: time.Now().Add(time.Hour)}, []byte("token"))
+		require.NoError(t, err)
+
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	_, err = s.User(ctx, "user@example.com", 0)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	_, err = s.IdempotencyRecord(ctx, "key", time.Time{})
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	n, err := s.count(ctx, "test", `SELECT COUNT(*) FROM sessions`)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestWithTx_NestedMethodsJoin(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	err := s.WithTx(ctx, func(tx storage.TxStorage) error {
+		// SaveUsers runs its own transaction outside WithTx.
+		_, err := tx.(*Storage).SaveUsers(ctx, userImports("a@example.com", "b@example.com"), false)
+		require.NoError(t, err)
+
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	n, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "the batch is rolled back with the enclosing transaction")
+}
+
+// TestWithTx_ConcurrentReadThenWrite runs transactions that read before they
+// write, like a login under a session cap, for different users at once. Deferred
+// transactions fail those with "database is locked" without waiting.
+func TestWithTx_ConcurrentReadThenWrite(t *testing.T) {
+	const logins = 32
+
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range logins {
+		userID, err := s.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"), []byte("salt"), 0, storage.Profile{})
+		require.NoError(t, err)
+
+		wg.Go(func() {
+			err := s.WithTx(ctx, func(tx storage.TxStorage) error {
+				if _, err := tx.ListSessions(ctx, userID); err != nil {
+					return err
+				}
+				// Let the other transactions read too before this one writes.
+				time.Sleep(10 * time.Millisecond)
+				session := models.Session{UserID: userID, AppID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+				_, err := tx.SaveSession(ctx, session, []byte(fmt.Sprintf("token%d", userID)))
+				return err
+			})
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+}
+
+func TestUpdatePasswordHash(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("bcrypt"), []byte(""), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.UpdatePasswordHash(ctx, id, []byte("stale"), []byte("argon2"), []byte("salt"), 1), storage.ErrUserNotFound,
+		"the password changed meanwhile")
+	require.NoError(t, s.UpdatePasswordHash(ctx, id, []byte("bcrypt"), []byte("argon2"), []byte("salt"), 1))
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("argon2"), user.PasswordHash)
+	assert.Equal(t, []byte("salt"), user.PasswordSalt)
+	assert.Equal(t, 1, user.PepperVersion)
+}
+
+func TestReconnect_RecoversFromDeadPool(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+
+	// Simulate losing every connection of the pool.
+	require.NoError(t, s.primary().Close())
+	require.Error(t, s.Ping(ctx))
+
+	require.NoError(t, s.Reconnect(ctx))
+	require.NoError(t, s.Ping(ctx))
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", user.Email)
+}
+
+func TestReconnect_InFlightTransactionCompletes(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	err := s.WithTx(ctx, func(tx storage.TxStorage) error {
+		require.NoError(t, s.Reconnect(ctx))
+
+		_, err := tx.SaveUser(ctx, "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+		return err
+	})
+	require.NoError(t, err, "the transaction keeps its connection from the old pool")
+
+	_, err = s.User(ctx, "user@example.com", 0)
+	assert.NoError(t, err)
+}
+
+// TestReconnect_RacesQueriesOnOldPool runs queries on pools picked up before
+// reconnects replace them, as a caller of primary that was preempted would.
+func TestReconnect_RacesQueriesOnOldPool(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 50 {
+				db := s.primary()
+				time.Sleep(time.Millisecond)
+				var one int
+				assert.NoError(t, db.QueryRowContext(ctx, `SELECT 1`).Scan(&one))
+			}
+		})
+	}
+	for range 10 {
+		require.NoError(t, s.Reconnect(ctx))
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+}
+
+func TestReconnect_ClosesOldPoolAfterGrace(t *testing.T) {
+	s, err := NewWithOptions(newTestDB(t), Options{ReconnectGrace: 50 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	old := s.primary()
+	require.NoError(t, s.Reconnect(ctx))
+	assert.NoError(t, old.PingContext(ctx), "the old pool stays open for operations using it")
+
+	assert.Eventually(t, func() bool {
+		return old.PingContext(ctx) != nil
+	}, time.Second, 10*time.Millisecond, "the old pool is closed after the grace period")
+	assert.NoError(t, s.Ping(ctx))
+
+	// Close doesn't wait for the grace period of pools still retiring.
+	require.NoError(t, s.Reconnect(ctx))
+	retiring := s.primary()
+	require.NoError(t, s.Reconnect(ctx))
+	require.NoError(t, s.Close())
+	assert.Error(t, retiring.PingContext(ctx))
+}
+
+func TestReconnect_PicksUpReplacedFile(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{JournalMode: "DELETE"} // a single file to replace
+
+	path := newTestDB(t)
+	s, err := NewWithOptions(path, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	replacementPath := newTestDB(t)
+	replacement, err := NewWithOptions(replacementPath, opts)
+	require.NoError(t, err)
+	_, err = replacement.SaveUser(ctx, "restored@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.NoError(t, err)
+	require.NoError(t, replacement.Close())
+
+	// E.g. a backup restored over the live database.
+	require.NoError(t, os.Rename(replacementPath, path))
+	_, err = s.User(ctx, "restored@example.com", 0)
+	require.ErrorIs(t, err, storage.ErrUserNotFound, "open connections still see the old file")
+
+	require.NoError(t, s.Reconnect(ctx))
+	_, err = s.User(ctx, "restored@example.com", 0)
+	assert.NoError(t, err)
+}
+
+func TestOpen_SQLiteDriver(t *testing.T) {
+	s, err := storage.Open(storage.Config{
+		StorageConfig: config.StorageConfig{Driver: storage.DriverSQLite, MaxOpenConns: 3, JournalMode: "DELETE"},
+		Path:          newTestDB(t),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	require.IsType(t, &Storage{}, s)
+	assert.Equal(t, 3, s.(*Storage).pools.opts.MaxOpenConns, "the storage config reaches the driver")
+	assert.Equal(t, DefaultOptions().ReconnectGrace, s.(*Storage).pools.opts.ReconnectGrace)
+	assert.NoError(t, s.Ping(context.Background()))
+
+	_, err = storage.Open(storage.Config{
+		StorageConfig: config.StorageConfig{Driver: storage.DriverSQLite, JournalMode: "bogus"},
+		Path:          newTestDB(t),
+	})
+	assert.ErrorContains(t, err, "storage.Open: sqlite:")
+}
+
+func TestSaveUser_LockedDatabase(t *testing.T) {
+	dbPath := newTestDB(t)
+	s, err := NewWithOptions(dbPath, Options{BusyTimeout: time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	// Another writer holds the write lock for longer than the busy timeout.
+	other, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = other.Close() })
+	tx, err := other.Begin()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback() })
+	_, err = tx.Exec("INSERT INTO apps (name, public_key, private_key) VALUES ('locker', '', '')")
+	require.NoError(t, err)
+
+	_, err = s.SaveUser(context.Background(), "user@example.com", []byte("hash"), []byte("salt"), 0, storage.Profile{})
+	require.Error(t, err)
+	assert.True(t, storage.IsUnavailable(err), "got %v", err)
+
+	assert.False(t, storage.IsUnavailable(errors.New("other")))
+}
@@ -2,21 +2,168 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sso/internal/domain/models"
+	"time"
 )
 
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user not found")
-	ErrAppNotFound  = errors.New("app not found")
+	ErrUserExists    = errors.New("user already exists")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrAppNotFound   = errors.New("app not found")
+	ErrAppExists     = errors.New("app already exists")
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenExpired  = errors.New("token expired")
+	ErrKeyNotFound   = errors.New("idempotency key not found")
+
+	// ErrInvalidExtraClaims means an app's extra claims are not a JSON object
+	// or set a reserved claim, see models.ParseExtraClaims.
+	ErrInvalidExtraClaims = errors.New("invalid extra claims")
+	// ErrInvalidCallbacks means an app's allowed callbacks are malformed, see
+	// models.ValidateCallbackPatterns.
+	ErrInvalidCallbacks = errors.New("invalid allowed callbacks")
+
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+
+	// ErrBatchRolledBack is the result of rows that were valid but rolled back
+	// because other rows of their batch failed.
+	ErrBatchRolledBack = errors.New("rolled back with the batch")
 )
 
+// IdempotencyRecord is the outcome of a request made with an idempotency key.
+type IdempotencyRecord struct {
+	Email  string // identifies the request the key was first used with
+	UserID int64
+}
+
+// Profile is optional user information captured at signup.
+type Profile struct {
+	DisplayName string
+	// Metadata is an arbitrary JSON object; empty stores none.
+	Metadata json.RawMessage
+	// AppID is the app the user registers through; 0 makes a user of all apps.
+	AppID int
+}
+
+// PasswordRecord is a stored password hash with what is needed to verify it.
+type PasswordRecord struct {
+	Hash          []byte
+	Salt          []byte
+	PepperVersion int
+}
+
+// UserImport is a user with already hashed credentials, e.g. exported from another system.
+type UserImport struct {
+	Email        string
+	PasswordHash []byte
+	PasswordSalt []byte
+}
+
+// ImportResult is the outcome of one row of a SaveUsers batch.
+type ImportResult struct {
+	// Index is the position of the row in the batch.
+	Index int
+	// UserID is the ID of the created user; 0 unless the row was saved.
+	UserID int64
+	// Skipped is set for rows left out because the email is taken.
+	Skipped bool
+	// Err is why the row was not saved: e.g. ErrUserExists for the row at
+	// fault, ErrBatchRolledBack for the other rows of a failed batch.
+	Err error
+}
+
+// PurgeResult counts the expired rows PurgeExpired deleted, by table.
+type PurgeResult struct {
+	Sessions                int64
+	EmailVerificationTokens int64
+	PasswordResetTokens     int64
+	IdempotencyKeys         int64
+}
+
+// Total is the number of rows deleted from all tables.
+func (r PurgeResult) Total() int64 {
+	return r.Sessions + r.EmailVerificationTokens + r.PasswordResetTokens + r.IdempotencyKeys
+}
+
+// Diagnostics is the health report of the database, see Storage.Diagnostics.
+type Diagnostics struct {
+	// OK means the integrity check found no problems.
+	OK bool
+	// Problems lists what the integrity check found, as the backend words it.
+	Problems []string
+	// PageCount is the size of the database in pages, FreePages how many of
+	// them are unused.
+	PageCount int64
+	FreePages int64
+}
+
+// TxStorage is the part of Storage available inside WithTx: operations on
+// users and their sessions that a flow may need to apply together.
+type TxStorage interface {
+	SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte, pepperVersion int, profile Profile) (int64, error)
+	User(ctx context.Context, email string, appID int) (models.User, error)
+	IdempotencyRecord(ctx context.Context, key string, notBefore time.Time) (IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, key string, record IdempotencyRecord, notBefore time.Time) error
+	SaveSession(ctx context.Context, session models.Session, tokenHash []byte) (int64, error)
+	ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, sessionID int64) error
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) (int64, error)
+	ChangePassword(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) error
+}
+
 // Storage defines the interface for user and application storage operations.
 type Storage interface {
-	SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte) (int64, error)
-	User(ctx context.Context, email string) (models.User, error)
+	// WithTx runs fn in a single transaction, committed if fn returns nil and
+	// rolled back otherwise. fn must do all its storage work through tx.
+	WithTx(ctx context.Context, fn func(tx TxStorage) error) error
+	SaveUser(ctx context.Context, email string, passwordHash []byte, passwordSalt []byte, pepperVersion int, profile Profile) (int64, error)
+	SaveUsers(ctx context.Context, users []UserImport, skipExisting bool) ([]ImportResult, error)
+	User(ctx context.Context, email string, appID int) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+	DeleteUser(ctx context.Context, userID int64) error
+	RestoreUser(ctx context.Context, userID int64) error
+	SaveEmailVerificationToken(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash []byte) (int64, error)
+	SavePasswordResetToken(ctx context.Context, userID int64, tokenHash []byte, expiresAt time.Time) error
+	// ResetPassword keeps the replaced password in the user's history, trimmed
+	// to the keepHistory most recent ones.
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) (int64, error)
+	// PasswordResetUser returns the user of a reset token without consuming it.
+	PasswordResetUser(ctx context.Context, tokenHash []byte) (int64, error)
+	// PasswordHistory returns the user's current password followed by the
+	// replaced ones, newest first, limit in total.
+	PasswordHistory(ctx context.Context, userID int64, limit int) ([]PasswordRecord, error)
+	// ChangePassword is ResetPassword for a user whose stored hash is still oldHash.
+	ChangePassword(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int, keepHistory int) error
+	UpdatePasswordHash(ctx context.Context, userID int64, oldHash []byte, passwordHash []byte, passwordSalt []byte, pepperVersion int) error
+	IdempotencyRecord(ctx context.Context, key string, notBefore time.Time) (IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, key string, record IdempotencyRecord, notBefore time.Time) error
+	SaveSession(ctx context.Context, session models.Session, tokenHash []byte) (int64, error)
+	ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, sessionID int64) error
+	TouchSession(ctx context.Context, tokenHash []byte) error
+	// PurgeExpired deletes sessions and one-time tokens that expired before
+	// expiredBefore, and idempotency keys stored before keysBefore.
+	PurgeExpired(ctx context.Context, expiredBefore time.Time, keysBefore time.Time) (PurgeResult, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	SetDisabled(ctx context.Context, userID int64, disabled bool) error
+	HasAdmin(ctx context.Context) (bool, error)
+	EmailExists(ctx context.Context, email string, appID int) (bool, error)
+	CountUsers(ctx context.Context) (int64, error)
+	CountAdmins(ctx context.Context) (int64, error)
+	CountApps(ctx context.Context) (int64, error)
 	App(ctx context.Context, appID int) (models.App, error)
+	SaveApp(ctx context.Context, app models.App) (int, error)
+	// ValidateCallback reports whether uri is one of the allowed callbacks of
+	// the app, see models.CallbackAllowed.
+	ValidateCallback(ctx context.Context, appID int, uri string) (bool, error)
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
+	// Diagnostics checks the integrity of the database. It reads the whole
+	// database, so it is meant for operators, not for frequent health checks.
+	Diagnostics(ctx context.Context) (Diagnostics, error)
 	Close() error
 }
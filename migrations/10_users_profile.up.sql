@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+-- A JSON object set by the app at signup; NULL when none was given.
+ALTER TABLE users ADD COLUMN metadata TEXT;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL,
+    -- SHA-256 of the token issued at login; the token itself is never stored.
+    token_hash BLOB NOT NULL UNIQUE,
+    client_ip TEXT,
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    revoked_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
@@ -0,0 +1,2 @@
+-- The user-agent metadata of the login; NULL when the client sent none.
+ALTER TABLE sessions ADD COLUMN user_agent TEXT;
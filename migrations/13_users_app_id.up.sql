@@ -0,0 +1,2 @@
+-- The app the user registered through; NULL for users shared by all apps.
+ALTER TABLE users ADD COLUMN app_id INTEGER;
@@ -0,0 +1,3 @@
+-- Fails while an email is registered in more than one app.
+DROP INDEX IF EXISTS idx_users_app_email_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_active ON users(email) WHERE deleted_at IS NULL;
@@ -0,0 +1,5 @@
+-- Emails are unique per app instead of globally; users of all apps (NULL
+-- app_id) share one more namespace. SaveUser also keeps an email of a user of
+-- all apps from being reused in any app, and the other way round.
+DROP INDEX IF EXISTS idx_users_email_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_app_email_active ON users(IFNULL(app_id, 0), email) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+-- Tokens of apps with bind_tokens carry a cnf claim and are only accepted from
+-- the client they were issued to.
+ALTER TABLE apps ADD COLUMN bind_tokens INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- The algorithm apps sign their tokens with, RS256 or EdDSA; it must match
+-- their keys.
+ALTER TABLE apps ADD COLUMN algorithm TEXT NOT NULL DEFAULT 'RS256';
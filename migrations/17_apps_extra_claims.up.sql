@@ -0,0 +1,3 @@
+-- A JSON object of static claims added to the app's access tokens, e.g.
+-- {"tenant": "acme"}; NULL adds none. Reserved claims are rejected on save.
+ALTER TABLE apps ADD COLUMN extra_claims TEXT CHECK (extra_claims IS NULL OR json_type(extra_claims) = 'object');
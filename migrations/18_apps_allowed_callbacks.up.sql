@@ -0,0 +1,3 @@
+-- A JSON array of the callback URIs the app may redirect to: exact URIs, or
+-- prefixes ending in "*". NULL allows none.
+ALTER TABLE apps ADD COLUMN allowed_callbacks TEXT CHECK (allowed_callbacks IS NULL OR json_type(allowed_callbacks) = 'array');
@@ -0,0 +1,12 @@
+-- Passwords replaced by a reset, newest with the highest id, so that a reset
+-- can refuse to reuse them. Only the most recent ones are kept.
+CREATE TABLE IF NOT EXISTS password_history
+(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash BLOB NOT NULL,
+    password_salt BLOB NOT NULL,
+    pepper_version INTEGER NOT NULL DEFAULT 0,
+    replaced_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history(user_id, id);
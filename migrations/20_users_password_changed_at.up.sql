@@ -0,0 +1,3 @@
+-- When the password was last reset or changed, in Unix seconds; NULL if it is
+-- still the one the user registered with.
+ALTER TABLE users ADD COLUMN password_changed_at INTEGER;
@@ -0,0 +1,3 @@
+-- Disabled users are kept, unlike deleted ones, but can't log in until an admin
+-- enables them again.
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- The iss claim of the app's tokens, e.g. a tenant URL; empty uses the
+-- service-wide issuer.
+ALTER TABLE apps ADD COLUMN issuer TEXT NOT NULL DEFAULT '';
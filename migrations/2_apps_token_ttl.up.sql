@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN token_ttl INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN previous_public_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,19 @@
+-- Restoring the UNIQUE constraint requires dropping soft-deleted users, whose
+-- emails may collide with active ones.
+CREATE TABLE users_old
+(
+    id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash BLOB NOT NULL,
+    password_salt BLOB NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    pepper_version INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO users_old (id, email, password_hash, password_salt, is_admin, pepper_version)
+SELECT id, email, password_hash, password_salt, is_admin, pepper_version FROM users WHERE deleted_at IS NULL;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
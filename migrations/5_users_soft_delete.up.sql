@@ -0,0 +1,21 @@
+-- SQLite cannot drop the inline UNIQUE constraint on email, so the table is
+-- rebuilt: uniqueness now only applies to users that are not soft-deleted.
+CREATE TABLE users_new
+(
+    id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL,
+    password_hash BLOB NOT NULL,
+    password_salt BLOB NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    pepper_version INTEGER NOT NULL DEFAULT 0,
+    deleted_at INTEGER
+);
+
+INSERT INTO users_new (id, email, password_hash, password_salt, is_admin, pepper_version)
+SELECT id, email, password_hash, password_salt, is_admin, pepper_version FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_active ON users(email) WHERE deleted_at IS NULL;
@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Accounts created before verification existed keep working when it becomes required.
+UPDATE users SET email_verified = TRUE;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens
+(
+    token_hash BLOB PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens(user_id);
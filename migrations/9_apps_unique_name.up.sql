@@ -0,0 +1,3 @@
+-- App names are unique regardless of ASCII case, so "Mobile" and "mobile" cannot
+-- coexist. Rename duplicate apps before applying this migration.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_apps_name ON apps(name COLLATE NOCASE);
@@ -0,0 +1,336 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"sso/internal/config"
+	"sso/internal/lib/keygen"
+	"sso/tests/suite"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const unknownAppID = 9999
+
+func TestInProcess_RegisterLogin(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respReg.GetUserId())
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    st.AppID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respLogin.GetToken())
+
+	publicKey, err := keygen.ParseRSAPublicKey(st.AppPublicKey)
+	require.NoError(t, err)
+
+	tokenParsed, err := jwt.Parse(respLogin.GetToken(), func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	require.NoError(t, err)
+
+	claims, ok := tokenParsed.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+
+	assert.Equal(t, respReg.GetUserId(), int64(claims["uid"].(float64)))
+	assert.Equal(t, email, claims["email"].(string))
+	assert.Equal(t, st.AppID, int32(claims["app_id"].(float64)))
+}
+
+func TestInProcess_Login_WrongPassword(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	email := gofakeit.Email()
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: randomFakePassword(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: "definitely-not-the-password",
+		AppId:    st.AppID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.ErrorContains(t, err, "invalid credentials")
+}
+
+func TestInProcess_Login_UnknownApp(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    unknownAppID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.ErrorContains(t, err, "invalid app id")
+}
+
+func TestInProcess_DuplicatedRegistration(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respReg.GetUserId())
+
+	respReg, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.Error(t, err)
+	assert.Empty(t, respReg.GetUserId())
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestInProcess_Register_OverLimitFields(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    strings.Repeat("a", 250) + "@example.com",
+		Password: randomFakePassword(),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "email over 254 characters")
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: strings.Repeat("p", st.Cfg.GRPC.MaxPasswordLength+1),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "password over the configured maximum")
+}
+
+func TestInProcess_Login_RememberMe(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+	require.Greater(t, st.Cfg.RememberMeTTL, st.Cfg.TokenTTL)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	publicKey, err := keygen.ParseRSAPublicKey(st.AppPublicKey)
+	require.NoError(t, err)
+
+	lifetime := func(ctx context.Context) time.Duration {
+		resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID})
+		require.NoError(t, err)
+
+		claims := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(resp.GetToken(), claims, func(token *jwt.Token) (interface{}, error) {
+			return publicKey, nil
+		})
+		require.NoError(t, err)
+
+		iat, err := claims.GetIssuedAt()
+		require.NoError(t, err)
+		exp, err := claims.GetExpirationTime()
+		require.NoError(t, err)
+
+		return exp.Sub(iat.Time)
+	}
+
+	assert.Equal(t, st.Cfg.TokenTTL, lifetime(ctx))
+	assert.Equal(t, st.Cfg.RememberMeTTL, lifetime(metadata.AppendToOutgoingContext(ctx, "remember-me", "true")))
+
+	_, err = st.AuthClient.Login(metadata.AppendToOutgoingContext(ctx, "remember-me", "sometimes"),
+		&ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestInProcess_ConcurrentLogins fires logins of several users to two apps at
+// once and checks every token is the right user's, signed with the right app's
+// key. Run with -race to check the shared token provider.
+func TestInProcess_ConcurrentLogins(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	const otherAppID = 2
+	publicKeys := map[int32]string{
+		st.AppID:   st.AppPublicKey,
+		otherAppID: st.SeedApp(otherAppID, "other"),
+	}
+
+	type user struct {
+		id       int64
+		email    string
+		password string
+	}
+	users := make([]user, 4)
+	for i := range users {
+		users[i] = user{email: gofakeit.Email(), password: randomFakePassword()}
+		resp, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: users[i].email, Password: users[i].password})
+		require.NoError(t, err)
+		users[i].id = resp.GetUserId()
+	}
+
+	// Stay within the hash concurrency limit, so no login waits for a slot.
+	logins := min(16, st.Cfg.Password.MaxConcurrentHashes)
+
+	var wg sync.WaitGroup
+	for i := range logins {
+		u := users[i%len(users)]
+		appID := st.AppID
+		if i/len(users)%2 == 1 {
+			appID = otherAppID
+		}
+
+		wg.Go(func() {
+			resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: u.email, Password: u.password, AppId: appID})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			publicKey, err := keygen.ParseRSAPublicKey(publicKeys[appID])
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			claims := jwt.MapClaims{}
+			_, err = jwt.ParseWithClaims(resp.GetToken(), claims, func(token *jwt.Token) (interface{}, error) {
+				return publicKey, nil
+			})
+			if !assert.NoError(t, err, "token for app %d", appID) {
+				return
+			}
+
+			assert.Equal(t, u.id, int64(claims["uid"].(float64)))
+			assert.Equal(t, u.email, claims["email"])
+			assert.Equal(t, appID, int32(claims["app_id"].(float64)))
+		})
+	}
+	wg.Wait()
+}
+
+func TestInProcess_Login_IDToken(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+	require.True(t, st.Cfg.JWT.IDTokens)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	publicKey, err := keygen.ParseRSAPublicKey(st.AppPublicKey)
+	require.NoError(t, err)
+
+	var header metadata.MD
+	resp, err := st.AuthClient.Login(metadata.AppendToOutgoingContext(ctx, "id-token", "true"),
+		&ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetToken())
+	require.Len(t, header.Get("id-token"), 1)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(header.Get("id-token")[0], claims, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(respReg.GetUserId(), 10), claims["sub"])
+	assert.Equal(t, email, claims["email"])
+	assert.Equal(t, false, claims["email_verified"])
+	assert.Contains(t, claims, "name")
+	assert.NotContains(t, claims, "uid")
+
+	header = nil
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get("id-token"), "ID tokens are only sent on request")
+}
+
+func TestInProcess_Login_UserInfo(t *testing.T) {
+	ctx, st := suite.NewInProcess(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	var header metadata.MD
+	resp, err := st.AuthClient.Login(metadata.AppendToOutgoingContext(ctx, "user-info", "true"),
+		&ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID}, grpc.Header(&header))
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(resp.GetToken(), claims)
+	require.NoError(t, err)
+	assert.Equal(t, []string{strconv.FormatInt(int64(claims["uid"].(float64)), 10)}, header.Get("user-id"))
+	assert.Equal(t, []string{claims["email"].(string)}, header.Get("user-email"))
+	assert.Equal(t, []string{strconv.FormatBool(claims["is_admin"].(bool))}, header.Get("user-is-admin"))
+	assert.Equal(t, []string{strconv.FormatInt(int64(claims["exp"].(float64)), 10)}, header.Get("token-expires-at"))
+
+	header = nil
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID}, grpc.Header(&header))
+	require.NoError(t, err)
+	for _, key := range []string{"user-id", "user-email", "user-is-admin", "token-expires-at"} {
+		assert.Empty(t, header.Get(key), "%s is only sent on request", key)
+	}
+}
+
+func TestInProcess_Login_HashedEmails(t *testing.T) {
+	ctx, st := suite.NewInProcess(t, func(cfg *config.Config) {
+		cfg.Storage.HashEmails = true
+		cfg.Storage.EmailHashKey = strings.Repeat("k", 32)
+	})
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: strings.ToUpper(email), Password: password})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: st.AppID})
+	require.NoError(t, err, "emails match regardless of case")
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(resp.GetToken(), claims)
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToLower(email), claims["email"])
+
+	db, err := sql.Open("sqlite3", st.Cfg.StoragePath)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	var stored string
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT email FROM users`).Scan(&stored))
+	assert.NotContains(t, stored, "@", "the column holds no plaintext email")
+}
@@ -2,24 +2,50 @@ package suite
 
 import (
 	"context"
+	"database/sql"
+	"io"
+	"log/slog"
 	"net"
+	"path/filepath"
+	"sso/internal/app"
 	"sso/internal/config"
+	"sso/internal/lib/emailhash"
+	"sso/internal/lib/hash"
+	"sso/internal/lib/keygen"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/services/auth"
+	"sso/internal/storage/sqlite"
 	"strconv"
 	"testing"
 
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
 	ssov1 "github.com/grpc-svc/protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-const grpcHost = "localhost"
+const (
+	grpcHost = "localhost"
+
+	migrationsPath = "../migrations"
+	seedAppID      = 1
+	seedAppName    = "test"
+	seedKeyBits    = 2048
+)
 
 type Suite struct {
 	*testing.T
 	Cfg        *config.Config
 	AuthClient ssov1.AuthClient
+
+	// AppID and AppPublicKey describe the app seeded by NewInProcess.
+	AppID        int32
+	AppPublicKey string
 }
 
+// New connects to an already running server at the port from the local config.
 func New(t *testing.T) (context.Context, *Suite) {
 	t.Helper()
 	t.Parallel()
@@ -50,6 +76,148 @@ func New(t *testing.T) (context.Context, *Suite) {
 	}
 }
 
+// NewInProcess boots the whole application on an ephemeral port backed by a
+// freshly migrated temporary SQLite database with one seeded app, and returns
+// a client connected to it. Unlike New, it needs no externally running server.
+// configure, if given, adjusts the local config before the application boots.
+func NewInProcess(t *testing.T, configure ...func(cfg *config.Config)) (context.Context, *Suite) {
+	t.Helper()
+	t.Parallel()
+
+	cfg := config.MustLoadByPath("../config/local.yaml")
+	cfg.StoragePath = filepath.Join(t.TempDir(), "sso.db")
+	cfg.GRPC.Port = 0
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	migrateDB(t, cfg.StoragePath)
+	publicKey := seedApp(t, cfg.StoragePath, seedAppID, seedAppName)
+
+	var emails *emailhash.Hasher
+	if cfg.Storage.HashEmails {
+		var err error
+		if emails, err = emailhash.New(cfg.Storage.EmailHashKey); err != nil {
+			t.Fatalf("failed to init email hashing: %v", err)
+		}
+	}
+
+	opts := sqlite.DefaultOptions()
+	opts.EmailHasher = emails
+	storage, err := sqlite.NewWithOptions(cfg.StoragePath, opts)
+	if err != nil {
+		t.Fatalf("failed to init storage: %v", err)
+	}
+
+	hasher, err := hash.NewHasher(
+		cfg.Password.Peppers,
+		cfg.Password.PepperVersion,
+		hash.WithConcurrencyLimit(cfg.Password.MaxConcurrentHashes, cfg.Password.HashQueueTimeout),
+		hash.WithVariant(hash.Variant(cfg.Password.Variant)),
+		hash.WithLengthCheck(hash.LengthCheck(cfg.Password.LengthCheck)),
+	)
+	if err != nil {
+		t.Fatalf("failed to init password hasher: %v", err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	application := app.New(log, hasher, storage, storage, cfg.GRPC, cfg.JWT, cfg.TokenTTL,
+		auth.WithRememberMeTTL(cfg.RememberMeTTL),
+		auth.WithIDTokens(cfg.JWT.IDTokens),
+		auth.WithEmailHashing(emails),
+		auth.WithEmailCheck(cfg.EmailCheck.Enabled, ratelimit.Limit{RPS: cfg.EmailCheck.RPS, Burst: cfg.EmailCheck.Burst}),
+	)
+
+	l, err := net.Listen("tcp", net.JoinHostPort(grpcHost, "0"))
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	go func() {
+		_ = application.GRPCSrv.Serve(l)
+	}()
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), cfg.GRPC.Timeout)
+
+	cc, err := grpc.NewClient(
+		l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to create grpc client: %v", err)
+	}
+
+	t.Cleanup(func() {
+		t.Helper()
+		cancelCtx()
+		if err := cc.Close(); err != nil {
+			t.Errorf("failed to close grpc connection: %v", err)
+		}
+		if err := application.Stop(); err != nil {
+			t.Errorf("failed to stop application: %v", err)
+		}
+		if err := storage.Close(); err != nil {
+			t.Errorf("failed to close storage: %v", err)
+		}
+	})
+
+	return ctx, &Suite{
+		T:            t,
+		Cfg:          cfg,
+		AuthClient:   ssov1.NewAuthClient(cc),
+		AppID:        seedAppID,
+		AppPublicKey: publicKey,
+	}
+}
+
+func migrateDB(t *testing.T, storagePath string) {
+	t.Helper()
+
+	m, err := migrate.New("file://"+migrationsPath, "sqlite3://"+storagePath)
+	if err != nil {
+		t.Fatalf("failed to init migrations: %v", err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	if srcErr, dbErr := m.Close(); srcErr != nil || dbErr != nil {
+		t.Fatalf("failed to close migrator: source: %v, db: %v", srcErr, dbErr)
+	}
+}
+
+// SeedApp adds an app with a fresh key pair to the database of a suite made by
+// NewInProcess and returns its public key.
+func (s *Suite) SeedApp(id int32, name string) string {
+	s.Helper()
+
+	return seedApp(s.T, s.Cfg.StoragePath, int(id), name)
+}
+
+// seedApp inserts an app with a freshly generated key pair and returns its public key.
+func seedApp(t *testing.T, storagePath string, id int, name string) string {
+	t.Helper()
+
+	keyPair, err := keygen.GenerateRSAKeyPair(seedKeyBits)
+	if err != nil {
+		t.Fatalf("failed to generate app keys: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", storagePath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	_, err = db.Exec(
+		`INSERT INTO apps (id, name, private_key, public_key) VALUES (?, ?, ?, ?)`,
+		id, name, keyPair.PrivateKey, keyPair.PublicKey,
+	)
+	if err != nil {
+		t.Fatalf("failed to seed app: %v", err)
+	}
+
+	return keyPair.PublicKey
+}
+
 func grpcAddress(cfg *config.Config) string {
 	return net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port))
 }